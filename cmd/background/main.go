@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"phonon/pkg/api"
 	"phonon/pkg/config"
 	"phonon/pkg/converter"
 	"phonon/pkg/instrumentation"
+	"phonon/pkg/model"
 	"phonon/pkg/queue"
 	"phonon/pkg/repository"
+	"phonon/pkg/storage"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -20,26 +26,117 @@ func main() {
 	config.Initialize()
 	instrumentation.InitializeLogging()
 
-	logrus.Info("Starting background with configuration:", viper.AllKeys(), viper.AllSettings())
+	logrus.Info("Starting background with configuration:", config.Redacted())
 
 	db, err := repository.NewDatabase()
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	audioConverter := converter.NewFFMPEG(viper.GetString("converter.target_format"))
+	filestore, err := storage.NewFilestore(storage.Config{
+		Type:     storage.Type(viper.GetString("storage.type")),
+		BasePath: viper.GetString("storage.local.base_path")})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	formats := converter.DefaultFormats()
+	profile, err := converter.ConfiguredProfile()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	audioConverter, err := converter.NewAudio(formats, profile)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	processor, err := converter.NewProcessor(formats, profile)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	waveforms, err := converter.ConfiguredWaveforms(formats, profile)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	renditions, err := converter.ConfiguredRenditions(formats, profile)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err = queue.ProvisionTopics(); err != nil {
+		logrus.Fatal(err)
+	}
 
-	consumer, err := queue.NewKafkaConsumer(queue.KafkaConfig{
-		Brokers: viper.GetStringSlice("mq.kafka.brokers"),
-		GroupID: viper.GetString("mq.kafka.audio_conversion.group"),
-		Topic:   viper.GetString("mq.kafka.audio_conversion.topic"),
-	})
+	consumer, err := queue.NewConsumer(db)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	defer consumer.Close()
 
-	audioConversionQueue := queue.NewAudioConversion(audioConverter, db, queue.AudioConversionWithConsumer(consumer))
+	consumerOptions := &queue.ConsumerOptions{}
+	if viper.GetBool("scheduler.enabled") {
+		// the scheduler needs more messages than it runs at once to choose among users
+		consumerOptions.Concurrency = viper.GetInt("scheduler.window")
+	}
+	deadLetterProducer, err := queue.NewDeadLetterProducer(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if deadLetterProducer != nil {
+		defer deadLetterProducer.Close()
+		consumerOptions.DeadLetter = deadLetterProducer
+	}
+	retryProducer, retryRelay, err := queue.NewRetryProducer()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if retryProducer != nil {
+		defer retryProducer.Close()
+		defer retryRelay.Close()
+		consumerOptions.Retry = retryProducer
+	}
+
+	// the producer republishes stuck conversions
+	producer, relay, err := queue.NewProducer(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer producer.Close()
+
+	// replies are compressed independently of the conversion jobs
+	replyProducer, err := queue.NewReplyProducer(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer replyProducer.Close()
+
+	audioConversionOptions := []queue.Option{
+		queue.AudioConversionWithProducer(producer),
+		queue.AudioConversionWithReplyProducer(replyProducer),
+		queue.AudioConversionWithTimeout(viper.GetDuration("converter.timeout")),
+		queue.AudioConversionWithProfile(profile.Name),
+		queue.AudioConversionWithProcessor(processor),
+		queue.AudioConversionWithArtifacts(filestore),
+	}
+	if waveforms != nil {
+		audioConversionOptions = append(audioConversionOptions, queue.AudioConversionWithWaveforms(waveforms))
+	}
+	if renditions != nil {
+		audioConversionOptions = append(audioConversionOptions, queue.AudioConversionWithRenditions(renditions))
+	}
+	audioConversionQueue := queue.NewAudioConversion(audioConverter, db, audioConversionOptions...)
+	cleanupQueue := queue.NewCleanup(filestore, nil)
+
+	// conversion jobs published before messages carried a type header are routed by default
+	mux := queue.NewMux()
+	mux.HandleType(model.AudioConversionMessageType, audioConversionQueue)
+	mux.HandleType(model.CleanupMessageType, cleanupQueue)
+	mux.HandleDefault(audioConversionQueue)
+
+	var handler queue.Handler = mux
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -47,8 +144,62 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if relay != nil {
+		go relay.Run(ctx)
+	}
+
+	if retryRelay != nil {
+		go retryRelay.Run(ctx)
+	}
+
+	if viper.GetBool("mq.dedup.enabled") {
+		dedup := queue.NewDeduplicator(mux, db, viper.GetDuration("mq.dedup.ttl"))
+		go dedup.RunCleanup(ctx, viper.GetDuration("mq.dedup.cleanup_interval"))
+		handler = dedup
+	}
+
+	if viper.GetBool("reconciler.enabled") {
+		reconciler := queue.NewReconciler(db, audioConversionQueue, queue.ReconcilerConfig{
+			Interval:    viper.GetDuration("reconciler.interval"),
+			StaleAfter:  viper.GetDuration("reconciler.stale_after"),
+			MaxAttempts: viper.GetInt("reconciler.max_attempts"),
+			BatchSize:   viper.GetInt("reconciler.batch_size"),
+		})
+		go reconciler.Run(ctx)
+	}
+
+	monitor := queue.NewMonitor(consumer, queue.HealthThresholds{
+		MaxLag:       viper.GetInt64("health.max_lag"),
+		MaxErrorRate: viper.GetFloat64("health.max_error_rate"),
+		MaxSilence:   viper.GetDuration("health.max_silence"),
+	}, viper.GetDuration("health.window"))
+	handler = monitor.Wrap(handler)
+	go monitor.Run(ctx, viper.GetDuration("health.metrics_interval"))
+
+	// scheduled last so messages waiting for a slot do not count as in flight
+	if viper.GetBool("scheduler.enabled") {
+		handler = queue.NewFairScheduler(handler, queue.FairSchedulerConfig{
+			Concurrency:        viper.GetInt("scheduler.concurrency"),
+			PerUserConcurrency: viper.GetInt("scheduler.per_user_concurrency"),
+		})
+	}
+
+	healthServer := &http.Server{
+		Addr:    fmt.Sprintf(":%s", viper.GetString("health.port")),
+		Handler: api.NewHealthRouter(monitor),
+	}
+
 	go func() {
-		audioConversionQueue.StartConsuming(ctx)
+		logrus.WithField("addr", healthServer.Addr).Info("starting health server")
+		if err := healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Fatalf("Health server failed: %v", err)
+		}
+	}()
+
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		consumer.Consume(ctx, handler, consumerOptions)
 	}()
 
 	logrus.Info("Cleanup consumer service started")
@@ -58,5 +209,20 @@ func main() {
 
 	cancel()
 
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), viper.GetDuration("server.shutdown_timeout"))
+	defer cancelShutdown()
+
+	// cancelling stops fetching messages, the ones already fetched or leased are handled to the end meanwhile.
+	// They are only committed or acknowledged once handled, so the ones cut off by the timeout are redelivered.
+	select {
+	case <-consumed:
+	case <-shutdownCtx.Done():
+		logrus.Warn("consumer did not stop before the shutdown timeout")
+	}
+
+	if err := healthServer.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Health server shutdown failed: %v", err)
+	}
+
 	logrus.Info("Cleanup consumer service stopped cleanly.")
 }
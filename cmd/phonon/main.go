@@ -26,7 +26,7 @@ func main() {
 	config.Initialize()
 	instrumentation.InitializeLogging()
 
-	logrus.Info("Starting phonon with configuration:", viper.AllKeys(), viper.AllSettings())
+	logrus.Info("Starting phonon with configuration:", config.Redacted())
 
 	db, err := repository.NewDatabase()
 	if err != nil {
@@ -40,22 +40,75 @@ func main() {
 		logrus.Fatal(err)
 	}
 
-	audioConverter := converter.NewFFMPEG(viper.GetString("converter.target_format"))
+	formats := converter.DefaultFormats()
+	profile, err := converter.ConfiguredProfile()
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	producer, err := queue.NewKafkaProducer(queue.KafkaConfig{
-		Brokers: viper.GetStringSlice("mq.kafka.brokers"),
-		Topic:   viper.GetString("mq.kafka.audio_conversion.topic"),
-	})
+	audioConverter, err := converter.NewAudio(formats, profile)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	waveforms, err := converter.ConfiguredWaveforms(formats, profile)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err = queue.ProvisionTopics(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	producer, relay, err := queue.NewProducer(db)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	defer producer.Close()
 
-	audioConversionQueue := queue.NewAudioConversion(audioConverter, db, queue.AudioConversionWithProducer(producer))
+	keyFunc, err := queue.KeyFuncFor(viper.GetString("mq.kafka.audio_conversion.partition_key"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	audioConversionOptions := []queue.Option{
+		queue.AudioConversionWithProducer(producer),
+		queue.AudioConversionWithKeyFunc(keyFunc),
+	}
+
+	instance := viper.GetString("mq.reply.instance")
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	replyConsumer, replyTo, err := queue.NewReplyConsumer(db, instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	backgroundCtx, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+
+	if relay != nil {
+		go relay.Run(backgroundCtx)
+	}
+
+	if replyConsumer != nil {
+		defer replyConsumer.Close()
+
+		requester := queue.NewRequester(producer, replyTo, viper.GetDuration("mq.reply.timeout"))
+		audioConversionOptions = append(audioConversionOptions, queue.AudioConversionWithRequester(requester))
+
+		go replyConsumer.Consume(backgroundCtx, requester, &queue.ConsumerOptions{})
+	}
+
+	audioConversionQueue := queue.NewAudioConversion(audioConverter, db, audioConversionOptions...)
 
-	audioService := service.NewAudioService(db, filestore, audioConversionQueue)
+	audioService := service.NewAudioService(db, filestore, audioConversionQueue, formats, waveforms)
 
-	router := api.NewRouter(audioService, producer)
+	router := api.NewRouter(audioService, producer, formats)
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%s", viper.GetString("server.port")),
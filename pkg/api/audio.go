@@ -1,10 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
+	"time"
 
+	"phonon/pkg/converter"
 	"phonon/pkg/errors"
 	"phonon/pkg/middleware"
 	"phonon/pkg/queue"
@@ -14,17 +21,40 @@ import (
 	"github.com/spf13/viper"
 )
 
-const defaultMaxUploadSize int64 = 10 * 1024 * 1024 // 10 MB
+const (
+	defaultMaxUploadSize     int64 = 10 * 1024 * 1024 // 10 MB
+	defaultMaxWaitUploadSize int64 = 1024 * 1024      // 1 MB
+
+	defaultSpectrogramRenderTimeout = 10 * time.Second
+)
 
 // AudioHandler handles audio-related HTTP requests
 type AudioHandler struct {
 	audioService service.Audio
 	producer     queue.Producer
+	backpressure *queue.Backpressure
+	formats      *converter.Formats
+}
+
+// NewAudioHandler creates a new instance of AudioHandler serving files with the media type of their format in formats.
+// Uploads are deferred or rejected when the conversion backlog of producer exceeds the backpressure thresholds.
+func NewAudioHandler(audioService service.Audio, producer queue.Producer, formats *converter.Formats) *AudioHandler {
+	return &AudioHandler{
+		audioService: audioService,
+		producer:     producer,
+		formats:      formats,
+		backpressure: queue.NewBackpressure(producer, backpressureThresholds, viper.GetDuration("backpressure.refresh")),
+	}
 }
 
-// NewAudioHandler creates a new instance of AudioHandler
-func NewAudioHandler(audioService service.Audio, producer queue.Producer) *AudioHandler {
-	return &AudioHandler{audioService: audioService, producer: producer}
+// backpressureThresholds reads the thresholds on every check, so they follow changes of the configuration file
+func backpressureThresholds() queue.BackpressureThresholds {
+	return queue.BackpressureThresholds{
+		DeferDepth:  viper.GetInt64("backpressure.defer_depth"),
+		RejectDepth: viper.GetInt64("backpressure.reject_depth"),
+		DeferDelay:  viper.GetDuration("backpressure.defer_delay"),
+		RetryAfter:  viper.GetDuration("backpressure.retry_after"),
+	}
 }
 
 // SuccessResponse represents the structure of success responses
@@ -58,6 +88,13 @@ func (h *AudioHandler) UploadAudio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	admission := h.backpressure.Admit(r.Context())
+	if admission.Reject {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(admission.RetryAfter.Seconds()))))
+		middleware.WriteError(w, errors.ErrServiceOverloaded)
+		return
+	}
+
 	file, fileHeader, err := r.FormFile("audio_file")
 	if err != nil {
 		middleware.WriteError(w, errors.ErrInvalidInput)
@@ -65,6 +102,17 @@ func (h *AudioHandler) UploadAudio(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	if admission.Defer > 0 {
+		h.uploadAudioDeferred(w, r, userID, phraseID, file, fileHeader.Filename, admission.Defer)
+		return
+	}
+
+	// the size of the parsed file, the content length is -1 for chunked requests
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait && fileHeader.Size <= maxWaitUploadSize() {
+		h.uploadAudioAndWait(w, r, userID, phraseID, file, fileHeader.Filename)
+		return
+	}
+
 	if err = h.audioService.StoreAudio(r.Context(), userID, phraseID, file, fileHeader.Filename); err != nil {
 		middleware.WriteError(w, err)
 		return
@@ -79,7 +127,55 @@ func (h *AudioHandler) UploadAudio(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetAudio handles GET requests to fetch and serve an audio file
+// uploadAudioAndWait stores the audio and waits for its conversion, answering 202 Accepted when it is still in progress
+func (h *AudioHandler) uploadAudioAndWait(w http.ResponseWriter, r *http.Request, userID, phraseID int64, file io.Reader, filename string) {
+	converted, err := h.audioService.StoreAudioAndWait(r.Context(), userID, phraseID, file, filename)
+	if err != nil {
+		middleware.WriteError(w, err)
+		return
+	}
+
+	response := SuccessResponse{
+		Message: "Audio uploaded and converted successfully",
+	}
+	status := http.StatusCreated
+	if !converted {
+		response.Message = "Audio uploaded successfully, conversion in progress"
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// uploadAudioDeferred stores the audio and defers its conversion, answering 202 Accepted
+func (h *AudioHandler) uploadAudioDeferred(w http.ResponseWriter, r *http.Request, userID, phraseID int64, file io.Reader, filename string, delay time.Duration) {
+	if err := h.audioService.StoreAudioDeferred(r.Context(), userID, phraseID, file, filename, delay); err != nil {
+		middleware.WriteError(w, err)
+		return
+	}
+
+	response := SuccessResponse{
+		Message: "Audio uploaded successfully, conversion deferred",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxWaitUploadSize returns the largest upload that may wait for its conversion, larger uploads are converted asynchronously
+func maxWaitUploadSize() int64 {
+	maxSize := viper.GetInt64("server.max_wait_upload_size")
+	if maxSize == 0 {
+		maxSize = defaultMaxWaitUploadSize
+	}
+
+	return maxSize
+}
+
+// GetAudio handles GET requests to fetch and serve an audio file in the requested or negotiated format
 func (h *AudioHandler) GetAudio(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
@@ -94,13 +190,150 @@ func (h *AudioHandler) GetAudio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// without a format in the path, the rendition is negotiated with the Accept header
 	audioFormat := vars["audio_format"]
+	if audioFormat == "" {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	uri, err := h.audioService.FetchAudio(r.Context(), userID, phraseID, audioFormat, r.Header.Get("Accept"))
+	if err != nil {
+		middleware.WriteError(w, err)
+		return
+	}
+
+	if format, err := h.formats.Lookup(filepath.Ext(uri)); err == nil {
+		w.Header().Set("Content-Type", format.ContentType())
+	}
+	http.ServeFile(w, r, uri)
+}
+
+// GetWaveform handles GET requests to fetch the waveform peaks of a converted audio file. It answers in the JSON format
+// of audiowaveform, or in its binary format with ?format=dat. ?samples_per_pixel selects the closest stored resolution.
+func (h *AudioHandler) GetWaveform(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
+	if err != nil {
+		middleware.WriteError(w, errors.ErrInvalidInput)
+		return
+	}
+
+	phraseID, err := strconv.ParseInt(vars["phrase_id"], 10, 64)
+	if err != nil {
+		middleware.WriteError(w, errors.ErrInvalidInput)
+		return
+	}
+
+	query := r.URL.Query()
+	var samplesPerPixel int
+	if value := query.Get("samples_per_pixel"); value != "" {
+		if samplesPerPixel, err = strconv.Atoi(value); err != nil || samplesPerPixel <= 0 {
+			middleware.WriteError(w, errors.ErrInvalidInput)
+			return
+		}
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "dat" {
+		middleware.WriteError(w, errors.ErrInvalidInput)
+		return
+	}
+
+	waveform, err := h.audioService.FetchWaveform(r.Context(), userID, phraseID, samplesPerPixel)
+	if err != nil {
+		middleware.WriteError(w, err)
+		return
+	}
+
+	if format == "dat" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		waveform.WriteTo(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(waveform)
+}
+
+// GetSpectrogram handles GET requests to fetch the spectrogram PNG of a converted audio file. The query parameters
+// fft_size, hop, scale, color_map, height and range override the configured settings.
+func (h *AudioHandler) GetSpectrogram(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
+	if err != nil {
+		middleware.WriteError(w, errors.ErrInvalidInput)
+		return
+	}
+
+	phraseID, err := strconv.ParseInt(vars["phrase_id"], 10, 64)
+	if err != nil {
+		middleware.WriteError(w, errors.ErrInvalidInput)
+		return
+	}
+
+	config, err := spectrogramConfig(r.URL.Query())
+	if err != nil {
+		middleware.WriteError(w, errors.ErrInvalidInput)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), spectrogramRenderTimeout())
+	defer cancel()
 
-	originalURI, err := h.audioService.FetchAudio(r.Context(), userID, phraseID, audioFormat)
+	image, err := h.audioService.FetchSpectrogram(ctx, userID, phraseID, config)
 	if err != nil {
 		middleware.WriteError(w, err)
 		return
 	}
+	defer image.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	io.Copy(w, image)
+}
+
+// spectrogramRenderTimeout returns the time a spectrogram may take to render, so requests cannot tie up the server
+func spectrogramRenderTimeout() time.Duration {
+	timeout := viper.GetDuration("spectrogram.render_timeout")
+	if timeout <= 0 {
+		timeout = defaultSpectrogramRenderTimeout
+	}
+
+	return timeout
+}
+
+// spectrogramConfig applies the settings of the query to the configured spectrogram settings
+func spectrogramConfig(query url.Values) (converter.SpectrogramConfig, error) {
+	config := converter.ConfiguredSpectrogram()
+
+	for key, target := range map[string]*int{"fft_size": &config.FFTSize, "hop": &config.Hop, "height": &config.Height} {
+		if value := query.Get(key); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				return config, errors.ErrInvalidInput
+			}
+			*target = parsed
+		}
+	}
+	if value := query.Get("range"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 {
+			return config, errors.ErrInvalidInput
+		}
+		config.Range = parsed
+	}
+	if value := query.Get("scale"); value != "" {
+		config.Scale = converter.FrequencyScale(value)
+	}
+	if value := query.Get("color_map"); value != "" {
+		config.ColorMap = value
+	}
+	// the hop and height follow a requested FFT size rather than the configured one
+	if query.Has("fft_size") && !query.Has("hop") {
+		config.Hop = 0
+	}
+	if query.Has("fft_size") && !query.Has("height") {
+		config.Height = 0
+	}
 
-	http.ServeFile(w, r, originalURI)
+	return config, config.Validate()
 }
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+
+	"phonon/pkg/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// HealthHandler serves the health of the background consumer
+type HealthHandler struct {
+	monitor *queue.Monitor
+}
+
+// NewHealthHandler creates a new instance of HealthHandler
+func NewHealthHandler(monitor *queue.Monitor) *HealthHandler {
+	return &HealthHandler{monitor: monitor}
+}
+
+// Live handles GET requests checking that the process is up
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// Ready handles GET requests reporting the consumer health, answering 503 when a threshold is exceeded
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	report := h.monitor.Health(r.Context())
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// NewHealthRouter creates the router of the health endpoints, alongside the expvar metrics
+func NewHealthRouter(monitor *queue.Monitor) *mux.Router {
+	healthHandler := NewHealthHandler(monitor)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/health/live", healthHandler.Live).Methods(http.MethodGet)
+	router.HandleFunc("/health/ready", healthHandler.Ready).Methods(http.MethodGet)
+	router.Handle("/debug/vars", expvar.Handler()).Methods(http.MethodGet)
+
+	return router
+}
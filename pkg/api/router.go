@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"phonon/pkg/converter"
 	"phonon/pkg/middleware"
 	"phonon/pkg/queue"
 	"phonon/pkg/service"
@@ -11,11 +12,15 @@ import (
 )
 
 // NewRouter creates a new router for Phonon Service
-func NewRouter(audioService service.Audio, producer queue.Producer) *mux.Router {
-	audioHandler := NewAudioHandler(audioService, producer)
+func NewRouter(audioService service.Audio, producer queue.Producer, formats *converter.Formats) *mux.Router {
+	audioHandler := NewAudioHandler(audioService, producer, formats)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/audio/user/{user_id:[0-9]+}/phrase/{phrase_id:[0-9]+}", audioHandler.UploadAudio).Methods(http.MethodPost)
+	router.HandleFunc("/audio/user/{user_id:[0-9]+}/phrase/{phrase_id:[0-9]+}", audioHandler.GetAudio).Methods(http.MethodGet)
+	// registered before the audio route, which would take waveform or spectrogram for a format
+	router.HandleFunc("/audio/user/{user_id:[0-9]+}/phrase/{phrase_id:[0-9]+}/waveform", audioHandler.GetWaveform).Methods(http.MethodGet)
+	router.HandleFunc("/audio/user/{user_id:[0-9]+}/phrase/{phrase_id:[0-9]+}/spectrogram", audioHandler.GetSpectrogram).Methods(http.MethodGet)
 	router.HandleFunc("/audio/user/{user_id:[0-9]+}/phrase/{phrase_id:[0-9]+}/{audio_format}", audioHandler.GetAudio).Methods(http.MethodGet)
 
 	router.Use(middleware.RecoveryMiddleware, middleware.LoggingMiddleware, middleware.ErrorHandler)
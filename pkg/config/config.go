@@ -3,6 +3,7 @@ package config
 import (
 	"strings"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -23,6 +24,44 @@ func Initialize() {
 	if err := viper.ReadInConfig(); err != nil {
 		logrus.Fatalf("Error reading config file: %v", err)
 	}
+
+	// settings read on use, like the backpressure thresholds, follow changes of the config file without a restart
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		logrus.WithField("file", e.Name).Info("configuration reloaded")
+	})
+	viper.WatchConfig()
+}
+
+// redacted replaces the values of secret settings in the logged configuration
+const redacted = "[REDACTED]"
+
+// secretKeys are the last segments of the keys holding credentials or the paths to private keys
+var secretKeys = []string{"password", "key_file", "secret", "token"}
+
+// Redacted returns every setting keyed by its full key, with the values of secret settings such as passwords and
+// private key paths replaced, so the configuration can be logged
+func Redacted() map[string]any {
+	settings := make(map[string]any)
+	for _, key := range viper.AllKeys() {
+		value := viper.Get(key)
+		if isSecret(key) && value != "" {
+			value = redacted
+		}
+		settings[key] = value
+	}
+
+	return settings
+}
+
+func isSecret(key string) bool {
+	name := key[strings.LastIndex(key, ".")+1:]
+	for _, secret := range secretKeys {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // bindEnvVariables binds all configuration keys to their corresponding environment variables
@@ -32,6 +71,7 @@ func bindEnvVariables() {
 	viper.BindEnv("server.port")
 	viper.BindEnv("server.shutdown_timeout")
 	viper.BindEnv("server.max_upload_size")
+	viper.BindEnv("server.max_wait_upload_size")
 
 	viper.BindEnv("database.driver")
 	viper.BindEnv("database.sqlite.path")
@@ -45,7 +85,115 @@ func bindEnvVariables() {
 	viper.BindEnv("storage.type")
 	viper.BindEnv("storage.local.base_path")
 
+	viper.BindEnv("converter.profile")
+	viper.BindEnv("converter.native")
+	viper.BindEnv("converter.normalize.enabled")
+	viper.BindEnv("converter.normalize.target_loudness")
+	viper.BindEnv("converter.normalize.peak_limit")
+	viper.BindEnv("converter.trim.enabled")
+	viper.BindEnv("converter.trim.threshold")
+	viper.BindEnv("converter.trim.padding")
+	viper.BindEnv("converter.renditions")
+	viper.BindEnv("converter.waveform.enabled")
+	viper.BindEnv("converter.waveform.resolutions")
+	viper.BindEnv("converter.waveform.bits")
+	viper.BindEnv("converter.timeout")
+	viper.BindEnv("converter.limits.cpu_time")
+	viper.BindEnv("converter.limits.memory")
+	viper.BindEnv("converter.limits.output_size")
+	viper.BindEnv("converter.limits.open_files")
+	viper.BindEnv("converter.protocols")
+
+	viper.BindEnv("spectrogram.fft_size")
+	viper.BindEnv("spectrogram.hop")
+	viper.BindEnv("spectrogram.scale")
+	viper.BindEnv("spectrogram.color_map")
+	viper.BindEnv("spectrogram.height")
+	viper.BindEnv("spectrogram.range")
+	viper.BindEnv("spectrogram.render_timeout")
+
+	viper.BindEnv("backpressure.refresh")
+	viper.BindEnv("backpressure.defer_depth")
+	viper.BindEnv("backpressure.reject_depth")
+	viper.BindEnv("backpressure.defer_delay")
+	viper.BindEnv("backpressure.retry_after")
+
+	viper.BindEnv("scheduler.enabled")
+	viper.BindEnv("scheduler.window")
+	viper.BindEnv("scheduler.concurrency")
+	viper.BindEnv("scheduler.per_user_concurrency")
+
+	viper.BindEnv("health.port")
+	viper.BindEnv("health.window")
+	viper.BindEnv("health.metrics_interval")
+	viper.BindEnv("health.max_lag")
+	viper.BindEnv("health.max_error_rate")
+	viper.BindEnv("health.max_silence")
+
+	viper.BindEnv("reconciler.enabled")
+	viper.BindEnv("reconciler.interval")
+	viper.BindEnv("reconciler.stale_after")
+	viper.BindEnv("reconciler.max_attempts")
+	viper.BindEnv("reconciler.batch_size")
+
+	viper.BindEnv("mq.driver")
+	viper.BindEnv("mq.compression.conversion_encoding")
+	viper.BindEnv("mq.compression.reply_encoding")
+	viper.BindEnv("mq.compression.dead_letter_encoding")
+	viper.BindEnv("mq.compression.min_size")
+	viper.BindEnv("mq.breaker.enabled")
+	viper.BindEnv("mq.breaker.failure_threshold")
+	viper.BindEnv("mq.breaker.open_timeout")
+	viper.BindEnv("mq.breaker.publish_timeout")
+	viper.BindEnv("mq.breaker.outbox_topic")
+	viper.BindEnv("mq.breaker.relay_interval")
+	viper.BindEnv("mq.dedup.enabled")
+	viper.BindEnv("mq.dedup.ttl")
+	viper.BindEnv("mq.dedup.cleanup_interval")
+	viper.BindEnv("mq.reply.instance")
+	viper.BindEnv("mq.reply.timeout")
+	viper.BindEnv("mq.sql.batch_size")
+	viper.BindEnv("mq.sql.poll_interval")
+	viper.BindEnv("mq.sql.lease_timeout")
+	viper.BindEnv("mq.sql.max_attempts")
+	viper.BindEnv("mq.sql.retry_backoff")
+	viper.BindEnv("mq.sql.audio_conversion.topic")
+	viper.BindEnv("mq.sql.audio_conversion.dead_letter_topic")
+	viper.BindEnv("mq.sql.audio_conversion.reply_topic")
+
+	viper.BindEnv("mq.nats.url")
+	viper.BindEnv("mq.nats.stream")
+	viper.BindEnv("mq.nats.ack_wait")
+	viper.BindEnv("mq.nats.max_deliver")
+	viper.BindEnv("mq.nats.retry_delay")
+	viper.BindEnv("mq.nats.audio_conversion.subject")
+	viper.BindEnv("mq.nats.audio_conversion.durable")
+	viper.BindEnv("mq.nats.audio_conversion.dead_letter_subject")
+	viper.BindEnv("mq.nats.audio_conversion.reply_subject")
+	viper.BindEnv("mq.nats.audio_conversion.retry_subject")
+
 	viper.BindEnv("mq.kafka.brokers")
+	viper.BindEnv("mq.kafka.client_id")
+	viper.BindEnv("mq.kafka.compression")
+	viper.BindEnv("mq.kafka.tls.enabled")
+	viper.BindEnv("mq.kafka.tls.ca_file")
+	viper.BindEnv("mq.kafka.tls.cert_file")
+	viper.BindEnv("mq.kafka.tls.key_file")
+	viper.BindEnv("mq.kafka.tls.insecure_skip_verify")
+	viper.BindEnv("mq.kafka.sasl.mechanism")
+	viper.BindEnv("mq.kafka.sasl.username")
+	viper.BindEnv("mq.kafka.sasl.password")
+	viper.BindEnv("mq.kafka.provision.enabled")
+	viper.BindEnv("mq.kafka.provision.replication_factor")
+	viper.BindEnv("mq.kafka.provision.timeout")
 	viper.BindEnv("mq.kafka.audio_conversion.group")
 	viper.BindEnv("mq.kafka.audio_conversion.topic")
+	viper.BindEnv("mq.kafka.audio_conversion.partition_key")
+	viper.BindEnv("mq.kafka.audio_conversion.dead_letter_topic")
+	viper.BindEnv("mq.kafka.audio_conversion.retry_topic")
+	viper.BindEnv("mq.kafka.audio_conversion.reply_topic")
+	viper.BindEnv("mq.kafka.audio_conversion.partitions")
+	viper.BindEnv("mq.kafka.audio_conversion.retention")
+	viper.BindEnv("mq.kafka.audio_conversion.dead_letter_retention")
+	viper.BindEnv("mq.kafka.audio_conversion.reply_retention")
 }
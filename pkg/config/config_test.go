@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phonon/pkg/converter"
+)
+
+// loadConfig reads the shipped config.yaml into viper
+func loadConfig(t *testing.T) {
+	t.Helper()
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigFile("../../config.yaml")
+	require.NoError(t, viper.ReadInConfig())
+}
+
+func TestConfig_Converter(t *testing.T) {
+	loadConfig(t)
+
+	assert.Equal(t, 5*time.Minute, viper.GetDuration("converter.timeout"))
+	assert.Equal(t, converter.SpectrogramConfig{
+		FFTSize:  512,
+		Hop:      128,
+		Scale:    converter.LinearScale,
+		ColorMap: "viridis",
+		Height:   256,
+		Range:    80,
+	}, converter.ConfiguredSpectrogram())
+	assert.False(t, viper.IsSet("spectrogram.timeout"), "converter settings stay out of the spectrogram section")
+}
+
+func TestConfig_ConverterLimits(t *testing.T) {
+	loadConfig(t)
+
+	assert.Equal(t, converter.Limits{
+		CPUTime:    2 * time.Minute,
+		Memory:     1 << 30,
+		OutputSize: 512 << 20,
+		OpenFiles:  64,
+	}, converter.ConfiguredLimits())
+	assert.Equal(t, []string{"file"}, viper.GetStringSlice("converter.protocols"))
+}
+
+func TestRedacted(t *testing.T) {
+	loadConfig(t)
+	viper.Set("mq.kafka.sasl.password", "broker-secret")
+	viper.Set("mq.kafka.tls.key_file", "/etc/phonon/client.key")
+
+	settings := Redacted()
+	assert.Equal(t, "[REDACTED]", settings["database.mysql.password"])
+	assert.Equal(t, "[REDACTED]", settings["mq.kafka.sasl.password"])
+	assert.Equal(t, "[REDACTED]", settings["mq.kafka.tls.key_file"])
+	assert.Equal(t, "", settings["mq.kafka.tls.ca_file"], "public settings are kept")
+	assert.Equal(t, "phonon", settings["database.mysql.username"])
+	assert.NotContains(t, fmt.Sprint(settings), "broker-secret")
+}
@@ -1,24 +1,10 @@
 package converter
 
-import "strings"
-
-type Format string
-
-const (
-	WAV Format = "WAV"
-	M4A Format = "M4A"
-)
-
-func IsValidAudioFormat(format string) bool {
-	switch Format(strings.ToUpper(format)) {
-	case WAV, M4A:
-		return true
-	default:
-		return false
-	}
-}
+import "context"
 
 // Audio is an interface for converting audio files.
 type Audio interface {
-	ConvertToStorageFormat(inputPath string) (string, error)
+	// ConvertToStorageFormat converts the file at inputPath and returns the path to the converted file.
+	// The conversion is abandoned when ctx is done, leaving no partial output behind.
+	ConvertToStorageFormat(ctx context.Context, inputPath string) (string, error)
 }
@@ -0,0 +1,172 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// ConfiguredProfile returns the profile named by converter.profile from converter.profiles. A profile is configured
+// either in the short notation of ParseProfile or as a map of format, codec, sample_rate, channels, sample_format,
+// bitrate and filters.
+func ConfiguredProfile() (Profile, error) {
+	return configuredProfile(viper.GetString("converter.profile"))
+}
+
+// configuredProfile returns the profile named name from converter.profiles
+func configuredProfile(name string) (Profile, error) {
+	key := "converter.profiles." + name
+	if name == "" || !viper.IsSet(key) {
+		return Profile{}, fmt.Errorf("%w: profile %q is not configured", ErrInvalidProfile, name)
+	}
+
+	if spec, ok := viper.Get(key).(string); ok {
+		return ParseProfile(name, spec)
+	}
+
+	sampleFormat, err := ParsePCMFormat(viper.GetString(key + ".sample_format"))
+	if err != nil {
+		return Profile{}, fmt.Errorf("%w %q: %w", ErrInvalidProfile, name, err)
+	}
+
+	return Profile{
+		Name:         name,
+		Format:       viper.GetString(key + ".format"),
+		Codec:        viper.GetString(key + ".codec"),
+		SampleRate:   viper.GetInt(key + ".sample_rate"),
+		Channels:     viper.GetInt(key + ".channels"),
+		SampleFormat: sampleFormat,
+		Bitrate:      viper.GetString(key + ".bitrate"),
+		Filters:      viper.GetStringSlice(key + ".filters"),
+	}, nil
+}
+
+// ConfiguredLimits returns the resource limits of ffmpeg configured in converter.limits
+func ConfiguredLimits() Limits {
+	return Limits{
+		CPUTime:    viper.GetDuration("converter.limits.cpu_time"),
+		Memory:     int64(viper.GetSizeInBytes("converter.limits.memory")),
+		OutputSize: int64(viper.GetSizeInBytes("converter.limits.output_size")),
+		OpenFiles:  viper.GetInt64("converter.limits.open_files"),
+	}
+}
+
+// configuredProtocols returns the protocols ffmpeg may open from converter.protocols, local files when unset
+func configuredProtocols() []string {
+	if protocols := viper.GetStringSlice("converter.protocols"); len(protocols) > 0 {
+		return protocols
+	}
+
+	return []string{defaultProtocol}
+}
+
+// NewAudio creates the converter applying profile, whose format must be one of formats.
+// With converter.native set, profiles producing plain WAV convert WAV input in Go and only other formats shell out to ffmpeg.
+func NewAudio(formats *Formats, profile Profile) (Audio, error) {
+	if err := profile.Validate(formats); err != nil {
+		return nil, err
+	}
+
+	ffmpeg := NewFFMPEG(profile.Format, FFMPEGWithProfile(profile), FFMPEGWithFormats(formats),
+		FFMPEGWithLimits(ConfiguredLimits()), FFMPEGWithProtocols(configuredProtocols()...))
+
+	if !viper.GetBool("converter.native") || !profile.native(formats) {
+		return ffmpeg, nil
+	}
+
+	return NewNative(NativeConfig{
+		SampleRate: profile.SampleRate,
+		Channels:   profile.Channels,
+		Format:     profile.SampleFormat,
+		Formats:    formats,
+	}, ffmpeg), nil
+}
+
+// NewProcessor creates the processing steps enabled in converter.normalize and converter.trim, or returns nil when
+// none is. The steps process WAV files, so profile must produce WAV.
+func NewProcessor(formats *Formats, profile Profile) (Processor, error) {
+	config := ProcessingConfig{
+		Normalize:        viper.GetBool("converter.normalize.enabled"),
+		TargetLoudness:   viper.GetFloat64("converter.normalize.target_loudness"),
+		PeakLimit:        viper.GetFloat64("converter.normalize.peak_limit"),
+		Trim:             viper.GetBool("converter.trim.enabled"),
+		SilenceThreshold: viper.GetFloat64("converter.trim.threshold"),
+		SilencePadding:   viper.GetDuration("converter.trim.padding"),
+	}
+	if !config.Normalize && !config.Trim {
+		return nil, nil
+	}
+
+	target, err := formats.Lookup(profile.Format)
+	if err != nil {
+		return nil, err
+	}
+	if target.Name != WAV {
+		return nil, fmt.Errorf("%w %q: loudness normalization and silence trimming need WAV output", ErrInvalidProfile, profile.Name)
+	}
+
+	return NewProcessing(config), nil
+}
+
+// ConfiguredWaveforms creates the waveform generator configured in converter.waveform, or returns nil when it is
+// disabled. Waveforms are computed from WAV files, so profile must produce WAV.
+func ConfiguredWaveforms(formats *Formats, profile Profile) (*Waveforms, error) {
+	if !viper.GetBool("converter.waveform.enabled") {
+		return nil, nil
+	}
+
+	target, err := formats.Lookup(profile.Format)
+	if err != nil {
+		return nil, err
+	}
+	if target.Name != WAV {
+		return nil, fmt.Errorf("%w %q: waveforms need WAV output", ErrInvalidProfile, profile.Name)
+	}
+
+	config := WaveformConfig{
+		Resolutions: viper.GetIntSlice("converter.waveform.resolutions"),
+		Bits:        viper.GetInt("converter.waveform.bits"),
+	}
+	if config.Bits != 0 && config.Bits != 8 && config.Bits != 16 {
+		return nil, fmt.Errorf("%w: %d bit peaks, expected 8 or 16", ErrInvalidWaveform, config.Bits)
+	}
+	for _, samplesPerPixel := range config.Resolutions {
+		if samplesPerPixel <= 0 {
+			return nil, fmt.Errorf("%w: resolution of %d samples per pixel", ErrInvalidWaveform, samplesPerPixel)
+		}
+	}
+
+	return NewWaveforms(config), nil
+}
+
+// ConfiguredSpectrogram returns the default spectrogram settings configured in spectrogram, requests may override them
+func ConfiguredSpectrogram() SpectrogramConfig {
+	return SpectrogramConfig{
+		FFTSize:  viper.GetInt("spectrogram.fft_size"),
+		Hop:      viper.GetInt("spectrogram.hop"),
+		Scale:    FrequencyScale(viper.GetString("spectrogram.scale")),
+		ColorMap: viper.GetString("spectrogram.color_map"),
+		Height:   viper.GetInt("spectrogram.height"),
+		Range:    viper.GetFloat64("spectrogram.range"),
+	}
+}
+
+// ConfiguredRenditions creates the generator of the renditions listed in converter.renditions, or returns nil when none
+// is. Renditions are encoded from masters converted with the master profile.
+func ConfiguredRenditions(formats *Formats, master Profile) (*Renditions, error) {
+	names := viper.GetStringSlice("converter.renditions")
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	profiles := make([]Profile, len(names))
+	for i, name := range names {
+		profile, err := configuredProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		profiles[i] = profile
+	}
+
+	return NewRenditions(formats, master, profiles, FFMPEGWithLimits(ConfiguredLimits()), FFMPEGWithProtocols(configuredProtocols()...))
+}
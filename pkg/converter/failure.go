@@ -0,0 +1,180 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxSummary is the longest summary kept of a failure, in bytes
+const maxSummary = 512
+
+// ErrorKind classifies why a conversion failed
+type ErrorKind string
+
+const (
+	CorruptInput     ErrorKind = "corrupt_input"     // The input is damaged, truncated or not audio
+	UnsupportedCodec ErrorKind = "unsupported_codec" // No decoder or encoder is available for a stream
+	IOFailure        ErrorKind = "io_error"          // Reading or writing a file failed
+	Timeout          ErrorKind = "timeout"           // The conversion did not finish in time
+	ResourceLimit    ErrorKind = "resource_limit"    // The converter exceeded its resource limits
+	UnknownFailure   ErrorKind = "unknown"
+)
+
+// stderrPatterns are the ffmpeg error messages of each kind of failure, matched case-insensitively in order
+var stderrPatterns = []struct {
+	kind     ErrorKind
+	messages []string
+}{
+	{IOFailure, []string{
+		"No such file or directory",
+		"Permission denied",
+		"No space left on device",
+		"Input/output error",
+		"Read-only file system",
+		"Broken pipe",
+	}},
+	{UnsupportedCodec, []string{
+		"Unknown encoder",
+		"Unknown decoder",
+		"Encoder not found",
+		"Decoder not found",
+		"Encoder (codec",
+		"Decoder (codec",
+		"not currently supported in container",
+		"Unsupported codec",
+	}},
+	{CorruptInput, []string{
+		"Invalid data found when processing input",
+		"moov atom not found",
+		"Could not find codec parameters",
+		"does not contain any stream",
+		"Error while decoding",
+		"Header missing",
+		"Invalid frame",
+		"not on whitelist",
+		"End of file",
+	}},
+}
+
+// ConversionError is a failed conversion with the reason reported by the converter
+type ConversionError struct {
+	Kind    ErrorKind
+	Summary string // The line of the converter output explaining the failure, empty when it gave none
+	Err     error
+}
+
+func (e *ConversionError) Error() string {
+	if e.Summary == "" {
+		return fmt.Sprintf("conversion failed (%s): %v", e.Kind, e.Err)
+	}
+
+	return fmt.Sprintf("conversion failed (%s): %s: %v", e.Kind, e.Summary, e.Err)
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// Reason returns the summary, or the underlying error when the converter reported none
+func (e *ConversionError) Reason() string {
+	if e.Summary != "" || e.Err == nil {
+		return e.Summary
+	}
+
+	return truncateSummary(e.Err.Error())
+}
+
+// Permanent reports whether the conversion is bound to fail the same way when retried with the same input
+func (e *ConversionError) Permanent() bool {
+	switch e.Kind {
+	case CorruptInput, UnsupportedCodec, ResourceLimit:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsPermanent reports whether err is a conversion failure retrying cannot fix
+func IsPermanent(err error) bool {
+	var conversionErr *ConversionError
+	return errors.As(err, &conversionErr) && conversionErr.Permanent()
+}
+
+// ffmpegError explains a failed ffmpeg run under limits by its context, the signal stopping it and its error output.
+// A signal not caused by the limits is transient, the error output of a killed ffmpeg does not explain its end.
+func ffmpegError(ctx context.Context, err error, stderr string, limits Limits) error {
+	if ctx.Err() != nil {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("ffmpeg interrupted: %w", context.Cause(ctx))
+		}
+		return &ConversionError{Kind: Timeout, Summary: "ffmpeg did not finish in time", Err: context.Cause(ctx)}
+	}
+
+	resource, signaled := signalLimit(err, limits)
+	if !signaled {
+		resource = stderrLimit(stderr)
+	}
+	if resource != "" {
+		return &ConversionError{
+			Kind:    ResourceLimit,
+			Summary: fmt.Sprintf("ffmpeg exceeded its %s limit", resource),
+			Err:     fmt.Errorf("%w: %w", ErrResourceLimit, err),
+		}
+	}
+
+	if signaled {
+		return &ConversionError{Kind: UnknownFailure, Summary: "ffmpeg was stopped by a signal", Err: err}
+	}
+
+	kind, summary := classifyStderr(stderr)
+	return &ConversionError{Kind: kind, Summary: summary, Err: err}
+}
+
+// classifyStderr returns the kind of failure the ffmpeg error output reports and the line reporting it, the last line
+// when no message is recognized
+func classifyStderr(stderr string) (ErrorKind, string) {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	for _, pattern := range stderrPatterns {
+		// the last errors are the closest to the failure, earlier ones may have been recovered from
+		for i := len(lines) - 1; i >= 0; i-- {
+			for _, message := range pattern.messages {
+				if strings.Contains(strings.ToLower(lines[i]), strings.ToLower(message)) {
+					return pattern.kind, truncateSummary(lines[i])
+				}
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		return UnknownFailure, ""
+	}
+
+	return UnknownFailure, truncateSummary(lines[len(lines)-1])
+}
+
+// truncateSummary cuts the summary to maxSummary bytes without splitting a character
+func truncateSummary(summary string) string {
+	if len(summary) <= maxSummary {
+		return summary
+	}
+
+	return strings.ToValidUTF8(summary[:maxSummary], "")
+}
+
+// decodeError classifies a failure of the native converter to decode the input, the decoder error explaining it
+func decodeError(err error) error {
+	kind := CorruptInput
+	if errors.Is(err, ErrUnsupportedWAV) {
+		kind = UnsupportedCodec
+	}
+
+	return &ConversionError{Kind: kind, Err: err}
+}
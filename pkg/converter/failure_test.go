@@ -0,0 +1,129 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyStderr(t *testing.T) {
+	tests := []struct {
+		name    string
+		stderr  string
+		kind    ErrorKind
+		summary string
+	}{
+		{
+			name:    "corrupt input",
+			stderr:  "[mov,mp4,m4a,3gp,3g2,mj2 @ 0x5581] moov atom not found\n/data/1/2/upload.m4a: Invalid data found when processing input\n",
+			kind:    CorruptInput,
+			summary: "/data/1/2/upload.m4a: Invalid data found when processing input",
+		},
+		{
+			name:    "unsupported codec",
+			stderr:  "Unknown encoder 'libfdk_aac'\n",
+			kind:    UnsupportedCodec,
+			summary: "Unknown encoder 'libfdk_aac'",
+		},
+		{
+			name:    "decoder missing",
+			stderr:  "[aac @ 0x1] Error while decoding stream #0:0\nDecoder (codec amr_wb) not found for input stream #0:0\n",
+			kind:    UnsupportedCodec,
+			summary: "Decoder (codec amr_wb) not found for input stream #0:0",
+		},
+		{
+			name:    "io error",
+			stderr:  "[aac @ 0x1] Error while decoding stream #0:0\n/data/1/2/upload.wav: No space left on device\n",
+			kind:    IOFailure,
+			summary: "/data/1/2/upload.wav: No space left on device",
+		},
+		{
+			name:    "unknown",
+			stderr:  "something odd\r\nConversion failed!\r\n\n",
+			kind:    UnknownFailure,
+			summary: "Conversion failed!",
+		},
+		{
+			name: "empty",
+			kind: UnknownFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, summary := classifyStderr(tt.stderr)
+			assert.Equal(t, tt.kind, kind)
+			assert.Equal(t, tt.summary, summary)
+		})
+	}
+
+	t.Run("long lines", func(t *testing.T) {
+		_, summary := classifyStderr(strings.Repeat("é", maxSummary))
+		assert.Len(t, summary, maxSummary)
+	})
+}
+
+func TestFFMPEGError(t *testing.T) {
+	exit := errors.New("exit status 1")
+
+	t.Run("classified", func(t *testing.T) {
+		err := ffmpegError(context.Background(), exit, "upload.m4a: Invalid data found when processing input\n", Limits{})
+		assert.EqualError(t, err, "conversion failed (corrupt_input): upload.m4a: Invalid data found when processing input: exit status 1")
+		assert.ErrorIs(t, err, exit)
+		assert.True(t, IsPermanent(err))
+	})
+
+	t.Run("resource limit", func(t *testing.T) {
+		err := ffmpegError(context.Background(), exit, "upload.m4a: Too many open files\n", Limits{})
+		assert.ErrorIs(t, err, ErrResourceLimit)
+		assert.True(t, IsPermanent(err))
+
+		var conversionErr *ConversionError
+		require.ErrorAs(t, err, &conversionErr)
+		assert.Equal(t, "ffmpeg exceeded its open files limit", conversionErr.Reason())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+
+		err := ffmpegError(ctx, exit, "", Limits{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.False(t, IsPermanent(err), "a timeout may pass on a less busy worker")
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := ffmpegError(ctx, exit, "", Limits{})
+		assert.ErrorIs(t, err, context.Canceled)
+		var conversionErr *ConversionError
+		assert.False(t, errors.As(err, &conversionErr), "stopping the worker is no conversion failure")
+	})
+}
+
+func TestConversionError(t *testing.T) {
+	for kind, permanent := range map[ErrorKind]bool{
+		CorruptInput:     true,
+		UnsupportedCodec: true,
+		ResourceLimit:    true,
+		IOFailure:        false,
+		Timeout:          false,
+		UnknownFailure:   false,
+	} {
+		err := fmt.Errorf("failed to encode rendition: %w", &ConversionError{Kind: kind, Err: errors.New("exit status 1")})
+		assert.Equal(t, permanent, IsPermanent(err), kind)
+	}
+	assert.False(t, IsPermanent(errors.New("failed to open input")), "other errors are transient")
+
+	decoded := decodeError(fmt.Errorf("%w: missing data chunk", ErrInvalidWAV))
+	assert.EqualError(t, decoded, "conversion failed (corrupt_input): invalid wav file: missing data chunk")
+	assert.Equal(t, "invalid wav file: missing data chunk", decoded.(*ConversionError).Reason())
+	assert.Equal(t, UnsupportedCodec, decodeError(fmt.Errorf("%w: format tag 0x55", ErrUnsupportedWAV)).(*ConversionError).Kind)
+}
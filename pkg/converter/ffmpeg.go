@@ -1,41 +1,197 @@
 package converter
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const defaultTargetFormat = "wav"
+const (
+	defaultTargetFormat = "wav"
+
+	// processWaitDelay bounds the wait for the output pipes of a killed ffmpeg to close
+	processWaitDelay = 5 * time.Second
+)
 
 // FFMPEG is an implementation of audio converter using ffmpeg
 type FFMPEG struct {
 	targetFormat string
+	sampleRate   int
+	channels     int
+	pcmFormat    PCMFormat
+	codec        string
+	bitrate      string
+	filters      []string
+	formats      *Formats
+	limits       Limits
+	protocols    []string
+}
+
+// FFMPEGOption configures the FFMPEG converter
+type FFMPEGOption func(*FFMPEG)
+
+// FFMPEGWithSampleRate resamples the output to sampleRate, the input rate is kept when zero
+func FFMPEGWithSampleRate(sampleRate int) FFMPEGOption {
+	return func(f *FFMPEG) {
+		f.sampleRate = sampleRate
+	}
+}
+
+// FFMPEGWithChannels remixes the output to channels, the input channels are kept when zero
+func FFMPEGWithChannels(channels int) FFMPEGOption {
+	return func(f *FFMPEG) {
+		f.channels = channels
+	}
+}
+
+// FFMPEGWithPCMFormat encodes WAV output with format, ffmpeg picks the encoding when its BitDepth is zero
+func FFMPEGWithPCMFormat(format PCMFormat) FFMPEGOption {
+	return func(f *FFMPEG) {
+		f.pcmFormat = format
+	}
+}
+
+// FFMPEGWithProfile applies the settings of a transcoding profile, replacing the target format
+func FFMPEGWithProfile(profile Profile) FFMPEGOption {
+	return func(f *FFMPEG) {
+		f.targetFormat = profile.Format
+		f.sampleRate = profile.SampleRate
+		f.channels = profile.Channels
+		f.pcmFormat = profile.SampleFormat
+		f.codec = profile.Codec
+		f.bitrate = profile.Bitrate
+		f.filters = profile.Filters
+	}
+}
+
+// FFMPEGWithFormats looks the target format up in formats instead of the built-in formats
+func FFMPEGWithFormats(formats *Formats) FFMPEGOption {
+	return func(f *FFMPEG) {
+		f.formats = formats
+	}
+}
+
+// FFMPEGWithLimits runs ffmpeg under resource limits, a conversion exceeding them fails with ErrResourceLimit
+func FFMPEGWithLimits(limits Limits) FFMPEGOption {
+	return func(f *FFMPEG) {
+		f.limits = limits
+	}
+}
+
+// FFMPEGWithProtocols sets the protocols ffmpeg may open, only local files by default so inputs such as playlists
+// cannot make it fetch URLs
+func FFMPEGWithProtocols(protocols ...string) FFMPEGOption {
+	return func(f *FFMPEG) {
+		f.protocols = protocols
+	}
 }
 
 // NewFFMPEG returns a new instance of FFmpegConverter
-func NewFFMPEG(targetFormat string) Audio {
-	ffmpeg := &FFMPEG{targetFormat: targetFormat}
+func NewFFMPEG(targetFormat string, opts ...FFMPEGOption) Audio {
+	return newFFMPEG(targetFormat, opts...)
+}
+
+func newFFMPEG(targetFormat string, opts ...FFMPEGOption) *FFMPEG {
+	ffmpeg := &FFMPEG{targetFormat: targetFormat, formats: DefaultFormats(), protocols: []string{defaultProtocol}}
 	if ffmpeg.targetFormat == "" {
 		ffmpeg.targetFormat = defaultTargetFormat
 	}
 
+	for _, opt := range opts {
+		opt(ffmpeg)
+	}
+
 	return ffmpeg
 }
 
-// ConvertToStorageFormat converts the audio file to the storage format using ffmpeg and returns the path to the converted file
-func (f *FFMPEG) ConvertToStorageFormat(inputPath string) (string, error) {
+// ConvertToStorageFormat converts the audio file to the storage format using ffmpeg and returns the path to the converted file.
+// ffmpeg and its children are killed when ctx is done, and the partial output is removed.
+func (f *FFMPEG) ConvertToStorageFormat(ctx context.Context, inputPath string) (string, error) {
+	target, err := f.formats.Lookup(f.targetFormat)
+	if err != nil {
+		return "", err
+	}
+	outputPath := storagePath(inputPath, target.Extension())
+
+	if err = f.convert(ctx, inputPath, outputPath, target); err != nil {
+		return "", err
+	}
+
+	return outputPath, nil
+}
+
+// convert runs ffmpeg encoding inputPath to outputPath in the target format within the limits.
+// A failure is returned as a ConversionError classified by the error output of ffmpeg.
+func (f *FFMPEG) convert(ctx context.Context, inputPath, outputPath string, target *FormatDescriptor) error {
+	stderr := &tailBuffer{size: stderrTail}
+	cmd := limitedCommand(ctx, f.limits, "ffmpeg", f.args(inputPath, outputPath, target)...)
+	cmd.Stderr = stderr
+	killProcessGroup(cmd)
+	cmd.WaitDelay = processWaitDelay
+
+	if err := cmd.Run(); err != nil {
+		os.Remove(outputPath)
+		return ffmpegError(ctx, err, stderr.String(), f.limits)
+	}
+
+	return nil
+}
+
+// args returns the ffmpeg arguments encoding inputPath to outputPath in the target format
+func (f *FFMPEG) args(inputPath, outputPath string, target *FormatDescriptor) []string {
+	// Never wait for input on stdin, only log errors so they explain failures, and drop video streams such as WebM
+	// video tracks and embedded cover art
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y"}
+	if len(f.protocols) > 0 {
+		args = append(args, "-protocol_whitelist", strings.Join(f.protocols, ","))
+	}
+	args = append(args, "-i", inputPath, "-vn")
+	if len(f.filters) > 0 {
+		args = append(args, "-af", strings.Join(f.filters, ","))
+	}
+	if f.sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(f.sampleRate))
+	}
+	if f.channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(f.channels))
+	}
+	switch {
+	case f.codec != "":
+		args = append(args, "-c:a", f.codec)
+	case f.pcmFormat.BitDepth > 0 && target.Name == WAV:
+		args = append(args, "-c:a", "pcm_"+f.pcmFormat.String()+pcmEndianness(f.pcmFormat))
+	default:
+		args = append(args, target.EncoderArgs...)
+	}
+	if f.bitrate != "" {
+		args = append(args, "-b:a", f.bitrate)
+	}
+
+	return append(args, outputPath)
+}
+
+// storagePath returns the path of the converted file next to the input, which keeps the original
+// when it already has the target format
+func storagePath(inputPath, targetFormat string) string {
 	fileExt := filepath.Ext(inputPath)
 	pathWithoutExt := strings.TrimSuffix(inputPath, fileExt)
 
-	outputPath := fmt.Sprintf("%s.%s", pathWithoutExt, f.targetFormat)
+	if strings.EqualFold(strings.TrimPrefix(fileExt, "."), targetFormat) {
+		return fmt.Sprintf("%s.converted.%s", pathWithoutExt, targetFormat)
+	}
 
-	cmd := exec.Command("ffmpeg", "-y", "-i", inputPath, outputPath)
-	err := cmd.Run()
-	if err != nil {
-		return "", err
+	return fmt.Sprintf("%s.%s", pathWithoutExt, targetFormat)
+}
+
+// pcmEndianness returns the suffix of the little endian ffmpeg PCM codecs, 8 bit samples have none
+func pcmEndianness(format PCMFormat) string {
+	if format.BitDepth == 8 {
+		return ""
 	}
 
-	return outputPath, nil
+	return "le"
 }
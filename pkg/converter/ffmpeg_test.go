@@ -0,0 +1,69 @@
+package converter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFFMPEG_Args(t *testing.T) {
+	formats := DefaultFormats()
+
+	tests := []struct {
+		name     string
+		target   string
+		opts     []FFMPEGOption
+		expected []string
+	}{
+		{
+			name:     "wav",
+			target:   "wav",
+			expected: []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-protocol_whitelist", "file", "-i", "in.m4a", "-vn", "out"},
+		},
+		{
+			name:     "wav with sample format",
+			target:   "wav",
+			opts:     []FFMPEGOption{FFMPEGWithSampleRate(16000), FFMPEGWithChannels(1), FFMPEGWithPCMFormat(PCMFormat{BitDepth: 16})},
+			expected: []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-protocol_whitelist", "file", "-i", "in.m4a", "-vn", "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", "out"},
+		},
+		{
+			name:     "opus",
+			target:   "ogg",
+			opts:     []FFMPEGOption{FFMPEGWithPCMFormat(PCMFormat{BitDepth: 16})},
+			expected: []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-protocol_whitelist", "file", "-i", "in.m4a", "-vn", "-c:a", "libopus", "out"},
+		},
+		{
+			name:   "profile",
+			target: "wav",
+			opts: []FFMPEGOption{FFMPEGWithProfile(Profile{
+				Format:  "m4a",
+				Codec:   "libfdk_aac",
+				Bitrate: "64k",
+				Filters: []string{"highpass=f=80", "loudnorm"},
+			})},
+			expected: []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-protocol_whitelist", "file", "-i", "in.m4a", "-vn", "-af", "highpass=f=80,loudnorm", "-c:a", "libfdk_aac", "-b:a", "64k", "out"},
+		},
+		{
+			name:     "mp3",
+			target:   "mp3",
+			expected: []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-protocol_whitelist", "file", "-i", "in.m4a", "-vn", "-c:a", "libmp3lame", "out"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := formats.Lookup(tt.target)
+			require.NoError(t, err)
+
+			ffmpeg := NewFFMPEG(tt.target, tt.opts...).(*FFMPEG)
+			assert.Equal(t, tt.expected, ffmpeg.args("in.m4a", "out", target))
+		})
+	}
+}
+
+func TestFFMPEG_UnsupportedTarget(t *testing.T) {
+	_, err := NewFFMPEG("aiff").ConvertToStorageFormat(context.Background(), "/tmp/upload.m4a")
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
@@ -0,0 +1,224 @@
+//go:build unix
+
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFFMPEG puts an ffmpeg script first on PATH. The script writes partial output, records the pid of a child
+// it spawns in pidFile, and hangs.
+func fakeFFMPEG(t *testing.T, pidFile string) {
+	t.Helper()
+
+	bin := t.TempDir()
+	script := `#!/bin/sh
+for last; do :; done
+echo partial > "$last"
+sleep 60 &
+echo $! > ` + pidFile + `
+wait
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestFFMPEG_ConvertToStorageFormat_Timeout(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	fakeFFMPEG(t, pidFile)
+
+	inputPath := filepath.Join(dir, "upload.m4a")
+	require.NoError(t, os.WriteFile(inputPath, []byte("m4a"), 0o644))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := NewFFMPEG("wav").ConvertToStorageFormat(ctx, inputPath)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, IsPermanent(err), "timeouts are retried")
+	assert.Less(t, time.Since(started), processWaitDelay, "ffmpeg is killed instead of waited for")
+	assert.NoFileExists(t, filepath.Join(dir, "upload.wav"), "partial output is removed")
+
+	pid, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	child, err := strconv.Atoi(strings.TrimSpace(string(pid)))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return !running(child)
+	}, time.Second, 10*time.Millisecond, "children of ffmpeg are killed with it")
+}
+
+// running reports whether the process exists and is not a zombie waiting to be reaped by an init that may never do so
+func running(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func TestRenditions_Generate(t *testing.T) {
+	bin := t.TempDir()
+	// writes the arguments to the output, the last argument
+	script := `#!/bin/sh
+for last; do :; done
+echo "$@" > "$last"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "upload.converted.wav")
+	require.NoError(t, os.WriteFile(masterPath, []byte("master"), 0o644))
+
+	renditions, err := NewRenditions(DefaultFormats(), Profile{Name: "storage_master", Format: "wav"},
+		[]Profile{{Name: "playback", Format: "m4a", Bitrate: "64k"}, {Name: "web", Format: "ogg", Bitrate: "32k"}})
+	require.NoError(t, err)
+
+	generated, err := renditions.Generate(context.Background(), masterPath)
+	require.NoError(t, err)
+	require.Len(t, generated, 3)
+	assert.Equal(t, "storage_master", generated[0].Profile)
+
+	playback := filepath.Join(dir, "upload.converted.playback.m4a")
+	assert.Equal(t, Rendition{Profile: "playback", Format: M4A, Path: playback}, Rendition{Profile: generated[1].Profile, Format: generated[1].Format, Path: generated[1].Path})
+	args, err := os.ReadFile(playback)
+	require.NoError(t, err)
+	assert.Equal(t, "-nostdin -hide_banner -loglevel error -y -protocol_whitelist file -i "+masterPath+" -vn -c:a aac -b:a 64k "+playback+"\n", string(args))
+	assert.Equal(t, int64(len(args)), generated[1].Size)
+	assert.Len(t, generated[1].Checksum, 64)
+
+	assert.Equal(t, OGG, generated[2].Format)
+	assert.Equal(t, filepath.Join(dir, "upload.converted.web.ogg"), generated[2].Path)
+}
+
+func TestRenditions_Generate_Failures(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	// encodes ogg and fails m4a with the given error output
+	fake := func(stderr string) {
+		script := `#!/bin/sh
+for last; do :; done
+case "$last" in
+*.m4a) echo "` + stderr + `" >&2; exit 1 ;;
+*) echo encoded > "$last" ;;
+esac
+`
+		require.NoError(t, os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755))
+	}
+
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "upload.converted.wav")
+	require.NoError(t, os.WriteFile(masterPath, []byte("master"), 0o644))
+
+	renditions, err := NewRenditions(DefaultFormats(), Profile{Name: "storage_master", Format: "wav"},
+		[]Profile{{Name: "web", Format: "ogg"}, {Name: "playback", Format: "m4a"}})
+	require.NoError(t, err)
+
+	t.Run("permanent failures only fail their rendition", func(t *testing.T) {
+		fake("Unknown encoder 'aac'")
+
+		generated, err := renditions.Generate(context.Background(), masterPath)
+		require.NoError(t, err)
+		require.Len(t, generated, 3)
+		assert.NoError(t, generated[1].Err)
+		assert.FileExists(t, generated[1].Path)
+
+		failed := generated[2]
+		assert.Equal(t, "playback", failed.Profile)
+		assert.Equal(t, M4A, failed.Format)
+		assert.Empty(t, failed.Path)
+		var conversionErr *ConversionError
+		require.ErrorAs(t, failed.Err, &conversionErr)
+		assert.Equal(t, UnsupportedCodec, conversionErr.Kind)
+	})
+
+	t.Run("other failures remove the encoded renditions", func(t *testing.T) {
+		fake("upload.converted.playback.m4a: No space left on device")
+
+		generated, err := renditions.Generate(context.Background(), masterPath)
+		assert.Error(t, err)
+		assert.False(t, IsPermanent(err))
+		assert.Nil(t, generated)
+		assert.NoFileExists(t, filepath.Join(dir, "upload.converted.web.ogg"))
+		assert.FileExists(t, masterPath, "the master is left to the caller")
+	})
+}
+
+func TestFFMPEG_ConvertToStorageFormat_Limits(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	fake := func(script string) {
+		require.NoError(t, os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte("#!/bin/sh\nfor last; do :; done\n"+script), 0o755))
+	}
+
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "upload.m4a")
+	require.NoError(t, os.WriteFile(inputPath, []byte("m4a"), 0o644))
+
+	t.Run("output size", func(t *testing.T) {
+		fake(`exec head -c 1048576 /dev/zero > "$last"` + "\n")
+
+		_, err := NewFFMPEG("wav", FFMPEGWithLimits(Limits{OutputSize: 64 * 1024})).ConvertToStorageFormat(context.Background(), inputPath)
+		assert.ErrorIs(t, err, ErrResourceLimit)
+		assert.ErrorContains(t, err, "output size")
+		assert.True(t, IsPermanent(err))
+		assert.NoFileExists(t, filepath.Join(dir, "upload.wav"), "partial output is removed")
+	})
+
+	t.Run("open files", func(t *testing.T) {
+		fake(`[ "$(ulimit -n)" = 16 ] || exit 3
+echo "$last: Too many open files" >&2
+exit 1
+`)
+
+		_, err := NewFFMPEG("wav", FFMPEGWithLimits(Limits{OpenFiles: 16})).ConvertToStorageFormat(context.Background(), inputPath)
+		assert.ErrorIs(t, err, ErrResourceLimit)
+		assert.ErrorContains(t, err, "open files")
+	})
+
+	t.Run("killed", func(t *testing.T) {
+		fake("echo 'Invalid data found when processing input' >&2\nkill -KILL $$\n")
+
+		_, err := NewFFMPEG("wav").ConvertToStorageFormat(context.Background(), inputPath)
+		assert.NotErrorIs(t, err, ErrResourceLimit)
+		assert.False(t, IsPermanent(err), "an OOM kill of a busy host is retried")
+
+		_, err = NewFFMPEG("wav", FFMPEGWithLimits(Limits{Memory: 1 << 30})).ConvertToStorageFormat(context.Background(), inputPath)
+		assert.False(t, IsPermanent(err), "the memory limit never kills")
+
+		_, err = NewFFMPEG("wav", FFMPEGWithLimits(Limits{CPUTime: time.Minute})).ConvertToStorageFormat(context.Background(), inputPath)
+		assert.ErrorIs(t, err, ErrResourceLimit, "the hard CPU limit kills")
+	})
+
+	t.Run("other failures", func(t *testing.T) {
+		fake(`echo "$last: Invalid data found when processing input" >&2` + "\nexit 1\n")
+
+		_, err := NewFFMPEG("wav", FFMPEGWithLimits(Limits{CPUTime: time.Minute})).ConvertToStorageFormat(context.Background(), inputPath)
+		assert.NotErrorIs(t, err, ErrResourceLimit)
+
+		var conversionErr *ConversionError
+		require.ErrorAs(t, err, &conversionErr)
+		assert.Equal(t, CorruptInput, conversionErr.Kind)
+		assert.Equal(t, filepath.Join(dir, "upload.wav")+": Invalid data found when processing input", conversionErr.Summary)
+	})
+}
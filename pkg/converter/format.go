@@ -0,0 +1,345 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Format is the upper case name of an audio format
+type Format string
+
+const (
+	WAV  Format = "WAV"
+	M4A  Format = "M4A"
+	MP3  Format = "MP3"
+	OGG  Format = "OGG"
+	FLAC Format = "FLAC"
+	WEBM Format = "WEBM"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// Signature is a byte pattern at a fixed offset of a file header. When Mask is set,
+// only the bits it selects are compared.
+type Signature struct {
+	Offset int
+	Bytes  []byte
+	Mask   []byte
+}
+
+// match reports whether the header starts with the signature
+func (s Signature) match(header []byte) bool {
+	if len(header) < s.Offset+len(s.Bytes) {
+		return false
+	}
+
+	for i, b := range s.Bytes {
+		h := header[s.Offset+i]
+		if s.Mask != nil {
+			h &= s.Mask[i]
+		}
+		if h != b {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FormatDescriptor describes how an audio format is recognized and encoded
+type FormatDescriptor struct {
+	Name Format
+	// Extensions are the lower case file extensions of the format, the first names converted files
+	Extensions []string
+	// MIMETypes are the media types of the format, the first is used when serving files
+	MIMETypes []string
+	// Magic lists the header signatures of the format, a header matching any of them is of the format
+	Magic []Signature
+	// EncoderArgs are the ffmpeg output arguments selecting the encoder of the format
+	EncoderArgs []string
+}
+
+// Extension returns the file extension of converted files
+func (d *FormatDescriptor) Extension() string {
+	if len(d.Extensions) == 0 {
+		return strings.ToLower(string(d.Name))
+	}
+
+	return d.Extensions[0]
+}
+
+// ContentType returns the media type served for files of the format
+func (d *FormatDescriptor) ContentType() string {
+	if len(d.MIMETypes) == 0 {
+		return "application/octet-stream"
+	}
+
+	return d.MIMETypes[0]
+}
+
+// Matches reports whether a file header is of the format, formats without signatures match any header
+func (d *FormatDescriptor) Matches(header []byte) bool {
+	if len(d.Magic) == 0 {
+		return true
+	}
+
+	for _, signature := range d.Magic {
+		if signature.match(header) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerLength returns the number of header bytes needed to match the signatures
+func (d *FormatDescriptor) headerLength() int {
+	length := 0
+	for _, signature := range d.Magic {
+		length = max(length, signature.Offset+len(signature.Bytes))
+	}
+
+	return length
+}
+
+// Formats selects format descriptors by name, file extension, media type or file header
+type Formats struct {
+	descriptors  []*FormatDescriptor
+	byName       map[Format]*FormatDescriptor
+	byExtension  map[string]*FormatDescriptor
+	byMIMEType   map[string]*FormatDescriptor
+	headerLength int
+}
+
+// NewFormats creates a format registry
+func NewFormats(descriptors ...FormatDescriptor) *Formats {
+	f := &Formats{
+		byName:      make(map[Format]*FormatDescriptor, len(descriptors)),
+		byExtension: make(map[string]*FormatDescriptor, len(descriptors)),
+		byMIMEType:  make(map[string]*FormatDescriptor, len(descriptors)),
+	}
+	for _, descriptor := range descriptors {
+		f.Register(descriptor)
+	}
+
+	return f
+}
+
+// DefaultFormats returns a registry of the built-in formats
+func DefaultFormats() *Formats {
+	return NewFormats(
+		FormatDescriptor{
+			Name:       WAV,
+			Extensions: []string{"wav", "wave"},
+			MIMETypes:  []string{"audio/wav", "audio/x-wav", "audio/wave", "audio/vnd.wave"},
+			Magic: []Signature{{
+				Bytes: []byte("RIFF\x00\x00\x00\x00WAVE"),
+				Mask:  []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff},
+			}},
+		},
+		FormatDescriptor{
+			Name:        M4A,
+			Extensions:  []string{"m4a", "mp4"},
+			MIMETypes:   []string{"audio/mp4", "audio/x-m4a", "audio/m4a"},
+			Magic:       []Signature{{Offset: 4, Bytes: []byte("ftyp")}},
+			EncoderArgs: []string{"-c:a", "aac"},
+		},
+		FormatDescriptor{
+			Name:       MP3,
+			Extensions: []string{"mp3"},
+			MIMETypes:  []string{"audio/mpeg", "audio/mp3"},
+			Magic: []Signature{
+				{Bytes: []byte("ID3")},
+				// MPEG audio frame sync
+				{Bytes: []byte{0xff, 0xe0}, Mask: []byte{0xff, 0xe0}},
+			},
+			EncoderArgs: []string{"-c:a", "libmp3lame"},
+		},
+		FormatDescriptor{
+			Name:        OGG,
+			Extensions:  []string{"ogg", "opus", "oga"},
+			MIMETypes:   []string{"audio/ogg", "audio/opus", "application/ogg"},
+			Magic:       []Signature{{Bytes: []byte("OggS")}},
+			EncoderArgs: []string{"-c:a", "libopus"},
+		},
+		FormatDescriptor{
+			Name:        FLAC,
+			Extensions:  []string{"flac"},
+			MIMETypes:   []string{"audio/flac", "audio/x-flac"},
+			Magic:       []Signature{{Bytes: []byte("fLaC")}},
+			EncoderArgs: []string{"-c:a", "flac"},
+		},
+		FormatDescriptor{
+			Name:       WEBM,
+			Extensions: []string{"webm"},
+			MIMETypes:  []string{"audio/webm", "video/webm"},
+			// EBML header
+			Magic:       []Signature{{Bytes: []byte{0x1a, 0x45, 0xdf, 0xa3}}},
+			EncoderArgs: []string{"-c:a", "libopus"},
+		},
+	)
+}
+
+// Register adds a format, replacing any format registered under the same name, extension or media type
+func (f *Formats) Register(descriptor FormatDescriptor) {
+	d := &descriptor
+	d.Name = Format(strings.ToUpper(string(d.Name)))
+
+	if previous, ok := f.byName[d.Name]; ok {
+		for i, registered := range f.descriptors {
+			if registered == previous {
+				f.descriptors = append(f.descriptors[:i], f.descriptors[i+1:]...)
+				break
+			}
+		}
+	}
+	f.descriptors = append(f.descriptors, d)
+
+	f.byName[d.Name] = d
+	for _, extension := range d.Extensions {
+		f.byExtension[strings.ToLower(extension)] = d
+	}
+	for _, mimeType := range d.MIMETypes {
+		f.byMIMEType[strings.ToLower(mimeType)] = d
+	}
+	f.headerLength = max(f.headerLength, d.headerLength())
+}
+
+// Lookup returns the format of a file extension or format name, ignoring case and a leading dot
+func (f *Formats) Lookup(name string) (*FormatDescriptor, error) {
+	name = strings.TrimPrefix(name, ".")
+	if d, ok := f.byExtension[strings.ToLower(name)]; ok {
+		return d, nil
+	}
+	if d, ok := f.byName[Format(strings.ToUpper(name))]; ok {
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, name)
+}
+
+// LookupMIMEType returns the format of a media type, ignoring parameters such as codecs
+func (f *Formats) LookupMIMEType(contentType string) (*FormatDescriptor, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, contentType)
+	}
+
+	d, ok := f.byMIMEType[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, contentType)
+	}
+
+	return d, nil
+}
+
+// Negotiate returns the candidate format of the highest quality in an Accept header, the first candidate winning ties.
+// An empty header accepts any candidate.
+func (f *Formats) Negotiate(accept string, candidates []Format) (*FormatDescriptor, error) {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	ranges := parseAccept(accept)
+
+	var best *FormatDescriptor
+	bestQuality := 0.0
+	for _, candidate := range candidates {
+		d, ok := f.byName[candidate]
+		if !ok {
+			continue
+		}
+		if quality := d.quality(ranges); quality > bestQuality {
+			best, bestQuality = d, quality
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: none of %v is acceptable for %q", ErrUnsupportedFormat, candidates, accept)
+	}
+
+	return best, nil
+}
+
+// mediaRange is a media range of an Accept header
+type mediaRange struct {
+	mediaType string // type/subtype, either part may be *
+	quality   float64
+}
+
+// parseAccept parses the media ranges of an Accept header, skipping malformed ones
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !strings.Contains(mediaType, "/") {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil || quality < 0 || quality > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+
+	return ranges
+}
+
+// quality returns the quality the most specific matching media range gives to the format, zero when none matches
+func (d *FormatDescriptor) quality(ranges []mediaRange) float64 {
+	quality, specificity := 0.0, -1
+	for _, mimeType := range d.MIMETypes {
+		mainType, _, _ := strings.Cut(strings.ToLower(mimeType), "/")
+		for _, r := range ranges {
+			var s int
+			switch r.mediaType {
+			case strings.ToLower(mimeType):
+				s = 2
+			case mainType + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s > specificity || (s == specificity && r.quality > quality) {
+				quality, specificity = r.quality, s
+			}
+		}
+	}
+
+	return quality
+}
+
+// Detect returns the first registered format whose signatures match the file header
+func (f *Formats) Detect(header []byte) (*FormatDescriptor, error) {
+	for _, d := range f.descriptors {
+		if len(d.Magic) > 0 && d.Matches(header) {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unrecognized header % x", ErrUnsupportedFormat, header[:min(len(header), 8)])
+}
+
+// HeaderLength returns the number of header bytes Detect and Matches need to recognize every registered format
+func (f *Formats) HeaderLength() int {
+	return f.headerLength
+}
+
+// IsSupported reports whether a file extension or format name is registered
+func (f *Formats) IsSupported(name string) bool {
+	_, err := f.Lookup(name)
+	return err == nil
+}
+
+// isWAV reports whether the path has an extension of the WAV format
+func (f *Formats) isWAV(path string) bool {
+	d, err := f.Lookup(filepath.Ext(path))
+	return err == nil && d.Name == WAV
+}
@@ -0,0 +1,165 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormats_Lookup(t *testing.T) {
+	formats := DefaultFormats()
+
+	tests := []struct {
+		name     string
+		format   string
+		expected Format
+	}{
+		{name: "WAV extension", format: "wav", expected: WAV},
+		{name: "M4A extension", format: "m4a", expected: M4A},
+		{name: "uppercase extension", format: "WAV", expected: WAV},
+		{name: "leading dot", format: ".flac", expected: FLAC},
+		{name: "MP3 extension", format: "mp3", expected: MP3},
+		{name: "Opus extension", format: "opus", expected: OGG},
+		{name: "OGG extension", format: "ogg", expected: OGG},
+		{name: "WebM extension", format: "webm", expected: WEBM},
+		{name: "format name", format: "WEBM", expected: WEBM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			descriptor, err := formats.Lookup(tt.format)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, descriptor.Name)
+			assert.True(t, formats.IsSupported(tt.format))
+		})
+	}
+
+	for _, format := range []string{"", "aiff", "txt"} {
+		_, err := formats.Lookup(format)
+		assert.ErrorIs(t, err, ErrUnsupportedFormat, format)
+		assert.False(t, formats.IsSupported(format))
+	}
+}
+
+func TestFormats_LookupMIMEType(t *testing.T) {
+	formats := DefaultFormats()
+
+	descriptor, err := formats.LookupMIMEType(`audio/webm;codecs="opus"`)
+	require.NoError(t, err)
+	assert.Equal(t, WEBM, descriptor.Name)
+
+	descriptor, err = formats.LookupMIMEType("audio/x-wav")
+	require.NoError(t, err)
+	assert.Equal(t, WAV, descriptor.Name)
+	assert.Equal(t, "audio/wav", descriptor.ContentType())
+
+	_, err = formats.LookupMIMEType("text/plain")
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestFormats_Detect(t *testing.T) {
+	formats := DefaultFormats()
+
+	tests := []struct {
+		name     string
+		header   []byte
+		expected Format
+	}{
+		{name: "WAV", header: []byte("RIFF\x24\x08\x00\x00WAVEfmt "), expected: WAV},
+		{name: "M4A", header: []byte("\x00\x00\x00\x20ftypM4A "), expected: M4A},
+		{name: "MP3 with ID3 tag", header: []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), expected: MP3},
+		{name: "MP3 frame", header: []byte{0xff, 0xfb, 0x90, 0x64}, expected: MP3},
+		{name: "OGG", header: []byte("OggS\x00\x02\x00\x00"), expected: OGG},
+		{name: "FLAC", header: []byte("fLaC\x00\x00\x00\x22"), expected: FLAC},
+		{name: "WebM", header: []byte{0x1a, 0x45, 0xdf, 0xa3, 0x9f, 0x42, 0x86, 0x81}, expected: WEBM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			descriptor, err := formats.Detect(tt.header)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, descriptor.Name)
+			assert.True(t, descriptor.Matches(tt.header))
+			assert.LessOrEqual(t, descriptor.headerLength(), formats.HeaderLength())
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := formats.Detect([]byte("RIFF\x24\x08\x00\x00AVI LIST"))
+		assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+		_, err = formats.Detect(nil)
+		assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		wav, err := formats.Lookup("wav")
+		require.NoError(t, err)
+		assert.False(t, wav.Matches([]byte("OggS\x00\x02\x00\x00")))
+		assert.False(t, wav.Matches([]byte("RIFF")), "truncated header")
+	})
+}
+
+func TestFormats_Register(t *testing.T) {
+	formats := DefaultFormats()
+	formats.Register(FormatDescriptor{
+		Name:        "3gp",
+		Extensions:  []string{"3gp", "3gpp"},
+		MIMETypes:   []string{"audio/3gpp"},
+		Magic:       []Signature{{Offset: 4, Bytes: []byte("ftyp3gp")}},
+		EncoderArgs: []string{"-c:a", "aac"},
+	})
+
+	descriptor, err := formats.Lookup("3gpp")
+	require.NoError(t, err)
+	assert.Equal(t, Format("3GP"), descriptor.Name)
+	assert.Equal(t, "3gp", descriptor.Extension())
+
+	t.Run("replaces formats of the same name", func(t *testing.T) {
+		formats.Register(FormatDescriptor{Name: MP3, Extensions: []string{"mp3"}, EncoderArgs: []string{"-c:a", "libshine"}})
+
+		descriptor, err := formats.Lookup("mp3")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"-c:a", "libshine"}, descriptor.EncoderArgs)
+		assert.True(t, descriptor.Matches([]byte("anything")), "formats without signatures match any header")
+
+		_, err = formats.Detect([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"))
+		assert.ErrorIs(t, err, ErrUnsupportedFormat, "the replaced signatures are gone")
+	})
+}
+
+func TestFormats_Negotiate(t *testing.T) {
+	formats := DefaultFormats()
+	candidates := []Format{WAV, M4A, OGG}
+
+	tests := []struct {
+		accept string
+		want   Format
+	}{
+		{"", WAV},
+		{"*/*", WAV},
+		{"audio/mp4", M4A},
+		{"audio/x-m4a", M4A},
+		{"audio/*", WAV},
+		{"audio/ogg, audio/mp4;q=0.9", OGG},
+		{"audio/wav;q=0.2, audio/*;q=0.5", M4A},
+		{"audio/opus;q=0.8, audio/mp4;q=0.8, */*;q=0.1", M4A},
+		{"text/html, audio/ogg;q=oops, audio/*;q=0.5", WAV},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got, err := formats.Negotiate(tt.accept, candidates)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Name)
+		})
+	}
+
+	t.Run("not acceptable", func(t *testing.T) {
+		_, err := formats.Negotiate("audio/flac", candidates)
+		assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+		_, err = formats.Negotiate("audio/*;q=0", candidates)
+		assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	})
+}
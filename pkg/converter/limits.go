@@ -0,0 +1,89 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProtocol = "file"
+
+	// stderrTail is the number of trailing stderr bytes of ffmpeg kept to explain failures
+	stderrTail = 4096
+)
+
+// ErrResourceLimit is returned when ffmpeg is stopped for exceeding its resource limits. The input is bound to exceed
+// them again, so the conversion should not be retried.
+var ErrResourceLimit = errors.New("conversion exceeded resource limits")
+
+// Limits bounds the resources of an ffmpeg process with rlimits where the platform supports them, zero values leave a
+// resource unlimited
+type Limits struct {
+	CPUTime    time.Duration // CPU time, ffmpeg is killed once it used it up
+	Memory     int64         // Bytes of address space
+	OutputSize int64         // Bytes of any file written
+	OpenFiles  int64         // Open file descriptors
+}
+
+// ulimits returns the shell commands setting the limits, empty without limits.
+// ulimit counts memory in KiB and, in a POSIX shell, file sizes in 512 byte blocks.
+func (l Limits) ulimits() string {
+	var commands []string
+	if l.CPUTime > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -t %d", int64(math.Ceil(l.CPUTime.Seconds()))))
+	}
+	if l.Memory > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -v %d", ceilDiv(l.Memory, 1024)))
+	}
+	if l.OutputSize > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -f %d", ceilDiv(l.OutputSize, 512)))
+	}
+	if l.OpenFiles > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -n %d", l.OpenFiles))
+	}
+
+	return strings.Join(commands, " && ")
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// limitMessages are the ffmpeg errors of exhausted rlimits that do not stop it with a signal
+var limitMessages = map[string]string{
+	"Cannot allocate memory": "memory",
+	"Too many open files":    "open files",
+}
+
+// stderrLimit returns the resource an ffmpeg error output reports as exhausted, empty when none is
+func stderrLimit(stderr string) string {
+	for message, resource := range limitMessages {
+		if strings.Contains(stderr, message) {
+			return resource
+		}
+	}
+
+	return ""
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	size int
+	buf  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.size {
+		b.buf = b.buf[len(b.buf)-b.size:]
+	}
+
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.buf)
+}
@@ -0,0 +1,32 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits_ulimits(t *testing.T) {
+	assert.Empty(t, Limits{}.ulimits())
+
+	limits := Limits{CPUTime: 1500 * time.Millisecond, Memory: 1 << 30, OutputSize: 1000, OpenFiles: 64}
+	assert.Equal(t, "ulimit -t 2 && ulimit -v 1048576 && ulimit -f 2 && ulimit -n 64", limits.ulimits())
+}
+
+func TestStderrLimit(t *testing.T) {
+	assert.Equal(t, "memory", stderrLimit("[aac @ 0x1] Error while decoding stream #0:0: Cannot allocate memory"))
+	assert.Equal(t, "open files", stderrLimit("in.m4a: Too many open files"))
+	assert.Empty(t, stderrLimit("in.m4a: Invalid data found when processing input"))
+}
+
+func TestTailBuffer(t *testing.T) {
+	buf := &tailBuffer{size: 8}
+	buf.Write([]byte("0123"))
+	buf.Write([]byte("456789"))
+	assert.Equal(t, "23456789", buf.String())
+
+	buf.Write([]byte(strings.Repeat("x", 20)))
+	assert.Equal(t, "xxxxxxxx", buf.String())
+}
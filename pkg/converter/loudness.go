@@ -0,0 +1,165 @@
+package converter
+
+import "math"
+
+// EBU R128 / ITU-R BS.1770 gating parameters
+const (
+	loudnessBlock         = 0.4 // seconds per gating block
+	loudnessStep          = 0.1 // seconds between gating blocks, a 75% overlap
+	loudnessAbsoluteGate  = -70 // LUFS
+	loudnessRelativeGate  = -10 // LU below the absolute-gated loudness
+	loudnessOffset        = -0.691
+	surroundChannelWeight = 1.41
+)
+
+// biquad is a second order IIR filter in direct form I
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the two stages of the BS.1770 K-weighting filter for a sample rate: a high shelf modelling the
+// head and a high pass. The coefficients are derived for any rate instead of the tabulated 48 kHz values.
+func kWeighting(sampleRate int) (shelf, highPass biquad) {
+	fs := float64(sampleRate)
+
+	const (
+		shelfFrequency = 1681.974450955533
+		shelfGain      = 3.999843853973347
+		shelfQ         = 0.7071752369554196
+	)
+	k := math.Tan(math.Pi * shelfFrequency / fs)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	const (
+		highPassFrequency = 38.13547087602444
+		highPassQ         = 0.5003270373238773
+	)
+	k = math.Tan(math.Pi * highPassFrequency / fs)
+	a0 = 1 + k/highPassQ + k*k
+	highPass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highPassQ + k*k) / a0,
+	}
+
+	return shelf, highPass
+}
+
+// channelWeights returns the BS.1770 weight of each channel, assuming the L R C LFE Ls Rs order for 5.1 audio
+func channelWeights(channels int) []float64 {
+	weights := make([]float64, channels)
+	for i := range weights {
+		weights[i] = 1
+	}
+	if channels == 6 {
+		weights[3] = 0 // LFE
+		weights[4] = surroundChannelWeight
+		weights[5] = surroundChannelWeight
+	}
+
+	return weights
+}
+
+// MeasureLoudness returns the integrated loudness of the audio in LUFS as specified by EBU R128, gating out silence
+// and quiet passages. Audio too short or too quiet to measure returns negative infinity.
+func MeasureLoudness(pcm *PCM) float64 {
+	if pcm.SampleRate <= 0 || pcm.Channels <= 0 {
+		return math.Inf(-1)
+	}
+
+	blockFrames := int(loudnessBlock * float64(pcm.SampleRate))
+	stepFrames := int(loudnessStep * float64(pcm.SampleRate))
+	frames := pcm.Frames()
+	if stepFrames == 0 || frames < blockFrames {
+		return math.Inf(-1)
+	}
+
+	// Mean square of the K-weighted channels per step, blocks sum four consecutive steps
+	weights := channelWeights(pcm.Channels)
+	steps := frames / stepFrames
+	energy := make([]float64, steps)
+	for channel := 0; channel < pcm.Channels; channel++ {
+		if weights[channel] == 0 {
+			continue
+		}
+		shelf, highPass := kWeighting(pcm.SampleRate)
+		for frame := 0; frame < steps*stepFrames; frame++ {
+			y := highPass.process(shelf.process(pcm.Samples[frame*pcm.Channels+channel]))
+			energy[frame/stepFrames] += weights[channel] * y * y
+		}
+	}
+
+	stepsPerBlock := blockFrames / stepFrames
+	var blocks []float64
+	for start := 0; start+stepsPerBlock <= steps; start++ {
+		sum := 0.0
+		for _, e := range energy[start : start+stepsPerBlock] {
+			sum += e
+		}
+		blocks = append(blocks, sum/float64(stepsPerBlock*stepFrames))
+	}
+
+	// the gates are compared in the energy domain, where the loudness offset cancels out
+	absoluteGate := math.Pow(10, (loudnessAbsoluteGate-loudnessOffset)/10)
+	gated := gatedMean(blocks, absoluteGate)
+	if gated == 0 {
+		return math.Inf(-1)
+	}
+	relativeGate := gated * math.Pow(10, loudnessRelativeGate/10.0)
+	integrated := gatedMean(blocks, max(relativeGate, absoluteGate))
+
+	return loudnessOffset + 10*math.Log10(integrated)
+}
+
+// gatedMean returns the mean of the block energies above the gate, zero when no block passes it
+func gatedMean(blocks []float64, gate float64) float64 {
+	sum, count := 0.0, 0
+	for _, block := range blocks {
+		if block > gate {
+			sum += block
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+
+	return sum / float64(count)
+}
+
+// Peak returns the highest absolute sample value
+func Peak(pcm *PCM) float64 {
+	peak := 0.0
+	for _, sample := range pcm.Samples {
+		peak = max(peak, math.Abs(sample))
+	}
+
+	return peak
+}
+
+// Gain scales the samples by gain decibels in place
+func Gain(pcm *PCM, gain float64) {
+	factor := math.Pow(10, gain/20)
+	for i := range pcm.Samples {
+		pcm.Samples[i] *= factor
+	}
+}
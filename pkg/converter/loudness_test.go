@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tone returns a sine of frequency at peak level dBFS on every channel
+func tone(frequency, level float64, sampleRate, channels int, seconds float64) *PCM {
+	frames := int(seconds * float64(sampleRate))
+	amplitude := math.Pow(10, level/20)
+	samples := make([]float64, frames*channels)
+	for frame := 0; frame < frames; frame++ {
+		sample := amplitude * math.Sin(2*math.Pi*frequency*float64(frame)/float64(sampleRate))
+		for channel := 0; channel < channels; channel++ {
+			samples[frame*channels+channel] = sample
+		}
+	}
+
+	return &PCM{SampleRate: sampleRate, Channels: channels, Samples: samples}
+}
+
+// silence returns seconds of digital silence
+func silence(sampleRate, channels int, seconds float64) *PCM {
+	return &PCM{SampleRate: sampleRate, Channels: channels, Samples: make([]float64, int(seconds*float64(sampleRate))*channels)}
+}
+
+// concat joins recordings of the same format
+func concat(parts ...*PCM) *PCM {
+	pcm := &PCM{SampleRate: parts[0].SampleRate, Channels: parts[0].Channels}
+	for _, part := range parts {
+		pcm.Samples = append(pcm.Samples, part.Samples...)
+	}
+	return pcm
+}
+
+func TestMeasureLoudness(t *testing.T) {
+	// EBU Tech 3341 test case 1: a stereo 1 kHz sine at -23 dBFS reads -23 LUFS
+	for _, rate := range []int{48000, 44100, 16000} {
+		assert.InDelta(t, -23, MeasureLoudness(tone(1000, -23, rate, 2, 5)), 0.1, "rate %d", rate)
+	}
+	assert.InDelta(t, -33, MeasureLoudness(tone(1000, -33, 48000, 2, 5)), 0.1)
+
+	t.Run("mono reads 3 LU below stereo", func(t *testing.T) {
+		assert.InDelta(t, -26.01, MeasureLoudness(tone(1000, -23, 48000, 1, 5)), 0.1)
+	})
+
+	t.Run("silence is gated", func(t *testing.T) {
+		// blocks straddling the edges of the tone pass the gates, a long tone keeps their share small
+		pcm := concat(silence(48000, 2, 3), tone(1000, -23, 48000, 2, 20), silence(48000, 2, 10))
+		assert.InDelta(t, -23, MeasureLoudness(pcm), 0.1)
+	})
+
+	t.Run("quiet passages are gated", func(t *testing.T) {
+		// EBU Tech 3341 test case 3: -36, -23 and -36 dBFS sines of 10, 60 and 10 seconds read -23 LUFS
+		pcm := concat(tone(1000, -36, 48000, 2, 10), tone(1000, -23, 48000, 2, 60), tone(1000, -36, 48000, 2, 10))
+		assert.InDelta(t, -23, MeasureLoudness(pcm), 0.1)
+	})
+
+	t.Run("unmeasurable", func(t *testing.T) {
+		assert.True(t, math.IsInf(MeasureLoudness(silence(48000, 2, 5)), -1))
+		assert.True(t, math.IsInf(MeasureLoudness(tone(1000, -23, 48000, 2, 0.2)), -1), "shorter than a gating block")
+	})
+}
+
+func TestGain(t *testing.T) {
+	pcm := tone(1000, -20, 48000, 1, 1)
+	assert.InDelta(t, 0.1, Peak(pcm), 1e-3)
+
+	Gain(pcm, 6)
+	assert.InDelta(t, 0.1995, Peak(pcm), 1e-3)
+}
@@ -0,0 +1,85 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrUnsupportedConversion = errors.New("conversion not supported")
+
+// NativeConfig holds the storage format of the native converter, zero values keep the input format
+type NativeConfig struct {
+	SampleRate int
+	Channels   int
+	Format     PCMFormat // Sample encoding of the output, the input encoding when BitDepth is zero
+	Formats    *Formats  // Registry recognizing WAV files, the built-in formats when nil
+}
+
+// Native is a pure Go implementation of the audio converter for WAV input and output, resampling and
+// remixing the audio to the configured format. Other conversions go to the fallback converter.
+type Native struct {
+	config   NativeConfig
+	fallback Audio
+}
+
+// NewNative returns a new instance of Native, fallback may be nil when only WAV is converted
+func NewNative(config NativeConfig, fallback Audio) Audio {
+	if config.Formats == nil {
+		config.Formats = DefaultFormats()
+	}
+
+	return &Native{config: config, fallback: fallback}
+}
+
+// ConvertToStorageFormat converts a WAV file to the storage format and returns the path to the converted file.
+// ctx is checked between the conversion steps.
+func (n *Native) ConvertToStorageFormat(ctx context.Context, inputPath string) (string, error) {
+	if !n.config.Formats.isWAV(inputPath) {
+		if n.fallback == nil {
+			return "", &ConversionError{Kind: UnsupportedCodec, Err: fmt.Errorf("%w: %s", ErrUnsupportedConversion, filepath.Ext(inputPath))}
+		}
+		return n.fallback.ConvertToStorageFormat(ctx, inputPath)
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+
+	pcm, format, err := DecodeWAV(input)
+	if err != nil {
+		return "", decodeError(err)
+	}
+	if err = context.Cause(ctx); err != nil {
+		return "", err
+	}
+
+	if n.config.Format.BitDepth != 0 {
+		format = n.config.Format
+	}
+	pcm = Remix(Resample(pcm, n.config.SampleRate), n.config.Channels)
+	if err = context.Cause(ctx); err != nil {
+		return "", err
+	}
+
+	outputPath := storagePath(inputPath, "wav")
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+
+	err = EncodeWAV(output, pcm, format)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+
+	return outputPath, nil
+}
@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockAudio is a mock implementation of the Audio interface
+type MockAudio struct {
+	mock.Mock
+}
+
+func (m *MockAudio) ConvertToStorageFormat(ctx context.Context, inputPath string) (string, error) {
+	args := m.Called(ctx, inputPath)
+	return args.String(0), args.Error(1)
+}
+
+func writeWAV(t *testing.T, path string, pcm *PCM, format PCMFormat) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, EncodeWAV(file, pcm, format))
+}
+
+func TestNative_ConvertToStorageFormat(t *testing.T) {
+	t.Run("converts wav", func(t *testing.T) {
+		inputPath := filepath.Join(t.TempDir(), "upload.wav")
+		stereo := Remix(sine(440, 44100, 4410), 2)
+		writeWAV(t, inputPath, stereo, PCMFormat{Encoding: SampleInt, BitDepth: 16})
+
+		native := NewNative(NativeConfig{SampleRate: 16000, Channels: 1, Format: PCMFormat{Encoding: SampleFloat, BitDepth: 32}}, nil)
+		outputPath, err := native.ConvertToStorageFormat(context.Background(), inputPath)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(filepath.Dir(inputPath), "upload.converted.wav"), outputPath)
+
+		output, err := os.Open(outputPath)
+		require.NoError(t, err)
+		defer output.Close()
+
+		pcm, format, err := DecodeWAV(output)
+		require.NoError(t, err)
+		assert.Equal(t, PCMFormat{Encoding: SampleFloat, BitDepth: 32}, format)
+		assert.Equal(t, 16000, pcm.SampleRate)
+		assert.Equal(t, 1, pcm.Channels)
+		assert.InDelta(t, 1600, pcm.Frames(), 1)
+	})
+
+	t.Run("keeps the input format by default", func(t *testing.T) {
+		inputPath := filepath.Join(t.TempDir(), "upload.wav")
+		writeWAV(t, inputPath, sine(440, 8000, 800), PCMFormat{Encoding: SampleInt, BitDepth: 24})
+
+		outputPath, err := NewNative(NativeConfig{}, nil).ConvertToStorageFormat(context.Background(), inputPath)
+		require.NoError(t, err)
+
+		output, err := os.Open(outputPath)
+		require.NoError(t, err)
+		defer output.Close()
+
+		pcm, format, err := DecodeWAV(output)
+		require.NoError(t, err)
+		assert.Equal(t, PCMFormat{Encoding: SampleInt, BitDepth: 24}, format)
+		assert.Equal(t, 8000, pcm.SampleRate)
+		assert.Equal(t, 800, pcm.Frames())
+	})
+
+	t.Run("invalid wav", func(t *testing.T) {
+		inputPath := filepath.Join(t.TempDir(), "upload.wav")
+		require.NoError(t, os.WriteFile(inputPath, []byte("not a wav file"), 0o644))
+
+		_, err := NewNative(NativeConfig{}, nil).ConvertToStorageFormat(context.Background(), inputPath)
+		assert.ErrorIs(t, err, ErrInvalidWAV)
+		assert.True(t, IsPermanent(err), "a corrupt input fails every attempt")
+		assert.NoFileExists(t, storagePath(inputPath, "wav"))
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		inputPath := filepath.Join(t.TempDir(), "upload.wav")
+		writeWAV(t, inputPath, sine(440, 8000, 800), PCMFormat{Encoding: SampleInt, BitDepth: 16})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := NewNative(NativeConfig{SampleRate: 16000}, nil).ConvertToStorageFormat(ctx, inputPath)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NoFileExists(t, storagePath(inputPath, "wav"))
+	})
+
+	t.Run("other formats use the fallback", func(t *testing.T) {
+		fallback := new(MockAudio)
+		fallback.On("ConvertToStorageFormat", mock.Anything, "/tmp/upload.m4a").Return("/tmp/upload.wav", nil)
+
+		outputPath, err := NewNative(NativeConfig{}, fallback).ConvertToStorageFormat(context.Background(), "/tmp/upload.m4a")
+		require.NoError(t, err)
+		assert.Equal(t, "/tmp/upload.wav", outputPath)
+		fallback.AssertExpectations(t)
+	})
+
+	t.Run("other formats without fallback", func(t *testing.T) {
+		_, err := NewNative(NativeConfig{}, nil).ConvertToStorageFormat(context.Background(), "/tmp/upload.m4a")
+		assert.ErrorIs(t, err, ErrUnsupportedConversion)
+	})
+}
+
+func TestStoragePath(t *testing.T) {
+	assert.Equal(t, "/tmp/upload.wav", storagePath("/tmp/upload.m4a", "wav"))
+	assert.Equal(t, "/tmp/upload.converted.wav", storagePath("/tmp/upload.wav", "wav"))
+	assert.Equal(t, "/tmp/upload.converted.wav", storagePath("/tmp/upload.WAV", "wav"))
+}
@@ -0,0 +1,102 @@
+package converter
+
+import "math"
+
+// resampleTaps is the number of input samples weighted on each side of an output sample
+const resampleTaps = 16
+
+// Resample converts pcm to sampleRate with a windowed sinc interpolator, which also low-pass filters
+// the signal below the lower of both Nyquist frequencies so downsampling does not alias
+func Resample(pcm *PCM, sampleRate int) *PCM {
+	if sampleRate <= 0 || sampleRate == pcm.SampleRate || pcm.Frames() == 0 {
+		return pcm
+	}
+
+	ratio := float64(sampleRate) / float64(pcm.SampleRate)
+	cutoff := min(1, ratio) // relative to the input Nyquist frequency
+	taps := int(math.Ceil(resampleTaps / cutoff))
+
+	inFrames := pcm.Frames()
+	outFrames := int(math.Round(float64(inFrames) * ratio))
+	out := &PCM{SampleRate: sampleRate, Channels: pcm.Channels, Samples: make([]float64, outFrames*pcm.Channels)}
+
+	for frame := 0; frame < outFrames; frame++ {
+		position := float64(frame) / ratio
+		center := int(math.Floor(position))
+
+		var norm float64
+		for i := center - taps + 1; i <= center+taps; i++ {
+			if i < 0 || i >= inFrames {
+				continue
+			}
+			weight := cutoff * sinc(cutoff*(position-float64(i))) * blackman(position-float64(i), float64(taps))
+			norm += weight
+			for channel := 0; channel < pcm.Channels; channel++ {
+				out.Samples[frame*pcm.Channels+channel] += weight * pcm.Samples[i*pcm.Channels+channel]
+			}
+		}
+
+		// normalizing keeps a constant signal constant, in particular near the edges where taps are missing
+		if norm != 0 {
+			for channel := 0; channel < pcm.Channels; channel++ {
+				out.Samples[frame*pcm.Channels+channel] /= norm
+			}
+		}
+	}
+
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman is a Blackman window of half width taps centered on zero
+func blackman(x, taps float64) float64 {
+	if math.Abs(x) >= taps {
+		return 0
+	}
+
+	phase := math.Pi * (x/taps + 1)
+	return 0.42 - 0.5*math.Cos(phase) + 0.08*math.Cos(2*phase)
+}
+
+// Remix converts pcm to channels. Downmixing averages the source channels folded onto each target channel,
+// so stereo becomes mono as the mean of left and right. Upmixing repeats the source channels.
+func Remix(pcm *PCM, channels int) *PCM {
+	if channels <= 0 || channels == pcm.Channels {
+		return pcm
+	}
+
+	frames := pcm.Frames()
+	out := &PCM{SampleRate: pcm.SampleRate, Channels: channels, Samples: make([]float64, frames*channels)}
+
+	if channels > pcm.Channels {
+		for frame := 0; frame < frames; frame++ {
+			for channel := 0; channel < channels; channel++ {
+				out.Samples[frame*channels+channel] = pcm.Samples[frame*pcm.Channels+channel%pcm.Channels]
+			}
+		}
+		return out
+	}
+
+	folded := make([]float64, channels)
+	for source := 0; source < pcm.Channels; source++ {
+		folded[source%channels]++
+	}
+
+	for frame := 0; frame < frames; frame++ {
+		for source := 0; source < pcm.Channels; source++ {
+			out.Samples[frame*channels+source%channels] += pcm.Samples[frame*pcm.Channels+source]
+		}
+		for channel := 0; channel < channels; channel++ {
+			out.Samples[frame*channels+channel] /= folded[channel]
+		}
+	}
+
+	return out
+}
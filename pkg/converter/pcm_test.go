@@ -0,0 +1,87 @@
+package converter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sine returns a mono tone of frequency at sampleRate lasting frames
+func sine(frequency float64, sampleRate, frames int) *PCM {
+	samples := make([]float64, frames)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate))
+	}
+
+	return &PCM{SampleRate: sampleRate, Channels: 1, Samples: samples}
+}
+
+// zeroCrossings counts the sign changes of the samples
+func zeroCrossings(samples []float64) int {
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+	return crossings
+}
+
+func TestResample(t *testing.T) {
+	t.Run("keeps the duration and pitch", func(t *testing.T) {
+		for _, rate := range []int{16000, 22050, 48000} {
+			resampled := Resample(sine(440, 44100, 44100), rate)
+
+			assert.Equal(t, rate, resampled.SampleRate)
+			assert.InDelta(t, rate, resampled.Frames(), 1)
+			assert.InDelta(t, 880, zeroCrossings(resampled.Samples), 4, "rate %d", rate)
+		}
+	})
+
+	t.Run("keeps constant signals", func(t *testing.T) {
+		pcm := &PCM{SampleRate: 8000, Channels: 2, Samples: make([]float64, 2*800)}
+		for i := range pcm.Samples {
+			pcm.Samples[i] = 0.25 * float64(1-2*(i%2))
+		}
+
+		resampled := Resample(pcm, 11025)
+		require.Equal(t, 2, resampled.Channels)
+		// Skip the edges where the kernel runs past the signal
+		for frame := 32; frame < resampled.Frames()-32; frame++ {
+			assert.InDelta(t, 0.25, resampled.Samples[frame*2], 1e-3)
+			assert.InDelta(t, -0.25, resampled.Samples[frame*2+1], 1e-3)
+		}
+	})
+
+	t.Run("same rate", func(t *testing.T) {
+		pcm := sine(440, 8000, 100)
+		assert.Same(t, pcm, Resample(pcm, 8000))
+		assert.Same(t, pcm, Resample(pcm, 0))
+	})
+}
+
+func TestRemix(t *testing.T) {
+	t.Run("downmix averages the channels", func(t *testing.T) {
+		pcm := &PCM{SampleRate: 8000, Channels: 2, Samples: []float64{1, 0, 0.5, -0.5, -1, -0.5}}
+
+		mono := Remix(pcm, 1)
+		assert.Equal(t, 1, mono.Channels)
+		assert.Equal(t, []float64{0.5, 0, -0.75}, mono.Samples)
+	})
+
+	t.Run("upmix repeats the channels", func(t *testing.T) {
+		pcm := &PCM{SampleRate: 8000, Channels: 1, Samples: []float64{0.5, -0.25}}
+
+		stereo := Remix(pcm, 2)
+		assert.Equal(t, 2, stereo.Channels)
+		assert.Equal(t, []float64{0.5, 0.5, -0.25, -0.25}, stereo.Samples)
+	})
+
+	t.Run("same channels", func(t *testing.T) {
+		pcm := &PCM{SampleRate: 8000, Channels: 2, Samples: []float64{0, 1}}
+		assert.Same(t, pcm, Remix(pcm, 2))
+		assert.Same(t, pcm, Remix(pcm, 0))
+	})
+}
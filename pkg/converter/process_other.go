@@ -0,0 +1,21 @@
+//go:build !unix
+
+package converter
+
+import (
+	"context"
+	"os/exec"
+)
+
+// killProcessGroup keeps the default cancellation of cmd, which kills ffmpeg only
+func killProcessGroup(cmd *exec.Cmd) {}
+
+// limitedCommand returns the command running name, the platform has no rlimits to apply the limits with
+func limitedCommand(ctx context.Context, limits Limits, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// signalLimit returns empty and false, processes are not stopped by rlimits or signals on the platform
+func signalLimit(err error, limits Limits) (string, bool) {
+	return "", false
+}
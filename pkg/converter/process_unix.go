@@ -0,0 +1,55 @@
+//go:build unix
+
+package converter
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup starts cmd in a process group of its own and kills the whole group when the context of cmd is done,
+// so processes spawned by ffmpeg do not outlive a cancelled conversion
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// limitedCommand returns the command running name under the limits. A shell sets them with ulimit and replaces itself
+// with name, so the limits are in place before name reads any input.
+func limitedCommand(ctx context.Context, limits Limits, name string, args ...string) *exec.Cmd {
+	ulimits := limits.ulimits()
+	if ulimits == "" {
+		return exec.CommandContext(ctx, name, args...)
+	}
+
+	return exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", ulimits + ` && exec "$0" "$@"`, name}, args...)...)
+}
+
+// signalLimit returns the resource whose rlimit stopped the process with a signal, empty when none did, and whether a
+// signal stopped it at all. SIGKILL follows the hard CPU limit, but also comes from the OOM killer of a busy host or
+// anyone else, so it only counts as exceeding the limit when one is set.
+func signalLimit(err error, limits Limits) (string, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "", false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return "", false
+	}
+
+	switch {
+	case status.Signal() == syscall.SIGXCPU:
+		return "CPU time", true
+	case status.Signal() == syscall.SIGXFSZ:
+		return "output size", true
+	case status.Signal() == syscall.SIGKILL && limits.CPUTime > 0:
+		return "CPU time", true
+	default:
+		return "", true
+	}
+}
@@ -0,0 +1,138 @@
+package converter
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultTargetLoudness   = -23.0 // LUFS, the EBU R128 broadcast target
+	defaultPeakLimit        = -1.0  // dBFS
+	defaultSilenceThreshold = -50.0 // dBFS
+	defaultSilencePadding   = 100 * time.Millisecond
+)
+
+// Analysis holds the measurements taken while processing a converted file
+type Analysis struct {
+	Loudness  float64       // Integrated loudness in LUFS before normalization, negative infinity for silence
+	Gain      float64       // Gain applied by the normalization in dB
+	TrimStart time.Duration // Leading silence removed
+	TrimEnd   time.Duration // Trailing silence removed
+}
+
+// Processor post-processes converted files in place
+type Processor interface {
+	Process(ctx context.Context, path string) (Analysis, error)
+}
+
+// ProcessingConfig enables the processing steps, zero values use the defaults
+type ProcessingConfig struct {
+	Normalize        bool
+	TargetLoudness   float64 // Integrated loudness in LUFS normalized recordings are brought to
+	PeakLimit        float64 // Peak level in dBFS the normalization gain never pushes samples above
+	Trim             bool
+	SilenceThreshold float64       // Level in dBFS below which the start and end of a recording are silent
+	SilencePadding   time.Duration // Silence kept before and after the trimmed recording
+}
+
+func (c ProcessingConfig) withDefaults() ProcessingConfig {
+	if c.TargetLoudness == 0 {
+		c.TargetLoudness = defaultTargetLoudness
+	}
+	if c.PeakLimit == 0 {
+		c.PeakLimit = defaultPeakLimit
+	}
+	if c.SilenceThreshold == 0 {
+		c.SilenceThreshold = defaultSilenceThreshold
+	}
+	if c.SilencePadding == 0 {
+		c.SilencePadding = defaultSilencePadding
+	}
+
+	return c
+}
+
+// Processing trims silence from and normalizes the loudness of converted WAV files, measuring their loudness on the way
+type Processing struct {
+	config ProcessingConfig
+}
+
+// NewProcessing returns a new instance of Processing
+func NewProcessing(config ProcessingConfig) *Processing {
+	return &Processing{config: config.withDefaults()}
+}
+
+// Process applies the enabled steps to the WAV file at path, replacing it once every step succeeded
+func (p *Processing) Process(ctx context.Context, path string) (Analysis, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return Analysis{}, err
+	}
+	pcm, format, err := DecodeWAV(input)
+	input.Close()
+	if err != nil {
+		return Analysis{}, err
+	}
+	if err = context.Cause(ctx); err != nil {
+		return Analysis{}, err
+	}
+
+	var analysis Analysis
+	if p.config.Trim {
+		padding := int(p.config.SilencePadding.Seconds() * float64(pcm.SampleRate))
+
+		var start, end int
+		pcm, start, end = TrimSilence(pcm, p.config.SilenceThreshold, padding)
+		analysis.TrimStart = framesDuration(start, pcm.SampleRate)
+		analysis.TrimEnd = framesDuration(end, pcm.SampleRate)
+	}
+
+	analysis.Loudness = MeasureLoudness(pcm)
+	if p.config.Normalize && !math.IsInf(analysis.Loudness, -1) {
+		analysis.Gain = p.config.TargetLoudness - analysis.Loudness
+		if peak := Peak(pcm); peak > 0 {
+			analysis.Gain = min(analysis.Gain, p.config.PeakLimit-20*math.Log10(peak))
+		}
+		Gain(pcm, analysis.Gain)
+	}
+	if err = context.Cause(ctx); err != nil {
+		return Analysis{}, err
+	}
+
+	return analysis, replaceWAV(path, pcm, format)
+}
+
+// replaceWAV writes the audio next to path and renames it over path, so readers never see a partial file
+func replaceWAV(path string, pcm *PCM, format PCMFormat) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	output, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = output.Chmod(info.Mode())
+	if err == nil {
+		err = EncodeWAV(output, pcm, format)
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), path)
+	}
+	if err != nil {
+		os.Remove(output.Name())
+	}
+
+	return err
+}
+
+func framesDuration(frames, sampleRate int) time.Duration {
+	return time.Duration(float64(frames) / float64(sampleRate) * float64(time.Second))
+}
@@ -0,0 +1,83 @@
+package converter
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readWAV(t *testing.T, path string) (*PCM, PCMFormat) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	pcm, format, err := DecodeWAV(file)
+	require.NoError(t, err)
+	return pcm, format
+}
+
+func TestProcessing_Process(t *testing.T) {
+	recording := concat(silence(16000, 1, 1.5), tone(1000, -35, 16000, 1, 20), silence(16000, 1, 2))
+	s16 := PCMFormat{Encoding: SampleInt, BitDepth: 16}
+
+	t.Run("trims and normalizes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "upload.converted.wav")
+		writeWAV(t, path, recording, s16)
+
+		processing := NewProcessing(ProcessingConfig{Normalize: true, Trim: true, SilencePadding: 250 * time.Millisecond})
+		analysis, err := processing.Process(context.Background(), path)
+		require.NoError(t, err)
+
+		assert.InDelta(t, -38, analysis.Loudness, 0.5, "a mono sine reads 3 LU below its level")
+		assert.InDelta(t, 15, analysis.Gain, 0.5)
+		assert.Equal(t, 1250*time.Millisecond, analysis.TrimStart)
+		assert.Equal(t, 1750*time.Millisecond, analysis.TrimEnd)
+
+		processed, format := readWAV(t, path)
+		assert.Equal(t, s16, format)
+		assert.InDelta(t, 20.5, float64(processed.Frames())/16000, 0.01)
+		assert.InDelta(t, -23, MeasureLoudness(processed), 0.5)
+
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "no temporary file is left behind")
+	})
+
+	t.Run("limits the gain to the peak limit", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "upload.converted.wav")
+		writeWAV(t, path, tone(440, -6, 16000, 1, 5), s16)
+
+		analysis, err := NewProcessing(ProcessingConfig{Normalize: true, TargetLoudness: -3}).Process(context.Background(), path)
+		require.NoError(t, err)
+		assert.InDelta(t, 5, analysis.Gain, 0.01)
+
+		processed, _ := readWAV(t, path)
+		assert.InDelta(t, -1, 20*math.Log10(Peak(processed)), 0.01)
+	})
+
+	t.Run("measures only", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "upload.converted.wav")
+		writeWAV(t, path, recording, s16)
+
+		analysis, err := NewProcessing(ProcessingConfig{Trim: true}).Process(context.Background(), path)
+		require.NoError(t, err)
+		assert.Zero(t, analysis.Gain, "normalization is disabled")
+		assert.InDelta(t, -38, analysis.Loudness, 0.5)
+	})
+
+	t.Run("not a wav file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "upload.converted.wav")
+		require.NoError(t, os.WriteFile(path, []byte("not a wav file"), 0o644))
+
+		_, err := NewProcessing(ProcessingConfig{Normalize: true}).Process(context.Background(), path)
+		assert.ErrorIs(t, err, ErrInvalidWAV)
+	})
+}
@@ -0,0 +1,111 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidProfile = errors.New("invalid transcoding profile")
+
+var (
+	sampleRatePattern = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)(k?)hz$`)
+	channelsPattern   = regexp.MustCompile(`(?i)^(\d+)ch$`)
+	bitratePattern    = regexp.MustCompile(`(?i)^\d+[km]?$`)
+)
+
+// Profile is a named set of transcoding settings, zero values leave the setting to the input or to ffmpeg
+type Profile struct {
+	Name         string
+	Format       string    // Target format, an extension or name of a registered format
+	Codec        string    // ffmpeg audio encoder, the encoder of the format when empty
+	SampleRate   int       // Output sample rate in Hz
+	Channels     int       // Output channel count
+	SampleFormat PCMFormat // Sample encoding of WAV output
+	Bitrate      string    // Target bitrate of lossy encoders in ffmpeg notation, e.g. 64k
+	Filters      []string  // ffmpeg audio filters applied in order before encoding
+}
+
+// ParseProfile parses the short notation of a profile: the format followed by slash separated settings in any order,
+// such as wav/16kHz/mono/s16 or m4a/aac/64k. Settings are a sample rate in Hz or kHz, mono, stereo or a channel
+// count like 6ch, a PCM sample format, a bitrate like 64k, and a codec name for anything else.
+func ParseProfile(name, spec string) (Profile, error) {
+	tokens := strings.Split(spec, "/")
+	profile := Profile{Name: name, Format: strings.TrimSpace(tokens[0])}
+	if profile.Format == "" {
+		return Profile{}, fmt.Errorf("%w %q: missing format in %q", ErrInvalidProfile, name, spec)
+	}
+
+	for _, token := range tokens[1:] {
+		token = strings.TrimSpace(token)
+
+		var duplicate bool
+		switch {
+		case sampleRatePattern.MatchString(token):
+			match := sampleRatePattern.FindStringSubmatch(token)
+			rate, _ := strconv.ParseFloat(match[1], 64)
+			if match[2] != "" {
+				rate *= 1000
+			}
+			duplicate = profile.SampleRate != 0
+			profile.SampleRate = int(rate)
+		case strings.EqualFold(token, "mono"), strings.EqualFold(token, "stereo"), channelsPattern.MatchString(token):
+			duplicate = profile.Channels != 0
+			profile.Channels = parseChannels(token)
+		case bitratePattern.MatchString(token):
+			duplicate = profile.Bitrate != ""
+			profile.Bitrate = token
+		default:
+			if format, err := ParsePCMFormat(token); err == nil {
+				duplicate = profile.SampleFormat.BitDepth != 0
+				profile.SampleFormat = format
+				break
+			}
+			duplicate = profile.Codec != ""
+			profile.Codec = token
+		}
+
+		if token == "" || duplicate {
+			return Profile{}, fmt.Errorf("%w %q: unexpected %q in %q", ErrInvalidProfile, name, token, spec)
+		}
+	}
+
+	return profile, nil
+}
+
+// parseChannels returns the channel count of mono, stereo or <n>ch
+func parseChannels(token string) int {
+	switch strings.ToLower(token) {
+	case "mono":
+		return 1
+	case "stereo":
+		return 2
+	}
+
+	channels, _ := strconv.Atoi(channelsPattern.FindStringSubmatch(token)[1])
+	return channels
+}
+
+// Validate checks the profile against the registered formats
+func (p Profile) Validate(formats *Formats) error {
+	target, err := formats.Lookup(p.Format)
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", ErrInvalidProfile, p.Name, err)
+	}
+	if p.SampleRate < 0 || p.Channels < 0 {
+		return fmt.Errorf("%w %q: negative sample rate or channels", ErrInvalidProfile, p.Name)
+	}
+	if p.SampleFormat.BitDepth != 0 && target.Name != WAV {
+		return fmt.Errorf("%w %q: sample format %s needs WAV output", ErrInvalidProfile, p.Name, p.SampleFormat)
+	}
+
+	return nil
+}
+
+// native reports whether the native converter can apply the profile, which needs WAV output without ffmpeg settings
+func (p Profile) native(formats *Formats) bool {
+	target, err := formats.Lookup(p.Format)
+	return err == nil && target.Name == WAV && p.Codec == "" && p.Bitrate == "" && len(p.Filters) == 0
+}
@@ -0,0 +1,75 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected Profile
+	}{
+		{
+			spec:     "wav/16kHz/mono/s16",
+			expected: Profile{Name: "p", Format: "wav", SampleRate: 16000, Channels: 1, SampleFormat: PCMFormat{Encoding: SampleInt, BitDepth: 16}},
+		},
+		{
+			spec:     "m4a/aac/64k",
+			expected: Profile{Name: "p", Format: "m4a", Codec: "aac", Bitrate: "64k"},
+		},
+		{
+			spec:     "ogg/stereo/48000Hz/libopus/96k",
+			expected: Profile{Name: "p", Format: "ogg", Codec: "libopus", SampleRate: 48000, Channels: 2, Bitrate: "96k"},
+		},
+		{
+			spec:     "flac/44.1kHz/6ch",
+			expected: Profile{Name: "p", Format: "flac", SampleRate: 44100, Channels: 6},
+		},
+		{
+			spec:     "wav",
+			expected: Profile{Name: "p", Format: "wav"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			profile, err := ParseProfile("p", tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, profile)
+		})
+	}
+
+	for _, spec := range []string{"", "/16kHz", "wav/16kHz/8kHz", "wav/mono/stereo", "m4a/aac/libfdk_aac", "wav//mono"} {
+		_, err := ParseProfile("p", spec)
+		assert.ErrorIs(t, err, ErrInvalidProfile, spec)
+	}
+}
+
+func TestProfile_Validate(t *testing.T) {
+	formats := DefaultFormats()
+
+	assert.NoError(t, Profile{Name: "storage_master", Format: "wav", SampleFormat: PCMFormat{BitDepth: 16}}.Validate(formats))
+	assert.NoError(t, Profile{Name: "playback", Format: "m4a", Codec: "aac", Bitrate: "64k"}.Validate(formats))
+
+	assert.ErrorIs(t, Profile{Name: "unknown", Format: "aiff"}.Validate(formats), ErrUnsupportedFormat)
+	assert.ErrorIs(t, Profile{Name: "pcm", Format: "m4a", SampleFormat: PCMFormat{BitDepth: 16}}.Validate(formats), ErrInvalidProfile)
+	assert.ErrorIs(t, Profile{Name: "negative", Format: "wav", Channels: -1}.Validate(formats), ErrInvalidProfile)
+}
+
+func TestNewAudio(t *testing.T) {
+	formats := DefaultFormats()
+
+	audio, err := NewAudio(formats, Profile{Name: "playback", Format: "m4a", Codec: "aac"})
+	require.NoError(t, err)
+	assert.IsType(t, &FFMPEG{}, audio)
+
+	_, err = NewAudio(formats, Profile{Name: "unknown", Format: "aiff"})
+	assert.ErrorIs(t, err, ErrInvalidProfile)
+
+	assert.True(t, Profile{Format: "wav", SampleRate: 16000}.native(formats))
+	assert.False(t, Profile{Format: "wav", Filters: []string{"loudnorm"}}.native(formats), "filters need ffmpeg")
+	assert.False(t, Profile{Format: "flac"}.native(formats))
+}
@@ -0,0 +1,148 @@
+package converter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rendition is an encoding of a converted recording stored next to it
+type Rendition struct {
+	Profile  string // Name of the transcoding profile
+	Format   Format
+	Path     string
+	Size     int64  // Bytes
+	Checksum string // Hex encoded SHA-256 of the file
+	Err      error  // Why the rendition could not be encoded, which leaves Path empty, nil when it was
+}
+
+// RenditionGenerator encodes the renditions of a converted recording
+type RenditionGenerator interface {
+	// Generate returns the converted recording itself followed by the renditions encoded from it.
+	// A rendition that cannot be encoded from the master is returned with its Err instead of failing the others.
+	Generate(ctx context.Context, masterPath string) ([]Rendition, error)
+}
+
+// Renditions transcodes the converted recording, the master, with further profiles using ffmpeg.
+// Renditions are encoded from the master rather than the upload, so they share its processing.
+type Renditions struct {
+	formats  *Formats
+	master   Profile
+	profiles []Profile
+	options  []FFMPEGOption
+}
+
+// NewRenditions returns a new instance of Renditions for masters converted with the master profile, encoding the
+// profiles with ffmpeg configured by opts
+func NewRenditions(formats *Formats, master Profile, profiles []Profile, opts ...FFMPEGOption) (*Renditions, error) {
+	names := map[string]bool{master.Name: true}
+	for _, profile := range profiles {
+		if names[profile.Name] {
+			return nil, fmt.Errorf("%w %q: listed twice as rendition", ErrInvalidProfile, profile.Name)
+		}
+		if profile.Name == "" || strings.ContainsAny(profile.Name, `/\`) {
+			return nil, fmt.Errorf("%w %q: rendition names must be plain file names", ErrInvalidProfile, profile.Name)
+		}
+		names[profile.Name] = true
+
+		if err := profile.Validate(formats); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Renditions{formats: formats, master: master, profiles: profiles, options: opts}, nil
+}
+
+// Generate encodes the renditions of the master next to it, replacing renditions left by an earlier attempt.
+// A permanent failure, such as a missing encoder, only fails its rendition, since retrying cannot fix it and the
+// master does not depend on it. Other failures fail the generation and remove the renditions encoded so far.
+func (r *Renditions) Generate(ctx context.Context, masterPath string) ([]Rendition, error) {
+	master, err := r.describe(r.master, masterPath)
+	if err != nil {
+		return nil, err
+	}
+	renditions := []Rendition{master}
+
+	for _, profile := range r.profiles {
+		rendition, err := r.encode(ctx, masterPath, profile)
+		if err != nil && !IsPermanent(err) {
+			removeRenditions(renditions[1:])
+			return nil, err
+		}
+		rendition.Err = err
+		renditions = append(renditions, rendition)
+	}
+
+	return renditions, nil
+}
+
+// encode encodes the rendition of a profile next to the master. A rendition that failed to encode keeps its profile
+// and format.
+func (r *Renditions) encode(ctx context.Context, masterPath string, profile Profile) (Rendition, error) {
+	target, err := r.formats.Lookup(profile.Format)
+	if err != nil {
+		return Rendition{}, err
+	}
+
+	outputPath := RenditionPath(masterPath, profile.Name, target.Extension())
+	opts := append([]FFMPEGOption{FFMPEGWithProfile(profile), FFMPEGWithFormats(r.formats)}, r.options...)
+	ffmpeg := newFFMPEG(profile.Format, opts...)
+	if err = ffmpeg.convert(ctx, masterPath, outputPath, target); err != nil {
+		return Rendition{Profile: profile.Name, Format: target.Name}, fmt.Errorf("failed to encode rendition %q: %w", profile.Name, err)
+	}
+
+	rendition, err := r.describe(profile, outputPath)
+	if err != nil {
+		os.Remove(outputPath)
+		return Rendition{}, err
+	}
+
+	return rendition, nil
+}
+
+// removeRenditions deletes the files of the encoded renditions
+func removeRenditions(renditions []Rendition) {
+	for _, rendition := range renditions {
+		if rendition.Path != "" {
+			os.Remove(rendition.Path)
+		}
+	}
+}
+
+// describe measures the file of a rendition
+func (r *Renditions) describe(profile Profile, path string) (Rendition, error) {
+	format, err := r.formats.Lookup(filepath.Ext(path))
+	if err != nil {
+		return Rendition{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return Rendition{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return Rendition{}, err
+	}
+
+	return Rendition{
+		Profile:  profile.Name,
+		Format:   format.Name,
+		Path:     path,
+		Size:     size,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// RenditionPath returns the path of the rendition of a profile next to the master
+func RenditionPath(masterPath, profile, extension string) string {
+	return fmt.Sprintf("%s.%s.%s", strings.TrimSuffix(masterPath, filepath.Ext(masterPath)), profile, extension)
+}
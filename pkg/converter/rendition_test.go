@@ -0,0 +1,56 @@
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRenditions(t *testing.T) {
+	formats := DefaultFormats()
+	master := Profile{Name: "storage_master", Format: "wav"}
+
+	_, err := NewRenditions(formats, master, []Profile{{Name: "playback", Format: "m4a"}, {Name: "web", Format: "ogg"}})
+	assert.NoError(t, err)
+
+	for name, profile := range map[string]Profile{
+		"master name":        {Name: "storage_master", Format: "m4a"},
+		"path separator":     {Name: "../web", Format: "ogg"},
+		"unsupported format": {Name: "web", Format: "aiff"},
+	} {
+		_, err = NewRenditions(formats, master, []Profile{profile})
+		assert.ErrorIs(t, err, ErrInvalidProfile, name)
+	}
+
+	_, err = NewRenditions(formats, master, []Profile{{Name: "web", Format: "ogg"}, {Name: "web", Format: "webm"}})
+	assert.ErrorIs(t, err, ErrInvalidProfile, "duplicate")
+}
+
+func TestRenditions_Generate_Master(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.converted.wav")
+	require.NoError(t, os.WriteFile(path, []byte("master"), 0o644))
+
+	renditions, err := NewRenditions(DefaultFormats(), Profile{Name: "storage_master", Format: "wav"}, nil)
+	require.NoError(t, err)
+
+	generated, err := renditions.Generate(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, []Rendition{{
+		Profile:  "storage_master",
+		Format:   WAV,
+		Path:     path,
+		Size:     6,
+		Checksum: "fc613b4dfd6736a7bd268c8a0e74ed0d1c04a959f59dd74ef2874983fd443fc9",
+	}}, generated)
+
+	_, err = renditions.Generate(context.Background(), filepath.Join(t.TempDir(), "missing.wav"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRenditionPath(t *testing.T) {
+	assert.Equal(t, "/data/upload.converted.playback.m4a", RenditionPath("/data/upload.converted.wav", "playback", "m4a"))
+}
@@ -0,0 +1,54 @@
+package converter
+
+import "math"
+
+// silenceWindow is the duration in seconds over which the level is measured when looking for silence
+const silenceWindow = 0.01
+
+// TrimSilence removes the leading and trailing silence of the audio, keeping padding frames of it around the sound.
+// Windows whose RMS level stays below threshold dBFS are silent. It returns the trimmed audio and the number of
+// frames removed from the start and from the end; audio that is silent throughout is returned unchanged.
+func TrimSilence(pcm *PCM, threshold float64, padding int) (*PCM, int, int) {
+	window := max(int(silenceWindow*float64(pcm.SampleRate)), 1)
+	frames := pcm.Frames()
+	limit := math.Pow(10, threshold/20)
+
+	loud := func(start int) bool {
+		end := min(start+window, frames)
+		sum := 0.0
+		for _, sample := range pcm.Samples[start*pcm.Channels : end*pcm.Channels] {
+			sum += sample * sample
+		}
+		return math.Sqrt(sum/float64((end-start)*pcm.Channels)) >= limit
+	}
+
+	first := -1
+	for start := 0; start < frames; start += window {
+		if loud(start) {
+			first = start
+			break
+		}
+	}
+	if first < 0 {
+		return pcm, 0, 0
+	}
+
+	last := first
+	for start := (frames - 1) / window * window; start > first; start -= window {
+		if loud(start) {
+			last = start
+			break
+		}
+	}
+
+	begin := max(first-padding, 0)
+	end := min(last+window+padding, frames)
+
+	trimmed := &PCM{
+		SampleRate: pcm.SampleRate,
+		Channels:   pcm.Channels,
+		Samples:    pcm.Samples[begin*pcm.Channels : end*pcm.Channels],
+	}
+
+	return trimmed, begin, frames - end
+}
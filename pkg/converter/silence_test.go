@@ -0,0 +1,41 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrimSilence(t *testing.T) {
+	pcm := concat(silence(8000, 2, 1), tone(440, -20, 8000, 2, 2), silence(8000, 2, 0.5))
+
+	t.Run("trims to the sound", func(t *testing.T) {
+		trimmed, start, end := TrimSilence(pcm, -50, 0)
+		assert.Equal(t, 8000, start)
+		assert.Equal(t, 4000, end)
+		assert.Equal(t, 16000, trimmed.Frames())
+		assert.Equal(t, 2, trimmed.Channels)
+	})
+
+	t.Run("keeps padding", func(t *testing.T) {
+		trimmed, start, end := TrimSilence(pcm, -50, 800)
+		assert.Equal(t, 7200, start)
+		assert.Equal(t, 3200, end)
+		assert.Equal(t, 17600, trimmed.Frames())
+	})
+
+	t.Run("noise below the threshold is silence", func(t *testing.T) {
+		noisy := concat(tone(50, -60, 8000, 2, 1), tone(440, -20, 8000, 2, 2), tone(50, -60, 8000, 2, 1))
+		_, start, end := TrimSilence(noisy, -50, 0)
+		assert.Equal(t, 8000, start)
+		assert.Equal(t, 8000, end)
+	})
+
+	t.Run("silence throughout", func(t *testing.T) {
+		quiet := silence(8000, 1, 1)
+		trimmed, start, end := TrimSilence(quiet, -50, 0)
+		assert.Same(t, quiet, trimmed)
+		assert.Zero(t, start)
+		assert.Zero(t, end)
+	})
+}
@@ -0,0 +1,301 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"strings"
+)
+
+const (
+	defaultSpectrogramFFTSize = 512
+	defaultSpectrogramScale   = LinearScale
+	defaultSpectrogramColors  = "viridis"
+	defaultSpectrogramRange   = 80
+
+	minSpectrogramFFTSize = 16
+	maxSpectrogramFFTSize = 16384
+	maxSpectrogramHeight  = 4096
+	maxSpectrogramWidth   = 4096
+
+	// spectrogramCheckInterval is the number of columns computed between checks of the context
+	spectrogramCheckInterval = 64
+
+	logScaleMinFrequency = 20 // Hz, the bottom row of the log scale
+)
+
+var ErrInvalidSpectrogram = errors.New("invalid spectrogram")
+
+// SpectrogramArtifactPrefix starts the names spectrograms are cached under next to the recording
+const SpectrogramArtifactPrefix = "spectrogram-"
+
+// FrequencyScale maps the rows of a spectrogram to frequencies
+type FrequencyScale string
+
+const (
+	LinearScale FrequencyScale = "linear"
+	LogScale    FrequencyScale = "log"
+	MelScale    FrequencyScale = "mel"
+)
+
+// colorMaps holds the stops of the color maps, evenly spread from silence to full scale
+var colorMaps = map[string][]color.RGBA{
+	"grayscale": {{0, 0, 0, 255}, {255, 255, 255, 255}},
+	"viridis":   {{0x44, 0x01, 0x54, 255}, {0x3b, 0x52, 0x8b, 255}, {0x21, 0x91, 0x8c, 255}, {0x5e, 0xc9, 0x62, 255}, {0xfd, 0xe7, 0x25, 255}},
+	"magma":     {{0x00, 0x00, 0x04, 255}, {0x3b, 0x0f, 0x70, 255}, {0x8c, 0x29, 0x81, 255}, {0xde, 0x49, 0x68, 255}, {0xfe, 0x9f, 0x6d, 255}, {0xfc, 0xfd, 0xbf, 255}},
+	"inferno":   {{0x00, 0x00, 0x04, 255}, {0x42, 0x0a, 0x68, 255}, {0x93, 0x26, 0x67, 255}, {0xdd, 0x51, 0x3a, 255}, {0xfc, 0xa5, 0x0a, 255}, {0xfc, 0xff, 0xa4, 255}},
+}
+
+// SpectrogramConfig holds the settings of a spectrogram, zero values use the defaults
+type SpectrogramConfig struct {
+	FFTSize  int            // Frames per transform, a power of two setting the frequency resolution
+	Hop      int            // Frames between transforms, one column each; a quarter of FFTSize by default, at least an eighth
+	Scale    FrequencyScale // Frequency axis: linear, log or mel
+	ColorMap string         // grayscale, viridis, magma or inferno
+	Height   int            // Rows of the image, one per frequency bin by default
+	Range    float64        // Dynamic range in dB below full scale, quieter bins take the first color
+}
+
+func (c SpectrogramConfig) withDefaults() SpectrogramConfig {
+	if c.FFTSize == 0 {
+		c.FFTSize = defaultSpectrogramFFTSize
+	}
+	if c.Hop == 0 {
+		c.Hop = max(1, c.FFTSize/4)
+	}
+	if c.Scale == "" {
+		c.Scale = defaultSpectrogramScale
+	}
+	if c.ColorMap == "" {
+		c.ColorMap = defaultSpectrogramColors
+	}
+	if c.Height == 0 {
+		c.Height = c.FFTSize / 2
+	}
+	if c.Range == 0 {
+		c.Range = defaultSpectrogramRange
+	}
+
+	return c
+}
+
+// Validate checks the settings, applying the defaults first
+func (c SpectrogramConfig) Validate() error {
+	c = c.withDefaults()
+
+	if c.FFTSize < minSpectrogramFFTSize || c.FFTSize > maxSpectrogramFFTSize || bits.OnesCount(uint(c.FFTSize)) != 1 {
+		return fmt.Errorf("%w: FFT size %d is not a power of two between %d and %d", ErrInvalidSpectrogram, c.FFTSize, minSpectrogramFFTSize, maxSpectrogramFFTSize)
+	}
+	if c.Hop < max(1, c.FFTSize/8) {
+		return fmt.Errorf("%w: hop of %d frames is less than an eighth of the FFT size", ErrInvalidSpectrogram, c.Hop)
+	}
+	if c.Height < 0 || c.Height > maxSpectrogramHeight {
+		return fmt.Errorf("%w: height %d is not between 1 and %d", ErrInvalidSpectrogram, c.Height, maxSpectrogramHeight)
+	}
+	if c.Range < 0 {
+		return fmt.Errorf("%w: dynamic range of %g dB", ErrInvalidSpectrogram, c.Range)
+	}
+	switch c.Scale {
+	case LinearScale, LogScale, MelScale:
+	default:
+		return fmt.Errorf("%w: frequency scale %q", ErrInvalidSpectrogram, c.Scale)
+	}
+	if _, ok := colorMaps[strings.ToLower(c.ColorMap)]; !ok {
+		return fmt.Errorf("%w: color map %q", ErrInvalidSpectrogram, c.ColorMap)
+	}
+
+	return nil
+}
+
+// Key identifies the rendering of the settings, configurations rendering the same image share it
+func (c SpectrogramConfig) Key() string {
+	c = c.withDefaults()
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d/%d/%s/%s/%d/%g", c.FFTSize, c.Hop, c.Scale, strings.ToLower(c.ColorMap), c.Height, c.Range)
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// Cacheable reports whether the settings are worth caching: those of configured, or of configured with another FFT size,
+// scale or color map. The number of cached variants stays small whatever settings are requested.
+func (c SpectrogramConfig) Cacheable(configured SpectrogramConfig) bool {
+	c = c.withDefaults()
+	configured = configured.withDefaults()
+
+	variant := SpectrogramConfig{FFTSize: c.FFTSize, Scale: c.Scale, ColorMap: c.ColorMap, Range: configured.Range}
+	if c.FFTSize == configured.FFTSize {
+		variant.Hop = configured.Hop
+		variant.Height = configured.Height
+	}
+
+	return c.Key() == variant.Key()
+}
+
+// RenderSpectrogram encodes the spectrogram of the audio as a PNG image
+func RenderSpectrogram(ctx context.Context, w io.Writer, pcm *PCM, config SpectrogramConfig) error {
+	img, err := Spectrogram(ctx, pcm, config)
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, img)
+}
+
+// Spectrogram computes the short-time Fourier transform of the audio mixed down to one channel and draws it, time
+// running left to right and frequency bottom to top. Magnitudes are in dB relative to a full scale sine.
+// The hop is widened for long recordings so the image is at most maxSpectrogramWidth columns wide, and the
+// computation stops with the cause of ctx once it is done.
+func Spectrogram(ctx context.Context, pcm *PCM, config SpectrogramConfig) (*image.RGBA, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config = config.withDefaults()
+	if pcm.SampleRate <= 0 || pcm.Channels <= 0 {
+		return nil, fmt.Errorf("%w: no audio", ErrInvalidSpectrogram)
+	}
+
+	mono := Remix(pcm, 1).Samples
+	if frames := len(mono) - config.FFTSize; frames > 0 {
+		config.Hop = max(config.Hop, (frames+maxSpectrogramWidth-2)/(maxSpectrogramWidth-1))
+	}
+	columns := max(1, (len(mono)-config.FFTSize)/config.Hop+1)
+	img := image.NewRGBA(image.Rect(0, 0, columns, config.Height))
+
+	window := hann(config.FFTSize)
+	rows := rowBins(config, pcm.SampleRate)
+	stops := colorMaps[strings.ToLower(config.ColorMap)]
+	// a Hann windowed full scale sine peaks at a quarter of the FFT size
+	reference := float64(config.FFTSize) / 4
+
+	frame := make([]complex128, config.FFTSize)
+	levels := make([]float64, config.FFTSize/2+1)
+	for column := 0; column < columns; column++ {
+		if column%spectrogramCheckInterval == 0 {
+			if err := context.Cause(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		start := column * config.Hop
+		for i := range frame {
+			sample := 0.0
+			if start+i < len(mono) {
+				sample = mono[start+i]
+			}
+			frame[i] = complex(sample*window[i], 0)
+		}
+		fft(frame)
+
+		for bin := range levels {
+			levels[bin] = 20 * math.Log10(cmplx.Abs(frame[bin])/reference+1e-12)
+		}
+		for row, bin := range rows {
+			level := interpolate(levels, bin)
+			img.SetRGBA(column, row, colorAt(stops, (level+config.Range)/config.Range))
+		}
+	}
+
+	return img, nil
+}
+
+// rowBins returns the fractional frequency bin of each row, the top row showing the Nyquist frequency
+func rowBins(config SpectrogramConfig, sampleRate int) []float64 {
+	nyquist := float64(sampleRate) / 2
+	binWidth := float64(sampleRate) / float64(config.FFTSize)
+
+	bins := make([]float64, config.Height)
+	for row := range bins {
+		// position of the row from the bottom, 0 to 1
+		position := 1.0
+		if config.Height > 1 {
+			position = float64(config.Height-1-row) / float64(config.Height-1)
+		}
+
+		var frequency float64
+		switch config.Scale {
+		case LogScale:
+			low := math.Min(logScaleMinFrequency, nyquist)
+			frequency = low * math.Pow(nyquist/low, position)
+		case MelScale:
+			frequency = melToHz(position * hzToMel(nyquist))
+		default:
+			frequency = position * nyquist
+		}
+		bins[row] = frequency / binWidth
+	}
+
+	return bins
+}
+
+func hzToMel(frequency float64) float64 {
+	return 2595 * math.Log10(1+frequency/700)
+}
+
+func melToHz(mel float64) float64 {
+	return 700 * (math.Pow(10, mel/2595) - 1)
+}
+
+// interpolate returns the value at a fractional index
+func interpolate(values []float64, index float64) float64 {
+	lower := min(int(index), len(values)-1)
+	upper := min(lower+1, len(values)-1)
+	fraction := index - float64(lower)
+
+	return values[lower] + (values[upper]-values[lower])*fraction
+}
+
+// colorAt returns the color of a value from 0 to 1, blending the neighboring stops
+func colorAt(stops []color.RGBA, value float64) color.RGBA {
+	value = math.Max(0, math.Min(1, value)) * float64(len(stops)-1)
+	lower := min(int(value), len(stops)-2)
+	fraction := value - float64(lower)
+
+	blend := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*fraction))
+	}
+	a, b := stops[lower], stops[lower+1]
+	return color.RGBA{R: blend(a.R, b.R), G: blend(a.G, b.G), B: blend(a.B, b.B), A: 255}
+}
+
+// hann returns a Hann window of size frames
+func hann(size int) []float64 {
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+
+	return window
+}
+
+// fft computes the discrete Fourier transform in place with the iterative radix-2 Cooley-Tukey algorithm, the length
+// must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+	shift := 64 - bits.Len(uint(n-1))
+	for i := range x {
+		if j := int(bits.Reverse64(uint64(i)) >> shift); j > i {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], w*x[start+k+size/2]
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
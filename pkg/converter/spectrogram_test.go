@@ -0,0 +1,158 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFFT(t *testing.T) {
+	input := make([]complex128, 64)
+	for i := range input {
+		input[i] = complex(math.Sin(float64(i)*0.3)+0.5*math.Cos(float64(i)*1.7), 0)
+	}
+
+	// naive discrete Fourier transform
+	expected := make([]complex128, len(input))
+	for k := range expected {
+		for n, x := range input {
+			expected[k] += x * cmplx.Exp(complex(0, -2*math.Pi*float64(k*n)/float64(len(input))))
+		}
+	}
+
+	fft(input)
+	for k := range expected {
+		assert.InDelta(t, real(expected[k]), real(input[k]), 1e-9, "bin %d", k)
+		assert.InDelta(t, imag(expected[k]), imag(input[k]), 1e-9, "bin %d", k)
+	}
+}
+
+func TestSpectrogramConfig_Validate(t *testing.T) {
+	assert.NoError(t, SpectrogramConfig{}.Validate())
+	assert.NoError(t, SpectrogramConfig{FFTSize: 2048, Hop: 300, Scale: MelScale, ColorMap: "Magma", Height: 300}.Validate())
+
+	for name, config := range map[string]SpectrogramConfig{
+		"fft size not a power of two": {FFTSize: 1000},
+		"fft size too small":          {FFTSize: 8},
+		"negative hop":                {Hop: -1},
+		"hop below an eighth":         {FFTSize: 16384, Hop: 1},
+		"height too large":            {Height: 5000},
+		"unknown scale":               {Scale: "bark"},
+		"unknown color map":           {ColorMap: "jet"},
+		"negative range":              {Range: -10},
+	} {
+		assert.ErrorIs(t, config.Validate(), ErrInvalidSpectrogram, name)
+	}
+}
+
+func TestSpectrogramConfig_Key(t *testing.T) {
+	assert.Equal(t, SpectrogramConfig{}.Key(), SpectrogramConfig{FFTSize: 512, Hop: 128, Scale: LinearScale, ColorMap: "Viridis"}.Key(),
+		"defaults render the same image as their explicit values")
+	assert.NotEqual(t, SpectrogramConfig{}.Key(), SpectrogramConfig{Scale: LogScale}.Key())
+	assert.Len(t, SpectrogramConfig{}.Key(), 16)
+}
+
+func TestSpectrogramConfig_Cacheable(t *testing.T) {
+	configured := SpectrogramConfig{FFTSize: 512, Hop: 100, Height: 200, Range: 90}
+
+	assert.True(t, configured.Cacheable(configured))
+	assert.True(t, SpectrogramConfig{FFTSize: 512, Hop: 100, Height: 200, Range: 90, Scale: MelScale, ColorMap: "magma"}.Cacheable(configured))
+	assert.True(t, SpectrogramConfig{FFTSize: 2048, Range: 90}.Cacheable(configured), "other FFT sizes with their default hop and height")
+
+	assert.False(t, SpectrogramConfig{FFTSize: 512, Hop: 101, Height: 200, Range: 90}.Cacheable(configured))
+	assert.False(t, SpectrogramConfig{FFTSize: 512, Hop: 100, Height: 201, Range: 90}.Cacheable(configured))
+	assert.False(t, SpectrogramConfig{FFTSize: 512, Hop: 100, Height: 200, Range: 90.5}.Cacheable(configured))
+	assert.False(t, SpectrogramConfig{FFTSize: 2048, Hop: 1024, Range: 90}.Cacheable(configured))
+}
+
+func TestSpectrogram(t *testing.T) {
+	// 2 kHz at 16 kHz is a quarter of the Nyquist frequency
+	pcm := tone(2000, -6, 16000, 2, 1)
+
+	t.Run("linear", func(t *testing.T) {
+		img, err := Spectrogram(context.Background(), pcm, SpectrogramConfig{FFTSize: 256, Hop: 64, ColorMap: "grayscale", Height: 129})
+		require.NoError(t, err)
+		assert.Equal(t, (16000-256)/64+1, img.Bounds().Dx())
+		assert.Equal(t, 129, img.Bounds().Dy())
+
+		column := img.Bounds().Dx() / 2
+		assert.Equal(t, 96, brightestRow(img.RGBAAt, column, 129), "rows run from the Nyquist frequency down to 0 Hz")
+		assert.Greater(t, img.RGBAAt(column, 96).R, uint8(200), "the tone is near full scale")
+		assert.Less(t, img.RGBAAt(column, 10).R, uint8(30), "no energy far from the tone")
+	})
+
+	t.Run("log and mel", func(t *testing.T) {
+		for _, scale := range []FrequencyScale{LogScale, MelScale} {
+			img, err := Spectrogram(context.Background(), pcm, SpectrogramConfig{FFTSize: 256, Scale: scale, ColorMap: "grayscale", Height: 200})
+			require.NoError(t, err)
+
+			bins := rowBins(SpectrogramConfig{FFTSize: 256, Scale: scale, Height: 200}, 16000)
+			row := brightestRow(img.RGBAAt, img.Bounds().Dx()/2, 200)
+			assert.InDelta(t, 32, bins[row], 1, "%s scale shows the tone at its bin", scale)
+		}
+	})
+
+	t.Run("encodes png", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, RenderSpectrogram(context.Background(), &buf, pcm, SpectrogramConfig{}))
+
+		img, err := png.Decode(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 256, img.Bounds().Dy())
+	})
+
+	t.Run("shorter than the fft", func(t *testing.T) {
+		img, err := Spectrogram(context.Background(), silence(16000, 1, 0.01), SpectrogramConfig{ColorMap: "grayscale"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, img.Bounds().Dx())
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, img.RGBAAt(0, 0), "silence takes the first color")
+	})
+
+	t.Run("long recordings", func(t *testing.T) {
+		img, err := Spectrogram(context.Background(), silence(16000, 1, 80), SpectrogramConfig{FFTSize: 16, Hop: 2, Height: 4})
+		require.NoError(t, err)
+		assert.LessOrEqual(t, img.Bounds().Dx(), maxSpectrogramWidth, "the hop is widened to cap the width")
+		assert.Greater(t, img.Bounds().Dx(), maxSpectrogramWidth-10)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := Spectrogram(ctx, pcm, SpectrogramConfig{})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Spectrogram(context.Background(), pcm, SpectrogramConfig{FFTSize: 100})
+		assert.ErrorIs(t, err, ErrInvalidSpectrogram)
+	})
+}
+
+// brightestRow returns the row of the column with the highest red value
+func brightestRow(at func(x, y int) color.RGBA, column, height int) int {
+	brightest := 0
+	for row := 1; row < height; row++ {
+		if at(column, row).R > at(column, brightest).R {
+			brightest = row
+		}
+	}
+
+	return brightest
+}
+
+func TestColorAt(t *testing.T) {
+	stops := colorMaps["viridis"]
+	assert.Equal(t, stops[0], colorAt(stops, -1))
+	assert.Equal(t, stops[0], colorAt(stops, 0))
+	assert.Equal(t, stops[2], colorAt(stops, 0.5))
+	assert.Equal(t, stops[4], colorAt(stops, 1))
+	assert.Equal(t, color.RGBA{128, 128, 128, 255}, colorAt(colorMaps["grayscale"], 0.5))
+}
@@ -0,0 +1,339 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// WAV format tags
+const (
+	wavFormatPCM        uint16 = 0x0001
+	wavFormatFloat      uint16 = 0x0003
+	wavFormatExtensible uint16 = 0xFFFE
+)
+
+// maxFormatChunkSize is the largest fmt chunk accepted, the extensible format needs 40 bytes
+const maxFormatChunkSize = 64
+
+var (
+	ErrInvalidWAV     = errors.New("invalid wav file")
+	ErrUnsupportedWAV = errors.New("unsupported wav encoding")
+)
+
+// SampleEncoding is the encoding of WAV samples
+type SampleEncoding int
+
+const (
+	SampleInt   SampleEncoding = iota // Signed integers, unsigned for 8 bits
+	SampleFloat                       // IEEE floats
+)
+
+// PCMFormat describes how samples are stored in a WAV file
+type PCMFormat struct {
+	Encoding SampleEncoding
+	BitDepth int // 8, 16, 24 or 32 for integers, 32 or 64 for floats
+}
+
+// ParsePCMFormat parses the ffmpeg name of a sample format: u8, s16, s24, s32, f32 or f64.
+// An empty name returns the zero PCMFormat, which keeps the input encoding.
+func ParsePCMFormat(name string) (PCMFormat, error) {
+	if name == "" {
+		return PCMFormat{}, nil
+	}
+
+	var format PCMFormat
+	switch name[0] {
+	case 'u', 's':
+		format.Encoding = SampleInt
+	case 'f':
+		format.Encoding = SampleFloat
+	default:
+		return PCMFormat{}, fmt.Errorf("%w: %s", ErrUnsupportedWAV, name)
+	}
+
+	bitDepth, err := strconv.Atoi(name[1:])
+	if err != nil || (name[0] == 'u') != (bitDepth == 8) {
+		return PCMFormat{}, fmt.Errorf("%w: %s", ErrUnsupportedWAV, name)
+	}
+	format.BitDepth = bitDepth
+
+	if err = format.validate(); err != nil {
+		return PCMFormat{}, err
+	}
+
+	return format, nil
+}
+
+// String returns the ffmpeg name of the sample format
+func (f PCMFormat) String() string {
+	switch {
+	case f.Encoding == SampleFloat:
+		return "f" + strconv.Itoa(f.BitDepth)
+	case f.BitDepth == 8:
+		return "u8"
+	default:
+		return "s" + strconv.Itoa(f.BitDepth)
+	}
+}
+
+func (f PCMFormat) validate() error {
+	switch {
+	case f.Encoding == SampleInt && (f.BitDepth == 8 || f.BitDepth == 16 || f.BitDepth == 24 || f.BitDepth == 32):
+		return nil
+	case f.Encoding == SampleFloat && (f.BitDepth == 32 || f.BitDepth == 64):
+		return nil
+	default:
+		return fmt.Errorf("%w: %d bit encoding %d", ErrUnsupportedWAV, f.BitDepth, f.Encoding)
+	}
+}
+
+// PCM is decoded audio, with interleaved samples normalized to [-1, 1]
+type PCM struct {
+	SampleRate int
+	Channels   int
+	Samples    []float64
+}
+
+// Frames returns the number of samples per channel
+func (p *PCM) Frames() int {
+	if p.Channels == 0 {
+		return 0
+	}
+
+	return len(p.Samples) / p.Channels
+}
+
+// DecodeWAV decodes a RIFF WAVE stream of integer or float samples, skipping the chunks it does not need
+func DecodeWAV(r io.Reader) (*PCM, PCMFormat, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, PCMFormat{}, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, PCMFormat{}, fmt.Errorf("%w: missing RIFF WAVE header", ErrInvalidWAV)
+	}
+
+	var pcm *PCM
+	var format PCMFormat
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, PCMFormat{}, fmt.Errorf("%w: missing data chunk", ErrInvalidWAV)
+		}
+		id, size := string(chunk[0:4]), binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			// the size is read from the upload, only chunks the format can fill are allocated
+			if size > maxFormatChunkSize {
+				return nil, PCMFormat{}, fmt.Errorf("%w: fmt chunk of %d bytes", ErrInvalidWAV, size)
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, PCMFormat{}, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+			}
+			var err error
+			if pcm, format, err = decodeFormatChunk(body); err != nil {
+				return nil, PCMFormat{}, err
+			}
+		case "data":
+			if pcm == nil {
+				return nil, PCMFormat{}, fmt.Errorf("%w: data chunk before fmt chunk", ErrInvalidWAV)
+			}
+			// streams written on the fly leave the data size unset, in which case the data runs to the end
+			var data io.Reader = r
+			if size != math.MaxUint32 {
+				data = io.LimitReader(r, int64(size))
+			}
+			samples, err := decodeSamples(data, format, pcm.Channels)
+			if err != nil {
+				return nil, PCMFormat{}, err
+			}
+			pcm.Samples = samples
+			return pcm, format, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return nil, PCMFormat{}, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+			}
+		}
+
+		// chunks are padded to an even size
+		if size%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return nil, PCMFormat{}, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+			}
+		}
+	}
+}
+
+func decodeFormatChunk(body []byte) (*PCM, PCMFormat, error) {
+	if len(body) < 16 {
+		return nil, PCMFormat{}, fmt.Errorf("%w: fmt chunk too short", ErrInvalidWAV)
+	}
+
+	tag := binary.LittleEndian.Uint16(body[0:2])
+	channels := int(binary.LittleEndian.Uint16(body[2:4]))
+	sampleRate := int(binary.LittleEndian.Uint32(body[4:8]))
+	bitDepth := int(binary.LittleEndian.Uint16(body[14:16]))
+
+	if tag == wavFormatExtensible {
+		// the sub format GUID starts with the actual format tag
+		if len(body) < 26 {
+			return nil, PCMFormat{}, fmt.Errorf("%w: extensible fmt chunk too short", ErrInvalidWAV)
+		}
+		tag = binary.LittleEndian.Uint16(body[24:26])
+	}
+
+	var format PCMFormat
+	switch tag {
+	case wavFormatPCM:
+		format = PCMFormat{Encoding: SampleInt, BitDepth: bitDepth}
+	case wavFormatFloat:
+		format = PCMFormat{Encoding: SampleFloat, BitDepth: bitDepth}
+	default:
+		return nil, PCMFormat{}, fmt.Errorf("%w: format tag %#x", ErrUnsupportedWAV, tag)
+	}
+	if err := format.validate(); err != nil {
+		return nil, PCMFormat{}, err
+	}
+	if channels == 0 || sampleRate == 0 {
+		return nil, PCMFormat{}, fmt.Errorf("%w: %d channels at %d Hz", ErrInvalidWAV, channels, sampleRate)
+	}
+
+	return &PCM{SampleRate: sampleRate, Channels: channels}, format, nil
+}
+
+// decodeSamples decodes interleaved samples, rejecting data that ends within a frame of channels samples
+func decodeSamples(r io.Reader, format PCMFormat, channels int) ([]float64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+	}
+
+	width := format.BitDepth / 8
+	if frame := channels * width; len(data)%frame != 0 {
+		return nil, fmt.Errorf("%w: %d bytes of sample data are no whole number of %d byte frames", ErrInvalidWAV, len(data), frame)
+	}
+
+	samples := make([]float64, len(data)/width)
+	for i := range samples {
+		b := data[i*width : (i+1)*width]
+		switch {
+		case format.Encoding == SampleFloat && width == 4:
+			samples[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case format.Encoding == SampleFloat:
+			samples[i] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case width == 1:
+			samples[i] = (float64(b[0]) - 128) / 128
+		case width == 2:
+			samples[i] = float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+		case width == 3:
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			samples[i] = float64(v) / (1 << 23)
+		default:
+			samples[i] = float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+		}
+	}
+
+	return samples, nil
+}
+
+// EncodeWAV encodes pcm as a RIFF WAVE stream. Integer samples are clipped to [-1, 1] and rounded.
+func EncodeWAV(w io.Writer, pcm *PCM, format PCMFormat) error {
+	if err := format.validate(); err != nil {
+		return err
+	}
+
+	width := format.BitDepth / 8
+	dataSize := len(pcm.Samples) * width
+	tag := wavFormatPCM
+	if format.Encoding == SampleFloat {
+		tag = wavFormatFloat
+	}
+
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	riffSize := 4 + 8 + 16 + 8 + dataSize + dataSize%2
+	if tag == wavFormatFloat {
+		// non-PCM formats carry a fact chunk with the number of frames
+		riffSize += 8 + 4
+	}
+	binary.Write(&header, binary.LittleEndian, uint32(riffSize))
+	header.WriteString("WAVE")
+
+	header.WriteString("fmt ")
+	for _, field := range []any{
+		uint32(16),
+		tag,
+		uint16(pcm.Channels),
+		uint32(pcm.SampleRate),
+		uint32(pcm.SampleRate * pcm.Channels * width),
+		uint16(pcm.Channels * width),
+		uint16(format.BitDepth),
+	} {
+		binary.Write(&header, binary.LittleEndian, field)
+	}
+
+	if tag == wavFormatFloat {
+		header.WriteString("fact")
+		binary.Write(&header, binary.LittleEndian, []uint32{4, uint32(pcm.Frames())})
+	}
+
+	header.WriteString("data")
+	binary.Write(&header, binary.LittleEndian, uint32(dataSize))
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	b := make([]byte, width)
+	for _, sample := range pcm.Samples {
+		encodeSample(b, sample, format)
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	if dataSize%2 == 1 {
+		if err := bw.WriteByte(0); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func encodeSample(b []byte, sample float64, format PCMFormat) {
+	if format.Encoding == SampleFloat {
+		if format.BitDepth == 32 {
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(sample)))
+		} else {
+			binary.LittleEndian.PutUint64(b, math.Float64bits(sample))
+		}
+		return
+	}
+
+	sample = max(-1, min(1, sample))
+	switch format.BitDepth {
+	case 8:
+		b[0] = uint8(max(0, min(255, math.Round(sample*128+128))))
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(int16(quantize(sample, 1<<15))))
+	case 24:
+		v := uint32(quantize(sample, 1<<23))
+		b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+	default:
+		binary.LittleEndian.PutUint32(b, uint32(quantize(sample, 1<<31)))
+	}
+}
+
+// quantize scales a sample in [-1, 1] to an integer in [-scale, scale-1]
+func quantize(sample float64, scale float64) int64 {
+	return int64(max(-scale, min(scale-1, math.Round(sample*scale))))
+}
@@ -0,0 +1,176 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAV_RoundTrip(t *testing.T) {
+	pcm := &PCM{SampleRate: 44100, Channels: 2, Samples: []float64{0, 0, 0.5, -0.5, 0.25, -1, 0.999, -0.75}}
+
+	tests := []struct {
+		format    PCMFormat
+		tolerance float64
+	}{
+		{format: PCMFormat{Encoding: SampleInt, BitDepth: 8}, tolerance: 1.0 / 128},
+		{format: PCMFormat{Encoding: SampleInt, BitDepth: 16}, tolerance: 1.0 / (1 << 15)},
+		{format: PCMFormat{Encoding: SampleInt, BitDepth: 24}, tolerance: 1.0 / (1 << 23)},
+		{format: PCMFormat{Encoding: SampleInt, BitDepth: 32}, tolerance: 1.0 / (1 << 31)},
+		{format: PCMFormat{Encoding: SampleFloat, BitDepth: 32}, tolerance: 1e-7},
+		{format: PCMFormat{Encoding: SampleFloat, BitDepth: 64}, tolerance: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, EncodeWAV(&buf, pcm, tt.format))
+
+			decoded, format, err := DecodeWAV(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, format)
+			assert.Equal(t, pcm.SampleRate, decoded.SampleRate)
+			assert.Equal(t, pcm.Channels, decoded.Channels)
+			require.Len(t, decoded.Samples, len(pcm.Samples))
+			for i, sample := range pcm.Samples {
+				assert.InDelta(t, sample, decoded.Samples[i], tt.tolerance, "sample %d", i)
+			}
+		})
+	}
+}
+
+func TestEncodeWAV_Header(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, EncodeWAV(&buf, &PCM{SampleRate: 8000, Channels: 1, Samples: []float64{0, 1, -1}}, PCMFormat{BitDepth: 16}))
+
+	b := buf.Bytes()
+	assert.Equal(t, "RIFF", string(b[0:4]))
+	assert.Equal(t, uint32(len(b)-8), binary.LittleEndian.Uint32(b[4:8]))
+	assert.Equal(t, "WAVE", string(b[8:12]))
+	assert.Equal(t, wavFormatPCM, binary.LittleEndian.Uint16(b[20:22]))
+	assert.Equal(t, uint32(16000), binary.LittleEndian.Uint32(b[28:32]), "byte rate")
+	assert.Equal(t, "data", string(b[36:40]))
+	assert.Equal(t, uint32(6), binary.LittleEndian.Uint32(b[40:44]))
+	assert.Equal(t, []byte{0, 0, 0xff, 0x7f, 0x00, 0x80}, b[44:], "samples are clipped to the integer range")
+
+	t.Run("odd data sizes are padded", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, EncodeWAV(&buf, &PCM{SampleRate: 8000, Channels: 1, Samples: []float64{0}}, PCMFormat{BitDepth: 8}))
+		assert.Equal(t, 46, buf.Len())
+		assert.Equal(t, uint32(38), binary.LittleEndian.Uint32(buf.Bytes()[4:8]))
+	})
+}
+
+// wavFile assembles a WAV file from raw chunks
+func wavFile(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+
+	file := []byte("RIFF")
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(body)))
+	return append(file, body...)
+}
+
+func chunk(id string, body []byte) []byte {
+	c := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	c = append(c, body...)
+	if len(body)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func fmtChunk(tag uint16, channels, sampleRate, bitDepth int, extension []byte) []byte {
+	var body []byte
+	body = binary.LittleEndian.AppendUint16(body, tag)
+	body = binary.LittleEndian.AppendUint16(body, uint16(channels))
+	body = binary.LittleEndian.AppendUint32(body, uint32(sampleRate))
+	body = binary.LittleEndian.AppendUint32(body, uint32(sampleRate*channels*bitDepth/8))
+	body = binary.LittleEndian.AppendUint16(body, uint16(channels*bitDepth/8))
+	body = binary.LittleEndian.AppendUint16(body, uint16(bitDepth))
+	return chunk("fmt ", append(body, extension...))
+}
+
+func TestDecodeWAV(t *testing.T) {
+	samples := []byte{0x00, 0x40, 0x00, 0xc0} // 0.5, -0.5 in 16 bits
+
+	t.Run("skips unknown chunks", func(t *testing.T) {
+		file := wavFile(chunk("LIST", []byte("odd")), fmtChunk(wavFormatPCM, 1, 8000, 16, nil), chunk("data", samples), chunk("LIST", []byte("info")))
+
+		pcm, _, err := DecodeWAV(bytes.NewReader(file))
+		require.NoError(t, err)
+		assert.Equal(t, []float64{0.5, -0.5}, pcm.Samples)
+	})
+
+	t.Run("extensible format", func(t *testing.T) {
+		extension := []byte{22, 0, 24, 0, 4, 0, 0, 0}
+		extension = binary.LittleEndian.AppendUint16(extension, wavFormatFloat)
+		extension = append(extension, make([]byte, 14)...)
+		data := binary.LittleEndian.AppendUint32(nil, math.Float32bits(0.25))
+
+		pcm, format, err := DecodeWAV(bytes.NewReader(wavFile(fmtChunk(wavFormatExtensible, 1, 48000, 32, extension), chunk("data", data))))
+		require.NoError(t, err)
+		assert.Equal(t, PCMFormat{Encoding: SampleFloat, BitDepth: 32}, format)
+		assert.Equal(t, []float64{0.25}, pcm.Samples)
+	})
+
+	t.Run("unknown data size runs to the end", func(t *testing.T) {
+		data := append([]byte("data"), 0xff, 0xff, 0xff, 0xff)
+		pcm, _, err := DecodeWAV(bytes.NewReader(wavFile(fmtChunk(wavFormatPCM, 1, 8000, 16, nil), append(data, samples...))))
+		require.NoError(t, err)
+		assert.Equal(t, []float64{0.5, -0.5}, pcm.Samples)
+	})
+
+	t.Run("24 bit sign extension", func(t *testing.T) {
+		pcm, _, err := DecodeWAV(bytes.NewReader(wavFile(fmtChunk(wavFormatPCM, 1, 8000, 24, nil), chunk("data", []byte{0, 0, 0xc0}))))
+		require.NoError(t, err)
+		assert.Equal(t, []float64{-0.5}, pcm.Samples)
+	})
+
+	errorTests := []struct {
+		name string
+		file []byte
+		err  error
+	}{
+		{name: "not a wav file", file: []byte("ID3\x04\x00\x00\x00\x00\x00\x00\x00\x00"), err: ErrInvalidWAV},
+		{name: "truncated", file: []byte("RIFF"), err: ErrInvalidWAV},
+		{name: "missing data chunk", file: wavFile(fmtChunk(wavFormatPCM, 1, 8000, 16, nil)), err: ErrInvalidWAV},
+		{name: "data before fmt", file: wavFile(chunk("data", samples)), err: ErrInvalidWAV},
+		{name: "compressed", file: wavFile(fmtChunk(0x0055, 1, 8000, 16, nil), chunk("data", samples)), err: ErrUnsupportedWAV},
+		{name: "unsupported bit depth", file: wavFile(fmtChunk(wavFormatPCM, 1, 8000, 12, nil), chunk("data", samples)), err: ErrUnsupportedWAV},
+		{name: "no channels", file: wavFile(fmtChunk(wavFormatPCM, 0, 8000, 16, nil), chunk("data", samples)), err: ErrInvalidWAV},
+		{name: "oversized fmt chunk", file: wavFile(append([]byte("fmt "), 0xf0, 0xff, 0xff, 0xff)), err: ErrInvalidWAV},
+		{name: "partial sample", file: wavFile(fmtChunk(wavFormatPCM, 1, 8000, 16, nil), chunk("data", samples[:3])), err: ErrInvalidWAV},
+		{name: "partial frame", file: wavFile(fmtChunk(wavFormatPCM, 2, 8000, 16, nil), chunk("data", append(samples, 0, 0))), err: ErrInvalidWAV},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DecodeWAV(bytes.NewReader(tt.file))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestParsePCMFormat(t *testing.T) {
+	for _, name := range []string{"u8", "s16", "s24", "s32", "f32", "f64"} {
+		format, err := ParsePCMFormat(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, format.String())
+	}
+
+	format, err := ParsePCMFormat("")
+	require.NoError(t, err)
+	assert.Zero(t, format)
+
+	for _, name := range []string{"s8", "u16", "f16", "s12", "x16", "s"} {
+		_, err := ParsePCMFormat(name)
+		assert.ErrorIs(t, err, ErrUnsupportedWAV, name)
+	}
+}
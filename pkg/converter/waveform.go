@@ -0,0 +1,259 @@
+package converter
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	waveformVersion     = 1 // version of the binary format
+	waveformJSONVersion = 2 // version of the JSON format
+	waveformFlag8Bit    = 1
+
+	defaultWaveformBits = 8
+)
+
+var defaultWaveformResolutions = []int{256, 1024, 4096}
+
+var ErrInvalidWaveform = errors.New("invalid waveform")
+
+// Waveform holds the minimum and maximum peaks of a recording at one resolution, mixed down to one channel.
+// It is encoded in the binary and JSON formats of the audiowaveform tool, which waveform-data.js and peaks.js read.
+type Waveform struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Bits            int     // 8 or 16, the range of the peaks
+	Data            []int16 // Minimum and maximum peak of each pixel, interleaved
+}
+
+// ComputeWaveform computes the peaks of each run of samplesPerPixel frames across all channels
+func ComputeWaveform(pcm *PCM, samplesPerPixel, bits int) *Waveform {
+	scale := float64(int(1)<<(bits-1) - 1)
+	frames := pcm.Frames()
+	waveform := &Waveform{
+		SampleRate:      pcm.SampleRate,
+		SamplesPerPixel: samplesPerPixel,
+		Bits:            bits,
+		Data:            make([]int16, 0, 2*((frames+samplesPerPixel-1)/samplesPerPixel)),
+	}
+
+	for start := 0; start < frames; start += samplesPerPixel {
+		end := min(start+samplesPerPixel, frames)
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, sample := range pcm.Samples[start*pcm.Channels : end*pcm.Channels] {
+			low = min(low, sample)
+			high = max(high, sample)
+		}
+		waveform.Data = append(waveform.Data, peak(low, scale), peak(high, scale))
+	}
+
+	return waveform
+}
+
+// peak quantizes a sample to the range of the waveform
+func peak(sample, scale float64) int16 {
+	return int16(math.Round(math.Max(-1, math.Min(1, sample)) * scale))
+}
+
+// Length returns the number of pixels
+func (w *Waveform) Length() int {
+	return len(w.Data) / 2
+}
+
+// MarshalJSON encodes the waveform in the JSON format of audiowaveform
+func (w *Waveform) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version         int     `json:"version"`
+		Channels        int     `json:"channels"`
+		SampleRate      int     `json:"sample_rate"`
+		SamplesPerPixel int     `json:"samples_per_pixel"`
+		Bits            int     `json:"bits"`
+		Length          int     `json:"length"`
+		Data            []int16 `json:"data"`
+	}{waveformJSONVersion, 1, w.SampleRate, w.SamplesPerPixel, w.Bits, w.Length(), w.Data})
+}
+
+// WriteTo encodes the waveform in the binary format of audiowaveform
+func (w *Waveform) WriteTo(writer io.Writer) (int64, error) {
+	var flags uint32
+	if w.Bits == 8 {
+		flags = waveformFlag8Bit
+	}
+
+	buf := binary.LittleEndian.AppendUint32(nil, waveformVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, flags)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(w.SampleRate))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(w.SamplesPerPixel))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(w.Length()))
+	for _, value := range w.Data {
+		if w.Bits == 8 {
+			buf = append(buf, byte(int8(value)))
+		} else {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(value))
+		}
+	}
+
+	n, err := writer.Write(buf)
+	return int64(n), err
+}
+
+// ReadWaveform decodes a waveform in the binary format of audiowaveform
+func ReadWaveform(r io.Reader) (*Waveform, error) {
+	var header struct {
+		Version         uint32
+		Flags           uint32
+		SampleRate      uint32
+		SamplesPerPixel uint32
+		Length          uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWaveform, err)
+	}
+	if header.Version != waveformVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidWaveform, header.Version)
+	}
+
+	waveform := &Waveform{
+		SampleRate:      int(header.SampleRate),
+		SamplesPerPixel: int(header.SamplesPerPixel),
+		Bits:            16,
+		Data:            make([]int16, 2*header.Length),
+	}
+	if header.Flags&waveformFlag8Bit != 0 {
+		waveform.Bits = 8
+		data := make([]int8, len(waveform.Data))
+		if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidWaveform, err)
+		}
+		for i, value := range data {
+			waveform.Data[i] = int16(value)
+		}
+	} else if err := binary.Read(r, binary.LittleEndian, waveform.Data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWaveform, err)
+	}
+
+	return waveform, nil
+}
+
+// WaveformPath returns the path of the waveform of the recording at audioPath at a resolution
+func WaveformPath(audioPath string, samplesPerPixel int) string {
+	return fmt.Sprintf("%s.peaks-%d.dat", strings.TrimSuffix(audioPath, filepath.Ext(audioPath)), samplesPerPixel)
+}
+
+// WaveformGenerator stores the waveforms of a converted recording alongside it
+type WaveformGenerator interface {
+	Generate(ctx context.Context, audioPath string) error
+	Paths(audioPath string) []string
+}
+
+// WaveformConfig holds the resolutions of the stored waveforms, zero values use the defaults
+type WaveformConfig struct {
+	Resolutions []int // Samples per pixel of each stored waveform
+	Bits        int   // 8 or 16
+}
+
+func (c WaveformConfig) withDefaults() WaveformConfig {
+	if len(c.Resolutions) == 0 {
+		c.Resolutions = defaultWaveformResolutions
+	}
+	if c.Bits == 0 {
+		c.Bits = defaultWaveformBits
+	}
+
+	return c
+}
+
+// Waveforms generates and loads the waveforms of WAV recordings
+type Waveforms struct {
+	config WaveformConfig
+}
+
+// NewWaveforms returns a new instance of Waveforms
+func NewWaveforms(config WaveformConfig) *Waveforms {
+	return &Waveforms{config: config.withDefaults()}
+}
+
+// Generate computes the waveforms of the WAV file at audioPath and stores them next to it
+func (w *Waveforms) Generate(ctx context.Context, audioPath string) error {
+	input, err := os.Open(audioPath)
+	if err != nil {
+		return err
+	}
+	pcm, _, err := DecodeWAV(input)
+	input.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, samplesPerPixel := range w.config.Resolutions {
+		if err = context.Cause(ctx); err != nil {
+			return err
+		}
+		if err = writeWaveform(WaveformPath(audioPath, samplesPerPixel), ComputeWaveform(pcm, samplesPerPixel, w.config.Bits)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeWaveform writes the waveform next to path and renames it over path, so readers never see a partial file
+func writeWaveform(path string, waveform *Waveform) error {
+	output, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = waveform.WriteTo(output)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(output.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), path)
+	}
+	if err != nil {
+		os.Remove(output.Name())
+	}
+
+	return err
+}
+
+// Load reads the stored waveform of the recording at audioPath whose resolution is closest to samplesPerPixel,
+// the finest one when samplesPerPixel is zero
+func (w *Waveforms) Load(audioPath string, samplesPerPixel int) (*Waveform, error) {
+	resolution := w.config.Resolutions[0]
+	for _, candidate := range w.config.Resolutions {
+		if samplesPerPixel > 0 && math.Abs(float64(candidate-samplesPerPixel)) < math.Abs(float64(resolution-samplesPerPixel)) {
+			resolution = candidate
+		}
+	}
+
+	file, err := os.Open(WaveformPath(audioPath, resolution))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadWaveform(file)
+}
+
+// Paths returns the paths of the waveforms stored for the recording at audioPath
+func (w *Waveforms) Paths(audioPath string) []string {
+	paths := make([]string, len(w.config.Resolutions))
+	for i, samplesPerPixel := range w.config.Resolutions {
+		paths[i] = WaveformPath(audioPath, samplesPerPixel)
+	}
+
+	return paths
+}
@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeWaveform(t *testing.T) {
+	t.Run("peaks per pixel", func(t *testing.T) {
+		pcm := &PCM{SampleRate: 8000, Channels: 1, Samples: []float64{0, 0.5, -0.25, 1, -1, 0.1, 2}}
+
+		waveform := ComputeWaveform(pcm, 3, 8)
+		assert.Equal(t, 8000, waveform.SampleRate)
+		assert.Equal(t, 3, waveform.SamplesPerPixel)
+		assert.Equal(t, 3, waveform.Length(), "the last pixel holds the remaining frame")
+		assert.Equal(t, []int16{-32, 64, -127, 127, 127, 127}, waveform.Data, "samples beyond full scale are clipped")
+	})
+
+	t.Run("mixes channels", func(t *testing.T) {
+		pcm := &PCM{SampleRate: 8000, Channels: 2, Samples: []float64{0.5, -0.5, 0.25, 0}}
+
+		waveform := ComputeWaveform(pcm, 2, 16)
+		assert.Equal(t, []int16{-16384, 16384}, waveform.Data)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		waveform := ComputeWaveform(&PCM{SampleRate: 8000, Channels: 1}, 256, 8)
+		assert.Zero(t, waveform.Length())
+	})
+}
+
+func TestWaveform_Encoding(t *testing.T) {
+	for _, bits := range []int{8, 16} {
+		waveform := ComputeWaveform(sine(440, 16000, 16000), 256, bits)
+
+		var buf bytes.Buffer
+		n, err := waveform.WriteTo(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, int64(20+2*waveform.Length()*bits/8), n)
+
+		decoded, err := ReadWaveform(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, waveform, decoded)
+	}
+
+	t.Run("json", func(t *testing.T) {
+		waveform := &Waveform{SampleRate: 16000, SamplesPerPixel: 512, Bits: 8, Data: []int16{-3, 5, -1, 1}}
+
+		data, err := json.Marshal(waveform)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"version":2,"channels":1,"sample_rate":16000,"samples_per_pixel":512,"bits":8,"length":2,"data":[-3,5,-1,1]}`, string(data))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ReadWaveform(bytes.NewReader([]byte{2, 0, 0, 0}))
+		assert.ErrorIs(t, err, ErrInvalidWaveform)
+
+		var buf bytes.Buffer
+		(&Waveform{SampleRate: 16000, SamplesPerPixel: 256, Bits: 16, Data: []int16{-1, 1}}).WriteTo(&buf)
+		_, err = ReadWaveform(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+		assert.ErrorIs(t, err, ErrInvalidWaveform, "truncated data")
+	})
+}
+
+func TestWaveformPath(t *testing.T) {
+	assert.Equal(t, "/data/1/2/upload.converted.peaks-256.dat", WaveformPath("/data/1/2/upload.converted.wav", 256))
+}
+
+func TestWaveforms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.converted.wav")
+	writeWAV(t, path, sine(440, 16000, 16000), PCMFormat{Encoding: SampleInt, BitDepth: 16})
+
+	waveforms := NewWaveforms(WaveformConfig{Resolutions: []int{128, 1024}})
+	require.NoError(t, waveforms.Generate(context.Background(), path))
+
+	for _, waveformPath := range waveforms.Paths(path) {
+		assert.FileExists(t, waveformPath)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "no temporary files are left behind")
+
+	t.Run("loads the closest resolution", func(t *testing.T) {
+		for samplesPerPixel, expected := range map[int]int{0: 128, 100: 128, 500: 128, 700: 1024, 8192: 1024} {
+			waveform, err := waveforms.Load(path, samplesPerPixel)
+			require.NoError(t, err)
+			assert.Equal(t, expected, waveform.SamplesPerPixel, "requested %d", samplesPerPixel)
+			assert.Equal(t, 8, waveform.Bits)
+			assert.Equal(t, (16000+expected-1)/expected, waveform.Length())
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := waveforms.Load(filepath.Join(t.TempDir(), "other.wav"), 0)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, waveforms.Generate(ctx, path), context.Canceled)
+	})
+
+	t.Run("invalid audio", func(t *testing.T) {
+		invalid := filepath.Join(t.TempDir(), "invalid.wav")
+		require.NoError(t, os.WriteFile(invalid, []byte("not a wav"), 0o644))
+		assert.ErrorIs(t, waveforms.Generate(context.Background(), invalid), ErrInvalidWAV)
+	})
+}
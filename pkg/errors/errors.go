@@ -36,4 +36,7 @@ var (
 
 	// ErrStorageOperation represents storage operation failures
 	ErrStorageOperation = errors.New("storage operation failed")
+
+	// ErrServiceOverloaded represents when new work is turned away because the backlog is too deep
+	ErrServiceOverloaded = errors.New("service is overloaded, retry later")
 )
@@ -0,0 +1,79 @@
+package instrumentation
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	metricsMu  sync.Mutex
+	namespaces = make(map[string]*expvar.Map)
+)
+
+// metrics returns the expvar map holding the metrics of a namespace, published on /debug/vars.
+func metrics(namespace string) *expvar.Map {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	m, ok := namespaces[namespace]
+	if !ok {
+		m = expvar.NewMap(namespace)
+		namespaces[namespace] = m
+	}
+
+	return m
+}
+
+// IncrementCounter adds one to the named counter of the namespace.
+func IncrementCounter(namespace, name string) {
+	AddCounter(namespace, name, 1)
+}
+
+// AddCounter adds delta to the named counter of the namespace.
+func AddCounter(namespace, name string, delta int64) {
+	metrics(namespace).Add(name, delta)
+}
+
+// SetGauge sets the named gauge of the namespace to value.
+func SetGauge(namespace, name string, value int64) {
+	m := metrics(namespace)
+
+	gauge, ok := m.Get(name).(*expvar.Int)
+	if !ok {
+		gauge = new(expvar.Int)
+		m.Set(name, gauge)
+	}
+
+	gauge.Set(value)
+}
+
+// SetFloatGauge sets the named gauge of the namespace to a fractional value, such as a rate.
+func SetFloatGauge(namespace, name string, value float64) {
+	m := metrics(namespace)
+
+	gauge, ok := m.Get(name).(*expvar.Float)
+	if !ok {
+		gauge = new(expvar.Float)
+		m.Set(name, gauge)
+	}
+
+	gauge.Set(value)
+}
+
+// FloatMetricValue returns the current value of a fractional gauge, zero when it was never recorded.
+func FloatMetricValue(namespace, name string) float64 {
+	if v, ok := metrics(namespace).Get(name).(*expvar.Float); ok {
+		return v.Value()
+	}
+
+	return 0
+}
+
+// MetricValue returns the current value of a counter or gauge, zero when it was never recorded.
+func MetricValue(namespace, name string) int64 {
+	if v, ok := metrics(namespace).Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+
+	return 0
+}
@@ -0,0 +1,31 @@
+package instrumentation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("counters accumulate", func(t *testing.T) {
+		IncrementCounter("test", "counter")
+		AddCounter("test", "counter", 2)
+		assert.Equal(t, int64(3), MetricValue("test", "counter"))
+	})
+
+	t.Run("gauges are overwritten", func(t *testing.T) {
+		SetGauge("test", "gauge", 10)
+		SetGauge("test", "gauge", 4)
+		assert.Equal(t, int64(4), MetricValue("test", "gauge"))
+	})
+
+	t.Run("float gauges are overwritten", func(t *testing.T) {
+		SetFloatGauge("test", "rate", 1.5)
+		SetFloatGauge("test", "rate", 0.25)
+		assert.Equal(t, 0.25, FloatMetricValue("test", "rate"))
+	})
+
+	t.Run("unknown metric", func(t *testing.T) {
+		assert.Zero(t, MetricValue("test", "unknown"))
+	})
+}
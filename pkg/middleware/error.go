@@ -77,6 +77,10 @@ func WriteError(w http.ResponseWriter, err error) {
 		status = http.StatusBadRequest
 		response.Message = err.Error()
 
+	case errors.Is(err, pkgerrors.ErrServiceOverloaded):
+		status = http.StatusServiceUnavailable
+		response.Message = err.Error()
+
 	default:
 		status = http.StatusInternalServerError
 		response.Message = "Internal server error"
@@ -6,24 +6,87 @@ const (
 	AudioConversionOngoing AudioRecordStatus = iota
 	AudioConversionCompleted
 	AudioDeleted
+	AudioConversionFailed
 )
 
 type AudioRecord struct {
-	UserID           int64
-	PhraseID         int64
-	OriginalFilename string
-	OriginalFormat   string
-	StoredURI        string
-	OriginalURI      string
-	Status           AudioRecordStatus
-	CreatedAt        int64
-	UpdatedAt        int64
+	UserID             int64
+	PhraseID           int64
+	OriginalFilename   string
+	OriginalFormat     string
+	StoredURI          string
+	StorageProfile     string // Name of the transcoding profile StoredURI was converted with
+	OriginalURI        string
+	Status             AudioRecordStatus
+	ConversionAttempts int
+	AudioAnalysis
+	ConversionFailure
+	CreatedAt int64
+	UpdatedAt int64 // Starts the staleness clock of a conversion, the time its job is due when deferred
 }
 
+// AudioAnalysis holds the measurements taken while processing the converted recording
+type AudioAnalysis struct {
+	Loudness    *float64 // Integrated loudness in LUFS before normalization, nil when not measured or silent
+	TrimStartMS int64    // Leading silence trimmed in milliseconds
+	TrimEndMS   int64    // Trailing silence trimmed in milliseconds
+}
+
+// ConversionFailure explains why the conversion of a failed record or rendition failed
+type ConversionFailure struct {
+	FailureKind    string // Class of the failure such as corrupt_input, empty unless the conversion failed
+	FailureSummary string // Reason reported by the converter
+}
+
+// AudioRendition is an encoding of a converted recording, the conversion itself included.
+// A rendition that could not be encoded has no URI and records its failure.
+type AudioRendition struct {
+	UserID   int64
+	PhraseID int64
+	Profile  string // Name of the transcoding profile, unique per recording
+	Format   string // Upper case name of the audio format
+	URI      string
+	Size     int64  // Bytes
+	Checksum string // Hex encoded SHA-256 of the file
+	ConversionFailure
+	CreatedAt int64
+}
+
+// Failed reports whether the rendition could not be encoded
+func (r AudioRendition) Failed() bool {
+	return r.FailureKind != ""
+}
+
+// Message types carried in the type header so consumers can route messages sharing a topic
+const (
+	AudioConversionMessageType = "audio_conversion"
+	CleanupMessageType         = "cleanup"
+
+	AudioConversionReplyMessageType = "audio_conversion_reply"
+)
+
+// Current schema versions of the message types, bump them together with an upcaster when a message changes shape
+const (
+	AudioConversionMessageVersion = 3
+	CleanupMessageVersion         = 1
+
+	AudioConversionReplyMessageVersion = 1
+)
+
+// AudioConversionMessage requests the conversion of an uploaded recording.
+// Version 1 was published as bare JSON, version 2 in an envelope without Attempt.
 type AudioConversionMessage struct {
 	UserID   int64  `json:"user_id"`
 	PhraseID int64  `json:"phrase_id"`
 	InputURI string `json:"input_uri"`
+	Attempt  int    `json:"attempt"` // 1 for the upload, incremented whenever the reconciler republishes the job
+}
+
+// AudioConversionReply is sent to the requester of a conversion once the converted recording is stored
+type AudioConversionReply struct {
+	UserID    int64  `json:"user_id"`
+	PhraseID  int64  `json:"phrase_id"`
+	OutputURI string `json:"output_uri"`
 }
 
 type CleanupMessage struct {
@@ -0,0 +1,28 @@
+package model
+
+type JobStatus int
+
+const (
+	JobPending JobStatus = iota
+	JobLeased
+	JobDead
+)
+
+// Job is a queued message stored in the jobs table of the database-backed queue.
+// Timestamps are unix milliseconds so delays and leases can be shorter than a second.
+type Job struct {
+	ID          int64
+	Topic       string
+	MessageID   string
+	MessageKey  string
+	Payload     []byte
+	Headers     map[string]string
+	Priority    int
+	Status      JobStatus
+	Attempts    int
+	AvailableAt int64
+	LeasedUntil int64
+	LeaseToken  string
+	LastError   string
+	CreatedAt   int64
+}
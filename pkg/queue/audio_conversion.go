@@ -2,21 +2,51 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"time"
 
 	"phonon/pkg/converter"
+	"phonon/pkg/instrumentation"
 	"phonon/pkg/model"
 	"phonon/pkg/repository"
+	"phonon/pkg/storage"
+
+	"github.com/sirupsen/logrus"
 )
 
-const defaultAudioConversionContentType = "application/json"
+const defaultAudioConversionContentType = ContentTypeJSON
 
 var (
-	ErrNoProducer = errors.New("no producer")
-	ErrNoConsumer = errors.New("no consumer")
+	ErrNoProducer  = errors.New("no producer")
+	ErrNoConsumer  = errors.New("no consumer")
+	ErrNoRequester = errors.New("no requester")
 )
 
+// KeyFunc derives the partitioning key of a conversion job, nil keys leave partitioning to the queue
+type KeyFunc func(msg model.AudioConversionMessage) []byte
+
+// KeyByUser keys conversion jobs by user so jobs of the same user stay on the same partition
+func KeyByUser(msg model.AudioConversionMessage) []byte {
+	return []byte(strconv.FormatInt(msg.UserID, 10))
+}
+
+// KeyFuncFor returns the KeyFunc configured by name: "user" (the default when empty) or "none"
+func KeyFuncFor(name string) (KeyFunc, error) {
+	switch name {
+	case "", "user":
+		return KeyByUser, nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported partition key: %s", name)
+	}
+}
+
 type Option func(ac *AudioConversion)
 
 func AudioConversionWithProducer(producer Producer) Option {
@@ -25,6 +55,13 @@ func AudioConversionWithProducer(producer Producer) Option {
 	}
 }
 
+// AudioConversionWithReplyProducer answers conversion requests through producer instead of the producer of the jobs
+func AudioConversionWithReplyProducer(producer Producer) Option {
+	return func(ac *AudioConversion) {
+		ac.replyProducer = producer
+	}
+}
+
 func AudioConversionWithConsumer(consumer Consumer) Option {
 	return func(ac *AudioConversion) {
 		ac.consumer = consumer
@@ -37,14 +74,98 @@ func AudioConversionWithContentType(contentType string) Option {
 	}
 }
 
+func AudioConversionWithConsumerOptions(consumerOptions *ConsumerOptions) Option {
+	return func(ac *AudioConversion) {
+		ac.consumerOptions = consumerOptions
+	}
+}
+
+func AudioConversionWithCodecs(codecs *Codecs) Option {
+	return func(ac *AudioConversion) {
+		ac.codecs = codecs
+	}
+}
+
+func AudioConversionWithKeyFunc(keyFunc KeyFunc) Option {
+	return func(ac *AudioConversion) {
+		ac.keyFunc = keyFunc
+	}
+}
+
+// AudioConversionWithRequester enables RequestAudioConversionJob, the requester publishes through its own producer
+func AudioConversionWithRequester(requester *Requester) Option {
+	return func(ac *AudioConversion) {
+		ac.requester = requester
+	}
+}
+
+// AudioConversionWithTimeout abandons conversions running longer than timeout, zero lets them run until the consumer stops
+func AudioConversionWithTimeout(timeout time.Duration) Option {
+	return func(ac *AudioConversion) {
+		ac.timeout = timeout
+	}
+}
+
+// AudioConversionWithProfile records the name of the transcoding profile of the converter on converted audio records
+func AudioConversionWithProfile(profile string) Option {
+	return func(ac *AudioConversion) {
+		ac.profile = profile
+	}
+}
+
+// AudioConversionWithProcessor processes converted files, for example to normalize their loudness, and stores the
+// measurements on the audio record
+func AudioConversionWithProcessor(processor converter.Processor) Option {
+	return func(ac *AudioConversion) {
+		ac.processor = processor
+	}
+}
+
+// AudioConversionWithWaveforms stores the waveform peaks of converted files next to them. A failure to compute them
+// is logged and does not fail the conversion, the recording is served without waveform.
+func AudioConversionWithWaveforms(waveforms converter.WaveformGenerator) Option {
+	return func(ac *AudioConversion) {
+		ac.waveforms = waveforms
+	}
+}
+
+// AudioConversionWithRenditions encodes further renditions of converted files and records them with the conversion
+// itself in the renditions of the audio record
+func AudioConversionWithRenditions(renditions converter.RenditionGenerator) Option {
+	return func(ac *AudioConversion) {
+		ac.renditions = renditions
+	}
+}
+
+// AudioConversionWithArtifacts removes the artifacts cached from the conversion of a recording, such as its
+// spectrograms, from fileStore once a conversion replaces it
+func AudioConversionWithArtifacts(fileStore storage.File) Option {
+	return func(ac *AudioConversion) {
+		ac.artifacts = fileStore
+	}
+}
+
 type AudioConversion struct {
 	audioConverter converter.Audio
+	processor      converter.Processor
+	waveforms      converter.WaveformGenerator
+	renditions     converter.RenditionGenerator
 	repo           repository.Database
+	artifacts      storage.File // nil keeps cached artifacts
 
-	producer Producer
-	consumer Consumer
+	producer        Producer
+	replyProducer   Producer // nil replies through producer
+	requester       *Requester
+	consumer        Consumer
+	consumerOptions *ConsumerOptions
 
 	contentType string
+	codecs      *Codecs
+	keyFunc     KeyFunc
+	timeout     time.Duration
+	profile     string
+
+	handler Handler
 }
 
 func NewAudioConversion(audioConverter converter.Audio, repo repository.Database, opts ...Option) *AudioConversion {
@@ -52,12 +173,16 @@ func NewAudioConversion(audioConverter converter.Audio, repo repository.Database
 		audioConverter: audioConverter,
 		repo:           repo,
 		contentType:    defaultAudioConversionContentType,
+		codecs:         NewCodecs(DefaultEnvelopeCodec(), ProtobufCodec{}),
+		keyFunc:        KeyByUser,
 	}
 
 	for _, opt := range opts {
 		opt(ac)
 	}
 
+	ac.handler = NewTypedHandler(ac.convert, ac.codecs)
+
 	return ac
 }
 
@@ -66,39 +191,351 @@ func (a *AudioConversion) PublishAudioConversionJob(ctx context.Context, convers
 		return ErrNoProducer
 	}
 
-	data, err := json.Marshal(conversionMessage)
+	msg, err := a.encode(conversionMessage)
 	if err != nil {
 		return err
 	}
 
-	msg := Message{
-		Value: data,
+	return a.producer.Publish(ctx, msg, &MessageOptions{
+		DeliveryMode: Persistent,
+		ContentType:  a.contentType,
+	})
+}
+
+// DeferAudioConversionJob publishes a conversion job that is not delivered before delay elapsed.
+// The SQL queue and JetStream reschedule the job, Kafka consumers park it on their retry producer meanwhile,
+// so deferred jobs do not hold back the jobs published after them.
+func (a *AudioConversion) DeferAudioConversionJob(ctx context.Context, conversionMessage model.AudioConversionMessage, delay time.Duration) error {
+	if a.producer == nil {
+		return ErrNoProducer
+	}
+
+	msg, err := a.encode(conversionMessage)
+	if err != nil {
+		return err
 	}
 
 	return a.producer.Publish(ctx, msg, &MessageOptions{
 		DeliveryMode: Persistent,
 		ContentType:  a.contentType,
+		NotBefore:    time.Now().Add(delay),
+	})
+}
+
+// RequestAudioConversionJob publishes a conversion job asking the worker for a reply once it is converted.
+// A client waits for the job, so it is published with PriorityInteractive.
+// The job is processed like any other when nobody waits for the reply anymore.
+func (a *AudioConversion) RequestAudioConversionJob(ctx context.Context, conversionMessage model.AudioConversionMessage) (*Call, error) {
+	if a.requester == nil {
+		return nil, ErrNoRequester
+	}
+
+	msg, err := a.encode(conversionMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.requester.Send(ctx, msg, &MessageOptions{
+		DeliveryMode: Persistent,
+		Priority:     PriorityInteractive,
+		ContentType:  a.contentType,
 	})
 }
 
+func (a *AudioConversion) encode(conversionMessage model.AudioConversionMessage) (Message, error) {
+	codec, err := a.codecs.Lookup(a.contentType)
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg, err := EncodeMessage(codec, model.AudioConversionMessageType, conversionMessage)
+	if err != nil {
+		return Message{}, err
+	}
+	if a.keyFunc != nil {
+		msg.Key = a.keyFunc(conversionMessage)
+	}
+
+	return msg, nil
+}
+
 func (a *AudioConversion) Handle(ctx context.Context, msg Message) error {
-	var conversionMessage model.AudioConversionMessage
-	err := json.Unmarshal(msg.Value, &conversionMessage)
+	return a.handler.Handle(ctx, msg)
+}
+
+func (a *AudioConversion) convert(ctx context.Context, conversionMessage model.AudioConversionMessage, msg Message) error {
+	outputPath, err := a.convertOnce(ctx, conversionMessage)
+	if errors.Is(err, repository.ErrConversionFailed) {
+		// the reconciler gave up on the record while this attempt was converting, its files were removed
+		instrumentation.IncrementCounter(metricsNamespace, "conversions_discarded")
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"user_id":   conversionMessage.UserID,
+			"phrase_id": conversionMessage.PhraseID,
+		}).Warn("discarding the conversion of a failed record")
+		return nil
+	}
+	if converter.IsPermanent(err) {
+		return a.failPermanently(ctx, conversionMessage, err)
+	}
 	if err != nil {
+		a.recordFailure(ctx, conversionMessage, err)
 		return err
 	}
 
-	outputPath, err := a.audioConverter.ConvertToStorageFormat(conversionMessage.InputURI)
+	if msg.Options.ReplyTo != "" {
+		// the conversion is stored, a lost reply only makes the requester fall back to polling
+		if err = a.reply(ctx, conversionMessage, outputPath, msg); err != nil {
+			logrus.WithContext(ctx).WithField("message_id", msg.ID).Warnf("failed to reply to conversion request: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// failPermanently marks the record failed instead of retrying a conversion that is bound to fail again,
+// such as one of a corrupt input or exceeding the resource limits of the converter, and records the reason
+func (a *AudioConversion) failPermanently(ctx context.Context, conversionMessage model.AudioConversionMessage, cause error) error {
+	var conversionErr *converter.ConversionError
+	errors.As(cause, &conversionErr)
+	failure := model.ConversionFailure{FailureKind: string(conversionErr.Kind), FailureSummary: conversionErr.Reason()}
+
+	if err := a.repo.FailConversion(ctx, conversionMessage.UserID, conversionMessage.PhraseID, failure); err != nil {
+		return err
+	}
+
+	instrumentation.IncrementCounter(metricsNamespace, "conversions_failed_permanently")
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"user_id":   conversionMessage.UserID,
+		"phrase_id": conversionMessage.PhraseID,
+		"kind":      conversionErr.Kind,
+	}).Warnf("conversion failed permanently: %v", cause)
+
+	return nil
+}
+
+// recordFailure records the reason of a conversion failing for now, so the record keeps it if its retries run out
+func (a *AudioConversion) recordFailure(ctx context.Context, conversionMessage model.AudioConversionMessage, cause error) {
+	var conversionErr *converter.ConversionError
+	if !errors.As(cause, &conversionErr) {
+		return
+	}
+
+	failure := model.ConversionFailure{FailureKind: string(conversionErr.Kind), FailureSummary: conversionErr.Reason()}
+	if err := a.repo.RecordConversionFailure(ctx, conversionMessage.UserID, conversionMessage.PhraseID, failure); err != nil {
+		logrus.WithContext(ctx).Errorf("failed to record conversion failure: %v", err)
+	}
+}
+
+// convertOnce converts the recording unless its record is already completed, which happens when a job is redelivered
+// or published twice, and returns the URI of the stored conversion
+func (a *AudioConversion) convertOnce(ctx context.Context, conversionMessage model.AudioConversionMessage) (string, error) {
+	record, err := a.repo.GetAudioRecord(ctx, conversionMessage.UserID, conversionMessage.PhraseID)
+	if err != nil {
+		return "", err
+	}
+	if record != nil && record.Status == model.AudioConversionCompleted {
+		instrumentation.IncrementCounter(metricsNamespace, "conversions_already_completed")
+		return record.StoredURI, nil
+	}
+
+	result, err := a.convertAudio(ctx, conversionMessage.InputURI)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.record(ctx, conversionMessage, result); err != nil {
+		// the redelivered job converts the recording again, the files of this conversion would be orphaned
+		a.removeOutputs(ctx, result)
+		return "", err
+	}
+	a.removeArtifacts(ctx, conversionMessage)
+
+	return result.outputPath, nil
+}
+
+// removeArtifacts removes the spectrograms cached from an earlier conversion of the recording, which no longer
+// match the audio once this conversion replaced it
+func (a *AudioConversion) removeArtifacts(ctx context.Context, conversionMessage model.AudioConversionMessage) {
+	if a.artifacts == nil {
+		return
+	}
+
+	if err := a.artifacts.RemoveArtifacts(ctx, conversionMessage.UserID, conversionMessage.PhraseID, converter.SpectrogramArtifactPrefix); err != nil {
+		logrus.WithContext(ctx).Warnf("failed to remove the cached spectrograms of %s: %v", conversionMessage.InputURI, err)
+	}
+}
+
+// record stores the analysis, the renditions and the conversion of a recording
+func (a *AudioConversion) record(ctx context.Context, conversionMessage model.AudioConversionMessage, result conversion) error {
+	if result.analysis != nil {
+		if err := a.repo.SaveAudioAnalysis(ctx, conversionMessage.UserID, conversionMessage.PhraseID, *result.analysis); err != nil {
+			return err
+		}
+	}
+
+	// renditions are recorded before the record completes, so a completed record lists all of them
+	for _, rendition := range result.renditions {
+		if err := a.repo.SaveAudioRendition(ctx, audioRendition(ctx, conversionMessage, rendition)); err != nil {
+			return err
+		}
+	}
+
+	return a.repo.SaveConvertedFormat(ctx, conversionMessage.UserID, conversionMessage.PhraseID, result.outputPath, a.profile)
+}
+
+// audioRendition converts a rendition to its stored form, a rendition that could not be encoded records the reason
+// and does not fail the conversion
+func audioRendition(ctx context.Context, conversionMessage model.AudioConversionMessage, rendition converter.Rendition) model.AudioRendition {
+	stored := model.AudioRendition{
+		UserID:   conversionMessage.UserID,
+		PhraseID: conversionMessage.PhraseID,
+		Profile:  rendition.Profile,
+		Format:   string(rendition.Format),
+		URI:      rendition.Path,
+		Size:     rendition.Size,
+		Checksum: rendition.Checksum,
+	}
+
+	var conversionErr *converter.ConversionError
+	if errors.As(rendition.Err, &conversionErr) {
+		stored.ConversionFailure = model.ConversionFailure{FailureKind: string(conversionErr.Kind), FailureSummary: conversionErr.Reason()}
+
+		instrumentation.IncrementCounter(metricsNamespace, "renditions_failed")
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"user_id":   conversionMessage.UserID,
+			"phrase_id": conversionMessage.PhraseID,
+			"profile":   rendition.Profile,
+			"kind":      conversionErr.Kind,
+		}).Warnf("rendition failed: %v", rendition.Err)
+	}
+
+	return stored
+}
+
+// conversion is the outcome of converting a recording
+type conversion struct {
+	outputPath string
+	analysis   *model.AudioAnalysis  // nil without processor
+	renditions []converter.Rendition // nil without rendition generator
+}
+
+// convertAudio runs the converter, the processor, the waveform generator and the rendition generator within the
+// conversion timeout
+func (a *AudioConversion) convertAudio(ctx context.Context, inputURI string) (conversion, error) {
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+
+	result, err := a.convertAndProcess(ctx, inputURI)
+	if errors.Is(err, context.DeadlineExceeded) {
+		instrumentation.IncrementCounter(metricsNamespace, "conversions_timed_out")
+	}
+
+	return result, err
+}
+
+func (a *AudioConversion) convertAndProcess(ctx context.Context, inputURI string) (conversion, error) {
+	outputPath, err := a.audioConverter.ConvertToStorageFormat(ctx, inputURI)
+	if err != nil {
+		return conversion{}, err
+	}
+	result := conversion{outputPath: outputPath}
+
+	if a.processor != nil {
+		processed, err := a.processor.Process(ctx, outputPath)
+		if err != nil {
+			a.removeOutputs(ctx, result)
+			return conversion{}, fmt.Errorf("failed to process %s: %w", outputPath, err)
+		}
+		result.analysis = audioAnalysis(processed)
+	}
+
+	// the waveform and the renditions are derived from the processed audio
+	if a.waveforms != nil {
+		if err = a.waveforms.Generate(ctx, outputPath); err != nil {
+			if ctxErr := context.Cause(ctx); ctxErr != nil {
+				a.removeOutputs(ctx, result)
+				return conversion{}, ctxErr
+			}
+			instrumentation.IncrementCounter(metricsNamespace, "waveforms_failed")
+			logrus.WithContext(ctx).Warnf("failed to compute the waveform of %s: %v", outputPath, err)
+		}
+	}
+
+	if a.renditions != nil {
+		if result.renditions, err = a.renditions.Generate(ctx, outputPath); err != nil {
+			// the generator removed the renditions it encoded
+			a.removeOutputs(ctx, result)
+			return conversion{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// removeOutputs removes the files of a conversion that failed after the recording was converted: the conversion,
+// its waveforms and its renditions
+func (a *AudioConversion) removeOutputs(ctx context.Context, result conversion) {
+	paths := []string{result.outputPath}
+	if a.waveforms != nil {
+		paths = append(paths, a.waveforms.Paths(result.outputPath)...)
+	}
+	for _, rendition := range result.renditions {
+		if rendition.Path != "" && rendition.Path != result.outputPath {
+			paths = append(paths, rendition.Path)
+		}
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logrus.WithContext(ctx).Warnf("failed to remove %s of a failed conversion: %v", path, err)
+		}
+	}
+}
+
+// audioAnalysis converts the analysis of the processor to its stored form, silence has no loudness
+func audioAnalysis(analysis converter.Analysis) *model.AudioAnalysis {
+	stored := &model.AudioAnalysis{
+		TrimStartMS: analysis.TrimStart.Milliseconds(),
+		TrimEndMS:   analysis.TrimEnd.Milliseconds(),
+	}
+	if !math.IsInf(analysis.Loudness, 0) && !math.IsNaN(analysis.Loudness) {
+		loudness := analysis.Loudness
+		stored.Loudness = &loudness
+	}
+
+	return stored
+}
+
+func (a *AudioConversion) reply(ctx context.Context, conversionMessage model.AudioConversionMessage, outputPath string, request Message) error {
+	producer := a.replyProducer
+	if producer == nil {
+		producer = a.producer
+	}
+	if producer == nil {
+		return ErrNoProducer
+	}
+
+	codec, err := a.codecs.Lookup(a.contentType)
 	if err != nil {
 		return err
 	}
 
-	err = a.repo.SaveConvertedFormat(ctx, conversionMessage.UserID, conversionMessage.PhraseID, outputPath)
+	reply, err := EncodeMessage(codec, model.AudioConversionReplyMessageType, model.AudioConversionReply{
+		UserID:    conversionMessage.UserID,
+		PhraseID:  conversionMessage.PhraseID,
+		OutputURI: outputPath,
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return Reply(ctx, producer, request, reply, &MessageOptions{
+		DeliveryMode: NonPersistent,
+		ContentType:  a.contentType,
+	})
 }
 
 func (a *AudioConversion) StartConsuming(ctx context.Context) {
@@ -106,5 +543,5 @@ func (a *AudioConversion) StartConsuming(ctx context.Context) {
 		return
 	}
 
-	a.consumer.Consume(ctx, a, nil)
+	a.consumer.Consume(ctx, a, a.consumerOptions)
 }
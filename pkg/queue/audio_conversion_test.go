@@ -1,15 +1,26 @@
 package queue
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
+	"phonon/pkg/converter"
+	"phonon/pkg/instrumentation"
 	"phonon/pkg/model"
 	"phonon/pkg/repository"
 
+	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockAudioConverter is a mock implementation of the Audio converter interface
@@ -17,11 +28,49 @@ type MockAudioConverter struct {
 	mock.Mock
 }
 
-func (m *MockAudioConverter) ConvertToStorageFormat(inputPath string) (string, error) {
-	args := m.Called(inputPath)
+func (m *MockAudioConverter) ConvertToStorageFormat(ctx context.Context, inputPath string) (string, error) {
+	args := m.Called(ctx, inputPath)
 	return args.String(0), args.Error(1)
 }
 
+// MockProcessor is a mock implementation of the converter Processor interface
+type MockProcessor struct {
+	mock.Mock
+}
+
+func (m *MockProcessor) Process(ctx context.Context, path string) (converter.Analysis, error) {
+	args := m.Called(ctx, path)
+	return args.Get(0).(converter.Analysis), args.Error(1)
+}
+
+// MockWaveformGenerator is a mock implementation of the converter WaveformGenerator interface
+type MockWaveformGenerator struct {
+	mock.Mock
+}
+
+func (m *MockWaveformGenerator) Generate(ctx context.Context, audioPath string) error {
+	args := m.Called(ctx, audioPath)
+	return args.Error(0)
+}
+
+func (m *MockWaveformGenerator) Paths(audioPath string) []string {
+	args := m.Called(audioPath)
+	return args.Get(0).([]string)
+}
+
+// MockRenditionGenerator is a mock implementation of the converter RenditionGenerator interface
+type MockRenditionGenerator struct {
+	mock.Mock
+}
+
+func (m *MockRenditionGenerator) Generate(ctx context.Context, masterPath string) ([]converter.Rendition, error) {
+	args := m.Called(ctx, masterPath)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]converter.Rendition), args.Error(1)
+}
+
 // MockProducer is a mock implementation of the Producer interface
 type MockProducer struct {
 	mock.Mock
@@ -51,6 +100,27 @@ func (m *MockConsumer) Close() error {
 	return args.Error(0)
 }
 
+// conversionMessageMatcher matches messages carrying the conversion message in an envelope of the current version
+func conversionMessageMatcher(expected model.AudioConversionMessage, key []byte) any {
+	return mock.MatchedBy(func(msg Message) bool {
+		var envelope Envelope
+		if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+			return false
+		}
+
+		var payload model.AudioConversionMessage
+		if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+			return false
+		}
+
+		return envelope.Type == model.AudioConversionMessageType &&
+			envelope.Version == model.AudioConversionMessageVersion &&
+			reflect.DeepEqual(expected, payload) &&
+			bytes.Equal(key, msg.Key) &&
+			msg.Headers[HeaderType] == model.AudioConversionMessageType
+	})
+}
+
 func TestNewAudioConversion(t *testing.T) {
 	mockConverter := new(MockAudioConverter)
 	mockRepo := new(repository.MockDatabase)
@@ -101,20 +171,105 @@ func TestAudioConversion_PublishAudioConversionJob(t *testing.T) {
 	}
 
 	t.Run("successful publish", func(t *testing.T) {
-		expectedData, _ := json.Marshal(msg)
-		expectedMessage := Message{Value: expectedData}
 		expectedOpts := &MessageOptions{
 			DeliveryMode: Persistent,
 			ContentType:  defaultAudioConversionContentType,
 		}
 
-		mockProducer.On("Publish", ctx, expectedMessage, expectedOpts).Return(nil)
+		mockProducer.On("Publish", ctx, conversionMessageMatcher(msg, []byte("1")), expectedOpts).Return(nil)
 
 		err := ac.PublishAudioConversionJob(ctx, msg)
 		assert.NoError(t, err)
 		mockProducer.AssertExpectations(t)
 	})
 
+	t.Run("publish without partition key", func(t *testing.T) {
+		unkeyedProducer := new(MockProducer)
+		unkeyed := NewAudioConversion(
+			mockConverter,
+			mockRepo,
+			AudioConversionWithProducer(unkeyedProducer),
+			AudioConversionWithKeyFunc(nil),
+		)
+
+		unkeyedProducer.On("Publish", ctx, conversionMessageMatcher(msg, nil), mock.Anything).Return(nil)
+
+		err := unkeyed.PublishAudioConversionJob(ctx, msg)
+		assert.NoError(t, err)
+		unkeyedProducer.AssertExpectations(t)
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		custom := NewAudioConversion(
+			mockConverter,
+			mockRepo,
+			AudioConversionWithProducer(mockProducer),
+			AudioConversionWithContentType("application/custom"),
+		)
+
+		err := custom.PublishAudioConversionJob(ctx, msg)
+		assert.ErrorIs(t, err, ErrUnsupportedContentType)
+	})
+
+	t.Run("deferred publish", func(t *testing.T) {
+		deferredProducer := new(MockProducer)
+		deferred := NewAudioConversion(mockConverter, mockRepo, AudioConversionWithProducer(deferredProducer))
+
+		start := time.Now()
+		deferredProducer.On("Publish", ctx, conversionMessageMatcher(msg, []byte("1")), mock.MatchedBy(func(opts *MessageOptions) bool {
+			return opts.DeliveryMode == Persistent && !opts.NotBefore.Before(start.Add(time.Minute))
+		})).Return(nil)
+
+		err := deferred.DeferAudioConversionJob(ctx, msg, time.Minute)
+		assert.NoError(t, err)
+		deferredProducer.AssertExpectations(t)
+	})
+
+	t.Run("deferred jobs do not block the kafka consumer", func(t *testing.T) {
+		published := new(recordingProducer)
+		deferred := NewAudioConversion(mockConverter, mockRepo, AudioConversionWithProducer(published))
+		require.NoError(t, deferred.DeferAudioConversionJob(ctx, msg, 5*time.Minute))
+
+		sent := published.published[0]
+		consumed := decodeKafkaMessage(kafka.Message{
+			Topic:   "audio_conversion",
+			Key:     sent.Key,
+			Value:   sent.Value,
+			Headers: encodeKafkaHeaders(sent, &sent.Options),
+		})
+
+		handler := new(MockHandler)
+		retries := new(recordingProducer)
+		start := time.Now()
+		assert.NoError(t, deliver(ctx, handler, consumed, &ConsumerOptions{Retry: retries}))
+		assert.Less(t, time.Since(start), time.Second)
+		handler.AssertNotCalled(t, "Handle", mock.Anything, mock.Anything)
+
+		require.Len(t, retries.published, 1)
+		assert.Equal(t, "audio_conversion", retries.published[0].Headers[HeaderRetryTopic])
+		assert.WithinDuration(t, sent.Options.NotBefore, retries.published[0].Options.NotBefore, time.Millisecond)
+	})
+
+	t.Run("request without requester", func(t *testing.T) {
+		_, err := ac.RequestAudioConversionJob(ctx, msg)
+		assert.ErrorIs(t, err, ErrNoRequester)
+	})
+
+	t.Run("request", func(t *testing.T) {
+		requestProducer := new(MockProducer)
+		requesting := NewAudioConversion(mockConverter, mockRepo,
+			AudioConversionWithRequester(NewRequester(requestProducer, "replies.instance", time.Second)))
+
+		requestProducer.On("Publish", ctx, conversionMessageMatcher(msg, []byte("1")), mock.MatchedBy(func(opts *MessageOptions) bool {
+			return opts.ReplyTo == "replies.instance" && opts.CorrelationID != "" && opts.DeliveryMode == Persistent
+		})).Return(nil)
+
+		call, err := requesting.RequestAudioConversionJob(ctx, msg)
+		assert.NoError(t, err)
+		call.Cancel()
+		requestProducer.AssertExpectations(t)
+	})
+
 	t.Run("no producer error", func(t *testing.T) {
 		acWithoutProducer := NewAudioConversion(mockConverter, mockRepo)
 		err := acWithoutProducer.PublishAudioConversionJob(ctx, msg)
@@ -140,8 +295,9 @@ func TestAudioConversion_Handle(t *testing.T) {
 		queueMsg := Message{Value: data}
 		outputPath := "output/path"
 
-		mockConverter.On("ConvertToStorageFormat", msg.InputURI).Return(outputPath, nil)
-		mockRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, outputPath).Return(nil)
+		mockRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(&model.AudioRecord{Status: model.AudioConversionOngoing}, nil).Once()
+		mockConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return(outputPath, nil)
+		mockRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, outputPath, "").Return(nil)
 
 		err := ac.Handle(ctx, queueMsg)
 		assert.NoError(t, err)
@@ -149,9 +305,429 @@ func TestAudioConversion_Handle(t *testing.T) {
 		mockRepo.AssertExpectations(t)
 	})
 
+	t.Run("already completed", func(t *testing.T) {
+		completedConverter := new(MockAudioConverter)
+		completedRepo := new(repository.MockDatabase)
+		completed := NewAudioConversion(completedConverter, completedRepo)
+
+		data, _ := json.Marshal(msg)
+		completedRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).
+			Return(&model.AudioRecord{Status: model.AudioConversionCompleted, StoredURI: "output/path"}, nil)
+
+		skipped := instrumentation.MetricValue(metricsNamespace, "conversions_already_completed")
+		err := completed.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err)
+		assert.Equal(t, skipped+1, instrumentation.MetricValue(metricsNamespace, "conversions_already_completed"))
+		completedConverter.AssertNotCalled(t, "ConvertToStorageFormat", mock.Anything, mock.Anything)
+		completedRepo.AssertNotCalled(t, "SaveConvertedFormat", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("replies when requested", func(t *testing.T) {
+		replyConverter := new(MockAudioConverter)
+		replyRepo := new(repository.MockDatabase)
+		replyProducer := new(MockProducer)
+		replying := NewAudioConversion(replyConverter, replyRepo, AudioConversionWithProducer(replyProducer))
+
+		data, _ := json.Marshal(msg)
+		queueMsg := Message{Value: data, ID: "msg-1", Options: MessageOptions{CorrelationID: "msg-1", ReplyTo: "replies.instance"}}
+
+		replyRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		replyConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		replyRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path", "").Return(nil)
+		replyProducer.On("Publish", ctx, mock.MatchedBy(func(reply Message) bool {
+			var value model.AudioConversionReply
+			err := DefaultEnvelopeCodec().Unmarshal(reply.Value, &value)
+			return err == nil && reply.Topic == "replies.instance" && value.OutputURI == "output/path"
+		}), &MessageOptions{DeliveryMode: NonPersistent, ContentType: defaultAudioConversionContentType, CorrelationID: "msg-1"}).
+			Return(errors.New("broker down"))
+
+		// a failed reply does not fail the stored conversion
+		err := replying.Handle(ctx, queueMsg)
+		assert.NoError(t, err)
+		replyProducer.AssertExpectations(t)
+	})
+
+	t.Run("replies through the reply producer", func(t *testing.T) {
+		replyConverter := new(MockAudioConverter)
+		replyRepo := new(repository.MockDatabase)
+		jobProducer := new(MockProducer)
+		replyProducer := new(MockProducer)
+		replying := NewAudioConversion(replyConverter, replyRepo,
+			AudioConversionWithProducer(jobProducer), AudioConversionWithReplyProducer(replyProducer))
+
+		data, _ := json.Marshal(msg)
+		queueMsg := Message{Value: data, ID: "msg-1", Options: MessageOptions{CorrelationID: "msg-1", ReplyTo: "replies.instance"}}
+
+		replyRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		replyConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		replyRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path", "").Return(nil)
+		replyProducer.On("Publish", ctx, mock.MatchedBy(func(reply Message) bool {
+			return reply.Topic == "replies.instance"
+		}), mock.Anything).Return(nil)
+
+		err := replying.Handle(ctx, queueMsg)
+		assert.NoError(t, err)
+		replyProducer.AssertExpectations(t)
+		jobProducer.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("records the profile", func(t *testing.T) {
+		profileConverter := new(MockAudioConverter)
+		profileRepo := new(repository.MockDatabase)
+		profiled := NewAudioConversion(profileConverter, profileRepo, AudioConversionWithProfile("storage_master"))
+
+		data, _ := json.Marshal(msg)
+		profileRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		profileConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		profileRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path", "storage_master").Return(nil)
+
+		err := profiled.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err)
+		profileRepo.AssertExpectations(t)
+	})
+
+	t.Run("processes the conversion", func(t *testing.T) {
+		processConverter := new(MockAudioConverter)
+		processor := new(MockProcessor)
+		processRepo := new(repository.MockDatabase)
+		processing := NewAudioConversion(processConverter, processRepo, AudioConversionWithProcessor(processor))
+
+		data, _ := json.Marshal(msg)
+		processRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		processConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		processor.On("Process", mock.Anything, "output/path").
+			Return(converter.Analysis{Loudness: -31.5, Gain: 8.5, TrimStart: 1200 * time.Millisecond, TrimEnd: 300 * time.Millisecond}, nil)
+		loudness := -31.5
+		processRepo.On("SaveAudioAnalysis", ctx, msg.UserID, msg.PhraseID, model.AudioAnalysis{Loudness: &loudness, TrimStartMS: 1200, TrimEndMS: 300}).Return(nil)
+		processRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path", "").Return(nil)
+
+		err := processing.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err)
+		processor.AssertExpectations(t)
+		processRepo.AssertExpectations(t)
+	})
+
+	t.Run("failed processing", func(t *testing.T) {
+		processConverter := new(MockAudioConverter)
+		processor := new(MockProcessor)
+		processRepo := new(repository.MockDatabase)
+		processing := NewAudioConversion(processConverter, processRepo, AudioConversionWithProcessor(processor))
+
+		data, _ := json.Marshal(msg)
+		processRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		processConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		processor.On("Process", mock.Anything, "output/path").Return(converter.Analysis{}, converter.ErrInvalidWAV)
+
+		err := processing.Handle(ctx, Message{Value: data})
+		assert.ErrorIs(t, err, converter.ErrInvalidWAV)
+		processRepo.AssertNotCalled(t, "SaveConvertedFormat", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("generates the waveform", func(t *testing.T) {
+		waveformConverter := new(MockAudioConverter)
+		waveforms := new(MockWaveformGenerator)
+		waveformRepo := new(repository.MockDatabase)
+		generating := NewAudioConversion(waveformConverter, waveformRepo, AudioConversionWithWaveforms(waveforms))
+
+		data, _ := json.Marshal(msg)
+		waveformRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		waveformConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		waveforms.On("Generate", mock.Anything, "output/path").Return(nil)
+		waveformRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path", "").Return(nil)
+
+		err := generating.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err)
+		waveforms.AssertExpectations(t)
+		waveformRepo.AssertExpectations(t)
+	})
+
+	t.Run("removes the cached spectrograms of the replaced conversion", func(t *testing.T) {
+		artifactConverter := new(MockAudioConverter)
+		artifactRepo := new(repository.MockDatabase)
+		fileStore := new(MockFile)
+		replacing := NewAudioConversion(artifactConverter, artifactRepo, AudioConversionWithArtifacts(fileStore))
+
+		data, _ := json.Marshal(msg)
+		artifactRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(&model.AudioRecord{Status: model.AudioConversionOngoing}, nil)
+		artifactConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		artifactRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path", "").Return(nil)
+		fileStore.On("RemoveArtifacts", ctx, msg.UserID, msg.PhraseID, converter.SpectrogramArtifactPrefix).Return(errors.New("disk failure"))
+
+		err := replacing.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err, "cached spectrograms left behind do not fail the conversion")
+		fileStore.AssertExpectations(t)
+	})
+
+	t.Run("failed waveform", func(t *testing.T) {
+		waveformConverter := new(MockAudioConverter)
+		waveforms := new(MockWaveformGenerator)
+		waveformRepo := new(repository.MockDatabase)
+		generating := NewAudioConversion(waveformConverter, waveformRepo, AudioConversionWithWaveforms(waveforms))
+
+		data, _ := json.Marshal(msg)
+		waveformRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		waveformConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path", nil)
+		waveforms.On("Generate", mock.Anything, "output/path").Return(converter.ErrInvalidWAV)
+		waveformRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path", "").Return(nil)
+
+		failed := instrumentation.MetricValue(metricsNamespace, "waveforms_failed")
+		err := generating.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err)
+		assert.Equal(t, failed+1, instrumentation.MetricValue(metricsNamespace, "waveforms_failed"))
+		waveformRepo.AssertExpectations(t)
+	})
+
+	t.Run("records the renditions", func(t *testing.T) {
+		renditionConverter := new(MockAudioConverter)
+		renditions := new(MockRenditionGenerator)
+		renditionRepo := new(repository.MockDatabase)
+		rendering := NewAudioConversion(renditionConverter, renditionRepo,
+			AudioConversionWithProfile("storage_master"), AudioConversionWithRenditions(renditions))
+
+		data, _ := json.Marshal(msg)
+		renditionRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		renditionConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path.wav", nil)
+		renditions.On("Generate", mock.Anything, "output/path.wav").Return([]converter.Rendition{
+			{Profile: "storage_master", Format: converter.WAV, Path: "output/path.wav", Size: 3200, Checksum: "aa"},
+			{Profile: "playback", Format: converter.M4A, Path: "output/path.playback.m4a", Size: 800, Checksum: "bb"},
+		}, nil)
+		renditionRepo.On("SaveAudioRendition", ctx, model.AudioRendition{
+			UserID: msg.UserID, PhraseID: msg.PhraseID, Profile: "storage_master", Format: "WAV", URI: "output/path.wav", Size: 3200, Checksum: "aa",
+		}).Return(nil).Once()
+		renditionRepo.On("SaveAudioRendition", ctx, model.AudioRendition{
+			UserID: msg.UserID, PhraseID: msg.PhraseID, Profile: "playback", Format: "M4A", URI: "output/path.playback.m4a", Size: 800, Checksum: "bb",
+		}).Return(nil).Once()
+		renditionRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path.wav", "storage_master").Return(nil)
+
+		err := rendering.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err)
+		renditions.AssertExpectations(t)
+		renditionRepo.AssertExpectations(t)
+	})
+
+	t.Run("failed renditions", func(t *testing.T) {
+		renditionConverter := new(MockAudioConverter)
+		renditions := new(MockRenditionGenerator)
+		renditionRepo := new(repository.MockDatabase)
+		rendering := NewAudioConversion(renditionConverter, renditionRepo, AudioConversionWithRenditions(renditions))
+
+		data, _ := json.Marshal(msg)
+		renditionRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		renditionConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("output/path.wav", nil)
+		renditions.On("Generate", mock.Anything, "output/path.wav").Return([]converter.Rendition{
+			{Profile: "", Format: converter.WAV, Path: "output/path.wav", Size: 3200, Checksum: "aa"},
+			{Profile: "playback", Format: converter.M4A, Err: &converter.ConversionError{
+				Kind:    converter.UnsupportedCodec,
+				Summary: "Unknown encoder 'libfdk_aac'",
+				Err:     errors.New("exit status 1"),
+			}},
+		}, nil)
+		renditionRepo.On("SaveAudioRendition", ctx, model.AudioRendition{
+			UserID: msg.UserID, PhraseID: msg.PhraseID, Format: "WAV", URI: "output/path.wav", Size: 3200, Checksum: "aa",
+		}).Return(nil).Once()
+		renditionRepo.On("SaveAudioRendition", ctx, model.AudioRendition{
+			UserID: msg.UserID, PhraseID: msg.PhraseID, Profile: "playback", Format: "M4A", ConversionFailure: model.ConversionFailure{
+				FailureKind:    "unsupported_codec",
+				FailureSummary: "Unknown encoder 'libfdk_aac'",
+			},
+		}).Return(nil).Once()
+		renditionRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, "output/path.wav", "").Return(nil)
+
+		failed := instrumentation.MetricValue(metricsNamespace, "renditions_failed")
+		err := rendering.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err, "the conversion completes without the rendition")
+		assert.Equal(t, failed+1, instrumentation.MetricValue(metricsNamespace, "renditions_failed"))
+		renditionRepo.AssertExpectations(t)
+	})
+
+	t.Run("removes the outputs of failed conversions", func(t *testing.T) {
+		dir := t.TempDir()
+		outputPath, playbackPath, waveformPath := filepath.Join(dir, "upload.wav"), filepath.Join(dir, "upload.playback.m4a"), filepath.Join(dir, "upload.peaks-256.dat")
+		for _, path := range []string{outputPath, playbackPath, waveformPath} {
+			require.NoError(t, os.WriteFile(path, []byte("audio"), 0o644))
+		}
+
+		removingConverter := new(MockAudioConverter)
+		waveforms := new(MockWaveformGenerator)
+		renditions := new(MockRenditionGenerator)
+		removingRepo := new(repository.MockDatabase)
+		removing := NewAudioConversion(removingConverter, removingRepo,
+			AudioConversionWithWaveforms(waveforms), AudioConversionWithRenditions(renditions))
+
+		data, _ := json.Marshal(msg)
+		removingRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		removingConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return(outputPath, nil)
+		waveforms.On("Generate", mock.Anything, outputPath).Return(nil)
+		waveforms.On("Paths", outputPath).Return([]string{waveformPath})
+		renditions.On("Generate", mock.Anything, outputPath).Return([]converter.Rendition{
+			{Format: converter.WAV, Path: outputPath, Size: 5, Checksum: "aa"},
+			{Profile: "playback", Format: converter.M4A, Path: playbackPath, Size: 5, Checksum: "bb"},
+		}, nil)
+		removingRepo.On("SaveAudioRendition", ctx, mock.Anything).Return(errors.New("connection lost")).Once()
+
+		err := removing.Handle(ctx, Message{Value: data})
+		assert.EqualError(t, err, "connection lost")
+		for _, path := range []string{outputPath, playbackPath, waveformPath} {
+			assert.NoFileExists(t, path)
+		}
+	})
+
+	t.Run("discards conversions of records failed meanwhile", func(t *testing.T) {
+		outputPath := filepath.Join(t.TempDir(), "upload.wav")
+		require.NoError(t, os.WriteFile(outputPath, []byte("audio"), 0o644))
+
+		lateConverter := new(MockAudioConverter)
+		lateRepo := new(repository.MockDatabase)
+		late := NewAudioConversion(lateConverter, lateRepo)
+
+		data, _ := json.Marshal(msg)
+		lateRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		lateConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return(outputPath, nil)
+		lateRepo.On("SaveConvertedFormat", ctx, msg.UserID, msg.PhraseID, outputPath, "").Return(repository.ErrConversionFailed)
+
+		discarded := instrumentation.MetricValue(metricsNamespace, "conversions_discarded")
+		err := late.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err, "the job is not retried")
+		assert.Equal(t, discarded+1, instrumentation.MetricValue(metricsNamespace, "conversions_discarded"))
+		assert.NoFileExists(t, outputPath)
+	})
+
+	t.Run("rendition errors fail the conversion", func(t *testing.T) {
+		outputPath := filepath.Join(t.TempDir(), "upload.wav")
+		require.NoError(t, os.WriteFile(outputPath, []byte("audio"), 0o644))
+
+		renditionConverter := new(MockAudioConverter)
+		renditions := new(MockRenditionGenerator)
+		renditionRepo := new(repository.MockDatabase)
+		rendering := NewAudioConversion(renditionConverter, renditionRepo, AudioConversionWithRenditions(renditions))
+
+		data, _ := json.Marshal(msg)
+		renditionRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		renditionConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return(outputPath, nil)
+		renditions.On("Generate", mock.Anything, outputPath).Return(nil, errors.New("ffmpeg failed"))
+
+		err := rendering.Handle(ctx, Message{Value: data})
+		assert.EqualError(t, err, "ffmpeg failed")
+		assert.NoFileExists(t, outputPath, "the redelivered job converts the recording again")
+		renditionRepo.AssertNotCalled(t, "SaveConvertedFormat", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		corruptConverter := new(MockAudioConverter)
+		corruptRepo := new(repository.MockDatabase)
+		corrupt := NewAudioConversion(corruptConverter, corruptRepo)
+
+		data, _ := json.Marshal(msg)
+		corruptRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		corruptConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("", &converter.ConversionError{
+			Kind:    converter.CorruptInput,
+			Summary: "input/path: Invalid data found when processing input",
+			Err:     errors.New("exit status 1"),
+		})
+		corruptRepo.On("FailConversion", ctx, msg.UserID, msg.PhraseID, model.ConversionFailure{
+			FailureKind:    "corrupt_input",
+			FailureSummary: "input/path: Invalid data found when processing input",
+		}).Return(nil)
+
+		failed := instrumentation.MetricValue(metricsNamespace, "conversions_failed_permanently")
+		err := corrupt.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err, "the job is not retried")
+		assert.Equal(t, failed+1, instrumentation.MetricValue(metricsNamespace, "conversions_failed_permanently"))
+		corruptRepo.AssertExpectations(t)
+		corruptRepo.AssertNotCalled(t, "SaveConvertedFormat", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("resource limit", func(t *testing.T) {
+		limitConverter := new(MockAudioConverter)
+		limitRepo := new(repository.MockDatabase)
+		limited := NewAudioConversion(limitConverter, limitRepo)
+
+		data, _ := json.Marshal(msg)
+		limitRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		limitConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("", &converter.ConversionError{
+			Kind:    converter.ResourceLimit,
+			Summary: "ffmpeg exceeded its output size limit",
+			Err:     fmt.Errorf("%w: signal: file size limit exceeded", converter.ErrResourceLimit),
+		})
+		limitRepo.On("FailConversion", ctx, msg.UserID, msg.PhraseID, model.ConversionFailure{
+			FailureKind:    "resource_limit",
+			FailureSummary: "ffmpeg exceeded its output size limit",
+		}).Return(nil)
+
+		err := limited.Handle(ctx, Message{Value: data})
+		assert.NoError(t, err, "the job is not retried")
+		limitRepo.AssertExpectations(t)
+	})
+
+	t.Run("transient failure", func(t *testing.T) {
+		ioConverter := new(MockAudioConverter)
+		ioRepo := new(repository.MockDatabase)
+		failing := NewAudioConversion(ioConverter, ioRepo)
+
+		data, _ := json.Marshal(msg)
+		ioRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		ioConverter.On("ConvertToStorageFormat", mock.Anything, msg.InputURI).Return("", &converter.ConversionError{
+			Kind:    converter.IOFailure,
+			Summary: "output/path.wav: No space left on device",
+			Err:     errors.New("exit status 1"),
+		})
+		ioRepo.On("RecordConversionFailure", ctx, msg.UserID, msg.PhraseID, model.ConversionFailure{
+			FailureKind:    string(converter.IOFailure),
+			FailureSummary: "output/path.wav: No space left on device",
+		}).Return(nil)
+
+		err := failing.Handle(ctx, Message{Value: data})
+		assert.ErrorContains(t, err, "No space left on device", "the job is retried")
+		ioRepo.AssertNotCalled(t, "FailConversion", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		ioRepo.AssertExpectations(t)
+	})
+
+	t.Run("conversion timeout", func(t *testing.T) {
+		timeoutConverter := new(MockAudioConverter)
+		timeoutRepo := new(repository.MockDatabase)
+		timingOut := NewAudioConversion(timeoutConverter, timeoutRepo, AudioConversionWithTimeout(time.Minute))
+
+		data, _ := json.Marshal(msg)
+		timeoutRepo.On("GetAudioRecord", ctx, msg.UserID, msg.PhraseID).Return(nil, nil)
+		withDeadline := mock.MatchedBy(func(ctx context.Context) bool {
+			deadline, ok := ctx.Deadline()
+			return ok && time.Until(deadline) <= time.Minute
+		})
+		timeoutConverter.On("ConvertToStorageFormat", withDeadline, msg.InputURI).
+			Return("", fmt.Errorf("ffmpeg interrupted: %w", context.DeadlineExceeded))
+
+		timedOut := instrumentation.MetricValue(metricsNamespace, "conversions_timed_out")
+		err := timingOut.Handle(ctx, Message{Value: data})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, timedOut+1, instrumentation.MetricValue(metricsNamespace, "conversions_timed_out"))
+		timeoutConverter.AssertExpectations(t)
+		timeoutRepo.AssertNotCalled(t, "SaveConvertedFormat", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
 	t.Run("invalid message format", func(t *testing.T) {
 		queueMsg := Message{Value: []byte("invalid json")}
 		err := ac.Handle(ctx, queueMsg)
 		assert.Error(t, err)
 	})
 }
+
+func TestKeyFuncFor(t *testing.T) {
+	msg := model.AudioConversionMessage{UserID: 42, PhraseID: 7}
+
+	t.Run("defaults to user", func(t *testing.T) {
+		keyFunc, err := KeyFuncFor("")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("42"), keyFunc(msg))
+	})
+
+	t.Run("none disables keying", func(t *testing.T) {
+		keyFunc, err := KeyFuncFor("none")
+		assert.NoError(t, err)
+		assert.Nil(t, keyFunc)
+	})
+
+	t.Run("unsupported key", func(t *testing.T) {
+		_, err := KeyFuncFor("tenant")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"phonon/pkg/instrumentation"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBackpressureRefresh    = 5 * time.Second
+	defaultBackpressureDeferDelay = 5 * time.Minute
+	defaultBackpressureRetryAfter = time.Minute
+)
+
+var ErrDepthUnavailable = errors.New("queue depth unavailable")
+
+// DepthReporter is implemented by producers that can tell how many messages wait in their topic
+type DepthReporter interface {
+	Depth(ctx context.Context) (int64, error)
+}
+
+// ProducerDepth returns the depth of the producer topic, or ErrDepthUnavailable when the producer cannot report it
+func ProducerDepth(ctx context.Context, producer Producer) (int64, error) {
+	reporter, ok := producer.(DepthReporter)
+	if !ok {
+		return 0, ErrDepthUnavailable
+	}
+
+	return reporter.Depth(ctx)
+}
+
+// BackpressureThresholds decide how new work is admitted depending on the queue depth, zero values disable a threshold
+type BackpressureThresholds struct {
+	DeferDepth  int64         // Depth above which work is accepted but deferred by DeferDelay
+	RejectDepth int64         // Depth above which work is rejected, to be retried after RetryAfter
+	DeferDelay  time.Duration // Delay of deferred work, defaulting to 5 minutes
+	RetryAfter  time.Duration // Time rejected clients should wait before retrying, defaulting to a minute
+}
+
+func (t BackpressureThresholds) withDefaults() BackpressureThresholds {
+	if t.DeferDelay <= 0 {
+		t.DeferDelay = defaultBackpressureDeferDelay
+	}
+	if t.RetryAfter <= 0 {
+		t.RetryAfter = defaultBackpressureRetryAfter
+	}
+
+	return t
+}
+
+// Admission is the outcome of a backpressure check
+type Admission struct {
+	Depth      int64
+	Defer      time.Duration // Delay to defer the work by, zero when the work is processed right away
+	Reject     bool
+	RetryAfter time.Duration // Time to wait before retrying rejected work
+}
+
+// Backpressure admits new work depending on the depth of the queue of a producer.
+// The depth is cached between refreshes so admission does not query the queue on every request,
+// and the thresholds are read on every check so they can change at runtime.
+type Backpressure struct {
+	producer   Producer
+	thresholds func() BackpressureThresholds
+	refresh    time.Duration
+
+	mu        sync.Mutex
+	depth     int64
+	err       error
+	checkedAt time.Time
+}
+
+// NewBackpressure creates a Backpressure checking the depth of producer at most once per refresh, defaulting to 5 seconds
+func NewBackpressure(producer Producer, thresholds func() BackpressureThresholds, refresh time.Duration) *Backpressure {
+	if refresh <= 0 {
+		refresh = defaultBackpressureRefresh
+	}
+
+	return &Backpressure{producer: producer, thresholds: thresholds, refresh: refresh}
+}
+
+// Admit checks the queue depth against the thresholds.
+// Work is admitted when the depth cannot be determined, an unreachable queue fails the publish anyway.
+func (b *Backpressure) Admit(ctx context.Context) Admission {
+	thresholds := b.thresholds().withDefaults()
+	if thresholds.DeferDepth <= 0 && thresholds.RejectDepth <= 0 {
+		return Admission{}
+	}
+
+	depth, err := b.currentDepth(ctx)
+	if err != nil {
+		if !errors.Is(err, ErrDepthUnavailable) {
+			logrus.WithContext(ctx).Warnf("failed to get queue depth, admitting work: %v", err)
+		}
+		return Admission{}
+	}
+
+	admission := Admission{Depth: depth}
+	switch {
+	case thresholds.RejectDepth > 0 && depth > thresholds.RejectDepth:
+		admission.Reject = true
+		admission.RetryAfter = thresholds.RetryAfter
+		instrumentation.IncrementCounter(metricsNamespace, "backpressure_rejected")
+	case thresholds.DeferDepth > 0 && depth > thresholds.DeferDepth:
+		admission.Defer = thresholds.DeferDelay
+		instrumentation.IncrementCounter(metricsNamespace, "backpressure_deferred")
+	}
+
+	return admission
+}
+
+func (b *Backpressure) currentDepth(ctx context.Context) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now := time.Now(); now.Sub(b.checkedAt) >= b.refresh {
+		b.depth, b.err = ProducerDepth(ctx, b.producer)
+		b.checkedAt = now
+		if b.err == nil {
+			instrumentation.SetGauge(metricsNamespace, "backpressure_depth", b.depth)
+		}
+	}
+
+	return b.depth, b.err
+}
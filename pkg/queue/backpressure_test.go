@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// depthProducer is a producer reporting a configurable depth
+type depthProducer struct {
+	MockProducer
+	depth int64
+	err   error
+	calls int
+}
+
+func (p *depthProducer) Depth(ctx context.Context) (int64, error) {
+	p.calls++
+	return p.depth, p.err
+}
+
+func TestProducerDepth(t *testing.T) {
+	ctx := context.Background()
+
+	depth, err := ProducerDepth(ctx, &depthProducer{depth: 7})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), depth)
+
+	_, err = ProducerDepth(ctx, new(MockProducer))
+	assert.ErrorIs(t, err, ErrDepthUnavailable)
+
+	t.Run("through decorators", func(t *testing.T) {
+		compressing, err := NewCompressingProducer(&depthProducer{depth: 3}, EncodingGzip, 0)
+		require.NoError(t, err)
+
+		depth, err := ProducerDepth(ctx, compressing)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), depth)
+
+		compressing, err = NewCompressingProducer(new(MockProducer), EncodingGzip, 0)
+		require.NoError(t, err)
+
+		_, err = ProducerDepth(ctx, compressing)
+		assert.ErrorIs(t, err, ErrDepthUnavailable)
+	})
+}
+
+func TestBackpressure_Admit(t *testing.T) {
+	ctx := context.Background()
+	thresholds := BackpressureThresholds{DeferDepth: 10, RejectDepth: 20, DeferDelay: time.Minute, RetryAfter: 30 * time.Second}
+	static := func() BackpressureThresholds { return thresholds }
+
+	tests := []struct {
+		name     string
+		producer Producer
+		expected Admission
+	}{
+		{name: "below thresholds", producer: &depthProducer{depth: 10}, expected: Admission{Depth: 10}},
+		{name: "deferred", producer: &depthProducer{depth: 11}, expected: Admission{Depth: 11, Defer: time.Minute}},
+		{name: "rejected", producer: &depthProducer{depth: 21}, expected: Admission{Depth: 21, Reject: true, RetryAfter: 30 * time.Second}},
+		{name: "depth unsupported", producer: new(MockProducer), expected: Admission{}},
+		{name: "depth failing", producer: &depthProducer{err: errors.New("unreachable")}, expected: Admission{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NewBackpressure(tt.producer, static, time.Minute).Admit(ctx))
+		})
+	}
+
+	t.Run("disabled thresholds do not query the depth", func(t *testing.T) {
+		producer := &depthProducer{depth: 100}
+		backpressure := NewBackpressure(producer, func() BackpressureThresholds { return BackpressureThresholds{} }, time.Minute)
+
+		assert.Equal(t, Admission{}, backpressure.Admit(ctx))
+		assert.Zero(t, producer.calls)
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		producer := &depthProducer{depth: 100}
+		backpressure := NewBackpressure(producer, func() BackpressureThresholds { return BackpressureThresholds{DeferDepth: 1} }, time.Minute)
+
+		assert.Equal(t, defaultBackpressureDeferDelay, backpressure.Admit(ctx).Defer)
+	})
+
+	t.Run("depth is cached between refreshes", func(t *testing.T) {
+		producer := &depthProducer{depth: 1}
+		backpressure := NewBackpressure(producer, static, 20*time.Millisecond)
+
+		backpressure.Admit(ctx)
+		producer.depth = 15
+		assert.Zero(t, backpressure.Admit(ctx).Defer)
+		assert.Equal(t, 1, producer.calls)
+
+		time.Sleep(25 * time.Millisecond)
+		assert.Equal(t, time.Minute, backpressure.Admit(ctx).Defer)
+		assert.Equal(t, 2, producer.calls)
+	})
+
+	t.Run("thresholds are read on every check", func(t *testing.T) {
+		current := thresholds
+		backpressure := NewBackpressure(&depthProducer{depth: 15}, func() BackpressureThresholds { return current }, time.Minute)
+
+		assert.Equal(t, time.Minute, backpressure.Admit(ctx).Defer)
+
+		current.RejectDepth = 12
+		assert.True(t, backpressure.Admit(ctx).Reject)
+	})
+}
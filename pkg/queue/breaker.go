@@ -0,0 +1,179 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"phonon/pkg/instrumentation"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 30 * time.Second
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreakerProducer
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Publishes go through
+	BreakerOpen                         // Publishes fail fast
+	BreakerHalfOpen                     // A single publish probes whether the broker recovered
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig holds configuration for the circuit breaker
+type BreakerConfig struct {
+	FailureThreshold int           // Consecutive failed publishes opening the circuit
+	OpenTimeout      time.Duration // Time the circuit stays open before a publish probes the broker again
+	PublishTimeout   time.Duration // Time a publish may take before it counts as failed, unlimited when zero
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = defaultBreakerOpenTimeout
+	}
+
+	return c
+}
+
+// CircuitBreakerProducer decorates a Producer so publishes fail fast with ErrCircuitOpen once the broker
+// failed FailureThreshold times in a row, instead of every caller waiting out the retries of the producer.
+// After OpenTimeout a single publish probes the broker, closing the circuit again when it succeeds.
+type CircuitBreakerProducer struct {
+	producer Producer
+	config   BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreakerProducer creates a CircuitBreakerProducer
+func NewCircuitBreakerProducer(producer Producer, config BreakerConfig) *CircuitBreakerProducer {
+	return &CircuitBreakerProducer{producer: producer, config: config.withDefaults()}
+}
+
+// Publish implements the Producer interface
+func (p *CircuitBreakerProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	if !p.allow(time.Now()) {
+		return ErrCircuitOpen
+	}
+
+	publishCtx := ctx
+	if p.config.PublishTimeout > 0 {
+		var cancel context.CancelFunc
+		publishCtx, cancel = context.WithTimeout(ctx, p.config.PublishTimeout)
+		defer cancel()
+	}
+
+	err := p.producer.Publish(publishCtx, msg, opts)
+	if err != nil && ctx.Err() != nil {
+		// the caller gave up, which says nothing about the broker
+		p.release()
+		return err
+	}
+	p.record(time.Now(), err)
+
+	return err
+}
+
+// State returns the current state of the circuit
+func (p *CircuitBreakerProducer) State() BreakerState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state
+}
+
+// allow reports whether a publish may go through, moving an open circuit to half-open once OpenTimeout elapsed
+func (p *CircuitBreakerProducer) allow(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch p.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if now.Sub(p.openedAt) < p.config.OpenTimeout {
+			return false
+		}
+		p.setState(BreakerHalfOpen)
+		return true
+	default:
+		// a probe is in flight
+		return false
+	}
+}
+
+// release gives the probe back when it ended without telling anything about the broker
+func (p *CircuitBreakerProducer) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == BreakerHalfOpen {
+		p.setState(BreakerOpen)
+	}
+}
+
+func (p *CircuitBreakerProducer) record(now time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		p.failures = 0
+		if p.state != BreakerClosed {
+			p.setState(BreakerClosed)
+			logrus.Info("queue circuit breaker closed")
+		}
+		return
+	}
+
+	p.failures++
+	if p.state == BreakerHalfOpen || p.failures >= p.config.FailureThreshold {
+		if p.state == BreakerClosed {
+			instrumentation.IncrementCounter(metricsNamespace, "breaker_opened")
+			logrus.Warnf("queue circuit breaker opened after %d failed publishes: %v", p.failures, err)
+		}
+		p.openedAt = now
+		p.setState(BreakerOpen)
+	}
+}
+
+// setState changes the state, p.mu must be held
+func (p *CircuitBreakerProducer) setState(state BreakerState) {
+	p.state = state
+	instrumentation.SetGauge(metricsNamespace, "breaker_state", int64(state))
+}
+
+// Depth implements the DepthReporter interface by asking the decorated producer
+func (p *CircuitBreakerProducer) Depth(ctx context.Context) (int64, error) {
+	return ProducerDepth(ctx, p.producer)
+}
+
+// Close implements the Producer interface
+func (p *CircuitBreakerProducer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCircuitBreakerProducer(t *testing.T) {
+	ctx := context.Background()
+	brokerDown := errors.New("broker down")
+	config := BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond}
+
+	open := func(t *testing.T, breaker *CircuitBreakerProducer) {
+		for i := 0; i < config.FailureThreshold; i++ {
+			assert.ErrorIs(t, breaker.Publish(ctx, Message{}, nil), brokerDown)
+		}
+		assert.Equal(t, BreakerOpen, breaker.State())
+	}
+
+	t.Run("opens after consecutive failures and fails fast", func(t *testing.T) {
+		producer := new(MockProducer)
+		producer.On("Publish", ctx, Message{}, (*MessageOptions)(nil)).Return(brokerDown).Times(2)
+		breaker := NewCircuitBreakerProducer(producer, config)
+
+		open(t, breaker)
+		assert.ErrorIs(t, breaker.Publish(ctx, Message{}, nil), ErrCircuitOpen)
+		producer.AssertNumberOfCalls(t, "Publish", 2)
+	})
+
+	t.Run("a success resets the failures", func(t *testing.T) {
+		producer := new(MockProducer)
+		producer.On("Publish", ctx, Message{ID: "failing"}, (*MessageOptions)(nil)).Return(brokerDown)
+		producer.On("Publish", ctx, Message{ID: "ok"}, (*MessageOptions)(nil)).Return(nil)
+		breaker := NewCircuitBreakerProducer(producer, config)
+
+		assert.Error(t, breaker.Publish(ctx, Message{ID: "failing"}, nil))
+		assert.NoError(t, breaker.Publish(ctx, Message{ID: "ok"}, nil))
+		assert.Error(t, breaker.Publish(ctx, Message{ID: "failing"}, nil))
+		assert.Equal(t, BreakerClosed, breaker.State())
+	})
+
+	t.Run("a successful probe closes the circuit", func(t *testing.T) {
+		producer := new(MockProducer)
+		producer.On("Publish", ctx, Message{}, (*MessageOptions)(nil)).Return(brokerDown).Times(2)
+		producer.On("Publish", ctx, Message{}, (*MessageOptions)(nil)).Return(nil)
+		breaker := NewCircuitBreakerProducer(producer, config)
+
+		open(t, breaker)
+		time.Sleep(config.OpenTimeout)
+
+		assert.NoError(t, breaker.Publish(ctx, Message{}, nil))
+		assert.Equal(t, BreakerClosed, breaker.State())
+	})
+
+	t.Run("a failed probe opens the circuit again", func(t *testing.T) {
+		producer := new(MockProducer)
+		producer.On("Publish", ctx, Message{}, (*MessageOptions)(nil)).Return(brokerDown)
+		breaker := NewCircuitBreakerProducer(producer, config)
+
+		open(t, breaker)
+		time.Sleep(config.OpenTimeout)
+
+		assert.ErrorIs(t, breaker.Publish(ctx, Message{}, nil), brokerDown)
+		assert.Equal(t, BreakerOpen, breaker.State())
+		assert.ErrorIs(t, breaker.Publish(ctx, Message{}, nil), ErrCircuitOpen)
+	})
+
+	t.Run("a single probe at a time", func(t *testing.T) {
+		probing := make(chan struct{})
+		release := make(chan struct{})
+		producer := new(MockProducer)
+		producer.On("Publish", ctx, Message{}, (*MessageOptions)(nil)).Return(brokerDown).Times(2)
+		producer.On("Publish", ctx, Message{}, (*MessageOptions)(nil)).Return(nil).Run(func(mock.Arguments) {
+			close(probing)
+			<-release
+		}).Once()
+		breaker := NewCircuitBreakerProducer(producer, config)
+
+		open(t, breaker)
+		time.Sleep(config.OpenTimeout)
+
+		done := make(chan error)
+		go func() { done <- breaker.Publish(ctx, Message{}, nil) }()
+		<-probing
+
+		assert.Equal(t, BreakerHalfOpen, breaker.State())
+		assert.ErrorIs(t, breaker.Publish(ctx, Message{}, nil), ErrCircuitOpen)
+
+		close(release)
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("slow publishes count as failures", func(t *testing.T) {
+		producer := new(MockProducer)
+		producer.On("Publish", mock.Anything, Message{}, (*MessageOptions)(nil)).Return(context.DeadlineExceeded).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		})
+		breaker := NewCircuitBreakerProducer(producer, BreakerConfig{FailureThreshold: 1, PublishTimeout: time.Millisecond})
+
+		assert.ErrorIs(t, breaker.Publish(ctx, Message{}, nil), context.DeadlineExceeded)
+		assert.Equal(t, BreakerOpen, breaker.State())
+	})
+
+	t.Run("callers giving up do not count", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		producer := new(MockProducer)
+		producer.On("Publish", canceled, Message{}, (*MessageOptions)(nil)).Return(context.Canceled)
+		breaker := NewCircuitBreakerProducer(producer, BreakerConfig{FailureThreshold: 1})
+
+		assert.ErrorIs(t, breaker.Publish(canceled, Message{}, nil), context.Canceled)
+		assert.Equal(t, BreakerClosed, breaker.State())
+	})
+}
@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"phonon/pkg/model"
+	"phonon/pkg/storage"
+)
+
+// Cleanup publishes and handles jobs removing stored files that are no longer needed
+type Cleanup struct {
+	fileStore storage.File
+	producer  Producer
+	codec     Codec
+	handler   Handler
+}
+
+// NewCleanup creates a Cleanup, the producer may be nil for consumers that never publish
+func NewCleanup(fileStore storage.File, producer Producer) *Cleanup {
+	c := &Cleanup{
+		fileStore: fileStore,
+		producer:  producer,
+		codec:     DefaultEnvelopeCodec(),
+	}
+	c.handler = NewTypedHandler(c.remove, NewCodecs(c.codec))
+
+	return c
+}
+
+// PublishCleanupJob publishes a cleanup job, opts.NotBefore can be used to schedule it for later
+func (c *Cleanup) PublishCleanupJob(ctx context.Context, cleanupMessage model.CleanupMessage, opts *MessageOptions) error {
+	if c.producer == nil {
+		return ErrNoProducer
+	}
+
+	msg, err := EncodeMessage(c.codec, model.CleanupMessageType, cleanupMessage)
+	if err != nil {
+		return err
+	}
+
+	publishOpts := MessageOptions{DeliveryMode: Persistent}
+	if opts != nil {
+		publishOpts = *opts
+	}
+	publishOpts.ContentType = c.codec.ContentType()
+
+	return c.producer.Publish(ctx, msg, &publishOpts)
+}
+
+// Handle implements the Handler interface
+func (c *Cleanup) Handle(ctx context.Context, msg Message) error {
+	return c.handler.Handle(ctx, msg)
+}
+
+// remove deletes the file of a cleanup message, files that are already gone count as removed
+func (c *Cleanup) remove(ctx context.Context, cleanupMessage model.CleanupMessage, msg Message) error {
+	err := c.fileStore.Remove(ctx, cleanupMessage.URI)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"phonon/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockFile is a mock implementation of the storage File interface
+type MockFile struct {
+	mock.Mock
+}
+
+func (m *MockFile) Save(ctx context.Context, userID, phraseID int64, file io.Reader, originalFormat string) (string, error) {
+	args := m.Called(ctx, userID, phraseID, file, originalFormat)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockFile) Delete(ctx context.Context, userID, phraseID int64) error {
+	args := m.Called(ctx, userID, phraseID)
+	return args.Error(0)
+}
+
+func (m *MockFile) SaveArtifact(ctx context.Context, userID, phraseID int64, name string, file io.Reader) (string, error) {
+	args := m.Called(ctx, userID, phraseID, name, file)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockFile) OpenArtifact(ctx context.Context, userID, phraseID int64, name string) (io.ReadCloser, error) {
+	args := m.Called(ctx, userID, phraseID, name)
+	if rc, ok := args.Get(0).(io.ReadCloser); ok {
+		return rc, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockFile) RemoveArtifacts(ctx context.Context, userID, phraseID int64, prefix string) error {
+	args := m.Called(ctx, userID, phraseID, prefix)
+	return args.Error(0)
+}
+
+func (m *MockFile) Remove(ctx context.Context, uri string) error {
+	args := m.Called(ctx, uri)
+	return args.Error(0)
+}
+
+func TestCleanup_PublishCleanupJob(t *testing.T) {
+	ctx := context.Background()
+	msg := model.CleanupMessage{URI: "data/audio_1_1.m4a"}
+	data, _ := json.Marshal(msg)
+
+	t.Run("schedules with options", func(t *testing.T) {
+		mockProducer := new(MockProducer)
+		cleanup := NewCleanup(new(MockFile), mockProducer)
+
+		notBefore := time.Now().Add(time.Hour)
+		expectedMessage := mock.MatchedBy(func(published Message) bool {
+			var envelope Envelope
+			if err := json.Unmarshal(published.Value, &envelope); err != nil {
+				return false
+			}
+			return envelope.Type == model.CleanupMessageType &&
+				string(envelope.Payload) == string(data) &&
+				published.Headers[HeaderType] == model.CleanupMessageType
+		})
+		expectedOpts := &MessageOptions{NotBefore: notBefore, ContentType: ContentTypeJSON}
+		mockProducer.On("Publish", ctx, expectedMessage, expectedOpts).Return(nil)
+
+		assert.NoError(t, cleanup.PublishCleanupJob(ctx, msg, &MessageOptions{NotBefore: notBefore}))
+		mockProducer.AssertExpectations(t)
+	})
+
+	t.Run("no producer error", func(t *testing.T) {
+		cleanup := NewCleanup(new(MockFile), nil)
+		assert.Equal(t, ErrNoProducer, cleanup.PublishCleanupJob(ctx, msg, nil))
+	})
+}
+
+func TestCleanup_Handle(t *testing.T) {
+	ctx := context.Background()
+	data, _ := json.Marshal(model.CleanupMessage{URI: "data/audio_1_1.m4a"})
+
+	t.Run("removes file", func(t *testing.T) {
+		mockFile := new(MockFile)
+		mockFile.On("Remove", ctx, "data/audio_1_1.m4a").Return(nil)
+
+		assert.NoError(t, NewCleanup(mockFile, nil).Handle(ctx, Message{Value: data}))
+		mockFile.AssertExpectations(t)
+	})
+
+	t.Run("missing file counts as removed", func(t *testing.T) {
+		mockFile := new(MockFile)
+		mockFile.On("Remove", ctx, "data/audio_1_1.m4a").Return(os.ErrNotExist)
+
+		assert.NoError(t, NewCleanup(mockFile, nil).Handle(ctx, Message{Value: data}))
+	})
+
+	t.Run("remove error", func(t *testing.T) {
+		mockFile := new(MockFile)
+		mockFile.On("Remove", ctx, "data/audio_1_1.m4a").Return(errors.New("permission denied"))
+
+		assert.Error(t, NewCleanup(mockFile, nil).Handle(ctx, Message{Value: data}))
+	})
+}
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types of the built-in codecs
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+var (
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrNotProtoMessage        = errors.New("value is not a protobuf message")
+)
+
+// Codec encodes and decodes message payloads of one content type
+type Codec interface {
+	// ContentType returns the MIME type of the payloads handled by the codec
+	ContentType() string
+	// Marshal encodes a value into a payload
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes a payload into the value pointed to by v
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes payloads as JSON
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtobufCodec encodes payloads in the protobuf wire format. Values must be protobuf messages,
+// and Unmarshal also accepts a pointer to a nil message pointer, which it allocates.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	// v is a pointer to a message pointer, as with TypedHandler[*pb.Message]
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Ptr {
+		return ErrNotProtoMessage
+	}
+	if rv.Elem().IsNil() {
+		rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+	}
+
+	m, ok := rv.Elem().Interface().(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+// Codecs selects codecs by content type, ignoring MIME parameters such as charset
+type Codecs struct {
+	byContentType map[string]Codec
+	fallback      Codec
+}
+
+// NewCodecs creates a codec registry. The first codec is used for messages without a content type.
+func NewCodecs(codecs ...Codec) *Codecs {
+	c := &Codecs{byContentType: make(map[string]Codec, len(codecs))}
+	for _, codec := range codecs {
+		c.Register(codec)
+	}
+
+	return c
+}
+
+// DefaultCodecs returns a registry of the built-in JSON and protobuf codecs, defaulting to JSON
+func DefaultCodecs() *Codecs {
+	return NewCodecs(JSONCodec{}, ProtobufCodec{})
+}
+
+// Register adds a codec, replacing any codec registered for the same content type
+func (c *Codecs) Register(codec Codec) {
+	c.byContentType[codec.ContentType()] = codec
+	if c.fallback == nil {
+		c.fallback = codec
+	}
+}
+
+// Lookup returns the codec of a content type, or the default codec when the content type is empty
+func (c *Codecs) Lookup(contentType string) (Codec, error) {
+	if contentType == "" {
+		if c.fallback == nil {
+			return nil, ErrUnsupportedContentType
+		}
+		return c.fallback, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+
+	codec, ok := c.byContentType[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+
+	return codec, nil
+}
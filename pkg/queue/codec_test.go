@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodecs_Lookup(t *testing.T) {
+	codecs := DefaultCodecs()
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        string
+		wantErr     bool
+	}{
+		{name: "empty defaults to json", contentType: "", want: ContentTypeJSON},
+		{name: "json", contentType: "application/json", want: ContentTypeJSON},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", want: ContentTypeJSON},
+		{name: "protobuf", contentType: "application/x-protobuf", want: ContentTypeProtobuf},
+		{name: "unsupported", contentType: "application/xml", wantErr: true},
+		{name: "malformed", contentType: ";;", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec, err := codecs.Lookup(tt.contentType)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrUnsupportedContentType)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, codec.ContentType())
+		})
+	}
+
+	t.Run("empty registry", func(t *testing.T) {
+		_, err := NewCodecs().Lookup("")
+		assert.ErrorIs(t, err, ErrUnsupportedContentType)
+	})
+}
+
+func TestProtobufCodec(t *testing.T) {
+	codec := ProtobufCodec{}
+
+	data, err := codec.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	t.Run("into message", func(t *testing.T) {
+		decoded := &wrapperspb.StringValue{}
+		require.NoError(t, codec.Unmarshal(data, decoded))
+		assert.Equal(t, "hello", decoded.GetValue())
+	})
+
+	t.Run("into nil message pointer", func(t *testing.T) {
+		var decoded *wrapperspb.StringValue
+		require.NoError(t, codec.Unmarshal(data, &decoded))
+		assert.Equal(t, "hello", decoded.GetValue())
+	})
+
+	t.Run("non protobuf values", func(t *testing.T) {
+		_, err := codec.Marshal("hello")
+		assert.ErrorIs(t, err, ErrNotProtoMessage)
+
+		var decoded string
+		assert.ErrorIs(t, codec.Unmarshal(data, &decoded), ErrNotProtoMessage)
+	})
+}
@@ -0,0 +1,172 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings supported for message payloads
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
+	EncodingSnappy   = "snappy"
+)
+
+// maxDecompressedSize bounds decompressed payloads so a corrupt or malicious message cannot exhaust memory
+const maxDecompressedSize = 64 << 20
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrPayloadTooLarge     = errors.New("decompressed payload too large")
+)
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+)
+
+// Compress encodes data with the given content encoding
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "", EncodingIdentity:
+		return data, nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	case EncodingSnappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// Decompress decodes data compressed with the given content encoding
+func Decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "", EncodingIdentity:
+		return data, nil
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		decoded, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > maxDecompressedSize {
+			return nil, ErrPayloadTooLarge
+		}
+		return decoded, nil
+	case EncodingZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(data, nil)
+	case EncodingSnappy:
+		size, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if size > maxDecompressedSize {
+			return nil, ErrPayloadTooLarge
+		}
+		return snappy.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// CompressingProducer decorates a Producer by compressing payloads of at least minSize bytes
+// and recording the encoding in MessageOptions.ContentEncoding, which consumers use to decompress them
+type CompressingProducer struct {
+	producer Producer
+	encoding string
+	minSize  int
+}
+
+// NewCompressingProducer creates a CompressingProducer for one of the supported encodings
+func NewCompressingProducer(producer Producer, encoding string, minSize int) (*CompressingProducer, error) {
+	if _, err := Compress(encoding, nil); err != nil {
+		return nil, err
+	}
+
+	return &CompressingProducer{producer: producer, encoding: encoding, minSize: minSize}, nil
+}
+
+// Publish implements the Producer interface. Payloads that are already encoded are published as they are.
+func (p *CompressingProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	if len(msg.Value) < p.minSize || (opts != nil && opts.ContentEncoding != "") {
+		return p.producer.Publish(ctx, msg, opts)
+	}
+
+	compressed, err := Compress(p.encoding, msg.Value)
+	if err != nil {
+		return err
+	}
+
+	var compressedOpts MessageOptions
+	if opts != nil {
+		compressedOpts = *opts
+	}
+	compressedOpts.ContentEncoding = p.encoding
+	msg.Value = compressed
+
+	return p.producer.Publish(ctx, msg, &compressedOpts)
+}
+
+// Depth implements the DepthReporter interface by asking the decorated producer
+func (p *CompressingProducer) Depth(ctx context.Context) (int64, error) {
+	return ProducerDepth(ctx, p.producer)
+}
+
+// Close implements the Producer interface
+func (p *CompressingProducer) Close() error {
+	return p.producer.Close()
+}
+
+// decompressMessage replaces a compressed payload with its decoded form so handlers never see the encoding
+func decompressMessage(msg Message) (Message, error) {
+	if msg.Options.ContentEncoding == "" {
+		return msg, nil
+	}
+
+	value, err := Decompress(msg.Options.ContentEncoding, msg.Value)
+	if err != nil {
+		return msg, fmt.Errorf("failed to decompress %s payload: %w", msg.Options.ContentEncoding, err)
+	}
+
+	msg.Value = value
+	msg.Options.ContentEncoding = ""
+
+	return msg, nil
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"user":"alice","phrase":"hello"}`), 64)
+
+	for _, encoding := range []string{"", EncodingIdentity, EncodingGzip, EncodingZstd, EncodingSnappy} {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := Compress(encoding, payload)
+			require.NoError(t, err)
+			if encoding != "" && encoding != EncodingIdentity {
+				assert.Less(t, len(compressed), len(payload))
+			}
+
+			decompressed, err := Decompress(encoding, compressed)
+			require.NoError(t, err)
+			assert.Equal(t, payload, decompressed)
+		})
+	}
+}
+
+func TestCompress_UnsupportedEncoding(t *testing.T) {
+	_, err := Compress("br", []byte("data"))
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+
+	_, err = Decompress("br", []byte("data"))
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+
+	_, err = NewCompressingProducer(&MockProducer{}, "br", 0)
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+}
+
+func TestDecompress_Corrupt(t *testing.T) {
+	for _, encoding := range []string{EncodingGzip, EncodingZstd, EncodingSnappy} {
+		_, err := Decompress(encoding, []byte("not compressed"))
+		assert.Error(t, err, encoding)
+	}
+}
+
+func TestCompressingProducer_Publish(t *testing.T) {
+	ctx := context.Background()
+	large := bytes.Repeat([]byte("a"), 256)
+	producer := new(MockProducer)
+	compressing, err := NewCompressingProducer(producer, EncodingZstd, 128)
+	require.NoError(t, err)
+
+	opts := &MessageOptions{DeliveryMode: 2, ContentType: ContentTypeJSON}
+	producer.On("Publish", ctx, mock.MatchedBy(func(msg Message) bool {
+		decompressed, err := Decompress(EncodingZstd, msg.Value)
+		return err == nil && bytes.Equal(decompressed, large)
+	}), &MessageOptions{DeliveryMode: 2, ContentType: ContentTypeJSON, ContentEncoding: EncodingZstd}).Return(nil).Once()
+
+	assert.NoError(t, compressing.Publish(ctx, Message{Value: large}, opts))
+	assert.Empty(t, opts.ContentEncoding, "caller options must not be modified")
+
+	// Small payloads and payloads that already carry an encoding are published untouched
+	producer.On("Publish", ctx, Message{Value: []byte("small")}, opts).Return(nil).Once()
+	assert.NoError(t, compressing.Publish(ctx, Message{Value: []byte("small")}, opts))
+
+	encoded := &MessageOptions{ContentEncoding: EncodingGzip}
+	producer.On("Publish", ctx, Message{Value: large}, encoded).Return(nil).Once()
+	assert.NoError(t, compressing.Publish(ctx, Message{Value: large}, encoded))
+
+	producer.AssertExpectations(t)
+}
+
+func TestDeliver_Decompresses(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"user":"alice"}`)
+	compressed, err := Compress(EncodingSnappy, payload)
+	require.NoError(t, err)
+
+	handler := new(MockHandler)
+	handler.On("Handle", ctx, Message{Value: payload}).Return(nil).Once()
+
+	err = deliver(ctx, handler, Message{Value: compressed, Options: MessageOptions{ContentEncoding: EncodingSnappy}}, &ConsumerOptions{})
+	assert.NoError(t, err)
+	handler.AssertExpectations(t)
+
+	err = deliver(ctx, handler, Message{Value: []byte("garbage"), Options: MessageOptions{ContentEncoding: EncodingGzip}}, &ConsumerOptions{})
+	assert.Error(t, err)
+	handler.AssertExpectations(t)
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"phonon/pkg/instrumentation"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDedupTTL             = 7 * 24 * time.Hour
+	defaultDedupCleanupInterval = time.Hour
+)
+
+// Ledger records the IDs of processed messages, repository.Database implements it
+type Ledger interface {
+	IsMessageProcessed(ctx context.Context, messageID string) (bool, error)
+	MarkMessageProcessed(ctx context.Context, messageID string, ttl time.Duration) error
+	PurgeProcessedMessages(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Deduplicator decorates a Handler so a message ID is processed at most once within the ledger TTL.
+// Redelivered messages are acknowledged without calling the handler. Messages without an ID are always handled.
+type Deduplicator struct {
+	handler Handler
+	ledger  Ledger
+	ttl     time.Duration
+}
+
+// NewDeduplicator creates a Deduplicator remembering processed messages for ttl, defaulting to 7 days
+func NewDeduplicator(handler Handler, ledger Ledger, ttl time.Duration) *Deduplicator {
+	if ttl <= 0 {
+		ttl = defaultDedupTTL
+	}
+
+	return &Deduplicator{handler: handler, ledger: ledger, ttl: ttl}
+}
+
+// Handle implements the Handler interface.
+// A failing ledger does not block processing, the handlers are expected to tolerate the rare duplicate.
+func (d *Deduplicator) Handle(ctx context.Context, msg Message) error {
+	if msg.ID == "" {
+		return d.handler.Handle(ctx, msg)
+	}
+
+	logger := logrus.WithContext(ctx).WithField("message_id", msg.ID)
+
+	processed, err := d.ledger.IsMessageProcessed(ctx, msg.ID)
+	if err != nil {
+		logger.Warnf("failed to look up processed message: %v", err)
+	}
+	if processed {
+		instrumentation.IncrementCounter(metricsNamespace, "messages_duplicate")
+		logger.Info("acknowledging duplicate message")
+		return nil
+	}
+
+	if err = d.handler.Handle(ctx, msg); err != nil {
+		return err
+	}
+
+	if err = d.ledger.MarkMessageProcessed(ctx, msg.ID, d.ttl); err != nil {
+		logger.Warnf("failed to record processed message: %v", err)
+	}
+
+	return nil
+}
+
+// RunCleanup purges expired ledger entries every interval, defaulting to an hour, until ctx is done
+func (d *Deduplicator) RunCleanup(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultDedupCleanupInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			purged, err := d.ledger.PurgeProcessedMessages(ctx, now)
+			if err != nil {
+				logrus.WithContext(ctx).Errorf("failed to purge processed messages: %v", err)
+				continue
+			}
+			instrumentation.AddCounter(metricsNamespace, "processed_messages_purged", purged)
+		}
+	}
+}
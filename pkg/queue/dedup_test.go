@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"phonon/pkg/instrumentation"
+	"phonon/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDeduplicator_Handle(t *testing.T) {
+	ctx := context.Background()
+	msg := Message{ID: "msg-1", Value: []byte("payload")}
+
+	t.Run("handles and records new messages", func(t *testing.T) {
+		ledger := new(repository.MockDatabase)
+		handler := new(MockHandler)
+		dedup := NewDeduplicator(handler, ledger, time.Hour)
+
+		ledger.On("IsMessageProcessed", ctx, "msg-1").Return(false, nil)
+		handler.On("Handle", ctx, msg).Return(nil)
+		ledger.On("MarkMessageProcessed", ctx, "msg-1", time.Hour).Return(nil)
+
+		assert.NoError(t, dedup.Handle(ctx, msg))
+		ledger.AssertExpectations(t)
+		handler.AssertExpectations(t)
+	})
+
+	t.Run("acknowledges duplicates", func(t *testing.T) {
+		ledger := new(repository.MockDatabase)
+		handler := new(MockHandler)
+		dedup := NewDeduplicator(handler, ledger, time.Hour)
+
+		ledger.On("IsMessageProcessed", ctx, "msg-1").Return(true, nil)
+
+		duplicates := instrumentation.MetricValue(metricsNamespace, "messages_duplicate")
+		assert.NoError(t, dedup.Handle(ctx, msg))
+		assert.Equal(t, duplicates+1, instrumentation.MetricValue(metricsNamespace, "messages_duplicate"))
+		handler.AssertNotCalled(t, "Handle", mock.Anything, mock.Anything)
+	})
+
+	t.Run("failed messages are not recorded", func(t *testing.T) {
+		ledger := new(repository.MockDatabase)
+		handler := new(MockHandler)
+		dedup := NewDeduplicator(handler, ledger, time.Hour)
+
+		ledger.On("IsMessageProcessed", ctx, "msg-1").Return(false, nil)
+		handler.On("Handle", ctx, msg).Return(errors.New("boom"))
+
+		assert.Error(t, dedup.Handle(ctx, msg))
+		ledger.AssertNotCalled(t, "MarkMessageProcessed", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("ledger failures do not block processing", func(t *testing.T) {
+		ledger := new(repository.MockDatabase)
+		handler := new(MockHandler)
+		dedup := NewDeduplicator(handler, ledger, time.Hour)
+
+		ledger.On("IsMessageProcessed", ctx, "msg-1").Return(false, errors.New("database down"))
+		handler.On("Handle", ctx, msg).Return(nil)
+		ledger.On("MarkMessageProcessed", ctx, "msg-1", time.Hour).Return(errors.New("database down"))
+
+		assert.NoError(t, dedup.Handle(ctx, msg))
+		handler.AssertExpectations(t)
+	})
+
+	t.Run("messages without ID bypass the ledger", func(t *testing.T) {
+		ledger := new(repository.MockDatabase)
+		handler := new(MockHandler)
+		dedup := NewDeduplicator(handler, ledger, time.Hour)
+
+		handler.On("Handle", ctx, Message{Value: []byte("payload")}).Return(nil)
+
+		assert.NoError(t, dedup.Handle(ctx, Message{Value: []byte("payload")}))
+		handler.AssertExpectations(t)
+		ledger.AssertNotCalled(t, "IsMessageProcessed", mock.Anything, mock.Anything)
+	})
+}
+
+func TestDeduplicator_RunCleanup(t *testing.T) {
+	ledger := new(repository.MockDatabase)
+	dedup := NewDeduplicator(new(MockHandler), ledger, time.Hour)
+
+	purged := make(chan struct{})
+	ledger.On("PurgeProcessedMessages", mock.Anything, mock.Anything).Return(int64(2), nil).Once().Run(func(mock.Arguments) { close(purged) })
+	ledger.On("PurgeProcessedMessages", mock.Anything, mock.Anything).Return(int64(0), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		dedup.RunCleanup(ctx, 10*time.Millisecond)
+		close(stopped)
+	}()
+
+	select {
+	case <-purged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expired entries were not purged")
+	}
+	cancel()
+	<-stopped
+}
@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"phonon/pkg/instrumentation"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	metricsNamespace = "queue"
+
+	// HeaderDeadLetterReason records why a message was moved to the dead letter producer
+	HeaderDeadLetterReason = "dead-letter-reason"
+	// HeaderRetryTopic records the topic a message parked on the retry producer is redelivered to once it is due
+	HeaderRetryTopic = "retry-topic"
+	// HeaderOriginalMessageID records the ID of the message a dead-lettered or parked copy was made from
+	HeaderOriginalMessageID = "original-message-id"
+
+	deadLetterReasonExpired = "expired"
+)
+
+// deliver hands a consumed message to the handler once it is due, and drops or dead-letters it when it expired.
+// Messages not due yet are parked on the retry producer of backends that cannot reschedule them, and are
+// waited for here when the consumer has none.
+// Compressed payloads are decompressed before they reach the handler.
+func deliver(ctx context.Context, handler Handler, msg Message, opts *ConsumerOptions) error {
+	if msg.Expired(time.Now()) {
+		return expire(ctx, msg, opts)
+	}
+
+	if delay := msg.Delay(time.Now()); delay > 0 {
+		if opts != nil && opts.Retry != nil {
+			return park(ctx, opts.Retry, msg)
+		}
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if msg.Expired(time.Now()) {
+			return expire(ctx, msg, opts)
+		}
+	}
+
+	decompressed, err := decompressMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	return handler.Handle(ctx, decompressed)
+}
+
+// keepAlive calls extend every interval until the returned function is called, so the lease or ack deadline of a
+// message does not run out while it waits for a slot of the handler or is handled
+func keepAlive(ctx context.Context, interval time.Duration, extend func(ctx context.Context) error) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := extend(ctx); err != nil {
+					logrus.WithContext(ctx).Warnf("failed to extend message lease: %v", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// expire counts an expired message and forwards it to the dead letter producer if one is configured
+func expire(ctx context.Context, msg Message, opts *ConsumerOptions) error {
+	instrumentation.IncrementCounter(metricsNamespace, "messages_expired")
+
+	if opts == nil || opts.DeadLetter == nil {
+		logrus.WithContext(ctx).WithField("message_id", msg.ID).Warn("dropping expired message")
+		return nil
+	}
+
+	return deadLetter(ctx, opts.DeadLetter, msg, deadLetterReasonExpired)
+}
+
+// park republishes a message that is not due yet to the retry producer, keeping its key, headers and options,
+// with the topic it was consumed from attached
+func park(ctx context.Context, producer Producer, msg Message) error {
+	headers := copyHeaders(msg)
+	headers[HeaderRetryTopic] = msg.Topic
+
+	parked := Message{
+		Value:     msg.Value,
+		ID:        NewMessageID(),
+		Key:       msg.Key,
+		Timestamp: msg.Timestamp,
+		Headers:   headers,
+	}
+	opts := msg.Options
+
+	instrumentation.IncrementCounter(metricsNamespace, "messages_parked")
+
+	return producer.Publish(ctx, parked, &opts)
+}
+
+// unpark restores a message parked by park, addressed to the topic it was consumed from, and the options to
+// publish it with. It keeps the ID of the parked copy, so a copy relayed twice is deduplicated.
+func unpark(parked Message) (Message, MessageOptions) {
+	headers := make(map[string]string, len(parked.Headers))
+	for key, value := range parked.Headers {
+		if key != HeaderRetryTopic {
+			headers[key] = value
+		}
+	}
+
+	msg := Message{
+		Value:     parked.Value,
+		ID:        parked.ID,
+		Key:       parked.Key,
+		Topic:     parked.Headers[HeaderRetryTopic],
+		Timestamp: parked.Timestamp,
+		Headers:   headers,
+		Options:   parked.Options,
+	}
+
+	return msg, parked.Options
+}
+
+// deadLetter republishes the message as consumed, keeping its key and headers, with the reason attached
+func deadLetter(ctx context.Context, producer Producer, msg Message, reason string) error {
+	headers := copyHeaders(msg)
+	headers[HeaderDeadLetterReason] = reason
+
+	// the expiration no longer applies once the message sits in the dead letter queue
+	opts := msg.Options
+	opts.Expiration = ""
+
+	dead := Message{
+		Value:     msg.Value,
+		ID:        NewMessageID(),
+		Key:       msg.Key,
+		Timestamp: msg.Timestamp,
+		Headers:   headers,
+	}
+
+	instrumentation.IncrementCounter(metricsNamespace, "messages_dead_lettered")
+
+	return producer.Publish(ctx, dead, &opts)
+}
+
+// copyHeaders copies the headers of a message republished as a copy, which gets an ID of its own: backends
+// deduplicating by ID, such as JetStream within its duplicate window, would drop a copy sharing the ID of the
+// message. The ID of the first message is kept in HeaderOriginalMessageID.
+func copyHeaders(msg Message) map[string]string {
+	headers := make(map[string]string, len(msg.Headers)+2)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	if _, ok := headers[HeaderOriginalMessageID]; !ok {
+		headers[HeaderOriginalMessageID] = msg.ID
+	}
+
+	return headers
+}
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"phonon/pkg/instrumentation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockHandler is a mock implementation of the Handler interface
+type MockHandler struct {
+	mock.Mock
+}
+
+func (m *MockHandler) Handle(ctx context.Context, msg Message) error {
+	args := m.Called(ctx, msg)
+	return args.Error(0)
+}
+
+func TestMessageOptions_TTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiration string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{name: "empty never expires", expiration: "", want: 0},
+		{name: "milliseconds", expiration: "3600", want: 3600 * time.Millisecond},
+		{name: "duration", expiration: "10m", want: 10 * time.Minute},
+		{name: "negative", expiration: "-5", wantErr: true},
+		{name: "invalid", expiration: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, err := MessageOptions{Expiration: tt.expiration}.TTL()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, ttl)
+		})
+	}
+}
+
+func TestMessage_ExpiredAndDelay(t *testing.T) {
+	now := time.Now()
+
+	msg := Message{Timestamp: now.Add(-time.Minute), Options: MessageOptions{Expiration: "30s"}}
+	assert.True(t, msg.Expired(now))
+
+	msg.Options.Expiration = "2m"
+	assert.False(t, msg.Expired(now))
+
+	msg = Message{Options: MessageOptions{Expiration: "1s"}}
+	assert.False(t, msg.Expired(now), "messages without timestamp never expire")
+
+	msg = Message{Options: MessageOptions{NotBefore: now.Add(time.Minute)}}
+	assert.Equal(t, time.Minute, msg.Delay(now))
+
+	msg.Options.NotBefore = now.Add(-time.Minute)
+	assert.Zero(t, msg.Delay(now))
+}
+
+func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("delivers due messages", func(t *testing.T) {
+		handler := new(MockHandler)
+		msg := Message{ID: "due", Timestamp: time.Now(), Options: MessageOptions{Expiration: "1m"}}
+		handler.On("Handle", ctx, msg).Return(nil)
+
+		assert.NoError(t, deliver(ctx, handler, msg, nil))
+		handler.AssertExpectations(t)
+	})
+
+	t.Run("drops expired messages", func(t *testing.T) {
+		handler := new(MockHandler)
+		before := instrumentation.MetricValue(metricsNamespace, "messages_expired")
+		msg := Message{ID: "expired", Timestamp: time.Now().Add(-time.Hour), Options: MessageOptions{Expiration: "1m"}}
+
+		assert.NoError(t, deliver(ctx, handler, msg, nil))
+		handler.AssertNotCalled(t, "Handle", mock.Anything, mock.Anything)
+		assert.Equal(t, before+1, instrumentation.MetricValue(metricsNamespace, "messages_expired"))
+	})
+
+	t.Run("dead-letters expired messages", func(t *testing.T) {
+		handler := new(MockHandler)
+		deadLetters := new(MockProducer)
+		msg := Message{
+			ID:        "expired",
+			Value:     []byte("payload"),
+			Timestamp: time.Now().Add(-time.Hour),
+			Headers:   map[string]string{HeaderExpiration: "1m"},
+			Options:   MessageOptions{Expiration: "1m", ContentType: "application/json"},
+		}
+
+		deadLetters.On("Publish", ctx, mock.MatchedBy(func(dead Message) bool {
+			return dead.ID != msg.ID && dead.Headers[HeaderOriginalMessageID] == msg.ID &&
+				dead.Headers[HeaderDeadLetterReason] == deadLetterReasonExpired
+		}), &MessageOptions{ContentType: "application/json"}).Return(nil)
+
+		assert.NoError(t, deliver(ctx, handler, msg, &ConsumerOptions{DeadLetter: deadLetters}))
+		handler.AssertNotCalled(t, "Handle", mock.Anything, mock.Anything)
+		deadLetters.AssertExpectations(t)
+	})
+
+	t.Run("waits for delayed messages", func(t *testing.T) {
+		handler := new(MockHandler)
+		msg := Message{ID: "delayed", Options: MessageOptions{NotBefore: time.Now().Add(50 * time.Millisecond)}}
+		handler.On("Handle", ctx, msg).Return(nil)
+
+		start := time.Now()
+		assert.NoError(t, deliver(ctx, handler, msg, nil))
+		assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+		handler.AssertExpectations(t)
+	})
+
+	t.Run("parks delayed messages on the retry producer", func(t *testing.T) {
+		handler := new(MockHandler)
+		retries := new(recordingProducer)
+		notBefore := time.Now().Add(time.Hour)
+		msg := Message{
+			ID:      "delayed",
+			Key:     []byte("1"),
+			Topic:   "audio_conversion",
+			Headers: map[string]string{"type": "audio_conversion"},
+			Options: MessageOptions{NotBefore: notBefore, ContentEncoding: EncodingGzip},
+		}
+
+		start := time.Now()
+		assert.NoError(t, deliver(ctx, handler, msg, &ConsumerOptions{Retry: retries}))
+		assert.Less(t, time.Since(start), time.Second, "the consumer does not wait for the message")
+		handler.AssertNotCalled(t, "Handle", mock.Anything, mock.Anything)
+
+		if assert.Len(t, retries.published, 1) {
+			parked := retries.published[0]
+			assert.NotEqual(t, "delayed", parked.ID, "the copy is not deduplicated against the message")
+			assert.Equal(t, msg.Key, parked.Key)
+			assert.Empty(t, parked.Topic, "parked messages go to the retry topic")
+			assert.Equal(t, map[string]string{
+				"type":                  "audio_conversion",
+				HeaderRetryTopic:        "audio_conversion",
+				HeaderOriginalMessageID: "delayed",
+			}, parked.Headers)
+			assert.Equal(t, msg.Options, parked.Options, "the payload is parked as consumed")
+		}
+	})
+
+	t.Run("stops waiting on cancellation", func(t *testing.T) {
+		handler := new(MockHandler)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		msg := Message{ID: "delayed", Options: MessageOptions{NotBefore: time.Now().Add(time.Hour)}}
+		assert.ErrorIs(t, deliver(cancelled, handler, msg, nil), context.Canceled)
+		handler.AssertNotCalled(t, "Handle", mock.Anything, mock.Anything)
+	})
+}
+
+func TestKeepAlive(t *testing.T) {
+	var extended atomic.Int32
+	stop := keepAlive(context.Background(), 5*time.Millisecond, func(context.Context) error {
+		extended.Add(1)
+		return nil
+	})
+
+	assert.Eventually(t, func() bool { return extended.Load() >= 2 }, time.Second, time.Millisecond)
+	stop()
+
+	stopped := extended.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, extended.Load(), "leases are no longer extended once stopped")
+}
@@ -0,0 +1,191 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"phonon/pkg/model"
+)
+
+var (
+	ErrUnknownSchema  = errors.New("unknown message schema")
+	ErrUnknownVersion = errors.New("unknown message version")
+)
+
+// Envelope wraps a payload with the type and schema version it was produced with
+type Envelope struct {
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	ProducedAt time.Time       `json:"produced_at"`
+	Producer   string          `json:"producer"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Upcaster converts a payload of one schema version into the payload of the next version
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+// Schema describes the envelope type and current version of a payload Go type
+type Schema struct {
+	Type      string
+	Version   int
+	Upcasters map[int]Upcaster // Keyed by the version they upcast from
+}
+
+// EnvelopeCodec implements Codec for JSON payloads wrapped in a versioned Envelope.
+// Unmarshal upcasts older versions to the current version of the schema, and treats
+// payloads published before envelopes existed as version 1.
+//
+// Workers must be upgraded before producers when a version is bumped: consumers reject
+// versions newer than the schema they know, so the message is retried until they catch up.
+type EnvelopeCodec struct {
+	producer string
+	schemas  map[reflect.Type]Schema
+}
+
+// NewEnvelopeCodec creates an EnvelopeCodec recording producer as the origin of the envelopes it marshals
+func NewEnvelopeCodec(producer string) *EnvelopeCodec {
+	return &EnvelopeCodec{producer: producer, schemas: make(map[reflect.Type]Schema)}
+}
+
+// DefaultEnvelopeCodec returns an EnvelopeCodec for the model messages, named after the running binary
+func DefaultEnvelopeCodec() *EnvelopeCodec {
+	codec := NewEnvelopeCodec(filepath.Base(os.Args[0]))
+	codec.Register(model.AudioConversionMessage{}, Schema{
+		Type:    model.AudioConversionMessageType,
+		Version: model.AudioConversionMessageVersion,
+		Upcasters: map[int]Upcaster{
+			1: upcastEnveloped,
+			2: upcastAudioConversionV2,
+		},
+	})
+	codec.Register(model.CleanupMessage{}, Schema{
+		Type:    model.CleanupMessageType,
+		Version: model.CleanupMessageVersion,
+	})
+	codec.Register(model.AudioConversionReply{}, Schema{
+		Type:    model.AudioConversionReplyMessageType,
+		Version: model.AudioConversionReplyMessageVersion,
+	})
+
+	return codec
+}
+
+// upcastEnveloped upcasts a payload published as bare JSON to the first enveloped version, which kept its shape
+func upcastEnveloped(payload json.RawMessage) (json.RawMessage, error) {
+	return payload, nil
+}
+
+// upcastAudioConversionV2 adds the attempt number introduced in version 3, version 2 jobs were never republished
+func upcastAudioConversionV2(payload json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+
+	if _, ok := fields["attempt"]; !ok {
+		fields["attempt"] = json.RawMessage("1")
+	}
+
+	return json.Marshal(fields)
+}
+
+// Register associates the Go type of value with a schema
+func (c *EnvelopeCodec) Register(value any, schema Schema) {
+	c.schemas[indirectType(reflect.TypeOf(value))] = schema
+}
+
+func (c *EnvelopeCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (c *EnvelopeCodec) Marshal(v any) ([]byte, error) {
+	schema, ok := c.schemas[indirectType(reflect.TypeOf(v))]
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnknownSchema, v)
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Envelope{
+		Type:       schema.Type,
+		Version:    schema.Version,
+		ProducedAt: time.Now().UTC(),
+		Producer:   c.producer,
+		Payload:    payload,
+	})
+}
+
+func (c *EnvelopeCodec) Unmarshal(data []byte, v any) error {
+	schema, ok := c.schemas[indirectType(reflect.TypeOf(v))]
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUnknownSchema, v)
+	}
+
+	envelope, err := c.Open(data, schema.Type)
+	if err != nil {
+		return err
+	}
+
+	if envelope.Type != schema.Type {
+		return fmt.Errorf("%w: expected %s, got %s", ErrUnknownSchema, schema.Type, envelope.Type)
+	}
+
+	payload, err := upcast(schema, envelope.Version, envelope.Payload)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(payload, v)
+}
+
+// Open parses an envelope without decoding its payload. Data without an envelope is
+// returned as version 1 of legacyType with the data as payload.
+func (c *EnvelopeCodec) Open(data []byte, legacyType string) (Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return Envelope{}, err
+	}
+
+	if envelope.Type == "" || envelope.Version == 0 || envelope.Payload == nil {
+		return Envelope{Type: legacyType, Version: 1, Payload: data}, nil
+	}
+
+	return envelope, nil
+}
+
+// upcast applies the upcasters of the schema until the payload reaches the current version
+func upcast(schema Schema, version int, payload json.RawMessage) (json.RawMessage, error) {
+	if version > schema.Version {
+		return nil, fmt.Errorf("%w: %s version %d is newer than %d", ErrUnknownVersion, schema.Type, version, schema.Version)
+	}
+
+	for ; version < schema.Version; version++ {
+		upcaster, ok := schema.Upcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no upcaster for %s version %d", ErrUnknownVersion, schema.Type, version)
+		}
+
+		var err error
+		if payload, err = upcaster(payload); err != nil {
+			return nil, fmt.Errorf("failed to upcast %s version %d: %w", schema.Type, version, err)
+		}
+	}
+
+	return payload, nil
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
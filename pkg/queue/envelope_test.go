@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"phonon/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEnvelopeCodec_Golden decodes a golden message of every released version of every message type.
+// Add a golden file whenever a schema version is released, and never change existing ones.
+func TestEnvelopeCodec_Golden(t *testing.T) {
+	codec := DefaultEnvelopeCodec()
+
+	tests := []struct {
+		golden string
+		decode func(data []byte) (any, error)
+		want   any
+	}{
+		{
+			golden: "audio_conversion_v1.json",
+			decode: decodeInto[model.AudioConversionMessage](codec),
+			want: model.AudioConversionMessage{
+				UserID:   1,
+				PhraseID: 2,
+				InputURI: "./data/user/audio_1_2.m4a",
+				Attempt:  1,
+			},
+		},
+		{
+			golden: "audio_conversion_v2.json",
+			decode: decodeInto[model.AudioConversionMessage](codec),
+			want: model.AudioConversionMessage{
+				UserID:   1,
+				PhraseID: 2,
+				InputURI: "./data/user/audio_1_2.m4a",
+				Attempt:  1,
+			},
+		},
+		{
+			golden: "audio_conversion_v3.json",
+			decode: decodeInto[model.AudioConversionMessage](codec),
+			want: model.AudioConversionMessage{
+				UserID:   1,
+				PhraseID: 2,
+				InputURI: "./data/user/audio_1_2.m4a",
+				Attempt:  2,
+			},
+		},
+		{
+			golden: "cleanup_v1.json",
+			decode: decodeInto[model.CleanupMessage](codec),
+			want:   model.CleanupMessage{URI: "./data/user/audio_1_2.m4a"},
+		},
+		{
+			golden: "audio_conversion_reply_v1.json",
+			decode: decodeInto[model.AudioConversionReply](codec),
+			want: model.AudioConversionReply{
+				UserID:    1,
+				PhraseID:  2,
+				OutputURI: "./data/user/audio_1_2.wav",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "envelope", tt.golden))
+			require.NoError(t, err)
+
+			got, err := tt.decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestEnvelopeCodec_GoldenCurrent checks that the golden messages of the current versions are what the codec encodes,
+// so they record the released format rather than a hand written one
+func TestEnvelopeCodec_GoldenCurrent(t *testing.T) {
+	codec := DefaultEnvelopeCodec()
+
+	tests := []struct {
+		golden string
+		value  any
+	}{
+		{
+			golden: "audio_conversion_v3.json",
+			value:  model.AudioConversionMessage{UserID: 1, PhraseID: 2, InputURI: "./data/user/audio_1_2.m4a", Attempt: 2},
+		},
+		{
+			golden: "audio_conversion_reply_v1.json",
+			value:  model.AudioConversionReply{UserID: 1, PhraseID: 2, OutputURI: "./data/user/audio_1_2.wav"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "envelope", tt.golden))
+			require.NoError(t, err)
+			var golden Envelope
+			require.NoError(t, json.Unmarshal(data, &golden))
+
+			data, err = codec.Marshal(tt.value)
+			require.NoError(t, err)
+			var encoded Envelope
+			require.NoError(t, json.Unmarshal(data, &encoded))
+
+			assert.Equal(t, golden.Type, encoded.Type)
+			assert.Equal(t, golden.Version, encoded.Version)
+			assert.JSONEq(t, string(golden.Payload), string(encoded.Payload))
+		})
+	}
+}
+
+func decodeInto[T any](codec *EnvelopeCodec) func(data []byte) (any, error) {
+	return func(data []byte) (any, error) {
+		var value T
+		err := codec.Unmarshal(data, &value)
+		return value, err
+	}
+}
+
+func TestEnvelopeCodec_RoundTrip(t *testing.T) {
+	codec := NewEnvelopeCodec("phonon")
+	codec.Register(model.AudioConversionMessage{}, Schema{Type: model.AudioConversionMessageType, Version: model.AudioConversionMessageVersion})
+
+	msg := model.AudioConversionMessage{UserID: 1, PhraseID: 2, InputURI: "input", Attempt: 2}
+	data, err := codec.Marshal(&msg)
+	require.NoError(t, err)
+
+	var envelope Envelope
+	require.NoError(t, json.Unmarshal(data, &envelope))
+	assert.Equal(t, model.AudioConversionMessageType, envelope.Type)
+	assert.Equal(t, model.AudioConversionMessageVersion, envelope.Version)
+	assert.Equal(t, "phonon", envelope.Producer)
+	assert.False(t, envelope.ProducedAt.IsZero())
+
+	var decoded model.AudioConversionMessage
+	require.NoError(t, codec.Unmarshal(data, &decoded))
+	assert.Equal(t, msg, decoded)
+}
+
+func TestEnvelopeCodec_Errors(t *testing.T) {
+	codec := DefaultEnvelopeCodec()
+
+	t.Run("unregistered type", func(t *testing.T) {
+		_, err := codec.Marshal(struct{}{})
+		assert.ErrorIs(t, err, ErrUnknownSchema)
+
+		var value struct{}
+		assert.ErrorIs(t, codec.Unmarshal([]byte("{}"), &value), ErrUnknownSchema)
+	})
+
+	t.Run("type mismatch", func(t *testing.T) {
+		data := []byte(`{"type":"cleanup","version":1,"payload":{"uri":"a"}}`)
+		var value model.AudioConversionMessage
+		assert.ErrorIs(t, codec.Unmarshal(data, &value), ErrUnknownSchema)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		data := []byte(`{"type":"cleanup","version":99,"payload":{"uri":"a"}}`)
+		var value model.CleanupMessage
+		assert.ErrorIs(t, codec.Unmarshal(data, &value), ErrUnknownVersion)
+	})
+
+	t.Run("missing upcaster", func(t *testing.T) {
+		legacy := NewEnvelopeCodec("phonon")
+		legacy.Register(model.CleanupMessage{}, Schema{Type: model.CleanupMessageType, Version: 2})
+
+		var value model.CleanupMessage
+		assert.ErrorIs(t, legacy.Unmarshal([]byte(`{"uri":"a"}`), &value), ErrUnknownVersion)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		var value model.CleanupMessage
+		assert.Error(t, codec.Unmarshal([]byte("invalid json"), &value))
+	})
+}
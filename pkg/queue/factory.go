@@ -0,0 +1,398 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"phonon/pkg/repository"
+
+	"github.com/spf13/viper"
+)
+
+// Supported values of mq.driver
+const (
+	DriverKafka = "kafka"
+	DriverSQL   = "sql"
+	DriverNATS  = "nats"
+)
+
+const defaultKafkaProvisionTimeout = 30 * time.Second
+
+var ErrUnsupportedDriver = errors.New("queue driver not supported")
+
+// NewProducer creates the audio conversion producer of the driver configured in mq.driver, defaulting to Kafka.
+// Payloads are compressed when mq.compression.conversion_encoding is set.
+// When mq.breaker.enabled is set, publishes fail fast while the broker is unavailable and are spooled to the
+// outbox of the repository database instead, and the returned relay must run to replay them. The relay is nil otherwise.
+func NewProducer(db repository.Database) (Producer, *OutboxRelay, error) {
+	producer, err := newDriverProducer(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var relay *OutboxRelay
+	if viper.GetBool("mq.breaker.enabled") {
+		if producer, relay, err = withOutbox(db, producer); err != nil {
+			producer.Close()
+			return nil, nil, err
+		}
+	}
+
+	compressing, err := withCompression(producer, viper.GetString("mq.compression.conversion_encoding"))
+	if err != nil {
+		producer.Close()
+		return nil, nil, err
+	}
+
+	return compressing, relay, nil
+}
+
+// NewReplyProducer creates the producer answering conversion requests, through the driver configured in mq.driver.
+// Replies are addressed to the topic of the requester and compressed when mq.compression.reply_encoding is set.
+func NewReplyProducer(db repository.Database) (Producer, error) {
+	producer, err := newDriverProducer(db)
+	if err != nil {
+		return nil, err
+	}
+
+	compressing, err := withCompression(producer, viper.GetString("mq.compression.reply_encoding"))
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+
+	return compressing, nil
+}
+
+// withCompression compresses the payloads producer publishes with encoding, leaving producer as it is
+// when encoding is empty or identity
+func withCompression(producer Producer, encoding string) (Producer, error) {
+	if encoding == "" || encoding == EncodingIdentity {
+		return producer, nil
+	}
+
+	return NewCompressingProducer(producer, encoding, viper.GetInt("mq.compression.min_size"))
+}
+
+// withOutbox puts producer behind a circuit breaker falling back to the outbox, the relay publishes through the same breaker
+func withOutbox(db repository.Database, producer Producer) (Producer, *OutboxRelay, error) {
+	breaker := NewCircuitBreakerProducer(producer, BreakerConfig{
+		FailureThreshold: viper.GetInt("mq.breaker.failure_threshold"),
+		OpenTimeout:      viper.GetDuration("mq.breaker.open_timeout"),
+		PublishTimeout:   viper.GetDuration("mq.breaker.publish_timeout"),
+	})
+
+	topic := viper.GetString("mq.breaker.outbox_topic")
+	outbox, err := NewOutboxProducer(db, topic)
+	if err != nil {
+		return producer, nil, err
+	}
+
+	relay, err := NewOutboxRelay(db, breaker, OutboxRelayConfig{
+		Topic:        topic,
+		Interval:     viper.GetDuration("mq.breaker.relay_interval"),
+		BatchSize:    viper.GetInt("mq.sql.batch_size"),
+		LeaseTimeout: viper.GetDuration("mq.sql.lease_timeout"),
+	})
+	if err != nil {
+		return producer, nil, err
+	}
+
+	return NewFallbackProducer(breaker, outbox), relay, nil
+}
+
+func newDriverProducer(db repository.Database) (Producer, error) {
+	switch viper.GetString("mq.driver") {
+	case "", DriverKafka:
+		// the group lets the producer report the depth of the topic
+		return NewKafkaProducer(kafkaConfig(
+			viper.GetString("mq.kafka.audio_conversion.topic"),
+			viper.GetString("mq.kafka.audio_conversion.group"),
+		))
+	case DriverSQL:
+		return NewSQLProducer(db, sqlConfig(viper.GetString("mq.sql.audio_conversion.topic")))
+	case DriverNATS:
+		return NewJetStreamProducer(jetStreamConfig(viper.GetString("mq.nats.audio_conversion.subject")))
+	default:
+		return nil, ErrUnsupportedDriver
+	}
+}
+
+// NewConsumer creates the audio conversion consumer of the driver configured in mq.driver, defaulting to Kafka
+func NewConsumer(db repository.Database) (Consumer, error) {
+	switch viper.GetString("mq.driver") {
+	case "", DriverKafka:
+		return NewKafkaConsumer(kafkaConfig(
+			viper.GetString("mq.kafka.audio_conversion.topic"),
+			viper.GetString("mq.kafka.audio_conversion.group"),
+		))
+	case DriverSQL:
+		return NewSQLConsumer(db, sqlConfig(viper.GetString("mq.sql.audio_conversion.topic")))
+	case DriverNATS:
+		return NewJetStreamConsumer(jetStreamConfig(viper.GetString("mq.nats.audio_conversion.subject")))
+	default:
+		return nil, ErrUnsupportedDriver
+	}
+}
+
+// NewDeadLetterProducer creates the producer receiving dead-lettered audio conversion jobs, compressing the ones
+// not compressed yet when mq.compression.dead_letter_encoding is set.
+// It returns nil when the configured driver has no dead letter topic.
+func NewDeadLetterProducer(db repository.Database) (Producer, error) {
+	producer, err := newDeadLetterDriverProducer(db)
+	if err != nil || producer == nil {
+		return nil, err
+	}
+
+	compressing, err := withCompression(producer, viper.GetString("mq.compression.dead_letter_encoding"))
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+
+	return compressing, nil
+}
+
+func newDeadLetterDriverProducer(db repository.Database) (Producer, error) {
+	switch viper.GetString("mq.driver") {
+	case "", DriverKafka:
+		topic := viper.GetString("mq.kafka.audio_conversion.dead_letter_topic")
+		if topic == "" {
+			return nil, nil
+		}
+		return NewKafkaProducer(kafkaConfig(topic, ""))
+	case DriverSQL:
+		topic := viper.GetString("mq.sql.audio_conversion.dead_letter_topic")
+		if topic == "" {
+			return nil, nil
+		}
+		return NewSQLProducer(db, sqlConfig(topic))
+	case DriverNATS:
+		subject := viper.GetString("mq.nats.audio_conversion.dead_letter_subject")
+		if subject == "" {
+			return nil, nil
+		}
+		return NewJetStreamProducer(jetStreamConfig(subject))
+	default:
+		return nil, ErrUnsupportedDriver
+	}
+}
+
+// NewRetryProducer creates the producer parking delayed audio conversion jobs on the retry topic, and the relay
+// redelivering them once they are due, which must run alongside the consumer. Both are nil when the configured
+// driver reschedules delayed messages itself or no retry topic is configured.
+func NewRetryProducer() (Producer, RetryRelay, error) {
+	switch viper.GetString("mq.driver") {
+	case "", DriverKafka:
+		return newKafkaRetryProducer()
+	case DriverNATS:
+		return newJetStreamRetryProducer()
+	default:
+		return nil, nil, nil
+	}
+}
+
+func newKafkaRetryProducer() (Producer, RetryRelay, error) {
+	topic := viper.GetString("mq.kafka.audio_conversion.retry_topic")
+	if topic == "" {
+		return nil, nil, nil
+	}
+
+	producer, err := NewKafkaProducer(kafkaConfig(topic, ""))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// parked messages keep their encoding, so they are redelivered without compressing them again
+	redelivery, err := NewKafkaProducer(kafkaConfig(viper.GetString("mq.kafka.audio_conversion.topic"), ""))
+	if err != nil {
+		producer.Close()
+		return nil, nil, err
+	}
+
+	relay, err := NewKafkaRetryRelay(kafkaConfig(topic, topic), redelivery)
+	if err != nil {
+		producer.Close()
+		redelivery.Close()
+		return nil, nil, err
+	}
+
+	return producer, relay, nil
+}
+
+// newJetStreamRetryProducer parks delayed jobs on the retry subject, as negatively acknowledging them until they
+// are due would use up the deliveries of the conversion consumer
+func newJetStreamRetryProducer() (Producer, RetryRelay, error) {
+	subject := viper.GetString("mq.nats.audio_conversion.retry_subject")
+	if subject == "" {
+		return nil, nil, nil
+	}
+
+	producer, err := NewJetStreamProducer(jetStreamConfig(subject))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// parked messages keep their encoding, so they are redelivered without compressing them again
+	redelivery, err := NewJetStreamProducer(jetStreamConfig(viper.GetString("mq.nats.audio_conversion.subject")))
+	if err != nil {
+		producer.Close()
+		return nil, nil, err
+	}
+
+	config := jetStreamConfig(subject)
+	// durable names cannot contain dots
+	config.Durable = strings.ReplaceAll(subject, ".", "_")
+	relay, err := NewJetStreamRetryRelay(config, redelivery)
+	if err != nil {
+		producer.Close()
+		redelivery.Close()
+		return nil, nil, err
+	}
+
+	return producer, relay, nil
+}
+
+// NewReplyConsumer creates the consumer of the reply topic of this instance, named after the configured
+// reply topic and the instance. It returns the topic replies are expected on, and a nil consumer when
+// the configured driver has no reply topic.
+func NewReplyConsumer(db repository.Database, instance string) (Consumer, string, error) {
+	switch viper.GetString("mq.driver") {
+	case "", DriverKafka:
+		topic := viper.GetString("mq.kafka.audio_conversion.reply_topic")
+		if topic == "" {
+			return nil, "", nil
+		}
+		topic = topic + "." + instance
+		if err := provisionKafkaTopics(KafkaTopicConfig{
+			Name:      topic,
+			Retention: viper.GetDuration("mq.kafka.audio_conversion.reply_retention"),
+		}); err != nil {
+			return nil, "", err
+		}
+		consumer, err := NewKafkaConsumer(kafkaConfig(topic, topic))
+		return consumer, topic, err
+	case DriverSQL:
+		topic := viper.GetString("mq.sql.audio_conversion.reply_topic")
+		if topic == "" {
+			return nil, "", nil
+		}
+		topic = topic + "." + instance
+		consumer, err := NewSQLConsumer(db, sqlConfig(topic))
+		return consumer, topic, err
+	case DriverNATS:
+		subject := viper.GetString("mq.nats.audio_conversion.reply_subject")
+		if subject == "" {
+			return nil, "", nil
+		}
+		subject = subject + "." + instance
+		config := jetStreamConfig(subject)
+		// durable names cannot contain dots
+		config.Durable = strings.ReplaceAll(subject, ".", "_")
+		consumer, err := NewJetStreamConsumer(config)
+		return consumer, subject, err
+	default:
+		return nil, "", ErrUnsupportedDriver
+	}
+}
+
+// ProvisionTopics creates the audio conversion, retry and dead letter topics when mq.kafka.provision.enabled is set
+// and the driver is Kafka. The reply topic of an instance is provisioned by NewReplyConsumer.
+func ProvisionTopics() error {
+	if driver := viper.GetString("mq.driver"); driver != "" && driver != DriverKafka {
+		return nil
+	}
+
+	return provisionKafkaTopics(audioConversionTopics()...)
+}
+
+// audioConversionTopics describes the configured audio conversion topics. The retry topic is partitioned like the
+// conversion topic and keeps parked messages as long as it does, so they are not deleted before they are due.
+func audioConversionTopics() []KafkaTopicConfig {
+	partitions := viper.GetInt("mq.kafka.audio_conversion.partitions")
+	retention := viper.GetDuration("mq.kafka.audio_conversion.retention")
+
+	return []KafkaTopicConfig{
+		{
+			Name:       viper.GetString("mq.kafka.audio_conversion.topic"),
+			Partitions: partitions,
+			Retention:  retention,
+		},
+		{
+			Name:       viper.GetString("mq.kafka.audio_conversion.retry_topic"),
+			Partitions: partitions,
+			Retention:  retention,
+		},
+		{
+			Name:       viper.GetString("mq.kafka.audio_conversion.dead_letter_topic"),
+			Partitions: partitions,
+			Retention:  viper.GetDuration("mq.kafka.audio_conversion.dead_letter_retention"),
+		},
+	}
+}
+
+func provisionKafkaTopics(topics ...KafkaTopicConfig) error {
+	if !viper.GetBool("mq.kafka.provision.enabled") {
+		return nil
+	}
+
+	for i := range topics {
+		topics[i].ReplicationFactor = viper.GetInt("mq.kafka.provision.replication_factor")
+	}
+
+	timeout := viper.GetDuration("mq.kafka.provision.timeout")
+	if timeout <= 0 {
+		timeout = defaultKafkaProvisionTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return ProvisionKafkaTopics(ctx, kafkaConfig("", ""), topics)
+}
+
+func kafkaConfig(topic, groupID string) KafkaConfig {
+	return KafkaConfig{
+		Brokers:     viper.GetStringSlice("mq.kafka.brokers"),
+		Topic:       topic,
+		GroupID:     groupID,
+		ClientID:    viper.GetString("mq.kafka.client_id"),
+		Compression: viper.GetString("mq.kafka.compression"),
+		TLS: KafkaTLSConfig{
+			Enabled:            viper.GetBool("mq.kafka.tls.enabled"),
+			CAFile:             viper.GetString("mq.kafka.tls.ca_file"),
+			CertFile:           viper.GetString("mq.kafka.tls.cert_file"),
+			KeyFile:            viper.GetString("mq.kafka.tls.key_file"),
+			InsecureSkipVerify: viper.GetBool("mq.kafka.tls.insecure_skip_verify"),
+		},
+		SASL: KafkaSASLConfig{
+			Mechanism: viper.GetString("mq.kafka.sasl.mechanism"),
+			Username:  viper.GetString("mq.kafka.sasl.username"),
+			Password:  viper.GetString("mq.kafka.sasl.password"),
+		},
+	}
+}
+
+func sqlConfig(topic string) SQLConfig {
+	return SQLConfig{
+		Topic:        topic,
+		BatchSize:    viper.GetInt("mq.sql.batch_size"),
+		PollInterval: viper.GetDuration("mq.sql.poll_interval"),
+		LeaseTimeout: viper.GetDuration("mq.sql.lease_timeout"),
+		MaxAttempts:  viper.GetInt("mq.sql.max_attempts"),
+		RetryBackoff: viper.GetDuration("mq.sql.retry_backoff"),
+	}
+}
+
+func jetStreamConfig(subject string) JetStreamConfig {
+	return JetStreamConfig{
+		URL:        viper.GetString("mq.nats.url"),
+		Stream:     viper.GetString("mq.nats.stream"),
+		Subject:    subject,
+		Durable:    viper.GetString("mq.nats.audio_conversion.durable"),
+		AckWait:    viper.GetDuration("mq.nats.ack_wait"),
+		MaxDeliver: viper.GetInt("mq.nats.max_deliver"),
+		RetryDelay: viper.GetDuration("mq.nats.retry_delay"),
+	}
+}
@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"testing"
+
+	"phonon/pkg/repository"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFactory_Compression(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("mq.driver", DriverSQL)
+	viper.Set("mq.sql.audio_conversion.topic", "audio_conversion")
+	viper.Set("mq.sql.audio_conversion.dead_letter_topic", "audio_conversion_dlq")
+	viper.Set("mq.compression.conversion_encoding", EncodingGzip)
+	viper.Set("mq.compression.reply_encoding", "")
+	viper.Set("mq.compression.dead_letter_encoding", EncodingIdentity)
+	db := new(repository.MockDatabase)
+
+	producer, relay, err := NewProducer(db)
+	require.NoError(t, err)
+	assert.Nil(t, relay)
+	assert.IsType(t, &CompressingProducer{}, producer)
+
+	replyProducer, err := NewReplyProducer(db)
+	require.NoError(t, err)
+	assert.IsType(t, &SQLProducer{}, replyProducer, "replies are compressed independently of the conversion jobs")
+
+	deadLetterProducer, err := NewDeadLetterProducer(db)
+	require.NoError(t, err)
+	assert.IsType(t, &SQLProducer{}, deadLetterProducer)
+
+	viper.Set("mq.compression.dead_letter_encoding", "brotli")
+	_, err = NewDeadLetterProducer(db)
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+}
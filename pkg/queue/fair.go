@@ -0,0 +1,211 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"phonon/pkg/instrumentation"
+)
+
+// FairSchedulerConfig holds configuration for the fair scheduler
+type FairSchedulerConfig struct {
+	Concurrency        int // Messages handled at the same time, defaults to 1
+	PerUserConcurrency int // Messages of a single user handled at the same time, defaults to 1
+}
+
+func (c FairSchedulerConfig) withDefaults() FairSchedulerConfig {
+	if c.Concurrency <= 0 {
+		c.Concurrency = 1
+	}
+	if c.PerUserConcurrency <= 0 {
+		c.PerUserConcurrency = 1
+	}
+
+	return c
+}
+
+// FairScheduler decorates a Handler so a single user cannot monopolize it. Messages wait for one of
+// Concurrency slots, and free slots go to the highest MessageOptions.Priority lane first, then round-robin
+// across the users waiting in that lane, skipping users already running PerUserConcurrency messages.
+// Users are told apart by the message key, which KeyByUser sets to the user ID.
+//
+// The scheduler can only choose among messages it sees, so the consumer must hand it more messages
+// than it runs at once through ConsumerOptions.Concurrency.
+type FairScheduler struct {
+	handler Handler
+	config  FairSchedulerConfig
+
+	mu            sync.Mutex
+	running       int
+	runningByUser map[string]int
+	waiting       int
+	lanes         map[uint8]*fairLane
+	priorities    []uint8 // lane priorities, highest first
+}
+
+// fairLane holds the messages waiting with one priority, queued per user
+type fairLane struct {
+	users   []string // users with waiting messages in round-robin order
+	next    int      // index in users of the next user to serve
+	waiting map[string][]*fairWaiter
+}
+
+type fairWaiter struct {
+	user     string
+	priority uint8
+	ready    chan struct{}
+}
+
+// NewFairScheduler creates a FairScheduler
+func NewFairScheduler(handler Handler, config FairSchedulerConfig) *FairScheduler {
+	return &FairScheduler{
+		handler:       handler,
+		config:        config.withDefaults(),
+		runningByUser: make(map[string]int),
+		lanes:         make(map[uint8]*fairLane),
+	}
+}
+
+// Handle implements the Handler interface, waiting for a slot before handing the message to the handler
+func (s *FairScheduler) Handle(ctx context.Context, msg Message) error {
+	waiter := &fairWaiter{user: string(msg.Key), priority: msg.Options.Priority, ready: make(chan struct{})}
+
+	s.mu.Lock()
+	s.enqueue(waiter)
+	s.schedule()
+	s.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+	case <-ctx.Done():
+		s.mu.Lock()
+		granted := !s.dequeue(waiter)
+		s.mu.Unlock()
+		if granted {
+			s.done(waiter)
+		}
+		return ctx.Err()
+	}
+	defer s.done(waiter)
+
+	return s.handler.Handle(ctx, msg)
+}
+
+// done frees the slot of a granted message and hands it to the next one
+func (s *FairScheduler) done(waiter *fairWaiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+	if s.runningByUser[waiter.user]--; s.runningByUser[waiter.user] == 0 {
+		delete(s.runningByUser, waiter.user)
+	}
+	s.schedule()
+}
+
+// enqueue adds a waiting message to the queue of its user in its lane, s.mu must be held
+func (s *FairScheduler) enqueue(waiter *fairWaiter) {
+	lane, ok := s.lanes[waiter.priority]
+	if !ok {
+		lane = &fairLane{waiting: make(map[string][]*fairWaiter)}
+		s.lanes[waiter.priority] = lane
+		s.priorities = append(s.priorities, waiter.priority)
+		slices.SortFunc(s.priorities, func(a, b uint8) int { return int(b) - int(a) })
+	}
+
+	if len(lane.waiting[waiter.user]) == 0 {
+		lane.users = append(lane.users, waiter.user)
+	}
+	lane.waiting[waiter.user] = append(lane.waiting[waiter.user], waiter)
+	s.waiting++
+}
+
+// dequeue removes a message that stopped waiting, it reports false when the message was granted a slot already.
+// s.mu must be held.
+func (s *FairScheduler) dequeue(waiter *fairWaiter) bool {
+	lane := s.lanes[waiter.priority]
+	queue := lane.waiting[waiter.user]
+
+	i := slices.Index(queue, waiter)
+	if i < 0 {
+		return false
+	}
+
+	lane.waiting[waiter.user] = slices.Delete(queue, i, i+1)
+	if len(lane.waiting[waiter.user]) == 0 {
+		lane.remove(slices.Index(lane.users, waiter.user))
+	}
+	s.waiting--
+	s.publish()
+
+	return true
+}
+
+// schedule grants free slots to waiting messages, s.mu must be held
+func (s *FairScheduler) schedule() {
+	for s.running < s.config.Concurrency {
+		waiter := s.pick()
+		if waiter == nil {
+			break
+		}
+
+		s.waiting--
+		s.running++
+		s.runningByUser[waiter.user]++
+		close(waiter.ready)
+	}
+
+	s.publish()
+}
+
+// pick takes the next message to run from the highest priority lane with a user below the per-user concurrency
+func (s *FairScheduler) pick() *fairWaiter {
+	for _, priority := range s.priorities {
+		lane := s.lanes[priority]
+		for i := range lane.users {
+			index := (lane.next + i) % len(lane.users)
+			user := lane.users[index]
+			if s.runningByUser[user] >= s.config.PerUserConcurrency {
+				continue
+			}
+
+			waiter := lane.waiting[user][0]
+			lane.waiting[user] = lane.waiting[user][1:]
+
+			// the user after the served one is next, which moves to index when the served user leaves the order
+			lane.next = index + 1
+			if len(lane.waiting[user]) == 0 {
+				lane.remove(index)
+				lane.next = index
+			}
+			if len(lane.users) > 0 {
+				lane.next %= len(lane.users)
+			}
+
+			return waiter
+		}
+	}
+
+	return nil
+}
+
+// remove drops the user at index from the round-robin order once it has no waiting message
+func (l *fairLane) remove(index int) {
+	delete(l.waiting, l.users[index])
+	l.users = slices.Delete(l.users, index, index+1)
+
+	switch {
+	case len(l.users) == 0:
+		l.next = 0
+	case index < l.next:
+		l.next--
+	case l.next >= len(l.users):
+		l.next = 0
+	}
+}
+
+func (s *FairScheduler) publish() {
+	instrumentation.SetGauge(metricsNamespace, "scheduler_running", int64(s.running))
+	instrumentation.SetGauge(metricsNamespace, "scheduler_waiting", int64(s.waiting))
+}
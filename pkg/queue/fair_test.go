@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orderHandler records the order messages are handled in, blocking the messages listed in block until released
+type orderHandler struct {
+	mu      sync.Mutex
+	handled []string
+	block   map[string]chan struct{}
+}
+
+func (h *orderHandler) Handle(ctx context.Context, msg Message) error {
+	h.mu.Lock()
+	h.handled = append(h.handled, msg.ID)
+	release := h.block[msg.ID]
+	h.mu.Unlock()
+
+	if release != nil {
+		<-release
+	}
+	return nil
+}
+
+func (h *orderHandler) order() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string(nil), h.handled...)
+}
+
+func TestFairScheduler(t *testing.T) {
+	ctx := context.Background()
+
+	message := func(id, user string, priority uint8) Message {
+		return Message{ID: id, Key: []byte(user), Options: MessageOptions{Priority: priority}}
+	}
+
+	// run hands the messages to the scheduler one after the other, each waiting in its own goroutine
+	run := func(t *testing.T, scheduler *FairScheduler, msgs ...Message) *sync.WaitGroup {
+		var wg sync.WaitGroup
+		for _, msg := range msgs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, scheduler.Handle(ctx, msg))
+			}()
+
+			scheduler.mu.Lock()
+			queued := scheduler.waiting + scheduler.running
+			scheduler.mu.Unlock()
+			require.Eventually(t, func() bool {
+				scheduler.mu.Lock()
+				defer scheduler.mu.Unlock()
+				return scheduler.waiting+scheduler.running > queued
+			}, time.Second, time.Millisecond)
+		}
+		return &wg
+	}
+
+	t.Run("round-robin across users", func(t *testing.T) {
+		release := make(chan struct{})
+		handler := &orderHandler{block: map[string]chan struct{}{"blocker": release}}
+		scheduler := NewFairScheduler(handler, FairSchedulerConfig{})
+
+		wg := run(t, scheduler,
+			message("blocker", "0", PriorityNormal),
+			message("a1", "1", PriorityNormal),
+			message("a2", "1", PriorityNormal),
+			message("a3", "1", PriorityNormal),
+			message("b1", "2", PriorityNormal),
+			message("c1", "3", PriorityNormal),
+			message("b2", "2", PriorityNormal),
+		)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, []string{"blocker", "a1", "b1", "c1", "a2", "b2", "a3"}, handler.order())
+	})
+
+	t.Run("higher priority lanes first", func(t *testing.T) {
+		release := make(chan struct{})
+		handler := &orderHandler{block: map[string]chan struct{}{"blocker": release}}
+		scheduler := NewFairScheduler(handler, FairSchedulerConfig{})
+
+		wg := run(t, scheduler,
+			message("blocker", "0", PriorityNormal),
+			message("bulk1", "1", PriorityNormal),
+			message("bulk2", "1", PriorityNormal),
+			message("interactive", "2", PriorityInteractive),
+			message("urgent", "3", 9),
+		)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, []string{"blocker", "urgent", "interactive", "bulk1", "bulk2"}, handler.order())
+	})
+
+	t.Run("per-user concurrency", func(t *testing.T) {
+		releaseA, releaseB := make(chan struct{}), make(chan struct{})
+		handler := &orderHandler{block: map[string]chan struct{}{"a1": releaseA, "b1": releaseB}}
+		scheduler := NewFairScheduler(handler, FairSchedulerConfig{Concurrency: 3, PerUserConcurrency: 1})
+
+		wg := run(t, scheduler,
+			message("a1", "1", PriorityNormal),
+			message("a2", "1", PriorityNormal),
+			message("b1", "2", PriorityNormal),
+		)
+
+		// a2 waits for a1 although a slot is free
+		assert.Equal(t, []string{"a1", "b1"}, handler.order())
+
+		close(releaseA)
+		assert.Eventually(t, func() bool { return len(handler.order()) == 3 }, time.Second, time.Millisecond)
+		assert.Equal(t, "a2", handler.order()[2])
+
+		close(releaseB)
+		wg.Wait()
+	})
+
+	t.Run("messages stop waiting when ctx is done", func(t *testing.T) {
+		release := make(chan struct{})
+		handler := &orderHandler{block: map[string]chan struct{}{"blocker": release}}
+		scheduler := NewFairScheduler(handler, FairSchedulerConfig{})
+
+		wg := run(t, scheduler, message("blocker", "0", PriorityNormal))
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.ErrorIs(t, scheduler.Handle(canceled, message("canceled", "1", PriorityNormal)), context.Canceled)
+
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, []string{"blocker"}, handler.order())
+		assert.Zero(t, scheduler.waiting)
+		assert.Zero(t, scheduler.running)
+		assert.Empty(t, scheduler.runningByUser)
+	})
+}
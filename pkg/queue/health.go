@@ -0,0 +1,238 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"phonon/pkg/instrumentation"
+)
+
+const (
+	defaultHealthWindow          = time.Minute
+	defaultHealthMetricsInterval = 15 * time.Second
+)
+
+// LagReporter is implemented by consumers that can tell how many messages wait to be consumed, keyed by partition
+type LagReporter interface {
+	Lag(ctx context.Context) (map[string]int64, error)
+}
+
+// HealthThresholds flip a Monitor to not ready when exceeded, zero values disable a threshold
+type HealthThresholds struct {
+	MaxLag       int64         // Messages waiting across all partitions
+	MaxErrorRate float64       // Share of handled messages that failed within the window, between 0 and 1
+	MaxSilence   time.Duration // Time since the last successfully handled message while messages are waiting
+}
+
+// HealthReport is a snapshot of the consumer health
+type HealthReport struct {
+	Ready          bool             `json:"ready"`
+	Reasons        []string         `json:"reasons,omitempty"`
+	Lag            map[string]int64 `json:"lag,omitempty"`
+	TotalLag       int64            `json:"total_lag"`
+	InFlight       int64            `json:"in_flight"`
+	ProcessingRate float64          `json:"processing_rate"` // Handled messages per second
+	ErrorRate      float64          `json:"error_rate"`      // Share of handled messages that failed
+	LastSuccess    *time.Time       `json:"last_success,omitempty"`
+}
+
+// Monitor tracks the health of a consumer: its lag, the messages in flight, the processing and error rates
+// and the time of the last success. Rates cover the last complete window, or the current one until a window completed.
+type Monitor struct {
+	lag        LagReporter
+	thresholds HealthThresholds
+	window     time.Duration
+	startedAt  time.Time
+
+	inFlight    atomic.Int64
+	lastSuccess atomic.Int64 // unix milliseconds, zero before the first success
+
+	mu             sync.Mutex
+	windowStart    time.Time
+	handled        int64
+	failed         int64
+	completed      bool
+	processingRate float64
+	errorRate      float64
+}
+
+// NewMonitor creates a Monitor for the consumer. Lag is only reported by consumers implementing LagReporter.
+// A non-positive window defaults to a minute.
+func NewMonitor(consumer Consumer, thresholds HealthThresholds, window time.Duration) *Monitor {
+	if window <= 0 {
+		window = defaultHealthWindow
+	}
+
+	lag, _ := consumer.(LagReporter)
+
+	now := time.Now()
+
+	return &Monitor{
+		lag:         lag,
+		thresholds:  thresholds,
+		window:      window,
+		startedAt:   now,
+		windowStart: now,
+	}
+}
+
+// Wrap returns a Handler recording the outcome of every message handled by handler
+func (m *Monitor) Wrap(handler Handler) Handler {
+	return &monitoredHandler{monitor: m, handler: handler}
+}
+
+type monitoredHandler struct {
+	monitor *Monitor
+	handler Handler
+}
+
+// Handle implements the Handler interface
+func (h *monitoredHandler) Handle(ctx context.Context, msg Message) error {
+	h.monitor.inFlight.Add(1)
+	defer h.monitor.inFlight.Add(-1)
+
+	err := h.handler.Handle(ctx, msg)
+	h.monitor.record(time.Now(), err)
+
+	return err
+}
+
+func (m *Monitor) record(now time.Time, err error) {
+	if err == nil {
+		m.lastSuccess.Store(now.UnixMilli())
+		instrumentation.IncrementCounter(metricsNamespace, "messages_handled")
+	} else {
+		instrumentation.IncrementCounter(metricsNamespace, "messages_failed")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rotate(now)
+	m.handled++
+	if err != nil {
+		m.failed++
+	}
+}
+
+// rotate closes the current window once it lasted longer than the configured window, m.mu must be held
+func (m *Monitor) rotate(now time.Time) {
+	elapsed := now.Sub(m.windowStart)
+	if elapsed < m.window {
+		return
+	}
+
+	m.processingRate, m.errorRate = rates(m.handled, m.failed, elapsed)
+	m.completed = true
+	m.windowStart = now
+	m.handled, m.failed = 0, 0
+}
+
+func rates(handled, failed int64, elapsed time.Duration) (float64, float64) {
+	var processingRate, errorRate float64
+	if elapsed > 0 {
+		processingRate = float64(handled) / elapsed.Seconds()
+	}
+	if handled > 0 {
+		errorRate = float64(failed) / float64(handled)
+	}
+
+	return processingRate, errorRate
+}
+
+// Health reports the consumer health and checks it against the thresholds.
+// A consumer whose lag cannot be determined is reported not ready.
+func (m *Monitor) Health(ctx context.Context) HealthReport {
+	now := time.Now()
+	report := HealthReport{Ready: true, InFlight: m.inFlight.Load()}
+
+	m.mu.Lock()
+	m.rotate(now)
+	if m.completed {
+		report.ProcessingRate, report.ErrorRate = m.processingRate, m.errorRate
+	} else {
+		report.ProcessingRate, report.ErrorRate = rates(m.handled, m.failed, now.Sub(m.windowStart))
+	}
+	m.mu.Unlock()
+
+	if lastSuccess := m.lastSuccess.Load(); lastSuccess > 0 {
+		t := time.UnixMilli(lastSuccess)
+		report.LastSuccess = &t
+	}
+
+	if m.lag != nil {
+		lag, err := m.lag.Lag(ctx)
+		if err != nil {
+			report.fail(fmt.Sprintf("lag unavailable: %v", err))
+		}
+		report.Lag = lag
+		for _, value := range lag {
+			report.TotalLag += value
+		}
+	}
+
+	if m.thresholds.MaxLag > 0 && report.TotalLag > m.thresholds.MaxLag {
+		report.fail(fmt.Sprintf("lag %d exceeds %d", report.TotalLag, m.thresholds.MaxLag))
+	}
+	if m.thresholds.MaxErrorRate > 0 && report.ErrorRate > m.thresholds.MaxErrorRate {
+		report.fail(fmt.Sprintf("error rate %.2f exceeds %.2f", report.ErrorRate, m.thresholds.MaxErrorRate))
+	}
+	if m.thresholds.MaxSilence > 0 && (report.TotalLag > 0 || report.InFlight > 0) {
+		// an idle consumer with nothing to do is healthy, only a consumer falling behind is silent
+		since := m.startedAt
+		if report.LastSuccess != nil {
+			since = *report.LastSuccess
+		}
+		if silence := now.Sub(since); silence > m.thresholds.MaxSilence {
+			report.fail(fmt.Sprintf("no message handled for %s", silence.Round(time.Second)))
+		}
+	}
+
+	return report
+}
+
+func (r *HealthReport) fail(reason string) {
+	r.Ready = false
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// Run publishes the health report as metrics every interval, defaulting to 15 seconds, until ctx is done
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultHealthMetricsInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.publish(m.Health(ctx))
+		}
+	}
+}
+
+func (m *Monitor) publish(report HealthReport) {
+	instrumentation.SetGauge(metricsNamespace, "consumer_lag", report.TotalLag)
+	for partition, lag := range report.Lag {
+		instrumentation.SetGauge(metricsNamespace, "consumer_lag."+partition, lag)
+	}
+	instrumentation.SetGauge(metricsNamespace, "consumer_in_flight", report.InFlight)
+	instrumentation.SetFloatGauge(metricsNamespace, "consumer_processing_rate", report.ProcessingRate)
+	instrumentation.SetFloatGauge(metricsNamespace, "consumer_error_rate", report.ErrorRate)
+	if report.LastSuccess != nil {
+		instrumentation.SetGauge(metricsNamespace, "consumer_last_success", report.LastSuccess.UnixMilli())
+	}
+
+	ready := int64(0)
+	if report.Ready {
+		ready = 1
+	}
+	instrumentation.SetGauge(metricsNamespace, "consumer_ready", ready)
+}
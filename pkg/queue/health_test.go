@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// lagConsumer is a consumer reporting a fixed lag
+type lagConsumer struct {
+	MockConsumer
+	lag map[string]int64
+	err error
+}
+
+func (c *lagConsumer) Lag(ctx context.Context) (map[string]int64, error) {
+	return c.lag, c.err
+}
+
+func TestMonitor_Health(t *testing.T) {
+	ctx := context.Background()
+	failing := errors.New("boom")
+
+	t.Run("ready when within thresholds", func(t *testing.T) {
+		consumer := &lagConsumer{lag: map[string]int64{"0": 2, "1": 3}}
+		monitor := NewMonitor(consumer, HealthThresholds{MaxLag: 10, MaxErrorRate: 0.5, MaxSilence: time.Minute}, time.Hour)
+
+		handler := new(MockHandler)
+		handler.On("Handle", ctx, mock.Anything).Return(nil)
+		require.NoError(t, monitor.Wrap(handler).Handle(ctx, Message{}))
+
+		report := monitor.Health(ctx)
+		assert.True(t, report.Ready, report.Reasons)
+		assert.Equal(t, int64(5), report.TotalLag)
+		assert.Equal(t, map[string]int64{"0": 2, "1": 3}, report.Lag)
+		assert.Zero(t, report.InFlight)
+		assert.Positive(t, report.ProcessingRate)
+		assert.Zero(t, report.ErrorRate)
+		assert.NotNil(t, report.LastSuccess)
+	})
+
+	t.Run("lag threshold", func(t *testing.T) {
+		monitor := NewMonitor(&lagConsumer{lag: map[string]int64{"0": 11}}, HealthThresholds{MaxLag: 10}, time.Hour)
+
+		report := monitor.Health(ctx)
+		assert.False(t, report.Ready)
+		assert.Len(t, report.Reasons, 1)
+	})
+
+	t.Run("error rate threshold", func(t *testing.T) {
+		monitor := NewMonitor(&lagConsumer{}, HealthThresholds{MaxErrorRate: 0.5}, time.Hour)
+
+		handler := new(MockHandler)
+		handler.On("Handle", ctx, Message{ID: "ok"}).Return(nil)
+		handler.On("Handle", ctx, Message{ID: "failing"}).Return(failing)
+		wrapped := monitor.Wrap(handler)
+
+		assert.NoError(t, wrapped.Handle(ctx, Message{ID: "ok"}))
+		assert.ErrorIs(t, wrapped.Handle(ctx, Message{ID: "failing"}), failing)
+		assert.True(t, monitor.Health(ctx).Ready, "half the messages failing is within the threshold")
+
+		assert.ErrorIs(t, wrapped.Handle(ctx, Message{ID: "failing"}), failing)
+		report := monitor.Health(ctx)
+		assert.False(t, report.Ready)
+		assert.InDelta(t, 2.0/3, report.ErrorRate, 0.001)
+	})
+
+	t.Run("silence only matters while messages wait", func(t *testing.T) {
+		idle := NewMonitor(&lagConsumer{lag: map[string]int64{"0": 0}}, HealthThresholds{MaxSilence: time.Millisecond}, time.Hour)
+		behind := NewMonitor(&lagConsumer{lag: map[string]int64{"0": 1}}, HealthThresholds{MaxSilence: time.Millisecond}, time.Hour)
+		time.Sleep(5 * time.Millisecond)
+
+		assert.True(t, idle.Health(ctx).Ready)
+		assert.False(t, behind.Health(ctx).Ready)
+	})
+
+	t.Run("unknown lag", func(t *testing.T) {
+		monitor := NewMonitor(&lagConsumer{err: failing}, HealthThresholds{}, time.Hour)
+		assert.False(t, monitor.Health(ctx).Ready)
+	})
+
+	t.Run("consumers without lag", func(t *testing.T) {
+		monitor := NewMonitor(new(MockConsumer), HealthThresholds{MaxLag: 1}, time.Hour)
+		assert.True(t, monitor.Health(ctx).Ready)
+	})
+
+	t.Run("rates of the last complete window", func(t *testing.T) {
+		monitor := NewMonitor(&lagConsumer{}, HealthThresholds{}, 20*time.Millisecond)
+
+		handler := new(MockHandler)
+		handler.On("Handle", ctx, mock.Anything).Return(failing)
+		assert.Error(t, monitor.Wrap(handler).Handle(ctx, Message{}))
+
+		time.Sleep(25 * time.Millisecond)
+		assert.Equal(t, 1.0, monitor.Health(ctx).ErrorRate)
+
+		time.Sleep(25 * time.Millisecond)
+		assert.Zero(t, monitor.Health(ctx).ErrorRate, "a window without messages has no errors")
+	})
+}
+
+func TestMonitor_InFlight(t *testing.T) {
+	ctx := context.Background()
+	monitor := NewMonitor(new(MockConsumer), HealthThresholds{}, time.Hour)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := new(MockHandler)
+	handler.On("Handle", ctx, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	})
+
+	done := make(chan struct{})
+	go func() {
+		monitor.Wrap(handler).Handle(ctx, Message{})
+		close(done)
+	}()
+
+	<-started
+	assert.Equal(t, int64(1), monitor.Health(ctx).InFlight)
+	close(release)
+	<-done
+	assert.Zero(t, monitor.Health(ctx).InFlight)
+}
@@ -0,0 +1,340 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultJetStreamBatchSize  = 10
+	defaultJetStreamFetchWait  = time.Second
+	defaultJetStreamAckWait    = 5 * time.Minute
+	defaultJetStreamMaxDeliver = 5
+	defaultJetStreamRetryDelay = 10 * time.Second
+
+	// jetStreamHeaderMessageKey carries Message.Key, which has no JetStream equivalent
+	jetStreamHeaderMessageKey = "message-key"
+)
+
+// JetStreamConfig holds configuration for the NATS JetStream connection
+type JetStreamConfig struct {
+	URL        string
+	Stream     string        // Stream storing the subject, created if it does not exist
+	Subject    string        // Subject messages are published to and consumed from
+	Durable    string        // Durable consumer name shared by all workers
+	AckWait    time.Duration // Time the server waits for an ack before redelivering
+	MaxDeliver int           // Deliveries before a failing message is terminated
+	RetryDelay time.Duration // Delay requested when a failed message is negatively acknowledged
+}
+
+func (c JetStreamConfig) withDefaults() JetStreamConfig {
+	if c.AckWait <= 0 {
+		c.AckWait = defaultJetStreamAckWait
+	}
+	if c.MaxDeliver <= 0 {
+		c.MaxDeliver = defaultJetStreamMaxDeliver
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = defaultJetStreamRetryDelay
+	}
+
+	return c
+}
+
+// connectJetStream connects to NATS and makes sure the configured stream captures the subject
+func connectJetStream(config JetStreamConfig) (*nats.Conn, jetstream.JetStream, error) {
+	if config.Stream == "" || config.Subject == "" {
+		return nil, nil, errors.New("jetstream stream and subject are required")
+	}
+
+	conn, err := nats.Connect(config.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := js.Stream(ctx, config.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     config.Stream,
+			Subjects: []string{config.Subject},
+		})
+	} else if err == nil && !containsSubject(stream.CachedInfo().Config.Subjects, config.Subject) {
+		streamConfig := stream.CachedInfo().Config
+		streamConfig.Subjects = append(streamConfig.Subjects, config.Subject)
+		_, err = js.UpdateStream(ctx, streamConfig)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, js, nil
+}
+
+func containsSubject(subjects []string, subject string) bool {
+	for _, s := range subjects {
+		if s == subject {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JetStreamProducer implements the Producer interface for NATS JetStream
+type JetStreamProducer struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	stream  string
+	subject string
+	durable string
+}
+
+// NewJetStreamProducer creates a new JetStream producer
+func NewJetStreamProducer(config JetStreamConfig) (*JetStreamProducer, error) {
+	conn, js, err := connectJetStream(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JetStreamProducer{
+		conn:    conn,
+		js:      js,
+		stream:  config.Stream,
+		subject: config.Subject,
+		durable: config.Durable,
+	}, nil
+}
+
+// Publish implements the Producer interface. The message ID doubles as the JetStream deduplication ID.
+func (p *JetStreamProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	if msg.ID == "" {
+		msg.ID = NewMessageID()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	subject := msg.Topic
+	if subject == "" {
+		subject = p.subject
+	}
+
+	natsMsg := nats.NewMsg(subject)
+	natsMsg.Data = msg.Value
+	for key, value := range encodeHeaders(msg, opts) {
+		natsMsg.Header.Set(key, value)
+	}
+	if len(msg.Key) > 0 {
+		natsMsg.Header.Set(jetStreamHeaderMessageKey, string(msg.Key))
+	}
+
+	_, err := p.js.PublishMsg(ctx, natsMsg, jetstream.WithMsgID(msg.ID))
+	return err
+}
+
+// Depth implements the DepthReporter interface with the stream messages not yet delivered to the durable consumer
+// of the producer subject. It requires the producer to be configured with the Durable name of the consumers.
+func (p *JetStreamProducer) Depth(ctx context.Context) (int64, error) {
+	if p.durable == "" {
+		return 0, ErrDepthUnavailable
+	}
+
+	consumer, err := p.js.Consumer(ctx, p.stream, p.durable)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(info.NumPending), nil
+}
+
+// Close implements the Producer interface
+func (p *JetStreamProducer) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+// JetStreamConsumer implements the Consumer interface with a durable JetStream pull consumer
+type JetStreamConsumer struct {
+	conn     *nats.Conn
+	consumer jetstream.Consumer
+	config   JetStreamConfig
+}
+
+// NewJetStreamConsumer creates a new JetStream consumer, creating or updating the durable consumer
+func NewJetStreamConsumer(config JetStreamConfig) (*JetStreamConsumer, error) {
+	if config.Durable == "" {
+		return nil, errors.New("jetstream durable consumer name is required")
+	}
+	config = config.withDefaults()
+
+	conn, js, err := connectJetStream(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, config.Stream, jetstream.ConsumerConfig{
+		Durable:       config.Durable,
+		FilterSubject: config.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       config.AckWait,
+		MaxDeliver:    config.MaxDeliver,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &JetStreamConsumer{conn: conn, consumer: consumer, config: config}, nil
+}
+
+// Consume implements the Consumer interface
+func (c *JetStreamConsumer) Consume(ctx context.Context, handler Handler, opts *ConsumerOptions) {
+	batchSize := defaultJetStreamBatchSize
+	if opts != nil && opts.BatchSize > 0 {
+		batchSize = opts.BatchSize
+	}
+
+	pool := newWorkerPool(opts.concurrency())
+	defer pool.Wait()
+
+	for {
+		// only fetch what can start right away, ack deadlines are kept alive while messages wait in the handler
+		if !pool.WaitFree(ctx) {
+			return
+		}
+
+		batch, err := c.consumer.Fetch(min(batchSize, pool.Free()), jetstream.FetchMaxWait(defaultJetStreamFetchWait))
+		if err != nil {
+			logrus.WithContext(ctx).Errorf("failed to fetch messages: %v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(defaultJetStreamFetchWait):
+			}
+			continue
+		}
+
+		// fetched messages are handled to the end, even when ctx is done meanwhile
+		handleCtx := context.WithoutCancel(ctx)
+		for m := range batch.Messages() {
+			pool.Go(handleCtx, func() { c.process(handleCtx, handler, m, opts) })
+		}
+
+		if err = batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			logrus.WithContext(ctx).Errorf("failed to fetch messages: %v", err)
+		}
+	}
+}
+
+// Lag implements the LagReporter interface with the number of stream messages not yet delivered to the durable consumer
+func (c *JetStreamConsumer) Lag(ctx context.Context) (map[string]int64, error) {
+	info, err := c.consumer.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int64{c.config.Subject: int64(info.NumPending)}, nil
+}
+
+// process delivers one message and settles it: failed messages are retried after RetryDelay and terminated once
+// they reach MaxDeliver. Delayed messages are parked on the retry producer. Without one, they are negatively
+// acknowledged until they are due, which counts towards MaxDeliver, so they are waited for on their last delivery.
+func (c *JetStreamConsumer) process(ctx context.Context, handler Handler, m jetstream.Msg, opts *ConsumerOptions) {
+	msg := decodeJetStreamMessage(m)
+	logger := logrus.WithContext(ctx).WithField("message_id", msg.ID)
+
+	if delay := msg.Delay(time.Now()); delay > 0 && !msg.Expired(time.Now()) && (opts == nil || opts.Retry == nil) {
+		if metadata, err := m.Metadata(); err != nil || metadata.NumDelivered < uint64(c.config.MaxDeliver) {
+			if err = m.NakWithDelay(delay); err != nil {
+				logger.Errorf("failed to delay message: %v", err)
+			}
+			return
+		}
+	}
+
+	stop := keepAlive(ctx, c.config.AckWait/2, func(context.Context) error { return m.InProgress() })
+	handleErr := deliver(ctx, handler, msg, opts)
+	stop()
+
+	if handleErr == nil {
+		if err := m.Ack(); err != nil {
+			logger.Errorf("failed to ack message: %v", err)
+		}
+		return
+	}
+
+	logger.Errorf("failed to handle message: %v", handleErr)
+
+	metadata, err := m.Metadata()
+	if err != nil || metadata.NumDelivered < uint64(c.config.MaxDeliver) {
+		if err = m.NakWithDelay(c.config.RetryDelay); err != nil {
+			logger.Errorf("failed to nak message: %v", err)
+		}
+		return
+	}
+
+	if opts != nil && opts.DeadLetter != nil {
+		if err = deadLetter(ctx, opts.DeadLetter, msg, handleErr.Error()); err != nil {
+			logger.Errorf("failed to dead-letter message: %v", err)
+		}
+	}
+
+	if err = m.TermWithReason(handleErr.Error()); err != nil {
+		logger.Errorf("failed to terminate message: %v", err)
+	}
+}
+
+// Close implements the Consumer interface
+func (c *JetStreamConsumer) Close() error {
+	c.conn.Close()
+	return nil
+}
+
+// decodeJetStreamMessage converts a JetStream message back into a Message.
+// The stream timestamp is used for messages published without a timestamp header.
+func decodeJetStreamMessage(m jetstream.Msg) Message {
+	headers := make(map[string]string, len(m.Headers()))
+	for key := range m.Headers() {
+		headers[key] = m.Headers().Get(key)
+	}
+
+	var key []byte
+	if value, ok := headers[jetStreamHeaderMessageKey]; ok {
+		key = []byte(value)
+		delete(headers, jetStreamHeaderMessageKey)
+	}
+
+	msg := decodeMessage(m.Data(), key, headers)
+	msg.Topic = m.Subject()
+	if msg.Timestamp.IsZero() {
+		if metadata, err := m.Metadata(); err == nil {
+			msg.Timestamp = metadata.Timestamp
+		}
+	}
+
+	return msg
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"phonon/pkg/instrumentation"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// jetStreamUnparkedSuffix is appended to the ID of a parked copy to derive the ID it is redelivered with
+const jetStreamUnparkedSuffix = "-unparked"
+
+// JetStreamRetryRelay redelivers the messages parked on a retry subject to the subject they were consumed from once
+// they are due. Consumers park delayed messages there through ConsumerOptions.Retry instead of negatively
+// acknowledging them until they are due, which counts towards their MaxDeliver and would leave deferred messages
+// no deliveries to be handled with.
+//
+// The relay reschedules parked messages with a durable consumer without a delivery limit, and acknowledges them
+// only after they were redelivered.
+type JetStreamRetryRelay struct {
+	conn     *nats.Conn
+	consumer jetstream.Consumer
+	producer Producer
+	config   JetStreamConfig
+}
+
+// NewJetStreamRetryRelay creates a JetStreamRetryRelay consuming config.Subject with the durable consumer
+// config.Durable and redelivering through producer, which must not compress the already encoded payloads again
+func NewJetStreamRetryRelay(config JetStreamConfig, producer Producer) (*JetStreamRetryRelay, error) {
+	if config.Durable == "" {
+		return nil, errors.New("jetstream durable consumer name is required")
+	}
+	config = config.withDefaults()
+
+	conn, js, err := connectJetStream(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// parked messages are redelivered to the retry subject until they are due, however long they wait
+	consumer, err := js.CreateOrUpdateConsumer(ctx, config.Stream, jetstream.ConsumerConfig{
+		Durable:       config.Durable,
+		FilterSubject: config.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       config.AckWait,
+		MaxDeliver:    -1,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &JetStreamRetryRelay{conn: conn, consumer: consumer, producer: producer, config: config}, nil
+}
+
+// Run relays parked messages until ctx is done
+func (r *JetStreamRetryRelay) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		batch, err := r.consumer.Fetch(defaultJetStreamBatchSize, jetstream.FetchMaxWait(defaultJetStreamFetchWait))
+		if err != nil {
+			logrus.WithContext(ctx).Errorf("failed to fetch parked messages: %v", err)
+			sleep(ctx, defaultJetStreamFetchWait)
+			continue
+		}
+
+		for m := range batch.Messages() {
+			r.relay(ctx, m)
+		}
+
+		if err = batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			logrus.WithContext(ctx).Errorf("failed to fetch parked messages: %v", err)
+		}
+	}
+}
+
+// relay redelivers a parked message that is due and acknowledges it, and asks for it again once it is due otherwise
+// or when it could not be redelivered
+func (r *JetStreamRetryRelay) relay(ctx context.Context, m jetstream.Msg) {
+	msg, opts := unpark(decodeJetStreamMessage(m))
+	// the parked copy went through the same stream, which would drop a redelivery sharing its ID as a duplicate.
+	// The derived ID still deduplicates a copy relayed twice.
+	msg.ID += jetStreamUnparkedSuffix
+	logger := logrus.WithContext(ctx).WithField("message_id", msg.ID)
+
+	if delay := msg.Delay(time.Now()); delay > 0 {
+		if err := m.NakWithDelay(delay); err != nil {
+			logger.Errorf("failed to delay parked message: %v", err)
+		}
+		return
+	}
+
+	if err := r.producer.Publish(ctx, msg, &opts); err != nil {
+		logger.Warnf("failed to redeliver parked message: %v", err)
+		if err = m.NakWithDelay(r.config.RetryDelay); err != nil {
+			logger.Errorf("failed to nak parked message: %v", err)
+		}
+		return
+	}
+	instrumentation.IncrementCounter(metricsNamespace, "messages_unparked")
+
+	if err := m.Ack(); err != nil {
+		// the message is relayed again, JetStream deduplicates it by the ID derived from the parked copy
+		logger.Errorf("failed to ack parked message: %v", err)
+	}
+}
+
+// Close implements the io.Closer interface, closing the connection and the producer
+func (r *JetStreamRetryRelay) Close() error {
+	r.conn.Close()
+	return r.producer.Close()
+}
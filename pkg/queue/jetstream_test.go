@@ -0,0 +1,242 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startJetStreamServer runs an embedded NATS server with JetStream enabled for the duration of the test
+func startJetStreamServer(t *testing.T) string {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+
+	require.True(t, ns.ReadyForConnections(5*time.Second), "nats server not ready")
+
+	return ns.ClientURL()
+}
+
+func TestJetStream(t *testing.T) {
+	url := startJetStreamServer(t)
+
+	newQueue := func(t *testing.T, subject string) (*JetStreamProducer, *JetStreamConsumer) {
+		config := JetStreamConfig{
+			URL:        url,
+			Stream:     "PHONON",
+			Subject:    subject,
+			Durable:    strings.ReplaceAll(subject, ".", "_"),
+			MaxDeliver: 2,
+			RetryDelay: 10 * time.Millisecond,
+		}
+
+		producer, err := NewJetStreamProducer(config)
+		require.NoError(t, err)
+		t.Cleanup(func() { producer.Close() })
+
+		consumer, err := NewJetStreamConsumer(config)
+		require.NoError(t, err)
+		t.Cleanup(func() { consumer.Close() })
+
+		return producer, consumer
+	}
+
+	consume := func(t *testing.T, consumer *JetStreamConsumer, handler Handler, opts *ConsumerOptions, done func() bool) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			consumer.Consume(ctx, handler, opts)
+			close(stopped)
+		}()
+
+		assert.Eventually(t, done, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		<-stopped
+	}
+
+	t.Run("round trip with headers", func(t *testing.T) {
+		producer, consumer := newQueue(t, "phonon.round_trip")
+
+		err := producer.Publish(context.Background(), Message{
+			Value:   []byte("payload"),
+			ID:      "msg-1",
+			Key:     []byte("42"),
+			Headers: map[string]string{"type": "audio_conversion"},
+		}, &MessageOptions{
+			DeliveryMode:  Persistent,
+			Priority:      4,
+			CorrelationID: "corr-1",
+			ReplyTo:       "replies",
+			ContentType:   "application/json",
+		})
+		require.NoError(t, err)
+
+		handler := &recordingHandler{}
+		consume(t, consumer, handler, nil, func() bool { return len(handler.handled()) == 1 })
+
+		msg := handler.handled()[0]
+		assert.Equal(t, "msg-1", msg.ID)
+		assert.Equal(t, []byte("payload"), msg.Value)
+		assert.Equal(t, []byte("42"), msg.Key)
+		assert.Equal(t, "audio_conversion", msg.Headers["type"])
+		assert.Equal(t, MessageOptions{
+			DeliveryMode:  Persistent,
+			Priority:      4,
+			CorrelationID: "corr-1",
+			ReplyTo:       "replies",
+			ContentType:   "application/json",
+		}, msg.Options)
+		assert.False(t, msg.Timestamp.IsZero())
+	})
+
+	t.Run("duplicate message IDs are published once", func(t *testing.T) {
+		producer, consumer := newQueue(t, "phonon.dedup")
+
+		for i := 0; i < 2; i++ {
+			require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("once"), ID: "same"}, nil))
+		}
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("marker"), ID: "marker"}, nil))
+
+		handler := &recordingHandler{}
+		consume(t, consumer, handler, nil, func() bool { return len(handler.handled()) == 2 })
+		assert.Equal(t, "marker", handler.handled()[1].ID)
+	})
+
+	t.Run("nak with delay then redeliver", func(t *testing.T) {
+		producer, consumer := newQueue(t, "phonon.retry")
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("retry")}, nil))
+
+		handler := &recordingHandler{failures: 1}
+		consume(t, consumer, handler, nil, func() bool { return len(handler.handled()) == 2 })
+	})
+
+	t.Run("dead-letters after max deliveries", func(t *testing.T) {
+		producer, consumer := newQueue(t, "phonon.poison")
+		// the dead letter subject is captured by the same stream, which deduplicates by message ID
+		deadLetters, deadLetterConsumer := newQueue(t, "phonon.poison_dlq")
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("poison"), ID: "poison"}, nil))
+
+		handler := &recordingHandler{failures: 2}
+		deadLettered := &recordingHandler{}
+		consume(t, consumer, handler, &ConsumerOptions{DeadLetter: deadLetters}, func() bool { return len(handler.handled()) == 2 })
+		consume(t, deadLetterConsumer, deadLettered, nil, func() bool { return len(deadLettered.handled()) == 1 })
+
+		require.Len(t, deadLettered.handled(), 1, "the dead letter is not dropped as a duplicate")
+		dead := deadLettered.handled()[0]
+		assert.Equal(t, []byte("poison"), dead.Value)
+		assert.Equal(t, "poison", dead.Headers[HeaderOriginalMessageID])
+		assert.Equal(t, "handler failed", dead.Headers[HeaderDeadLetterReason])
+	})
+
+	t.Run("lag and depth count undelivered messages", func(t *testing.T) {
+		producer, consumer := newQueue(t, "phonon.lag")
+		for i := 0; i < 3; i++ {
+			require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("waiting")}, nil))
+		}
+
+		lag, err := consumer.Lag(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{"phonon.lag": 3}, lag)
+
+		depth, err := producer.Depth(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), depth)
+	})
+
+	t.Run("publishes to the message topic", func(t *testing.T) {
+		producer, _ := newQueue(t, "phonon.requests")
+		_, replies := newQueue(t, "phonon.replies.instance")
+
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("reply"), Topic: "phonon.replies.instance"}, nil))
+
+		handler := &recordingHandler{}
+		consume(t, replies, handler, nil, func() bool { return len(handler.handled()) == 1 })
+		assert.Equal(t, []byte("reply"), handler.handled()[0].Value)
+	})
+
+	t.Run("delayed delivery", func(t *testing.T) {
+		producer, consumer := newQueue(t, "phonon.delayed")
+		notBefore := time.Now().Add(200 * time.Millisecond)
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("delayed")}, &MessageOptions{NotBefore: notBefore}))
+
+		handler := &recordingHandler{}
+		consume(t, consumer, handler, nil, func() bool { return len(handler.handled()) == 1 })
+		assert.False(t, time.Now().Before(notBefore))
+	})
+
+	t.Run("delayed delivery is waited for on the last delivery", func(t *testing.T) {
+		config := JetStreamConfig{URL: url, Stream: "PHONON", Subject: "phonon.last_delivery", Durable: "phonon_last_delivery", MaxDeliver: 1}
+		producer, err := NewJetStreamProducer(config)
+		require.NoError(t, err)
+		t.Cleanup(func() { producer.Close() })
+		consumer, err := NewJetStreamConsumer(config)
+		require.NoError(t, err)
+		t.Cleanup(func() { consumer.Close() })
+
+		notBefore := time.Now().Add(200 * time.Millisecond)
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("delayed")}, &MessageOptions{NotBefore: notBefore}))
+
+		handler := &recordingHandler{}
+		consume(t, consumer, handler, nil, func() bool { return len(handler.handled()) == 1 })
+		assert.False(t, time.Now().Before(notBefore))
+	})
+
+	t.Run("delayed delivery parked on the retry subject keeps its deliveries", func(t *testing.T) {
+		producer, consumer := newQueue(t, "phonon.deferred")
+		retry, _ := newQueue(t, "phonon.deferred_retry")
+
+		redelivery, err := NewJetStreamProducer(JetStreamConfig{URL: url, Stream: "PHONON", Subject: "phonon.deferred"})
+		require.NoError(t, err)
+		relay, err := NewJetStreamRetryRelay(JetStreamConfig{
+			URL:        url,
+			Stream:     "PHONON",
+			Subject:    "phonon.deferred_retry",
+			Durable:    "phonon_deferred_relay",
+			RetryDelay: 10 * time.Millisecond,
+		}, redelivery)
+		require.NoError(t, err)
+		t.Cleanup(func() { relay.Close() })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		relayed := make(chan struct{})
+		go func() {
+			relay.Run(ctx)
+			close(relayed)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-relayed
+		})
+
+		notBefore := time.Now().Add(200 * time.Millisecond)
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("deferred"), ID: "deferred"}, &MessageOptions{NotBefore: notBefore}))
+
+		// the first attempt fails, a deferred message still has all of its deliveries left to be retried with
+		handler := &recordingHandler{failures: 1}
+		consume(t, consumer, handler, &ConsumerOptions{Retry: retry}, func() bool { return len(handler.handled()) == 2 })
+
+		require.Len(t, handler.handled(), 2)
+		msg := handler.handled()[1]
+		assert.Equal(t, []byte("deferred"), msg.Value)
+		assert.Equal(t, "phonon.deferred", msg.Topic)
+		assert.Equal(t, "deferred", msg.Headers[HeaderOriginalMessageID])
+		assert.NotContains(t, msg.Headers, HeaderRetryTopic)
+		assert.False(t, time.Now().Before(notBefore))
+	})
+}
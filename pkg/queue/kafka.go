@@ -2,6 +2,11 @@ package queue
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
@@ -15,44 +20,159 @@ type KafkaConfig struct {
 	MinBytes    int
 	MaxBytes    int
 	MaxAttempts int
+	ClientID    string // Client ID reported to the brokers, kafka-go picks one when empty
+	Compression string // Batch compression of the producer: gzip, snappy, lz4, zstd or empty for none
+	TLS         KafkaTLSConfig
+	SASL        KafkaSASLConfig
 }
 
 // KafkaProducer implements the Producer interface for Kafka
 type KafkaProducer struct {
-	writer *kafka.Writer
+	writer  *kafka.Writer
+	client  *kafka.Client
+	topic   string
+	groupID string
 }
 
 // NewKafkaProducer creates a new Kafka producer
 func NewKafkaProducer(config KafkaConfig) (*KafkaProducer, error) {
+	transport, err := config.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := kafkaCodec(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	// the topic is set per message so messages can override it
 	writer := &kafka.Writer{
-		Addr:         kafka.TCP(config.Brokers...),
-		Topic:        config.Topic,
-		Balancer:     &kafka.LeastBytes{},
+		Addr: kafka.TCP(config.Brokers...),
+		// Hash keeps messages sharing a key on the same partition and round-robins unkeyed messages
+		Balancer:     &kafka.Hash{},
 		RequiredAcks: kafka.RequireAll,
 		MaxAttempts:  config.MaxAttempts,
+		Compression:  codec,
+		Transport:    transport,
 	}
 
-	return &KafkaProducer{writer: writer}, nil
+	return &KafkaProducer{
+		writer:  writer,
+		client:  &kafka.Client{Addr: writer.Addr, Transport: transport},
+		topic:   config.Topic,
+		groupID: config.GroupID,
+	}, nil
 }
 
 // Publish implements the Producer interface
 func (p *KafkaProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	if msg.ID == "" {
+		msg.ID = NewMessageID()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	topic := msg.Topic
+	if topic == "" {
+		topic = p.topic
+	}
+
 	kafkaMsg := kafka.Message{
-		Value: msg.Value,
+		Topic:   topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: encodeKafkaHeaders(msg, opts),
+	}
+
+	return p.writer.WriteMessages(ctx, kafkaMsg)
+}
+
+// Depth implements the DepthReporter interface with the lag of the consumer group of the producer topic,
+// partitions the group never committed an offset on count from their first offset.
+// It requires the producer to be configured with the GroupID of the consumers.
+func (p *KafkaProducer) Depth(ctx context.Context) (int64, error) {
+	if p.groupID == "" {
+		return 0, ErrDepthUnavailable
+	}
+
+	lag, err := kafkaGroupLag(ctx, p.client, p.topic, p.groupID)
+	if err != nil {
+		return 0, err
+	}
+
+	var depth int64
+	for _, value := range lag {
+		depth += value
 	}
 
-	if opts != nil {
-		// Set message headers based on options
-		kafkaMsg.Headers = []kafka.Header{
-			{Key: "content-type", Value: []byte(opts.ContentType)},
-			{Key: "content-encoding", Value: []byte(opts.ContentEncoding)},
-			{Key: "correlation-id", Value: []byte(opts.CorrelationID)},
-			{Key: "reply-to", Value: []byte(opts.ReplyTo)},
-			{Key: "expiration", Value: []byte(opts.Expiration)},
+	return depth, nil
+}
+
+// kafkaGroupLag fetches the last offsets of every partition of topic and the offsets groupID committed on them
+func kafkaGroupLag(ctx context.Context, client *kafka.Client, topic, groupID string) (map[int]int64, error) {
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata.Topics) != 1 || metadata.Topics[0].Error != nil {
+		return nil, fmt.Errorf("kafka topic %s metadata unavailable", topic)
+	}
+
+	var partitions []int
+	var offsetRequests []kafka.OffsetRequest
+	for _, partition := range metadata.Topics[0].Partitions {
+		partitions = append(partitions, partition.ID)
+		offsetRequests = append(offsetRequests, kafka.FirstOffsetOf(partition.ID), kafka.LastOffsetOf(partition.ID))
+	}
+
+	offsets, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: offsetRequests},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: groupID,
+		Topics:  map[string][]int{topic: partitions},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if committed.Error != nil {
+		return nil, committed.Error
+	}
+
+	committedOffsets := make(map[int]int64)
+	for _, partition := range committed.Topics[topic] {
+		if partition.Error != nil {
+			return nil, partition.Error
 		}
+		committedOffsets[partition.Partition] = partition.CommittedOffset
 	}
 
-	return p.writer.WriteMessages(ctx, kafkaMsg)
+	return kafkaLag(offsets.Topics[topic], committedOffsets)
+}
+
+// kafkaLag counts the messages between the committed and the last offset of every partition
+func kafkaLag(offsets []kafka.PartitionOffsets, committed map[int]int64) (map[int]int64, error) {
+	lag := make(map[int]int64, len(offsets))
+	for _, partition := range offsets {
+		if partition.Error != nil {
+			return nil, partition.Error
+		}
+
+		start, ok := committed[partition.Partition]
+		if !ok || start < partition.FirstOffset {
+			// a negative committed offset means the group did not commit on this partition yet
+			start = partition.FirstOffset
+		}
+		lag[partition.Partition] = max(partition.LastOffset-start, 0)
+	}
+
+	return lag, nil
 }
 
 // Close implements the Producer interface
@@ -62,66 +182,197 @@ func (p *KafkaProducer) Close() error {
 
 // KafkaConsumer implements the Consumer interface for Kafka
 type KafkaConsumer struct {
-	reader *kafka.Reader
+	reader  kafkaReader
+	client  *kafka.Client
+	topic   string
+	groupID string
 }
 
 // NewKafkaConsumer creates a new Kafka consumer
 func NewKafkaConsumer(config KafkaConfig) (*KafkaConsumer, error) {
+	dialer, err := config.dialer()
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := config.transport()
+	if err != nil {
+		return nil, err
+	}
+
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  config.Brokers,
 		Topic:    config.Topic,
 		GroupID:  config.GroupID,
 		MinBytes: config.MinBytes,
 		MaxBytes: config.MaxBytes,
+		Dialer:   dialer,
 	})
 
-	return &KafkaConsumer{reader: reader}, nil
+	return &KafkaConsumer{
+		reader:  reader,
+		client:  &kafka.Client{Addr: kafka.TCP(config.Brokers...), Transport: transport},
+		topic:   config.Topic,
+		groupID: config.GroupID,
+	}, nil
 }
 
-// Consume implements the Consumer interface
+// kafkaReader is the part of kafka.Reader the consumer uses
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Consume implements the Consumer interface. Offsets are committed once the messages were handled, in the order
+// they were fetched from their partition, so the messages still waiting or handled when the process stops are
+// redelivered to the next consumer of the group.
 func (c *KafkaConsumer) Consume(ctx context.Context, handler Handler, opts *ConsumerOptions) {
+	pool := newWorkerPool(opts.concurrency())
+	defer pool.Wait()
+
+	committer := newKafkaCommitter(c.reader)
+
 	for {
-		select {
-		case <-ctx.Done():
+		if !pool.WaitFree(ctx) {
 			return
-		default:
-			m, err := c.reader.ReadMessage(ctx)
-			if err != nil {
-				logrus.WithContext(ctx).Errorf("failed to read message: %v", err)
-				continue
-			}
+		}
 
-			msg := Message{
-				Value: m.Value,
+		m, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
+			logrus.WithContext(ctx).Errorf("failed to fetch message: %v", err)
+			continue
+		}
 
-			// Extract message options from headers
-			if len(m.Headers) > 0 {
-				opts := &MessageOptions{}
-				for _, h := range m.Headers {
-					switch h.Key {
-					case "content-type":
-						opts.ContentType = string(h.Value)
-					case "content-encoding":
-						opts.ContentEncoding = string(h.Value)
-					case "correlation-id":
-						opts.CorrelationID = string(h.Value)
-					case "reply-to":
-						opts.ReplyTo = string(h.Value)
-					case "expiration":
-						opts.Expiration = string(h.Value)
-					}
-				}
-			}
+		msg := decodeKafkaMessage(m)
+		fetched := committer.track(m)
 
-			if err := handler.Handle(ctx, msg); err != nil {
-				logrus.WithContext(ctx).Errorf("failed to handle message: %v", err)
+		// fetched messages are handled to the end, even when ctx is done meanwhile
+		handleCtx := context.WithoutCancel(ctx)
+		pool.Go(handleCtx, func() {
+			if err := deliver(handleCtx, handler, msg, opts); err != nil {
+				logrus.WithContext(handleCtx).WithField("message_id", msg.ID).Errorf("failed to handle message: %v", err)
 			}
-		}
+			committer.done(handleCtx, fetched)
+		})
+	}
+}
+
+// kafkaCommitter commits the offset of a handled message once every message fetched before it from its partition
+// was handled too, since committing an offset acknowledges all the messages before it
+type kafkaCommitter struct {
+	reader kafkaReader
+
+	mu      sync.Mutex
+	pending map[int][]*kafkaFetched // messages not committed yet per partition, in the order they were fetched
+}
+
+// kafkaFetched is a message fetched from a partition, done once handled
+type kafkaFetched struct {
+	message kafka.Message
+	handled bool
+}
+
+func newKafkaCommitter(reader kafkaReader) *kafkaCommitter {
+	return &kafkaCommitter{reader: reader, pending: make(map[int][]*kafkaFetched)}
+}
+
+// track records a fetched message, which must be passed to done once handled
+func (c *kafkaCommitter) track(m kafka.Message) *kafkaFetched {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fetched := &kafkaFetched{message: m}
+	c.pending[m.Partition] = append(c.pending[m.Partition], fetched)
+
+	return fetched
+}
+
+// done marks a message handled and commits the last of the handled messages at the head of its partition.
+// Commits are made under the lock, so the offset of a partition never moves back.
+func (c *kafkaCommitter) done(ctx context.Context, fetched *kafkaFetched) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fetched.handled = true
+
+	partition := fetched.message.Partition
+	pending := c.pending[partition]
+	handled := 0
+	for handled < len(pending) && pending[handled].handled {
+		handled++
+	}
+	if handled == 0 {
+		return
 	}
+
+	if err := c.reader.CommitMessages(ctx, pending[handled-1].message); err != nil {
+		// the messages are redelivered, as after a rebalance took the partition away
+		logrus.WithContext(ctx).Errorf("failed to commit messages: %v", err)
+	}
+	c.pending[partition] = pending[handled:]
+}
+
+// Lag implements the LagReporter interface with the messages between the offsets committed by the consumer group
+// and the last offsets of the partitions, so partitions the consumer stopped reading from keep reporting their lag.
+// Consumers without group commit no offsets and report no lag.
+func (c *KafkaConsumer) Lag(ctx context.Context) (map[string]int64, error) {
+	if c.groupID == "" {
+		return nil, nil
+	}
+
+	partitionLag, err := kafkaGroupLag(ctx, c.client, c.topic, c.groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	lag := make(map[string]int64, len(partitionLag))
+	for partition, value := range partitionLag {
+		lag[strconv.Itoa(partition)] = value
+	}
+
+	return lag, nil
 }
 
 // Close implements the Consumer interface
 func (c *KafkaConsumer) Close() error {
 	return c.reader.Close()
 }
+
+// encodeKafkaHeaders converts the message headers and options into Kafka headers, sorted to keep the output stable
+func encodeKafkaHeaders(msg Message, opts *MessageOptions) []kafka.Header {
+	headers := encodeHeaders(msg, opts)
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kafkaHeaders := make([]kafka.Header, 0, len(keys))
+	for _, key := range keys {
+		kafkaHeaders = append(kafkaHeaders, kafka.Header{Key: key, Value: []byte(headers[key])})
+	}
+
+	return kafkaHeaders
+}
+
+// decodeKafkaMessage converts a consumed Kafka message back into a Message with its ID, key, headers and options.
+// The broker timestamp is used for messages published without a timestamp header.
+func decodeKafkaMessage(m kafka.Message) Message {
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	msg := decodeMessage(m.Value, m.Key, headers)
+	msg.Topic = m.Topic
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = m.Time
+	}
+
+	return msg
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"phonon/pkg/instrumentation"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultKafkaRetryBackoff = 5 * time.Second
+
+// KafkaRetryRelay redelivers the messages parked on a retry topic to the topic they were consumed from once
+// they are due. Consumers park delayed messages there through ConsumerOptions.Retry instead of waiting for them,
+// which would hold back the rest of their partition.
+//
+// A parked message is committed only after it was redelivered, so messages waiting on shutdown are relayed by
+// the next run. Messages are relayed in the order of their retry partition, a message due early waits for the
+// ones parked before it, which suits delays of similar length such as deferred conversions.
+type KafkaRetryRelay struct {
+	reader   *kafka.Reader
+	producer Producer
+}
+
+// NewKafkaRetryRelay creates a KafkaRetryRelay reading config.Topic with the consumer group config.GroupID and
+// redelivering through producer, which must not compress the already encoded payloads again
+func NewKafkaRetryRelay(config KafkaConfig, producer Producer) (*KafkaRetryRelay, error) {
+	if config.Topic == "" || config.GroupID == "" {
+		return nil, errors.New("kafka retry topic and group are required")
+	}
+
+	dialer, err := config.dialer()
+	if err != nil {
+		return nil, err
+	}
+
+	// offsets are committed explicitly once a message was redelivered
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  config.Brokers,
+		Topic:    config.Topic,
+		GroupID:  config.GroupID,
+		MinBytes: config.MinBytes,
+		MaxBytes: config.MaxBytes,
+		Dialer:   dialer,
+	})
+
+	return &KafkaRetryRelay{reader: reader, producer: producer}, nil
+}
+
+// Run relays parked messages until ctx is done
+func (r *KafkaRetryRelay) Run(ctx context.Context) {
+	for {
+		m, err := r.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logrus.WithContext(ctx).Errorf("failed to fetch parked message: %v", err)
+			continue
+		}
+
+		if err = r.relay(ctx, m); err != nil {
+			// the message was not committed, the next run relays it again
+			return
+		}
+	}
+}
+
+// relay waits until the parked message is due, redelivers it, retrying failed publishes, and commits it.
+// It only fails when ctx is done first.
+func (r *KafkaRetryRelay) relay(ctx context.Context, m kafka.Message) error {
+	msg, opts := unparkKafkaMessage(m)
+	logger := logrus.WithContext(ctx).WithField("message_id", msg.ID)
+
+	if err := sleep(ctx, msg.Delay(time.Now())); err != nil {
+		return err
+	}
+
+	for {
+		err := r.producer.Publish(ctx, msg, &opts)
+		if err == nil {
+			break
+		}
+		logger.Warnf("failed to redeliver parked message: %v", err)
+
+		if err = sleep(ctx, defaultKafkaRetryBackoff); err != nil {
+			return err
+		}
+	}
+	instrumentation.IncrementCounter(metricsNamespace, "messages_unparked")
+
+	if err := r.reader.CommitMessages(ctx, m); err != nil {
+		// the message is redelivered again by the next run, consumers deduplicate it by the ID of the parked copy
+		logger.Errorf("failed to commit parked message: %v", err)
+	}
+
+	return nil
+}
+
+// Close implements the io.Closer interface, closing the reader and the producer
+func (r *KafkaRetryRelay) Close() error {
+	return errors.Join(r.reader.Close(), r.producer.Close())
+}
+
+// unparkKafkaMessage restores a message parked by park, addressed to the topic it was consumed from,
+// and the options to publish it with
+func unparkKafkaMessage(m kafka.Message) (Message, MessageOptions) {
+	return unpark(decodeKafkaMessage(m))
+}
+
+// sleep waits for d, returning early with the error of ctx when it is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
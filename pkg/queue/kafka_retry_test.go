@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnparkKafkaMessage(t *testing.T) {
+	retries := new(recordingProducer)
+	msg := Message{
+		Value:     []byte("compressed"),
+		ID:        "msg-123",
+		Key:       []byte("1"),
+		Topic:     "audio_conversion",
+		Timestamp: time.UnixMilli(1700000000000),
+		Headers:   map[string]string{"type": "audio_conversion"},
+		Options: MessageOptions{
+			Priority:        PriorityInteractive,
+			ContentEncoding: EncodingGzip,
+			NotBefore:       time.UnixMilli(1700000300000),
+		},
+	}
+	assert.NoError(t, park(context.Background(), retries, msg))
+
+	parked := retries.published[0]
+	unparked, opts := unparkKafkaMessage(kafka.Message{
+		Topic:   "audio_conversion_retry",
+		Key:     parked.Key,
+		Value:   parked.Value,
+		Headers: encodeKafkaHeaders(parked, &parked.Options),
+	})
+
+	assert.Equal(t, "audio_conversion", unparked.Topic, "the message returns to the topic it was consumed from")
+	assert.Equal(t, parked.ID, unparked.ID, "redelivered copies are deduplicated by the ID of the parked copy")
+	assert.Equal(t, msg.ID, unparked.Headers[HeaderOriginalMessageID])
+	assert.Equal(t, msg.Key, unparked.Key)
+	assert.Equal(t, msg.Value, unparked.Value)
+	assert.True(t, msg.Timestamp.Equal(unparked.Timestamp))
+	assert.NotContains(t, unparked.Headers, HeaderRetryTopic)
+	assert.Equal(t, "audio_conversion", unparked.Headers["type"])
+	assert.True(t, msg.Options.NotBefore.Equal(opts.NotBefore))
+	assert.Equal(t, 5*time.Minute, unparked.Delay(msg.Timestamp), "the relay waits until the parked message is due")
+	opts.NotBefore = msg.Options.NotBefore
+	assert.Equal(t, msg.Options, opts)
+}
+
+func TestNewKafkaRetryRelay_RequiresTopicAndGroup(t *testing.T) {
+	_, err := NewKafkaRetryRelay(KafkaConfig{Topic: "audio_conversion_retry"}, new(recordingProducer))
+	assert.Error(t, err)
+}
+
+func TestSleep(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NoError(t, sleep(context.Background(), 0))
+	assert.ErrorIs(t, sleep(cancelled, time.Hour), context.Canceled)
+}
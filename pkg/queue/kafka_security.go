@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Supported SASL mechanisms
+const (
+	SASLPlain       = "plain"
+	SASLScramSHA256 = "scram-sha-256"
+	SASLScramSHA512 = "scram-sha-512"
+)
+
+const defaultKafkaDialTimeout = 10 * time.Second
+
+var (
+	ErrUnsupportedSASLMechanism = errors.New("sasl mechanism not supported")
+	ErrUnsupportedKafkaCodec    = errors.New("kafka compression codec not supported")
+)
+
+// KafkaTLSConfig holds the TLS settings of the broker connections
+type KafkaTLSConfig struct {
+	Enabled            bool
+	CAFile             string // PEM bundle verifying the brokers, the system pool when empty
+	CertFile           string // PEM client certificate for mutual TLS, requires KeyFile
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// KafkaSASLConfig holds the SASL credentials of the broker connections
+type KafkaSASLConfig struct {
+	Mechanism string // plain, scram-sha-256 or scram-sha-512, SASL is disabled when empty
+	Username  string
+	Password  string
+}
+
+func (c KafkaTLSConfig) build() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kafka CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in kafka CA file %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kafka client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func (c KafkaSASLConfig) build() (sasl.Mechanism, error) {
+	switch c.Mechanism {
+	case "":
+		return nil, nil
+	case SASLPlain:
+		return plain.Mechanism{Username: c.Username, Password: c.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, c.Username, c.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, c.Username, c.Password)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSASLMechanism, c.Mechanism)
+	}
+}
+
+// kafkaCodec maps a codec name to the batch compression of the Kafka writer, none when empty
+func kafkaCodec(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedKafkaCodec, name)
+	}
+}
+
+// transport builds the transport of writers and clients from the client ID, TLS and SASL settings
+func (c KafkaConfig) transport() (*kafka.Transport, error) {
+	tlsConfig, err := c.TLS.build()
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism, err := c.SASL.build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Transport{
+		DialTimeout: defaultKafkaDialTimeout,
+		ClientID:    c.ClientID,
+		TLS:         tlsConfig,
+		SASL:        mechanism,
+	}, nil
+}
+
+// dialer builds the dialer of readers from the client ID, TLS and SASL settings
+func (c KafkaConfig) dialer() (*kafka.Dialer, error) {
+	tlsConfig, err := c.TLS.build()
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism, err := c.SASL.build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Dialer{
+		Timeout:       defaultKafkaDialTimeout,
+		DualStack:     true,
+		ClientID:      c.ClientID,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}, nil
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate and its key as PEM files
+func writeCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kafka"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestKafkaTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+
+	t.Run("disabled", func(t *testing.T) {
+		config, err := KafkaTLSConfig{CAFile: certFile}.build()
+		require.NoError(t, err)
+		assert.Nil(t, config)
+	})
+
+	t.Run("system roots", func(t *testing.T) {
+		config, err := KafkaTLSConfig{Enabled: true}.build()
+		require.NoError(t, err)
+		assert.Nil(t, config.RootCAs)
+		assert.Empty(t, config.Certificates)
+	})
+
+	t.Run("custom CA and client certificate", func(t *testing.T) {
+		config, err := KafkaTLSConfig{Enabled: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile}.build()
+		require.NoError(t, err)
+		assert.NotNil(t, config.RootCAs)
+		assert.Len(t, config.Certificates, 1)
+	})
+
+	t.Run("invalid CA", func(t *testing.T) {
+		_, err := KafkaTLSConfig{Enabled: true, CAFile: keyFile}.build()
+		assert.Error(t, err)
+
+		_, err = KafkaTLSConfig{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")}.build()
+		assert.Error(t, err)
+	})
+
+	t.Run("certificate without key", func(t *testing.T) {
+		_, err := KafkaTLSConfig{Enabled: true, CertFile: certFile}.build()
+		assert.Error(t, err)
+	})
+}
+
+func TestKafkaSASLConfig(t *testing.T) {
+	mechanism, err := KafkaSASLConfig{}.build()
+	require.NoError(t, err)
+	assert.Nil(t, mechanism)
+
+	mechanism, err = KafkaSASLConfig{Mechanism: SASLPlain, Username: "user", Password: "secret"}.build()
+	require.NoError(t, err)
+	assert.Equal(t, plain.Mechanism{Username: "user", Password: "secret"}, mechanism)
+
+	mechanism, err = KafkaSASLConfig{Mechanism: SASLScramSHA256, Username: "user", Password: "secret"}.build()
+	require.NoError(t, err)
+	assert.Equal(t, "SCRAM-SHA-256", mechanism.Name())
+
+	mechanism, err = KafkaSASLConfig{Mechanism: SASLScramSHA512, Username: "user", Password: "secret"}.build()
+	require.NoError(t, err)
+	assert.Equal(t, "SCRAM-SHA-512", mechanism.Name())
+
+	_, err = KafkaSASLConfig{Mechanism: "gssapi"}.build()
+	assert.ErrorIs(t, err, ErrUnsupportedSASLMechanism)
+}
+
+func TestKafkaCodec(t *testing.T) {
+	for name, expected := range map[string]kafka.Compression{"": 0, "none": 0, "gzip": kafka.Gzip, "snappy": kafka.Snappy, "lz4": kafka.Lz4, "zstd": kafka.Zstd} {
+		codec, err := kafkaCodec(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, codec, name)
+	}
+
+	_, err := kafkaCodec("brotli")
+	assert.ErrorIs(t, err, ErrUnsupportedKafkaCodec)
+}
+
+func TestKafkaConfig_Connections(t *testing.T) {
+	config := KafkaConfig{
+		ClientID: "phonon",
+		TLS:      KafkaTLSConfig{Enabled: true},
+		SASL:     KafkaSASLConfig{Mechanism: SASLPlain, Username: "user", Password: "secret"},
+	}
+
+	transport, err := config.transport()
+	require.NoError(t, err)
+	assert.Equal(t, "phonon", transport.ClientID)
+	assert.NotNil(t, transport.TLS)
+	assert.NotNil(t, transport.SASL)
+
+	dialer, err := config.dialer()
+	require.NoError(t, err)
+	assert.Equal(t, "phonon", dialer.ClientID)
+	assert.NotNil(t, dialer.TLS)
+	assert.NotNil(t, dialer.SASLMechanism)
+
+	t.Run("invalid settings fail the producer and consumer", func(t *testing.T) {
+		_, err := NewKafkaProducer(KafkaConfig{SASL: KafkaSASLConfig{Mechanism: "gssapi"}})
+		assert.ErrorIs(t, err, ErrUnsupportedSASLMechanism)
+
+		_, err = NewKafkaProducer(KafkaConfig{Compression: "brotli"})
+		assert.ErrorIs(t, err, ErrUnsupportedKafkaCodec)
+
+		_, err = NewKafkaConsumer(KafkaConfig{SASL: KafkaSASLConfig{Mechanism: "gssapi"}})
+		assert.ErrorIs(t, err, ErrUnsupportedSASLMechanism)
+	})
+}
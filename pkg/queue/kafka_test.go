@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafkaHeaders(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		msg := Message{
+			Value:     []byte(`{"user_id":1}`),
+			ID:        "msg-123",
+			Key:       []byte("1"),
+			Timestamp: time.UnixMilli(1700000000000),
+			Headers:   map[string]string{"type": "audio_conversion"},
+		}
+		opts := &MessageOptions{
+			DeliveryMode:  Persistent,
+			Priority:      3,
+			CorrelationID: "corr-123",
+			Expiration:    "60000",
+			ContentType:   "application/json",
+			NotBefore:     time.UnixMilli(1700000060000),
+		}
+
+		decoded := decodeKafkaMessage(kafka.Message{
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: encodeKafkaHeaders(msg, opts),
+		})
+
+		assert.Equal(t, msg.ID, decoded.ID)
+		assert.Equal(t, msg.Key, decoded.Key)
+		assert.Equal(t, msg.Value, decoded.Value)
+		assert.True(t, msg.Timestamp.Equal(decoded.Timestamp))
+		assert.Equal(t, opts.Expiration, decoded.Options.Expiration)
+		assert.True(t, opts.NotBefore.Equal(decoded.Options.NotBefore))
+		decoded.Options.NotBefore = opts.NotBefore
+		assert.Equal(t, *opts, decoded.Options)
+		assert.Equal(t, "audio_conversion", decoded.Headers["type"])
+		assert.Equal(t, "corr-123", decoded.Headers[HeaderCorrelationID])
+	})
+
+	t.Run("headers are sorted and options win over custom headers", func(t *testing.T) {
+		msg := Message{
+			ID:      "msg-123",
+			Headers: map[string]string{HeaderContentType: "text/plain"},
+		}
+
+		headers := encodeKafkaHeaders(msg, &MessageOptions{ContentType: "application/json"})
+		assert.Equal(t, []kafka.Header{
+			{Key: HeaderContentType, Value: []byte("application/json")},
+			{Key: HeaderMessageID, Value: []byte("msg-123")},
+		}, headers)
+	})
+
+	t.Run("message without headers", func(t *testing.T) {
+		brokerTime := time.UnixMilli(1700000000000)
+		decoded := decodeKafkaMessage(kafka.Message{Value: []byte("value"), Time: brokerTime})
+		assert.Empty(t, decoded.ID)
+		assert.Equal(t, brokerTime, decoded.Timestamp)
+		assert.Equal(t, MessageOptions{}, decoded.Options)
+	})
+}
+
+func TestKafkaConsumer_Lag(t *testing.T) {
+	consumer, err := NewKafkaConsumer(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "audio_conversion"})
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	lag, err := consumer.Lag(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, lag, "consumers without group commit no offsets")
+}
+
+func TestKafkaLag(t *testing.T) {
+	offsets := []kafka.PartitionOffsets{
+		{Partition: 0, FirstOffset: 0, LastOffset: 10},
+		{Partition: 1, FirstOffset: 5, LastOffset: 8},
+		{Partition: 2, FirstOffset: 20, LastOffset: 30},
+		{Partition: 3, FirstOffset: 0, LastOffset: 4},
+	}
+	committed := map[int]int64{
+		0: 7,  // 3 waiting
+		1: -1, // never committed, counts from the first offset
+		2: 12, // committed offset removed by retention
+		3: 4,  // caught up
+	}
+
+	lag, err := kafkaLag(offsets, committed)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int64{0: 3, 1: 3, 2: 10, 3: 0}, lag)
+
+	_, err = kafkaLag([]kafka.PartitionOffsets{{Error: kafka.UnknownTopicOrPartition}}, committed)
+	assert.ErrorIs(t, err, kafka.UnknownTopicOrPartition)
+}
+
+// fakeKafkaReader hands out the messages sent to fetch and records the commits
+type fakeKafkaReader struct {
+	fetch chan kafka.Message
+
+	mu        sync.Mutex
+	committed map[int]int64 // last committed offset per partition
+}
+
+func (r *fakeKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r.fetch:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeKafkaReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range msgs {
+		r.committed[m.Partition] = m.Offset
+	}
+	return nil
+}
+
+func (r *fakeKafkaReader) commits() map[int]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	commits := make(map[int]int64, len(r.committed))
+	for partition, offset := range r.committed {
+		commits[partition] = offset
+	}
+	return commits
+}
+
+func (r *fakeKafkaReader) Close() error {
+	return nil
+}
+
+// slowHandler blocks on the message with the slow ID until release is closed and records the other ones
+type slowHandler struct {
+	slow    string
+	release chan struct{}
+	recordingHandler
+}
+
+func (h *slowHandler) Handle(ctx context.Context, msg Message) error {
+	if msg.ID == h.slow {
+		<-h.release
+	}
+	return h.recordingHandler.Handle(ctx, msg)
+}
+
+func TestKafkaConsumer_CommitsHandledMessages(t *testing.T) {
+	reader := &fakeKafkaReader{fetch: make(chan kafka.Message, 3), committed: make(map[int]int64)}
+	consumer := &KafkaConsumer{reader: reader}
+
+	message := func(id string, partition int, offset int64) kafka.Message {
+		return kafka.Message{Partition: partition, Offset: offset, Headers: encodeKafkaHeaders(Message{ID: id}, nil)}
+	}
+	reader.fetch <- message("slow", 0, 0)
+	reader.fetch <- message("fast", 0, 1)
+	reader.fetch <- message("other", 1, 0)
+
+	handler := &slowHandler{slow: "slow", release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		consumer.Consume(ctx, handler, &ConsumerOptions{Concurrency: 3})
+		close(stopped)
+	}()
+
+	assert.Eventually(t, func() bool { return len(handler.handled()) == 2 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return len(reader.commits()) == 1 }, time.Second, time.Millisecond)
+
+	// shutting down while a message is handled leaves it and the messages after it uncommitted for redelivery
+	cancel()
+	assert.Equal(t, map[int]int64{1: 0}, reader.commits(), "handled messages behind an unhandled one are not committed")
+
+	close(handler.release)
+	<-stopped
+	assert.Equal(t, map[int]int64{0: 1, 1: 0}, reader.commits(), "messages are committed once handled")
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// KafkaTopicConfig describes a topic to provision
+type KafkaTopicConfig struct {
+	Name              string
+	Partitions        int           // Defaults to 1
+	ReplicationFactor int           // Defaults to 1
+	Retention         time.Duration // Broker default when zero
+}
+
+func (c KafkaTopicConfig) build() kafka.TopicConfig {
+	topic := kafka.TopicConfig{
+		Topic:             c.Name,
+		NumPartitions:     max(c.Partitions, 1),
+		ReplicationFactor: max(c.ReplicationFactor, 1),
+	}
+	if c.Retention > 0 {
+		topic.ConfigEntries = append(topic.ConfigEntries, kafka.ConfigEntry{
+			ConfigName:  "retention.ms",
+			ConfigValue: strconv.FormatInt(c.Retention.Milliseconds(), 10),
+		})
+	}
+
+	return topic
+}
+
+// ProvisionKafkaTopics creates the topics that do not exist yet on the brokers of config.
+// Existing topics are left untouched, even when their partitions or retention differ.
+func ProvisionKafkaTopics(ctx context.Context, config KafkaConfig, topics []KafkaTopicConfig) error {
+	transport, err := config.transport()
+	if err != nil {
+		return err
+	}
+	client := &kafka.Client{Addr: kafka.TCP(config.Brokers...), Transport: transport}
+
+	request := &kafka.CreateTopicsRequest{}
+	for _, topic := range topics {
+		if topic.Name != "" {
+			request.Topics = append(request.Topics, topic.build())
+		}
+	}
+	if len(request.Topics) == 0 {
+		return nil
+	}
+
+	response, err := client.CreateTopics(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for name, topicErr := range response.Errors {
+		switch {
+		case topicErr == nil:
+			logrus.WithField("topic", name).Info("created kafka topic")
+		case errors.Is(topicErr, kafka.TopicAlreadyExists):
+		default:
+			errs = append(errs, fmt.Errorf("failed to create kafka topic %s: %w", name, topicErr))
+		}
+	}
+
+	return errors.Join(errs...)
+}
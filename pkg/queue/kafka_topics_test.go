@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaTopicConfig(t *testing.T) {
+	assert.Equal(t, kafka.TopicConfig{
+		Topic:             "audio_conversion",
+		NumPartitions:     6,
+		ReplicationFactor: 3,
+		ConfigEntries:     []kafka.ConfigEntry{{ConfigName: "retention.ms", ConfigValue: "604800000"}},
+	}, KafkaTopicConfig{Name: "audio_conversion", Partitions: 6, ReplicationFactor: 3, Retention: 7 * 24 * time.Hour}.build())
+
+	assert.Equal(t, kafka.TopicConfig{
+		Topic:             "replies",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}, KafkaTopicConfig{Name: "replies"}.build())
+}
+
+func TestAudioConversionTopics(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("mq.kafka.audio_conversion.topic", "audio_conversion")
+	viper.Set("mq.kafka.audio_conversion.retry_topic", "audio_conversion_retry")
+	viper.Set("mq.kafka.audio_conversion.dead_letter_topic", "audio_conversion_dlq")
+	viper.Set("mq.kafka.audio_conversion.partitions", 6)
+	viper.Set("mq.kafka.audio_conversion.retention", "168h")
+	viper.Set("mq.kafka.audio_conversion.dead_letter_retention", "720h")
+
+	assert.Equal(t, []KafkaTopicConfig{
+		{Name: "audio_conversion", Partitions: 6, Retention: 168 * time.Hour},
+		{Name: "audio_conversion_retry", Partitions: 6, Retention: 168 * time.Hour},
+		{Name: "audio_conversion_dlq", Partitions: 6, Retention: 720 * time.Hour},
+	}, audioConversionTopics())
+}
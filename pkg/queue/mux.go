@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrNoRoute = errors.New("no handler for message")
+
+// Mux implements Handler by routing messages to the handler registered for their type header,
+// then to the handler of the topic they were consumed from, then to the default handler.
+type Mux struct {
+	byType   map[string]Handler
+	byTopic  map[string]Handler
+	fallback Handler
+}
+
+// NewMux creates an empty Mux
+func NewMux() *Mux {
+	return &Mux{
+		byType:  make(map[string]Handler),
+		byTopic: make(map[string]Handler),
+	}
+}
+
+// HandleType routes messages whose type header matches messageType to the handler
+func (m *Mux) HandleType(messageType string, handler Handler) {
+	m.byType[messageType] = handler
+}
+
+// HandleTopic routes messages without a registered type consumed from topic to the handler
+func (m *Mux) HandleTopic(topic string, handler Handler) {
+	m.byTopic[topic] = handler
+}
+
+// HandleDefault routes messages matching no type or topic to the handler, e.g. messages published before types existed
+func (m *Mux) HandleDefault(handler Handler) {
+	m.fallback = handler
+}
+
+// Handle implements the Handler interface
+func (m *Mux) Handle(ctx context.Context, msg Message) error {
+	if handler, ok := m.byType[msg.Headers[HeaderType]]; ok {
+		return handler.Handle(ctx, msg)
+	}
+
+	if handler, ok := m.byTopic[msg.Topic]; ok {
+		return handler.Handle(ctx, msg)
+	}
+
+	if m.fallback != nil {
+		return m.fallback.Handle(ctx, msg)
+	}
+
+	return fmt.Errorf("%w: type %q on topic %q", ErrNoRoute, msg.Headers[HeaderType], msg.Topic)
+}
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMux(t *testing.T) {
+	ctx := context.Background()
+
+	typed := new(MockHandler)
+	topic := new(MockHandler)
+	fallback := new(MockHandler)
+
+	mux := NewMux()
+	mux.HandleType("cleanup", typed)
+	mux.HandleTopic("audio_conversion", topic)
+
+	t.Run("routes by type header first", func(t *testing.T) {
+		msg := Message{Topic: "audio_conversion", Headers: map[string]string{HeaderType: "cleanup"}}
+		typed.On("Handle", ctx, msg).Return(nil).Once()
+
+		assert.NoError(t, mux.Handle(ctx, msg))
+		typed.AssertExpectations(t)
+	})
+
+	t.Run("routes by topic", func(t *testing.T) {
+		msg := Message{Topic: "audio_conversion", Headers: map[string]string{HeaderType: "unknown"}}
+		topic.On("Handle", ctx, msg).Return(nil).Once()
+
+		assert.NoError(t, mux.Handle(ctx, msg))
+		topic.AssertExpectations(t)
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		assert.ErrorIs(t, mux.Handle(ctx, Message{Topic: "other"}), ErrNoRoute)
+	})
+
+	t.Run("routes to default", func(t *testing.T) {
+		mux.HandleDefault(fallback)
+		msg := Message{Topic: "other"}
+		fallback.On("Handle", ctx, msg).Return(nil).Once()
+
+		assert.NoError(t, mux.Handle(ctx, msg))
+		fallback.AssertExpectations(t)
+		typed.AssertNumberOfCalls(t, "Handle", 1)
+		topic.AssertNotCalled(t, "Handle", mock.Anything, msg)
+	})
+}
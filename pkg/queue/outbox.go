@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"phonon/pkg/instrumentation"
+	"phonon/pkg/model"
+	"phonon/pkg/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HeaderOutboxTopic keeps the topic a spooled message was published to, empty for the default topic of the producer
+	HeaderOutboxTopic = "outbox-topic"
+
+	defaultOutboxRelayInterval     = 5 * time.Second
+	defaultOutboxRelayBatchSize    = 100
+	defaultOutboxRelayLeaseTimeout = time.Minute
+)
+
+// FallbackProducer decorates a Producer by publishing what it fails to publish to a fallback producer,
+// typically an OutboxProducer, so callers do not fail while the broker is unavailable
+type FallbackProducer struct {
+	producer Producer
+	fallback Producer
+}
+
+// NewFallbackProducer creates a FallbackProducer
+func NewFallbackProducer(producer, fallback Producer) *FallbackProducer {
+	return &FallbackProducer{producer: producer, fallback: fallback}
+}
+
+// Publish implements the Producer interface. It only fails when both producers fail or the caller gave up.
+func (p *FallbackProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	err := p.producer.Publish(ctx, msg, opts)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	if fallbackErr := p.fallback.Publish(ctx, msg, opts); fallbackErr != nil {
+		return errors.Join(err, fallbackErr)
+	}
+
+	instrumentation.IncrementCounter(metricsNamespace, "messages_spooled")
+	logrus.WithContext(ctx).WithField("message_id", msg.ID).Warnf("spooled message after failed publish: %v", err)
+
+	return nil
+}
+
+// Depth implements the DepthReporter interface by asking the primary producer
+func (p *FallbackProducer) Depth(ctx context.Context) (int64, error) {
+	return ProducerDepth(ctx, p.producer)
+}
+
+// Close implements the Producer interface
+func (p *FallbackProducer) Close() error {
+	return errors.Join(p.producer.Close(), p.fallback.Close())
+}
+
+// OutboxProducer spools messages to the jobs table of the repository database under the outbox topic,
+// from where an OutboxRelay replays them to the broker
+type OutboxProducer struct {
+	producer *SQLProducer
+}
+
+// NewOutboxProducer creates an OutboxProducer spooling to topic
+func NewOutboxProducer(db repository.Database, topic string) (*OutboxProducer, error) {
+	producer, err := NewSQLProducer(db, SQLConfig{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxProducer{producer: producer}, nil
+}
+
+// Publish implements the Producer interface. The message topic is kept in HeaderOutboxTopic.
+// A message published within a repository transaction carried by ctx is spooled within it, so spooling cannot
+// wait for the lock the transaction holds, and the message is only replayed once the transaction commits.
+func (p *OutboxProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[HeaderOutboxTopic] = msg.Topic
+
+	msg.Headers = headers
+	msg.Topic = ""
+
+	return p.producer.Publish(ctx, msg, opts)
+}
+
+// Depth implements the DepthReporter interface with the number of spooled messages waiting to be replayed
+func (p *OutboxProducer) Depth(ctx context.Context) (int64, error) {
+	return p.producer.Depth(ctx)
+}
+
+// Close implements the Producer interface
+func (p *OutboxProducer) Close() error {
+	return p.producer.Close()
+}
+
+// OutboxRelayConfig holds configuration for the outbox relay
+type OutboxRelayConfig struct {
+	Topic        string        // Outbox topic of the jobs table
+	Interval     time.Duration // Time between two relay passes while the outbox is drained or the broker is unavailable
+	BatchSize    int           // Messages leased per pass
+	LeaseTimeout time.Duration // Time a leased message is reserved for the relay
+}
+
+func (c OutboxRelayConfig) withDefaults() OutboxRelayConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultOutboxRelayInterval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultOutboxRelayBatchSize
+	}
+	if c.LeaseTimeout <= 0 {
+		c.LeaseTimeout = defaultOutboxRelayLeaseTimeout
+	}
+
+	return c
+}
+
+// OutboxRelay replays spooled messages to the broker with their original ID, topic and options.
+// Messages are kept until they are published, however long the broker is unavailable.
+type OutboxRelay struct {
+	db       repository.Database
+	producer Producer
+	config   OutboxRelayConfig
+}
+
+// NewOutboxRelay creates an OutboxRelay publishing through producer, which must not spool to the outbox itself
+func NewOutboxRelay(db repository.Database, producer Producer, config OutboxRelayConfig) (*OutboxRelay, error) {
+	if config.Topic == "" {
+		return nil, errors.New("outbox topic is required")
+	}
+
+	return &OutboxRelay{db: db, producer: producer, config: config.withDefaults()}, nil
+}
+
+// Run relays spooled messages until ctx is done
+func (r *OutboxRelay) Run(ctx context.Context) {
+	for {
+		relayed, err := r.Relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.WithContext(ctx).Warnf("failed to relay outbox: %v", err)
+		}
+
+		if err == nil && relayed == r.config.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.config.Interval):
+		}
+	}
+}
+
+// Relay runs a single relay pass and returns the number of messages replayed.
+// The pass stops at the first failed publish, releasing the rest of the batch for a later pass.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	jobs, err := r.db.LeaseJobs(ctx, r.config.Topic, r.config.BatchSize, r.config.LeaseTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, job := range jobs {
+		if err = r.relay(ctx, job); err != nil {
+			r.release(ctx, jobs[i:], err)
+			return i, err
+		}
+
+		if err = r.db.CompleteJob(ctx, job); err != nil {
+			// the lease expires and the message is replayed again, consumers deduplicate it by ID
+			logrus.WithContext(ctx).WithField("job_id", job.ID).Errorf("failed to complete relayed job: %v", err)
+		}
+		instrumentation.IncrementCounter(metricsNamespace, "messages_relayed")
+	}
+
+	return len(jobs), nil
+}
+
+func (r *OutboxRelay) relay(ctx context.Context, job model.Job) error {
+	msg := decodeMessage(job.Payload, []byte(job.MessageKey), job.Headers)
+
+	headers := make(map[string]string, len(msg.Headers))
+	for key, value := range msg.Headers {
+		if key != HeaderOutboxTopic {
+			headers[key] = value
+		}
+	}
+	msg.Topic = msg.Headers[HeaderOutboxTopic]
+	msg.Headers = headers
+	opts := msg.Options
+	msg.Options = MessageOptions{}
+
+	return r.producer.Publish(ctx, msg, &opts)
+}
+
+// release makes the leased jobs available again to the next pass
+func (r *OutboxRelay) release(ctx context.Context, jobs []model.Job, cause error) {
+	availableAt := time.Now().Add(r.config.Interval)
+	for _, job := range jobs {
+		if err := r.db.RetryJob(ctx, job, availableAt, cause.Error()); err != nil {
+			logrus.WithContext(ctx).WithField("job_id", job.ID).Errorf("failed to release outbox job: %v", err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"phonon/pkg/model"
+	"phonon/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackProducer(t *testing.T) {
+	ctx := context.Background()
+	brokerDown := errors.New("broker down")
+	msg := Message{ID: "msg-1", Value: []byte("payload")}
+	opts := &MessageOptions{DeliveryMode: Persistent}
+
+	t.Run("publishes through the primary producer", func(t *testing.T) {
+		primary, fallback := new(MockProducer), new(MockProducer)
+		primary.On("Publish", ctx, msg, opts).Return(nil)
+
+		assert.NoError(t, NewFallbackProducer(primary, fallback).Publish(ctx, msg, opts))
+		fallback.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("spools failed publishes", func(t *testing.T) {
+		primary, fallback := new(MockProducer), new(MockProducer)
+		primary.On("Publish", ctx, msg, opts).Return(ErrCircuitOpen)
+		fallback.On("Publish", ctx, msg, opts).Return(nil)
+
+		assert.NoError(t, NewFallbackProducer(primary, fallback).Publish(ctx, msg, opts))
+		fallback.AssertExpectations(t)
+	})
+
+	t.Run("fails when both producers fail", func(t *testing.T) {
+		primary, fallback := new(MockProducer), new(MockProducer)
+		primary.On("Publish", ctx, msg, opts).Return(brokerDown)
+		fallback.On("Publish", ctx, msg, opts).Return(errors.New("database down"))
+
+		assert.ErrorIs(t, NewFallbackProducer(primary, fallback).Publish(ctx, msg, opts), brokerDown)
+	})
+
+	t.Run("does not spool when the caller gave up", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		primary, fallback := new(MockProducer), new(MockProducer)
+		primary.On("Publish", canceled, msg, opts).Return(context.Canceled)
+
+		assert.ErrorIs(t, NewFallbackProducer(primary, fallback).Publish(canceled, msg, opts), context.Canceled)
+		fallback.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+// recordingProducer collects published messages and fails while err is set
+type recordingProducer struct {
+	err       error
+	published []Message
+}
+
+func (p *recordingProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	if p.err != nil {
+		return p.err
+	}
+	if opts != nil {
+		msg.Options = *opts
+	}
+	p.published = append(p.published, msg)
+	return nil
+}
+
+func (p *recordingProducer) Close() error {
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := repository.NewSQLite(filepath.Join(t.TempDir(), "outbox.db"))
+	require.NoError(t, err)
+
+	outbox, err := NewOutboxProducer(db, "outbox")
+	require.NoError(t, err)
+
+	broker := &recordingProducer{}
+	relay, err := NewOutboxRelay(db, broker, OutboxRelayConfig{Topic: "outbox", Interval: time.Millisecond})
+	require.NoError(t, err)
+
+	timestamp := time.UnixMilli(1700000000000)
+	spooled := []Message{
+		{ID: "default-topic", Key: []byte("1"), Value: []byte("job"), Timestamp: timestamp, Headers: map[string]string{HeaderType: "audio_conversion"}},
+		{ID: "reply", Topic: "replies.instance-1", Value: []byte("reply"), Timestamp: timestamp},
+	}
+	opts := []*MessageOptions{
+		{DeliveryMode: Persistent, ContentType: ContentTypeJSON, ContentEncoding: EncodingGzip},
+		{DeliveryMode: NonPersistent, CorrelationID: "corr-1"},
+	}
+
+	for i, msg := range spooled {
+		require.NoError(t, outbox.Publish(ctx, msg, opts[i]))
+	}
+
+	depth, err := outbox.Depth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), depth)
+
+	t.Run("keeps spooled messages while the broker is unavailable", func(t *testing.T) {
+		broker.err = ErrCircuitOpen
+
+		relayed, err := relay.Relay(ctx)
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.Zero(t, relayed)
+
+		time.Sleep(5 * time.Millisecond)
+		depth, err := outbox.Depth(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), depth)
+	})
+
+	t.Run("replays messages with their topic, ID and options", func(t *testing.T) {
+		broker.err = nil
+
+		relayed, err := relay.Relay(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, relayed)
+		require.Len(t, broker.published, 2)
+
+		published := make(map[string]Message)
+		for _, msg := range broker.published {
+			published[msg.ID] = msg
+		}
+
+		job := published["default-topic"]
+		assert.Empty(t, job.Topic)
+		assert.Equal(t, []byte("1"), job.Key)
+		assert.Equal(t, []byte("job"), job.Value)
+		assert.True(t, timestamp.Equal(job.Timestamp))
+		assert.Equal(t, "audio_conversion", job.Headers[HeaderType])
+		assert.NotContains(t, job.Headers, HeaderOutboxTopic)
+		assert.Equal(t, *opts[0], job.Options)
+
+		reply := published["reply"]
+		assert.Equal(t, "replies.instance-1", reply.Topic)
+		assert.Equal(t, *opts[1], reply.Options)
+
+		depth, err := outbox.Depth(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+	})
+}
+
+func TestOutbox_SpoolsWithinTransaction(t *testing.T) {
+	db, err := repository.NewSQLite(filepath.Join(t.TempDir(), "outbox.db"))
+	require.NoError(t, err)
+
+	outbox, err := NewOutboxProducer(db, "outbox")
+	require.NoError(t, err)
+	producer := NewFallbackProducer(&recordingProducer{err: ErrCircuitOpen}, outbox)
+
+	// SQLite waits 5 seconds for a lock before failing, spooling must not wait for the transaction it runs in
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	spool := func(t *testing.T, id string) repository.Transaction {
+		tx, err := db.BeginTx(ctx)
+		require.NoError(t, err)
+		require.NoError(t, tx.SaveAudioRecord(ctx, model.AudioRecord{UserID: 1, PhraseID: 1, Status: model.AudioConversionOngoing}))
+		require.NoError(t, producer.Publish(repository.ContextWithTransaction(ctx, tx), Message{ID: id, Value: []byte("job")}, nil))
+
+		return tx
+	}
+
+	require.NoError(t, spool(t, "rolled-back").Rollback())
+	depth, err := outbox.Depth(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, depth, "messages of rolled back transactions are never replayed")
+
+	require.NoError(t, spool(t, "committed").Commit())
+	depth, err = outbox.Depth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), depth)
+}
+
+func TestNewOutboxRelay_RequiresTopic(t *testing.T) {
+	_, err := NewOutboxRelay(new(repository.MockDatabase), new(MockProducer), OutboxRelayConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// workerPool runs up to size functions concurrently, consumers use it to hand several messages to the handler at once
+type workerPool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{slots: make(chan struct{}, max(size, 1))}
+}
+
+// Go runs f once a slot is free. It reports false without running f when ctx is done first.
+func (p *workerPool) Go(ctx context.Context, f func()) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+		f()
+	}()
+
+	return true
+}
+
+// WaitFree blocks until a slot is free without taking it, so consumers only fetch what can start right away.
+// It reports false when ctx is done first.
+func (p *workerPool) WaitFree(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		<-p.slots
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Free returns the number of free slots
+func (p *workerPool) Free() int {
+	return cap(p.slots) - len(p.slots)
+}
+
+// Wait blocks until every function returned
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
@@ -2,23 +2,214 @@ package queue
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Header keys used to carry the message ID and MessageOptions alongside the payload
+const (
+	HeaderMessageID       = "message-id"
+	HeaderTimestamp       = "timestamp"
+	HeaderDeliveryMode    = "delivery-mode"
+	HeaderPriority        = "priority"
+	HeaderCorrelationID   = "correlation-id"
+	HeaderReplyTo         = "reply-to"
+	HeaderExpiration      = "expiration"
+	HeaderContentType     = "content-type"
+	HeaderContentEncoding = "content-encoding"
+	HeaderNotBefore       = "not-before"
 )
 
 // Message represents a generic message in the queue system
 type Message struct {
-	Value []byte
-	ID    string // Unique identifier for the message
+	Value     []byte
+	ID        string            // Unique identifier for the message
+	Key       []byte            // Partitioning key, messages sharing a key are delivered in order
+	Topic     string            // Topic or subject the message was consumed from, overrides the producer's topic on publish
+	Timestamp time.Time         // Time the message was published, set by the producer when zero
+	Headers   map[string]string // Headers carried with the message, including the encoded options on consume
+	Options   MessageOptions    // Options the message was published with, populated on consume
+}
+
+// Expired reports whether the message outlived its expiration at the given time
+func (m Message) Expired(now time.Time) bool {
+	ttl, err := m.Options.TTL()
+	if err != nil || ttl == 0 || m.Timestamp.IsZero() {
+		return false
+	}
+
+	return now.After(m.Timestamp.Add(ttl))
+}
+
+// Delay returns how long the message must wait at the given time before it may be delivered
+func (m Message) Delay(now time.Time) time.Duration {
+	if m.Options.NotBefore.IsZero() || !m.Options.NotBefore.After(now) {
+		return 0
+	}
+
+	return m.Options.NotBefore.Sub(now)
 }
 
 // MessageOptions defines configuration options for message publishing
 type MessageOptions struct {
-	DeliveryMode    uint8  // 1 for non-persistent, 2 for persistent
-	Priority        uint8  // Message priority (0-9)
-	CorrelationID   string // For request-reply pattern
-	ReplyTo         string // Queue name for replies
-	Expiration      string // Message expiration time
-	ContentType     string // MIME content type
-	ContentEncoding string // MIME content encoding
+	DeliveryMode    uint8     // 1 for non-persistent, 2 for persistent
+	Priority        uint8     // Message priority (0-9)
+	CorrelationID   string    // For request-reply pattern
+	ReplyTo         string    // Queue name for replies
+	Expiration      string    // Message expiration time
+	ContentType     string    // MIME content type
+	ContentEncoding string    // MIME content encoding
+	NotBefore       time.Time // Earliest delivery time for delayed messages, zero delivers immediately
+}
+
+// TTL parses Expiration, which is either a Go duration ("10m") or a number of milliseconds ("60000").
+// An empty expiration never expires and returns zero.
+func (o MessageOptions) TTL() (time.Duration, error) {
+	if o.Expiration == "" {
+		return 0, nil
+	}
+
+	if ms, err := strconv.ParseInt(o.Expiration, 10, 64); err == nil {
+		if ms < 0 {
+			return 0, fmt.Errorf("negative expiration: %s", o.Expiration)
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	ttl, err := time.ParseDuration(o.Expiration)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid expiration: %s", o.Expiration)
+	}
+
+	return ttl, nil
+}
+
+// Headers encodes the options as message headers, leaving out unset values
+func (o *MessageOptions) Headers() map[string]string {
+	headers := make(map[string]string)
+	if o == nil {
+		return headers
+	}
+
+	if o.DeliveryMode != 0 {
+		headers[HeaderDeliveryMode] = strconv.Itoa(int(o.DeliveryMode))
+	}
+	if o.Priority != 0 {
+		headers[HeaderPriority] = strconv.Itoa(int(o.Priority))
+	}
+	if !o.NotBefore.IsZero() {
+		headers[HeaderNotBefore] = formatTime(o.NotBefore)
+	}
+
+	for key, value := range map[string]string{
+		HeaderCorrelationID:   o.CorrelationID,
+		HeaderReplyTo:         o.ReplyTo,
+		HeaderExpiration:      o.Expiration,
+		HeaderContentType:     o.ContentType,
+		HeaderContentEncoding: o.ContentEncoding,
+	} {
+		if value != "" {
+			headers[key] = value
+		}
+	}
+
+	return headers
+}
+
+// ParseMessageOptions decodes the options written by MessageOptions.Headers, ignoring unknown or malformed headers
+func ParseMessageOptions(headers map[string]string) MessageOptions {
+	var opts MessageOptions
+	for key, value := range headers {
+		switch key {
+		case HeaderDeliveryMode:
+			if mode, err := strconv.ParseUint(value, 10, 8); err == nil {
+				opts.DeliveryMode = uint8(mode)
+			}
+		case HeaderPriority:
+			if priority, err := strconv.ParseUint(value, 10, 8); err == nil {
+				opts.Priority = uint8(priority)
+			}
+		case HeaderCorrelationID:
+			opts.CorrelationID = value
+		case HeaderReplyTo:
+			opts.ReplyTo = value
+		case HeaderExpiration:
+			opts.Expiration = value
+		case HeaderContentType:
+			opts.ContentType = value
+		case HeaderContentEncoding:
+			opts.ContentEncoding = value
+		case HeaderNotBefore:
+			if notBefore, ok := parseTime(value); ok {
+				opts.NotBefore = notBefore
+			}
+		}
+	}
+
+	return opts
+}
+
+// encodeHeaders merges the custom message headers, the message ID, the timestamp and the options into one header set.
+// Options take precedence over custom headers with the same key.
+func encodeHeaders(msg Message, opts *MessageOptions) map[string]string {
+	headers := make(map[string]string, len(msg.Headers))
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	for key, value := range opts.Headers() {
+		headers[key] = value
+	}
+	if msg.ID != "" {
+		headers[HeaderMessageID] = msg.ID
+	}
+	if !msg.Timestamp.IsZero() {
+		headers[HeaderTimestamp] = formatTime(msg.Timestamp)
+	}
+
+	return headers
+}
+
+// decodeMessage rebuilds a consumed Message from its payload, key and the headers written by encodeHeaders
+func decodeMessage(value, key []byte, headers map[string]string) Message {
+	msg := Message{
+		Value:   value,
+		ID:      headers[HeaderMessageID],
+		Key:     key,
+		Headers: headers,
+		Options: ParseMessageOptions(headers),
+	}
+	if timestamp, ok := parseTime(headers[HeaderTimestamp]); ok {
+		msg.Timestamp = timestamp
+	}
+
+	return msg
+}
+
+// formatTime encodes header timestamps as unix milliseconds
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func parseTime(value string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.UnixMilli(ms), true
+}
+
+// NewMessageID generates a random identifier for messages published without one
+func NewMessageID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
 }
 
 // Producer defines the interface for publishing messages to a queue
@@ -36,13 +227,30 @@ const (
 	Persistent    uint8 = 2
 )
 
+// Priority constants, higher priorities are delivered first where the backend supports it
+const (
+	PriorityNormal      uint8 = 0
+	PriorityInteractive uint8 = 5 // Work a client is waiting for
+)
+
 // ConsumerOptions defines configuration options for message consumption
 type ConsumerOptions struct {
-	BatchSize      int    // Number of messages to fetch in a batch
-	PrefetchCount  int    // Number of messages to prefetch
-	ConsumerGroup  string // Consumer group identifier
-	AutoAck        bool   // Auto acknowledge messages
-	RequeueOnError bool   // Requeue messages on error
+	BatchSize      int      // Number of messages to fetch in a batch
+	PrefetchCount  int      // Number of messages to prefetch
+	ConsumerGroup  string   // Consumer group identifier
+	AutoAck        bool     // Auto acknowledge messages
+	RequeueOnError bool     // Requeue messages on error
+	DeadLetter     Producer // Receives expired messages, which are dropped when nil
+	Retry          Producer // Parks messages not due yet, which the consumer waits for or reschedules itself when nil
+	Concurrency    int      // Messages handed to the handler concurrently, one at a time when zero
+}
+
+func (o *ConsumerOptions) concurrency() int {
+	if o == nil || o.Concurrency <= 0 {
+		return 1
+	}
+
+	return o.Concurrency
 }
 
 // Consumer defines the interface for consuming messages from a queue
@@ -53,6 +261,14 @@ type Consumer interface {
 	Close() error
 }
 
+// RetryRelay defines the interface for redelivering the messages parked on a retry producer once they are due
+type RetryRelay interface {
+	// Run relays parked messages until ctx is done
+	Run(ctx context.Context)
+	// Close shuts down the relay and its producer
+	Close() error
+}
+
 // Handler defines the interface for processing consumed messages
 type Handler interface {
 	// Handle processes a single message
@@ -40,6 +40,46 @@ func TestMessageOptions(t *testing.T) {
 	})
 }
 
+func TestMessageOptionsHeaders(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		opts := MessageOptions{
+			DeliveryMode:    Persistent,
+			Priority:        5,
+			CorrelationID:   "corr-123",
+			ReplyTo:         "reply-queue",
+			Expiration:      "3600",
+			ContentType:     "application/json",
+			ContentEncoding: "gzip",
+		}
+
+		headers := opts.Headers()
+		assert.Equal(t, "2", headers[HeaderDeliveryMode])
+		assert.Equal(t, "5", headers[HeaderPriority])
+		assert.Equal(t, opts, ParseMessageOptions(headers))
+	})
+
+	t.Run("unset values are left out", func(t *testing.T) {
+		opts := MessageOptions{ContentType: "application/json"}
+		assert.Equal(t, map[string]string{HeaderContentType: "application/json"}, opts.Headers())
+	})
+
+	t.Run("nil options", func(t *testing.T) {
+		var opts *MessageOptions
+		assert.Empty(t, opts.Headers())
+	})
+
+	t.Run("malformed numeric headers are ignored", func(t *testing.T) {
+		opts := ParseMessageOptions(map[string]string{HeaderPriority: "high", HeaderReplyTo: "replies"})
+		assert.Equal(t, MessageOptions{ReplyTo: "replies"}, opts)
+	})
+}
+
+func TestNewMessageID(t *testing.T) {
+	id := NewMessageID()
+	assert.Len(t, id, 32)
+	assert.NotEqual(t, id, NewMessageID())
+}
+
 func TestConsumerOptions(t *testing.T) {
 	t.Run("create consumer options", func(t *testing.T) {
 		opts := ConsumerOptions{
@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"phonon/pkg/instrumentation"
+	"phonon/pkg/model"
+	"phonon/pkg/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReconcileInterval    = time.Minute
+	defaultReconcileStaleAfter  = 15 * time.Minute
+	defaultReconcileMaxAttempts = 3
+	defaultReconcileBatchSize   = 100
+
+	// attemptsExhausted is the failure kind of conversions the reconciler gave up on
+	attemptsExhausted = "attempts_exhausted"
+)
+
+// ReconcilerConfig holds configuration for the conversion reconciler
+type ReconcilerConfig struct {
+	Interval    time.Duration // Time between two reconciliation passes
+	StaleAfter  time.Duration // Time a conversion may stay ongoing without progress before it is republished
+	MaxAttempts int           // Conversion attempts, including the upload, before the record is marked failed
+	BatchSize   int           // Records reconciled per pass
+}
+
+func (c ReconcilerConfig) withDefaults() ReconcilerConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultReconcileInterval
+	}
+	if c.StaleAfter <= 0 {
+		c.StaleAfter = defaultReconcileStaleAfter
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultReconcileMaxAttempts
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultReconcileBatchSize
+	}
+
+	return c
+}
+
+// Reconciler republishes conversions stuck in AudioConversionOngoing, which happens when a job is lost
+// or dead-lettered, and marks them failed once they used up their attempts
+type Reconciler struct {
+	repo            repository.Database
+	audioConversion *AudioConversion
+	config          ReconcilerConfig
+}
+
+// NewReconciler creates a Reconciler republishing through audioConversion
+func NewReconciler(repo repository.Database, audioConversion *AudioConversion, config ReconcilerConfig) *Reconciler {
+	return &Reconciler{repo: repo, audioConversion: audioConversion, config: config.withDefaults()}
+}
+
+// Run reconciles stuck conversions every interval until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reconcile(ctx); err != nil {
+				logrus.WithContext(ctx).Errorf("failed to reconcile conversions: %v", err)
+			}
+		}
+	}
+}
+
+// Reconcile runs a single reconciliation pass
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	records, err := r.repo.ListStaleConversions(ctx, time.Now().Add(-r.config.StaleAfter), r.config.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		logger := logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"user_id":   record.UserID,
+			"phrase_id": record.PhraseID,
+			"attempts":  record.ConversionAttempts,
+		})
+
+		if record.ConversionAttempts >= r.config.MaxAttempts {
+			// the reason the last attempt failed for is kept, attempts that got lost have none
+			failure := record.ConversionFailure
+			if failure.FailureKind == "" {
+				failure = model.ConversionFailure{
+					FailureKind:    attemptsExhausted,
+					FailureSummary: fmt.Sprintf("not converted after %d attempts", record.ConversionAttempts),
+				}
+			}
+			if err = r.repo.FailConversion(ctx, record.UserID, record.PhraseID, failure); err != nil {
+				logger.Errorf("failed to mark conversion failed: %v", err)
+				continue
+			}
+			instrumentation.IncrementCounter(metricsNamespace, "conversions_failed")
+			logger.Warn("conversion failed after max attempts")
+			continue
+		}
+
+		claimed, err := r.repo.ClaimConversionRetry(ctx, record)
+		if err != nil {
+			logger.Errorf("failed to claim conversion retry: %v", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		// a failed publish used up the attempt, the record becomes stale again and is retried on a later pass
+		err = r.audioConversion.PublishAudioConversionJob(ctx, model.AudioConversionMessage{
+			UserID:   record.UserID,
+			PhraseID: record.PhraseID,
+			InputURI: record.OriginalURI,
+			Attempt:  record.ConversionAttempts + 1,
+		})
+		if err != nil {
+			logger.Errorf("failed to republish conversion: %v", err)
+			continue
+		}
+
+		instrumentation.IncrementCounter(metricsNamespace, "conversions_republished")
+		logger.Info("republished stuck conversion")
+	}
+
+	return nil
+}
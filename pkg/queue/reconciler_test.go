@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"phonon/pkg/instrumentation"
+	"phonon/pkg/model"
+	"phonon/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReconciler_Reconcile(t *testing.T) {
+	ctx := context.Background()
+
+	stuck := model.AudioRecord{UserID: 1, PhraseID: 2, OriginalURI: "input/path", Status: model.AudioConversionOngoing, ConversionAttempts: 1}
+	exhausted := model.AudioRecord{UserID: 1, PhraseID: 3, OriginalURI: "input/other", Status: model.AudioConversionOngoing, ConversionAttempts: 3}
+	contended := model.AudioRecord{UserID: 1, PhraseID: 4, OriginalURI: "input/contended", Status: model.AudioConversionOngoing, ConversionAttempts: 1}
+	lastFailure := model.ConversionFailure{FailureKind: "io_error", FailureSummary: "output/path.wav: No space left on device"}
+	classified := model.AudioRecord{UserID: 1, PhraseID: 5, OriginalURI: "input/full", Status: model.AudioConversionOngoing, ConversionAttempts: 3, ConversionFailure: lastFailure}
+
+	mockRepo := new(repository.MockDatabase)
+	mockProducer := new(MockProducer)
+	ac := NewAudioConversion(new(MockAudioConverter), mockRepo, AudioConversionWithProducer(mockProducer))
+	reconciler := NewReconciler(mockRepo, ac, ReconcilerConfig{StaleAfter: time.Minute, MaxAttempts: 3, BatchSize: 10})
+
+	mockRepo.On("ListStaleConversions", ctx, mock.MatchedBy(func(before time.Time) bool {
+		return before.Before(time.Now().Add(-59 * time.Second))
+	}), 10).Return([]model.AudioRecord{stuck, exhausted, contended, classified}, nil)
+	mockRepo.On("ClaimConversionRetry", ctx, stuck).Return(true, nil)
+	mockRepo.On("ClaimConversionRetry", ctx, contended).Return(false, nil)
+	mockRepo.On("FailConversion", ctx, exhausted.UserID, exhausted.PhraseID, model.ConversionFailure{
+		FailureKind:    "attempts_exhausted",
+		FailureSummary: "not converted after 3 attempts",
+	}).Return(nil)
+	mockRepo.On("FailConversion", ctx, classified.UserID, classified.PhraseID, lastFailure).Return(nil)
+
+	republished := model.AudioConversionMessage{UserID: 1, PhraseID: 2, InputURI: "input/path", Attempt: 2}
+	mockProducer.On("Publish", ctx, conversionMessageMatcher(republished, []byte("1")), mock.Anything).Return(nil).Once()
+
+	failed := instrumentation.MetricValue(metricsNamespace, "conversions_failed")
+	assert.NoError(t, reconciler.Reconcile(ctx))
+	assert.Equal(t, failed+2, instrumentation.MetricValue(metricsNamespace, "conversions_failed"))
+
+	mockRepo.AssertExpectations(t)
+	mockProducer.AssertExpectations(t)
+}
+
+func TestReconciler_ReconcileListError(t *testing.T) {
+	mockRepo := new(repository.MockDatabase)
+	reconciler := NewReconciler(mockRepo, NewAudioConversion(new(MockAudioConverter), mockRepo), ReconcilerConfig{})
+
+	mockRepo.On("ListStaleConversions", mock.Anything, mock.Anything, defaultReconcileBatchSize).Return(nil, errors.New("database down"))
+
+	assert.Error(t, reconciler.Reconcile(context.Background()))
+}
@@ -0,0 +1,157 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRequestTimeout = 10 * time.Second
+
+var (
+	ErrRequestTimeout = errors.New("timed out waiting for reply")
+	ErrNoReplyTo      = errors.New("message has no reply-to topic")
+)
+
+// Requester implements request/reply on top of a Producer. Requests carry a correlation ID and the reply topic of
+// this instance, and the Requester doubles as the Handler of the reply topic consumer, matching replies to waiting calls.
+type Requester struct {
+	producer Producer
+	replyTo  string
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	pending map[string]chan Message
+}
+
+// NewRequester creates a Requester publishing through producer and expecting replies on replyTo.
+// A non-positive timeout defaults to 10 seconds.
+func NewRequester(producer Producer, replyTo string, timeout time.Duration) *Requester {
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+
+	return &Requester{
+		producer: producer,
+		replyTo:  replyTo,
+		timeout:  timeout,
+		pending:  make(map[string]chan Message),
+	}
+}
+
+// Call is a published request waiting for its reply
+type Call struct {
+	requester     *Requester
+	correlationID string
+	deadline      time.Time
+	reply         chan Message
+}
+
+// Send publishes a request and returns the Call to wait on. The call is registered before publishing,
+// so a reply arriving before Wait is called is not lost. Callers must Cancel calls they no longer wait for.
+func (r *Requester) Send(ctx context.Context, msg Message, opts *MessageOptions) (*Call, error) {
+	if msg.ID == "" {
+		msg.ID = NewMessageID()
+	}
+
+	var requestOpts MessageOptions
+	if opts != nil {
+		requestOpts = *opts
+	}
+	if requestOpts.CorrelationID == "" {
+		requestOpts.CorrelationID = msg.ID
+	}
+	requestOpts.ReplyTo = r.replyTo
+
+	call := &Call{
+		requester:     r,
+		correlationID: requestOpts.CorrelationID,
+		deadline:      time.Now().Add(r.timeout),
+		reply:         make(chan Message, 1),
+	}
+
+	r.mu.Lock()
+	r.pending[call.correlationID] = call.reply
+	r.mu.Unlock()
+
+	if err := r.producer.Publish(ctx, msg, &requestOpts); err != nil {
+		call.Cancel()
+		return nil, err
+	}
+
+	return call, nil
+}
+
+// Request publishes a request and waits for its reply
+func (r *Requester) Request(ctx context.Context, msg Message, opts *MessageOptions) (Message, error) {
+	call, err := r.Send(ctx, msg, opts)
+	if err != nil {
+		return Message{}, err
+	}
+	defer call.Cancel()
+
+	return call.Wait(ctx)
+}
+
+// Handle implements the Handler interface for the reply topic. Replies nobody waits for anymore are dropped.
+func (r *Requester) Handle(ctx context.Context, msg Message) error {
+	correlationID := msg.Options.CorrelationID
+
+	r.mu.Lock()
+	reply, ok := r.pending[correlationID]
+	delete(r.pending, correlationID)
+	r.mu.Unlock()
+
+	if !ok {
+		logrus.WithContext(ctx).WithField("correlation_id", correlationID).Debug("dropping uncorrelated reply")
+		return nil
+	}
+
+	reply <- msg
+
+	return nil
+}
+
+// Wait blocks until the reply arrives, the request times out or ctx is done
+func (c *Call) Wait(ctx context.Context) (Message, error) {
+	timer := time.NewTimer(time.Until(c.deadline))
+	defer timer.Stop()
+
+	select {
+	case msg := <-c.reply:
+		return msg, nil
+	case <-timer.C:
+		return Message{}, ErrRequestTimeout
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// Cancel stops waiting for the reply
+func (c *Call) Cancel() {
+	c.requester.mu.Lock()
+	delete(c.requester.pending, c.correlationID)
+	c.requester.mu.Unlock()
+}
+
+// Reply publishes a reply to the reply topic of request, correlated with it
+func Reply(ctx context.Context, producer Producer, request Message, reply Message, opts *MessageOptions) error {
+	if request.Options.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+
+	var replyOpts MessageOptions
+	if opts != nil {
+		replyOpts = *opts
+	}
+	replyOpts.CorrelationID = request.Options.CorrelationID
+	if replyOpts.CorrelationID == "" {
+		replyOpts.CorrelationID = request.ID
+	}
+	reply.Topic = request.Options.ReplyTo
+
+	return producer.Publish(ctx, reply, &replyOpts)
+}
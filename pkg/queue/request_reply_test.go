@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// loopbackProducer delivers published messages straight to a handler, decoding the options like a consumer would
+type loopbackProducer struct {
+	handler Handler
+}
+
+func (p *loopbackProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	if opts != nil {
+		msg.Options = *opts
+	}
+	return p.handler.Handle(ctx, msg)
+}
+
+func (p *loopbackProducer) Close() error {
+	return nil
+}
+
+// echoHandler replies to every request with its own payload
+type echoHandler struct {
+	replies Producer
+}
+
+func (h *echoHandler) Handle(ctx context.Context, msg Message) error {
+	return Reply(ctx, h.replies, msg, Message{Value: msg.Value}, nil)
+}
+
+func TestRequester_Request(t *testing.T) {
+	ctx := context.Background()
+
+	worker := &echoHandler{}
+	requester := NewRequester(&loopbackProducer{handler: worker}, "replies.instance", time.Second)
+	worker.replies = &loopbackProducer{handler: requester}
+
+	reply, err := requester.Request(ctx, Message{Value: []byte("ping")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ping"), reply.Value)
+	assert.Equal(t, "replies.instance", reply.Topic)
+	assert.Empty(t, requester.pending)
+}
+
+func TestRequester_Send(t *testing.T) {
+	ctx := context.Background()
+	producer := new(MockProducer)
+	requester := NewRequester(producer, "replies.instance", 50*time.Millisecond)
+
+	producer.On("Publish", ctx, mock.MatchedBy(func(msg Message) bool {
+		return msg.ID == "msg-1"
+	}), &MessageOptions{ContentType: ContentTypeJSON, CorrelationID: "msg-1", ReplyTo: "replies.instance"}).Return(nil)
+
+	t.Run("times out without reply", func(t *testing.T) {
+		call, err := requester.Send(ctx, Message{ID: "msg-1"}, &MessageOptions{ContentType: ContentTypeJSON})
+		require.NoError(t, err)
+		defer call.Cancel()
+
+		_, err = call.Wait(ctx)
+		assert.ErrorIs(t, err, ErrRequestTimeout)
+	})
+
+	t.Run("reply arriving before wait", func(t *testing.T) {
+		call, err := requester.Send(ctx, Message{ID: "msg-1"}, &MessageOptions{ContentType: ContentTypeJSON})
+		require.NoError(t, err)
+		defer call.Cancel()
+
+		require.NoError(t, requester.Handle(ctx, Message{Value: []byte("pong"), Options: MessageOptions{CorrelationID: "msg-1"}}))
+
+		reply, err := call.Wait(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("pong"), reply.Value)
+	})
+
+	t.Run("uncorrelated replies are dropped", func(t *testing.T) {
+		assert.NoError(t, requester.Handle(ctx, Message{Options: MessageOptions{CorrelationID: "unknown"}}))
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		call, err := requester.Send(ctx, Message{ID: "msg-1"}, &MessageOptions{ContentType: ContentTypeJSON})
+		require.NoError(t, err)
+		defer call.Cancel()
+
+		cancel()
+		_, err = call.Wait(cancelled)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	producer.AssertExpectations(t)
+}
+
+func TestReply(t *testing.T) {
+	ctx := context.Background()
+	producer := new(MockProducer)
+
+	producer.On("Publish", ctx, Message{Value: []byte("pong"), Topic: "replies.instance"},
+		&MessageOptions{ContentType: ContentTypeJSON, CorrelationID: "corr-1"}).Return(nil)
+
+	request := Message{ID: "msg-1", Options: MessageOptions{CorrelationID: "corr-1", ReplyTo: "replies.instance"}}
+	err := Reply(ctx, producer, request, Message{Value: []byte("pong")}, &MessageOptions{ContentType: ContentTypeJSON})
+	assert.NoError(t, err)
+	producer.AssertExpectations(t)
+
+	err = Reply(ctx, producer, Message{ID: "msg-2"}, Message{Value: []byte("pong")}, nil)
+	assert.ErrorIs(t, err, ErrNoReplyTo)
+}
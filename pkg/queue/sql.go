@@ -0,0 +1,218 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"phonon/pkg/model"
+	"phonon/pkg/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSQLBatchSize    = 10
+	defaultSQLPollInterval = time.Second
+	defaultSQLLeaseTimeout = 5 * time.Minute
+	defaultSQLMaxAttempts  = 5
+	defaultSQLRetryBackoff = 10 * time.Second
+)
+
+// SQLConfig holds configuration for the database-backed queue
+type SQLConfig struct {
+	Topic        string
+	BatchSize    int           // Number of jobs leased per poll
+	PollInterval time.Duration // Wait between polls when no job is available
+	LeaseTimeout time.Duration // Visibility timeout after which an unacknowledged job is leased again
+	MaxAttempts  int           // Attempts before a failing job is marked dead
+	RetryBackoff time.Duration // Base delay before a failed job is retried, multiplied by the attempt number
+}
+
+func (c SQLConfig) withDefaults() SQLConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultSQLBatchSize
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultSQLPollInterval
+	}
+	if c.LeaseTimeout <= 0 {
+		c.LeaseTimeout = defaultSQLLeaseTimeout
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultSQLMaxAttempts
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultSQLRetryBackoff
+	}
+
+	return c
+}
+
+// SQLProducer implements the Producer interface on top of the jobs table of the repository database
+type SQLProducer struct {
+	db    repository.Database
+	topic string
+}
+
+// NewSQLProducer creates a new database-backed producer
+func NewSQLProducer(db repository.Database, config SQLConfig) (*SQLProducer, error) {
+	if config.Topic == "" {
+		return nil, errors.New("sql queue topic is required")
+	}
+
+	return &SQLProducer{db: db, topic: config.Topic}, nil
+}
+
+// Publish implements the Producer interface. Every job is persisted, so the delivery mode is recorded but has no effect.
+// The job is enqueued within the transaction ctx carries, if any, and becomes visible once it commits.
+func (p *SQLProducer) Publish(ctx context.Context, msg Message, opts *MessageOptions) error {
+	if msg.ID == "" {
+		msg.ID = NewMessageID()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	topic := msg.Topic
+	if topic == "" {
+		topic = p.topic
+	}
+
+	job := model.Job{
+		Topic:       topic,
+		MessageID:   msg.ID,
+		MessageKey:  string(msg.Key),
+		Payload:     msg.Value,
+		Headers:     encodeHeaders(msg, opts),
+		AvailableAt: msg.Timestamp.UnixMilli(),
+	}
+
+	if opts != nil {
+		job.Priority = int(opts.Priority)
+		if opts.NotBefore.After(msg.Timestamp) {
+			job.AvailableAt = opts.NotBefore.UnixMilli()
+		}
+	}
+
+	return repository.EnqueueJob(ctx, p.db, job)
+}
+
+// Depth implements the DepthReporter interface with the number of jobs of the producer topic ready to be leased
+func (p *SQLProducer) Depth(ctx context.Context) (int64, error) {
+	return p.db.CountLeasableJobs(ctx, p.topic)
+}
+
+// Close implements the Producer interface
+func (p *SQLProducer) Close() error {
+	return nil
+}
+
+// SQLConsumer implements the Consumer interface by polling the jobs table of the repository database
+type SQLConsumer struct {
+	db     repository.Database
+	config SQLConfig
+}
+
+// NewSQLConsumer creates a new database-backed consumer
+func NewSQLConsumer(db repository.Database, config SQLConfig) (*SQLConsumer, error) {
+	if config.Topic == "" {
+		return nil, errors.New("sql queue topic is required")
+	}
+
+	return &SQLConsumer{db: db, config: config.withDefaults()}, nil
+}
+
+// Lag implements the LagReporter interface with the number of jobs ready to be leased
+func (c *SQLConsumer) Lag(ctx context.Context) (map[string]int64, error) {
+	count, err := c.db.CountLeasableJobs(ctx, c.config.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int64{c.config.Topic: count}, nil
+}
+
+// Consume implements the Consumer interface. Failed jobs are retried with a linear backoff until
+// MaxAttempts is reached, then marked dead and forwarded to the dead letter producer if one is configured.
+func (c *SQLConsumer) Consume(ctx context.Context, handler Handler, opts *ConsumerOptions) {
+	batchSize := c.config.BatchSize
+	if opts != nil && opts.BatchSize > 0 {
+		batchSize = opts.BatchSize
+	}
+
+	pool := newWorkerPool(opts.concurrency())
+	defer pool.Wait()
+
+	for {
+		// only lease what can start right away, leases are kept alive while jobs wait in the handler
+		if !pool.WaitFree(ctx) {
+			return
+		}
+
+		jobs, err := c.db.LeaseJobs(ctx, c.config.Topic, min(batchSize, pool.Free()), c.config.LeaseTimeout)
+		if err != nil && ctx.Err() == nil {
+			logrus.WithContext(ctx).Errorf("failed to lease jobs: %v", err)
+		}
+
+		// leased jobs are handled to the end, even when ctx is done meanwhile
+		handleCtx := context.WithoutCancel(ctx)
+		for _, job := range jobs {
+			pool.Go(handleCtx, func() { c.process(handleCtx, handler, job, opts) })
+		}
+
+		if len(jobs) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.config.PollInterval):
+		}
+	}
+}
+
+func (c *SQLConsumer) process(ctx context.Context, handler Handler, job model.Job, opts *ConsumerOptions) {
+	msg := decodeMessage(job.Payload, []byte(job.MessageKey), job.Headers)
+	msg.Topic = job.Topic
+	logger := logrus.WithContext(ctx).WithField("message_id", msg.ID)
+
+	stop := keepAlive(ctx, c.config.LeaseTimeout/2, func(ctx context.Context) error {
+		return c.db.ExtendJobLease(ctx, job, c.config.LeaseTimeout)
+	})
+	handleErr := deliver(ctx, handler, msg, opts)
+	stop()
+
+	if handleErr == nil {
+		if err := c.db.CompleteJob(ctx, job); err != nil {
+			logger.Errorf("failed to complete job: %v", err)
+		}
+		return
+	}
+
+	logger.Errorf("failed to handle message: %v", handleErr)
+
+	if job.Attempts < c.config.MaxAttempts {
+		availableAt := time.Now().Add(time.Duration(job.Attempts) * c.config.RetryBackoff)
+		if err := c.db.RetryJob(ctx, job, availableAt, handleErr.Error()); err != nil {
+			logger.Errorf("failed to retry job: %v", err)
+		}
+		return
+	}
+
+	if opts != nil && opts.DeadLetter != nil {
+		if err := deadLetter(ctx, opts.DeadLetter, msg, handleErr.Error()); err != nil {
+			logger.Errorf("failed to dead-letter message: %v", err)
+		}
+	}
+
+	if err := c.db.FailJob(ctx, job, handleErr.Error()); err != nil {
+		logger.Errorf("failed to fail job: %v", err)
+	}
+}
+
+// Close implements the Consumer interface
+func (c *SQLConsumer) Close() error {
+	return nil
+}
@@ -0,0 +1,270 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"phonon/pkg/model"
+	"phonon/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// barrierHandler makes every message wait until as many messages as entered was set to are handled at once
+type barrierHandler struct {
+	entered sync.WaitGroup
+	handled atomic.Int32
+}
+
+func (h *barrierHandler) Handle(ctx context.Context, msg Message) error {
+	h.entered.Done()
+	h.entered.Wait()
+	h.handled.Add(1)
+	return nil
+}
+
+// recordingHandler records handled messages and fails the first failures calls
+type recordingHandler struct {
+	mu       sync.Mutex
+	failures int
+	messages []Message
+}
+
+func (h *recordingHandler) Handle(ctx context.Context, msg Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.messages = append(h.messages, msg)
+	if len(h.messages) <= h.failures {
+		return errors.New("handler failed")
+	}
+
+	return nil
+}
+
+func (h *recordingHandler) handled() []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]Message(nil), h.messages...)
+}
+
+func TestSQLQueue(t *testing.T) {
+	db, err := repository.NewSQLite(filepath.Join(t.TempDir(), "queue.db"))
+	require.NoError(t, err)
+
+	config := SQLConfig{Topic: "audio_conversion", PollInterval: 10 * time.Millisecond, RetryBackoff: time.Millisecond, MaxAttempts: 2}
+
+	producer, err := NewSQLProducer(db, config)
+	require.NoError(t, err)
+
+	consumer, err := NewSQLConsumer(db, config)
+	require.NoError(t, err)
+
+	consume := func(t *testing.T, handler Handler, opts *ConsumerOptions, done func() bool) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			consumer.Consume(ctx, handler, opts)
+			close(stopped)
+		}()
+
+		assert.Eventually(t, done, 2*time.Second, 10*time.Millisecond)
+		cancel()
+		<-stopped
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		ctx := context.Background()
+		err := producer.Publish(ctx, Message{Value: []byte("low"), Key: []byte("1")}, &MessageOptions{Priority: 1, ContentType: "application/json"})
+		require.NoError(t, err)
+		err = producer.Publish(ctx, Message{Value: []byte("high"), ID: "high"}, &MessageOptions{Priority: 9})
+		require.NoError(t, err)
+
+		handler := &recordingHandler{}
+		consume(t, handler, nil, func() bool { return len(handler.handled()) == 2 })
+
+		handled := handler.handled()
+		assert.Equal(t, "high", handled[0].ID)
+		assert.Equal(t, []byte("low"), handled[1].Value)
+		assert.Equal(t, []byte("1"), handled[1].Key)
+		assert.Equal(t, "application/json", handled[1].Options.ContentType)
+		assert.NotEmpty(t, handled[1].ID)
+		assert.False(t, handled[1].Timestamp.IsZero())
+	})
+
+	t.Run("retries then succeeds", func(t *testing.T) {
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("retry")}, nil))
+
+		handler := &recordingHandler{failures: 1}
+		consume(t, handler, nil, func() bool { return len(handler.handled()) == 2 })
+	})
+
+	t.Run("dead-letters after max attempts", func(t *testing.T) {
+		require.NoError(t, producer.Publish(context.Background(), Message{Value: []byte("poison"), ID: "poison"}, nil))
+
+		deadLettered := make(chan struct{})
+		deadLetters := new(MockProducer)
+		deadLetters.On("Publish", mock.Anything, mock.MatchedBy(func(msg Message) bool {
+			return msg.Headers[HeaderOriginalMessageID] == "poison" && msg.Headers[HeaderDeadLetterReason] == "handler failed"
+		}), mock.Anything).Return(nil).Once().Run(func(mock.Arguments) { close(deadLettered) })
+
+		handler := &recordingHandler{failures: config.MaxAttempts}
+		consume(t, handler, &ConsumerOptions{DeadLetter: deadLetters}, func() bool {
+			select {
+			case <-deadLettered:
+				return true
+			default:
+				return false
+			}
+		})
+
+		assert.Len(t, handler.handled(), config.MaxAttempts)
+		deadLetters.AssertExpectations(t)
+	})
+
+	t.Run("delayed delivery", func(t *testing.T) {
+		ctx := context.Background()
+		notBefore := time.Now().Add(150 * time.Millisecond)
+		require.NoError(t, producer.Publish(ctx, Message{Value: []byte("delayed")}, &MessageOptions{NotBefore: notBefore}))
+
+		handler := &recordingHandler{}
+		consume(t, handler, nil, func() bool { return len(handler.handled()) == 1 })
+		assert.False(t, time.Now().Before(notBefore))
+	})
+
+	t.Run("concurrent handlers", func(t *testing.T) {
+		ctx := context.Background()
+		for i := 0; i < 3; i++ {
+			require.NoError(t, producer.Publish(ctx, Message{Value: []byte("concurrent")}, nil))
+		}
+
+		handler := &barrierHandler{}
+		handler.entered.Add(3)
+		consume(t, handler, &ConsumerOptions{Concurrency: 3}, func() bool { return handler.handled.Load() == 3 })
+	})
+
+	t.Run("leases what can start and keeps the lease", func(t *testing.T) {
+		ctx := context.Background()
+		config := SQLConfig{Topic: "lease", PollInterval: 10 * time.Millisecond, LeaseTimeout: 50 * time.Millisecond}
+		producer, err := NewSQLProducer(db, config)
+		require.NoError(t, err)
+		consumer, err := NewSQLConsumer(db, config)
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, producer.Publish(ctx, Message{Value: []byte("slow")}, nil))
+		}
+
+		handler := &blockingHandler{entered: make(chan context.Context, 2), release: make(chan struct{})}
+		consumeCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			consumer.Consume(consumeCtx, handler, nil)
+			close(stopped)
+		}()
+
+		handleCtx := <-handler.entered
+		time.Sleep(3 * config.LeaseTimeout)
+		count, err := db.CountLeasableJobs(ctx, config.Topic)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count, "the second job is not leased before a slot is free, the first keeps its lease")
+
+		cancel()
+		assert.NoError(t, handleCtx.Err(), "leased jobs are handled to the end")
+		close(handler.release)
+		<-stopped
+
+		count, err = db.CountLeasableJobs(ctx, config.Topic)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count, "the first job is completed once")
+	})
+}
+
+// blockingHandler reports the context of every message it handles and blocks until release is closed
+type blockingHandler struct {
+	entered chan context.Context
+	release chan struct{}
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, msg Message) error {
+	h.entered <- ctx
+	<-h.release
+	return nil
+}
+
+func TestSQLProducer_Publish(t *testing.T) {
+	mockRepo := new(repository.MockDatabase)
+	producer, err := NewSQLProducer(mockRepo, SQLConfig{Topic: "audio_conversion"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	notBefore := time.Now().Add(time.Hour)
+
+	mockRepo.On("EnqueueJob", ctx, mock.MatchedBy(func(job model.Job) bool {
+		return job.Topic == "audio_conversion" &&
+			job.MessageID == "msg-1" &&
+			job.Priority == 7 &&
+			job.AvailableAt == notBefore.UnixMilli() &&
+			job.Headers[HeaderPriority] == "7"
+	})).Return(nil)
+
+	err = producer.Publish(ctx, Message{ID: "msg-1", Value: []byte("payload")}, &MessageOptions{Priority: 7, NotBefore: notBefore})
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSQLProducer_PublishToMessageTopic(t *testing.T) {
+	mockRepo := new(repository.MockDatabase)
+	producer, err := NewSQLProducer(mockRepo, SQLConfig{Topic: "audio_conversion"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	mockRepo.On("EnqueueJob", ctx, mock.MatchedBy(func(job model.Job) bool {
+		return job.Topic == "audio_conversion_reply.instance"
+	})).Return(nil)
+
+	err = producer.Publish(ctx, Message{Value: []byte("reply"), Topic: "audio_conversion_reply.instance"}, nil)
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSQLConsumer_Lag(t *testing.T) {
+	mockRepo := new(repository.MockDatabase)
+	consumer, err := NewSQLConsumer(mockRepo, SQLConfig{Topic: "audio_conversion"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	mockRepo.On("CountLeasableJobs", ctx, "audio_conversion").Return(int64(4), nil)
+
+	lag, err := consumer.Lag(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"audio_conversion": 4}, lag)
+}
+
+func TestSQLProducer_Depth(t *testing.T) {
+	mockRepo := new(repository.MockDatabase)
+	producer, err := NewSQLProducer(mockRepo, SQLConfig{Topic: "audio_conversion"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	mockRepo.On("CountLeasableJobs", ctx, "audio_conversion").Return(int64(4), nil)
+
+	depth, err := producer.Depth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), depth)
+}
+
+func TestNewSQLProducer_RequiresTopic(t *testing.T) {
+	_, err := NewSQLProducer(new(repository.MockDatabase), SQLConfig{})
+	assert.Error(t, err)
+
+	_, err = NewSQLConsumer(new(repository.MockDatabase), SQLConfig{})
+	assert.Error(t, err)
+}
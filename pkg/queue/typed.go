@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+)
+
+// HeaderType names the message type used by Mux to route messages sharing a topic
+const HeaderType = "type"
+
+// TypedHandlerFunc processes a decoded message value alongside the message it was decoded from
+type TypedHandlerFunc[T any] func(ctx context.Context, value T, msg Message) error
+
+// TypedHandler implements Handler by decoding message payloads into T with the codec
+// matching the message content type before calling the handler function
+type TypedHandler[T any] struct {
+	handle TypedHandlerFunc[T]
+	codecs *Codecs
+}
+
+// NewTypedHandler creates a TypedHandler, decoding with DefaultCodecs when codecs is nil
+func NewTypedHandler[T any](handle TypedHandlerFunc[T], codecs *Codecs) *TypedHandler[T] {
+	if codecs == nil {
+		codecs = DefaultCodecs()
+	}
+
+	return &TypedHandler[T]{handle: handle, codecs: codecs}
+}
+
+// Handle implements the Handler interface
+func (h *TypedHandler[T]) Handle(ctx context.Context, msg Message) error {
+	codec, err := h.codecs.Lookup(msg.Options.ContentType)
+	if err != nil {
+		return err
+	}
+
+	var value T
+	if err = codec.Unmarshal(msg.Value, &value); err != nil {
+		return fmt.Errorf("failed to decode %s message: %w", codec.ContentType(), err)
+	}
+
+	return h.handle(ctx, value, msg)
+}
+
+// EncodeMessage encodes a value with the codec into a message tagged with its type.
+// Publish it with the codec content type so consumers pick the same codec.
+func EncodeMessage(codec Codec, messageType string, value any) (Message, error) {
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{Value: data}
+	if messageType != "" {
+		msg.Headers = map[string]string{HeaderType: messageType}
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"phonon/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTypedHandler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("decodes json", func(t *testing.T) {
+		var got model.CleanupMessage
+		handler := NewTypedHandler(func(ctx context.Context, value model.CleanupMessage, msg Message) error {
+			got = value
+			return nil
+		}, nil)
+
+		msg, err := EncodeMessage(JSONCodec{}, model.CleanupMessageType, model.CleanupMessage{URI: "a.wav"})
+		require.NoError(t, err)
+		msg.Options.ContentType = ContentTypeJSON
+
+		require.NoError(t, handler.Handle(ctx, msg))
+		assert.Equal(t, "a.wav", got.URI)
+	})
+
+	t.Run("decodes protobuf by content type", func(t *testing.T) {
+		var got string
+		handler := NewTypedHandler(func(ctx context.Context, value *wrapperspb.StringValue, msg Message) error {
+			got = value.GetValue()
+			return nil
+		}, nil)
+
+		msg, err := EncodeMessage(ProtobufCodec{}, "", wrapperspb.String("hello"))
+		require.NoError(t, err)
+		assert.Nil(t, msg.Headers)
+		msg.Options.ContentType = ContentTypeProtobuf
+
+		require.NoError(t, handler.Handle(ctx, msg))
+		assert.Equal(t, "hello", got)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		handler := NewTypedHandler(func(ctx context.Context, value model.CleanupMessage, msg Message) error {
+			return nil
+		}, nil)
+
+		assert.Error(t, handler.Handle(ctx, Message{Value: []byte("invalid json")}))
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		handler := NewTypedHandler(func(ctx context.Context, value model.CleanupMessage, msg Message) error {
+			return nil
+		}, NewCodecs(JSONCodec{}))
+
+		msg := Message{Value: []byte("{}"), Options: MessageOptions{ContentType: ContentTypeProtobuf}}
+		assert.ErrorIs(t, handler.Handle(ctx, msg), ErrUnsupportedContentType)
+	})
+
+	t.Run("handler error", func(t *testing.T) {
+		handlerErr := errors.New("handler failed")
+		handler := NewTypedHandler(func(ctx context.Context, value model.CleanupMessage, msg Message) error {
+			return handlerErr
+		}, nil)
+
+		assert.ErrorIs(t, handler.Handle(ctx, Message{Value: []byte("{}")}), handlerErr)
+	})
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"phonon/pkg/model"
+)
+
+// ErrConversionFailed is returned when a conversion is saved for a record that failed meanwhile,
+// such as one the reconciler gave up on while a late worker was still converting it
+var ErrConversionFailed = errors.New("audio conversion already failed")
+
+// saveAudioRecord inserts a record, whose UpdatedAt starts the staleness clock of its conversion and defaults to now
+func saveAudioRecord(ctx context.Context, db execQuerier, record model.AudioRecord) error {
+	updatedAt := record.UpdatedAt
+	if updatedAt == 0 {
+		updatedAt = time.Now().Unix()
+	}
+
+	query := "INSERT INTO audio_records (user_id, phrase_id, original_filename, original_format, original_file_uri, status, conversion_attempts, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := db.ExecContext(ctx, query, record.UserID, record.PhraseID, record.OriginalFilename, record.OriginalFormat, record.OriginalURI, record.Status, record.ConversionAttempts, updatedAt)
+	return err
+}
+
+// saveConvertedFormat completes the conversion of a record unless it failed meanwhile, which is not overturned.
+// The failures of earlier attempts are cleared.
+func saveConvertedFormat(ctx context.Context, db execQuerier, userID, phraseID int64, uri, profile string) error {
+	query := "UPDATE audio_records SET stored_file_uri = ?, storage_profile = ?, status = ?, failure_kind = '', failure_summary = '', updated_at = ? WHERE user_id = ? AND phrase_id = ? AND status <> ?"
+	res, err := db.ExecContext(ctx, query, uri, profile, model.AudioConversionCompleted, time.Now().Unix(), userID, phraseID, model.AudioConversionFailed)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	var status model.AudioRecordStatus
+	err = db.QueryRowContext(ctx, "SELECT status FROM audio_records WHERE user_id = ? AND phrase_id = ?", userID, phraseID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("no record found")
+	}
+	if err != nil {
+		return err
+	}
+	if status == model.AudioConversionFailed {
+		return ErrConversionFailed
+	}
+
+	// MySQL only counts rows whose values changed, a redelivered conversion may complete the record again
+	return nil
+}
+
+func listStaleConversions(ctx context.Context, db execQuerier, updatedBefore time.Time, limit int) ([]model.AudioRecord, error) {
+	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, storage_profile, status, conversion_attempts, loudness, trim_start_ms, trim_end_ms, failure_kind, failure_summary, created_at, updated_at FROM audio_records WHERE status = ? AND updated_at <= ? ORDER BY updated_at LIMIT ?"
+	rows, err := db.QueryContext(ctx, query, model.AudioConversionOngoing, updatedBefore.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []model.AudioRecord
+	for rows.Next() {
+		var rec model.AudioRecord
+		var storedURI sql.NullString
+		err = rows.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &storedURI, &rec.StorageProfile, &rec.Status, &rec.ConversionAttempts, &rec.Loudness, &rec.TrimStartMS, &rec.TrimEndMS, &rec.FailureKind, &rec.FailureSummary, &rec.CreatedAt, &rec.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rec.StoredURI = storedURI.String
+
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
+
+// claimConversionRetry counts a new attempt only if the record is unchanged since it was listed,
+// so concurrent reconcilers never republish the same conversion twice
+func claimConversionRetry(ctx context.Context, db execQuerier, record model.AudioRecord) (bool, error) {
+	query := "UPDATE audio_records SET conversion_attempts = conversion_attempts + 1, updated_at = ? WHERE user_id = ? AND phrase_id = ? AND status = ? AND conversion_attempts = ?"
+	res, err := db.ExecContext(ctx, query, time.Now().Unix(), record.UserID, record.PhraseID, model.AudioConversionOngoing, record.ConversionAttempts)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+func failConversion(ctx context.Context, db execQuerier, userID, phraseID int64, failure model.ConversionFailure) error {
+	query := "UPDATE audio_records SET status = ?, failure_kind = ?, failure_summary = ?, updated_at = ? WHERE user_id = ? AND phrase_id = ? AND status = ?"
+	_, err := db.ExecContext(ctx, query, model.AudioConversionFailed, failure.FailureKind, failure.FailureSummary, time.Now().Unix(), userID, phraseID, model.AudioConversionOngoing)
+	return err
+}
+
+// recordConversionFailure leaves updated_at alone, which keeps the staleness clock of the reconciler running
+func recordConversionFailure(ctx context.Context, db execQuerier, userID, phraseID int64, failure model.ConversionFailure) error {
+	query := "UPDATE audio_records SET failure_kind = ?, failure_summary = ? WHERE user_id = ? AND phrase_id = ? AND status = ?"
+	_, err := db.ExecContext(ctx, query, failure.FailureKind, failure.FailureSummary, userID, phraseID, model.AudioConversionOngoing)
+	return err
+}
+
+func saveAudioAnalysis(ctx context.Context, db execQuerier, userID, phraseID int64, analysis model.AudioAnalysis) error {
+	query := "UPDATE audio_records SET loudness = ?, trim_start_ms = ?, trim_end_ms = ?, updated_at = ? WHERE user_id = ? AND phrase_id = ?"
+	_, err := db.ExecContext(ctx, query, analysis.Loudness, analysis.TrimStartMS, analysis.TrimEndMS, time.Now().Unix(), userID, phraseID)
+	return err
+}
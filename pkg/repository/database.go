@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -22,8 +23,35 @@ type Transaction interface {
 	GetAudioRecord(ctx context.Context, userID, phraseID int64) (*model.AudioRecord, error)
 	// IsAudioRecordExists checks if an audio record exists for the given user and phrase within the transaction
 	IsAudioRecordExists(ctx context.Context, userID, phraseID int64) (bool, error)
-	// SaveConvertedFormat saves the converted format for a given user and phrase within the transaction
-	SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error
+	// SaveConvertedFormat saves the converted format and the name of its transcoding profile for a given user and phrase within the transaction,
+	// returning ErrConversionFailed when the record failed meanwhile
+	SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error
+	// EnqueueJob inserts a pending job into the jobs table within the transaction
+	EnqueueJob(ctx context.Context, job model.Job) error
+}
+
+type transactionKey struct{}
+
+// ContextWithTransaction returns a copy of ctx carrying tx, so jobs enqueued with it commit or roll back together with
+// the changes of tx
+func ContextWithTransaction(ctx context.Context, tx Transaction) context.Context {
+	return context.WithValue(ctx, transactionKey{}, tx)
+}
+
+// TransactionFromContext returns the transaction carried by ctx, nil when it carries none
+func TransactionFromContext(ctx context.Context) Transaction {
+	tx, _ := ctx.Value(transactionKey{}).(Transaction)
+	return tx
+}
+
+// EnqueueJob inserts a pending job within the transaction carried by ctx, or directly into db when it carries none.
+// Enqueueing beside an open transaction waits for its locks, which on SQLite never come free before it commits.
+func EnqueueJob(ctx context.Context, db Database, job model.Job) error {
+	if tx := TransactionFromContext(ctx); tx != nil {
+		return tx.EnqueueJob(ctx, job)
+	}
+
+	return db.EnqueueJob(ctx, job)
 }
 
 // Database is an interface for repository operations
@@ -36,8 +64,45 @@ type Database interface {
 	GetAudioRecord(ctx context.Context, userID, phraseID int64) (*model.AudioRecord, error)
 	// IsAudioRecordExists checks if an audio record exists for the given user and phrase
 	IsAudioRecordExists(ctx context.Context, userID, phraseID int64) (bool, error)
-	// SaveConvertedFormat saves the converted format for a given user and phrase
-	SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error
+	// SaveConvertedFormat saves the converted format and the name of its transcoding profile for a given user and phrase,
+	// returning ErrConversionFailed when the record failed meanwhile
+	SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error
+	// SaveAudioAnalysis stores the measurements of the processed conversion for a given user and phrase
+	SaveAudioAnalysis(ctx context.Context, userID, phraseID int64, analysis model.AudioAnalysis) error
+	// SaveAudioRendition records a rendition of the converted recording, replacing the rendition of the same profile
+	SaveAudioRendition(ctx context.Context, rendition model.AudioRendition) error
+	// ListAudioRenditions lists the renditions of the converted recording for a given user and phrase by profile name
+	ListAudioRenditions(ctx context.Context, userID, phraseID int64) ([]model.AudioRendition, error)
+	// EnqueueJob inserts a pending job into the jobs table
+	EnqueueJob(ctx context.Context, job model.Job) error
+	// LeaseJobs leases up to limit available jobs of a topic for the given duration, highest priority first
+	LeaseJobs(ctx context.Context, topic string, limit int, leaseFor time.Duration) ([]model.Job, error)
+	// CountLeasableJobs counts the jobs of a topic LeaseJobs would lease right now
+	CountLeasableJobs(ctx context.Context, topic string) (int64, error)
+	// ExtendJobLease leases a leased job for another leaseFor from now, while it is still being processed
+	ExtendJobLease(ctx context.Context, job model.Job, leaseFor time.Duration) error
+	// CompleteJob removes a leased job once it was processed
+	CompleteJob(ctx context.Context, job model.Job) error
+	// RetryJob releases a leased job so it can be leased again from availableAt
+	RetryJob(ctx context.Context, job model.Job, availableAt time.Time, lastError string) error
+	// FailJob marks a leased job as dead so it is never leased again
+	FailJob(ctx context.Context, job model.Job, lastError string) error
+	// ListStaleConversions lists up to limit records still converting that were not updated since updatedBefore, oldest first
+	ListStaleConversions(ctx context.Context, updatedBefore time.Time, limit int) ([]model.AudioRecord, error)
+	// ClaimConversionRetry counts another conversion attempt for a record listed by ListStaleConversions.
+	// It reports false when the record changed in the meantime, for example because another reconciler claimed it.
+	ClaimConversionRetry(ctx context.Context, record model.AudioRecord) (bool, error)
+	// FailConversion marks a record still converting as failed, recording why
+	FailConversion(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error
+	// RecordConversionFailure records why the last attempt to convert a record still converting failed, leaving it
+	// converting, so the reason is kept when the reconciler gives up on it
+	RecordConversionFailure(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error
+	// IsMessageProcessed checks if the message is recorded as processed and its entry has not expired
+	IsMessageProcessed(ctx context.Context, messageID string) (bool, error)
+	// MarkMessageProcessed records the message as processed for the given time to live
+	MarkMessageProcessed(ctx context.Context, messageID string, ttl time.Duration) error
+	// PurgeProcessedMessages removes the processed message entries expired at now and returns how many were removed
+	PurgeProcessedMessages(ctx context.Context, now time.Time) (int64, error)
 }
 
 func NewDatabase() (Database, error) {
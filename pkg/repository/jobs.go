@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"phonon/pkg/model"
+)
+
+// ErrJobLeaseLost is returned when a job is acknowledged after its lease expired and another worker leased it
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+const jobColumns = "id, topic, message_id, message_key, payload, headers, priority, status, attempts, available_at, leased_until, lease_token, last_error, created_at"
+
+// leasableJobsCondition matches pending jobs that are due and leased jobs whose lease expired.
+// It expects the topic, the pending status, the current time, the leased status and the current time as arguments.
+const leasableJobsCondition = "topic = ? AND ((status = ? AND available_at <= ?) OR (status = ? AND leased_until <= ?))"
+
+// execQuerier is satisfied by both *sql.DB and *sql.Tx
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func enqueueJob(ctx context.Context, db execQuerier, job model.Job) error {
+	headers, err := json.Marshal(job.Headers)
+	if err != nil {
+		return err
+	}
+
+	if job.AvailableAt == 0 {
+		job.AvailableAt = time.Now().UnixMilli()
+	}
+
+	query := "INSERT INTO jobs (topic, message_id, message_key, payload, headers, priority, status, attempts, available_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)"
+	_, err = db.ExecContext(ctx, query, job.Topic, job.MessageID, job.MessageKey, job.Payload, string(headers), job.Priority, model.JobPending, job.AvailableAt, time.Now().UnixMilli())
+	return err
+}
+
+// leaseArgs returns the arguments of leasableJobsCondition for the given topic and time
+func leaseArgs(topic string, now int64) []any {
+	return []any{topic, model.JobPending, now, model.JobLeased, now}
+}
+
+func selectLeasedJobs(ctx context.Context, db execQuerier, token string) ([]model.Job, error) {
+	query := "SELECT " + jobColumns + " FROM jobs WHERE lease_token = ? AND status = ? ORDER BY priority DESC, available_at, id"
+	rows, err := db.QueryContext(ctx, query, token, model.JobLeased)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []model.Job
+	for rows.Next() {
+		var job model.Job
+		var headers string
+		var lastError sql.NullString
+		err = rows.Scan(&job.ID, &job.Topic, &job.MessageID, &job.MessageKey, &job.Payload, &headers, &job.Priority, &job.Status, &job.Attempts, &job.AvailableAt, &job.LeasedUntil, &job.LeaseToken, &lastError, &job.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal([]byte(headers), &job.Headers); err != nil {
+			return nil, err
+		}
+		job.LastError = lastError.String
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+func countLeasableJobs(ctx context.Context, db execQuerier, topic string) (int64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT COUNT(*) FROM jobs WHERE "+leasableJobsCondition, leaseArgs(topic, time.Now().UnixMilli())...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int64
+	if rows.Next() {
+		if err = rows.Scan(&count); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, rows.Err()
+}
+
+func extendJobLease(ctx context.Context, db execQuerier, job model.Job, leaseFor time.Duration) error {
+	query := "UPDATE jobs SET leased_until = ? WHERE id = ? AND lease_token = ? AND status = ?"
+	res, err := db.ExecContext(ctx, query, time.Now().Add(leaseFor).UnixMilli(), job.ID, job.LeaseToken, model.JobLeased)
+	if err != nil {
+		return err
+	}
+
+	return checkLease(res)
+}
+
+func completeJob(ctx context.Context, db execQuerier, job model.Job) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM jobs WHERE id = ? AND lease_token = ?", job.ID, job.LeaseToken)
+	if err != nil {
+		return err
+	}
+
+	return checkLease(res)
+}
+
+func retryJob(ctx context.Context, db execQuerier, job model.Job, availableAt time.Time, lastError string) error {
+	query := "UPDATE jobs SET status = ?, available_at = ?, leased_until = 0, lease_token = '', last_error = ? WHERE id = ? AND lease_token = ?"
+	res, err := db.ExecContext(ctx, query, model.JobPending, availableAt.UnixMilli(), lastError, job.ID, job.LeaseToken)
+	if err != nil {
+		return err
+	}
+
+	return checkLease(res)
+}
+
+func failJob(ctx context.Context, db execQuerier, job model.Job, lastError string) error {
+	query := "UPDATE jobs SET status = ?, leased_until = 0, lease_token = '', last_error = ? WHERE id = ? AND lease_token = ?"
+	res, err := db.ExecContext(ctx, query, model.JobDead, lastError, job.ID, job.LeaseToken)
+	if err != nil {
+		return err
+	}
+
+	return checkLease(res)
+}
+
+func checkLease(res sql.Result) error {
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrJobLeaseLost
+	}
+
+	return nil
+}
+
+// newLeaseToken generates the token identifying one LeaseJobs call, so only the leasing worker can settle its jobs
+func newLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
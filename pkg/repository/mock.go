@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"phonon/pkg/model"
 
@@ -41,8 +42,13 @@ func (m *MockTransaction) IsAudioRecordExists(ctx context.Context, userID, phras
 	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockTransaction) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error {
-	args := m.Called(ctx, userID, phraseID, uri)
+func (m *MockTransaction) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error {
+	args := m.Called(ctx, userID, phraseID, uri, profile)
+	return args.Error(0)
+}
+
+func (m *MockTransaction) EnqueueJob(ctx context.Context, job model.Job) error {
+	args := m.Called(ctx, job)
 	return args.Error(0)
 }
 
@@ -77,7 +83,101 @@ func (m *MockDatabase) IsAudioRecordExists(ctx context.Context, userID, phraseID
 	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockDatabase) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error {
-	args := m.Called(ctx, userID, phraseID, uri)
+func (m *MockDatabase) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error {
+	args := m.Called(ctx, userID, phraseID, uri, profile)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) SaveAudioAnalysis(ctx context.Context, userID, phraseID int64, analysis model.AudioAnalysis) error {
+	args := m.Called(ctx, userID, phraseID, analysis)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) SaveAudioRendition(ctx context.Context, rendition model.AudioRendition) error {
+	args := m.Called(ctx, rendition)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ListAudioRenditions(ctx context.Context, userID, phraseID int64) ([]model.AudioRendition, error) {
+	args := m.Called(ctx, userID, phraseID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.AudioRendition), args.Error(1)
+}
+
+func (m *MockDatabase) EnqueueJob(ctx context.Context, job model.Job) error {
+	args := m.Called(ctx, job)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) LeaseJobs(ctx context.Context, topic string, limit int, leaseFor time.Duration) ([]model.Job, error) {
+	args := m.Called(ctx, topic, limit, leaseFor)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.Job), args.Error(1)
+}
+
+func (m *MockDatabase) ExtendJobLease(ctx context.Context, job model.Job, leaseFor time.Duration) error {
+	args := m.Called(ctx, job, leaseFor)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) CompleteJob(ctx context.Context, job model.Job) error {
+	args := m.Called(ctx, job)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) RetryJob(ctx context.Context, job model.Job, availableAt time.Time, lastError string) error {
+	args := m.Called(ctx, job, availableAt, lastError)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) CountLeasableJobs(ctx context.Context, topic string) (int64, error) {
+	args := m.Called(ctx, topic)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDatabase) FailJob(ctx context.Context, job model.Job, lastError string) error {
+	args := m.Called(ctx, job, lastError)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ListStaleConversions(ctx context.Context, updatedBefore time.Time, limit int) ([]model.AudioRecord, error) {
+	args := m.Called(ctx, updatedBefore, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.AudioRecord), args.Error(1)
+}
+
+func (m *MockDatabase) ClaimConversionRetry(ctx context.Context, record model.AudioRecord) (bool, error) {
+	args := m.Called(ctx, record)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) FailConversion(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error {
+	args := m.Called(ctx, userID, phraseID, failure)
 	return args.Error(0)
 }
+
+func (m *MockDatabase) RecordConversionFailure(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error {
+	args := m.Called(ctx, userID, phraseID, failure)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) IsMessageProcessed(ctx context.Context, messageID string) (bool, error) {
+	args := m.Called(ctx, messageID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) MarkMessageProcessed(ctx context.Context, messageID string, ttl time.Duration) error {
+	args := m.Called(ctx, messageID, ttl)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) PurgeProcessedMessages(ctx context.Context, now time.Time) (int64, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).(int64), args.Error(1)
+}
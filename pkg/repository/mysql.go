@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
+	"time"
+
 	"phonon/pkg/model"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -28,17 +31,15 @@ func (t *mysqlTx) Rollback() error {
 }
 
 func (t *mysqlTx) SaveAudioRecord(ctx context.Context, record model.AudioRecord) error {
-	query := "INSERT INTO audio_records (user_id, phrase_id, original_filename, original_format, original_file_uri, status) VALUES (?, ?, ?, ?, ?, ?)"
-	_, err := t.tx.ExecContext(ctx, query, record.UserID, record.PhraseID, record.OriginalFilename, record.OriginalFormat, record.OriginalURI, record.Status)
-	return err
+	return saveAudioRecord(ctx, t.tx, record)
 }
 
 func (t *mysqlTx) GetAudioRecord(ctx context.Context, userID, phraseID int64) (*model.AudioRecord, error) {
-	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, status, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
+	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, storage_profile, status, conversion_attempts, loudness, trim_start_ms, trim_end_ms, failure_kind, failure_summary, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
 	row := t.tx.QueryRowContext(ctx, query, userID, phraseID)
 
 	var rec model.AudioRecord
-	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &rec.StoredURI, &rec.Status, &rec.CreatedAt, &rec.UpdatedAt)
+	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &rec.StoredURI, &rec.StorageProfile, &rec.Status, &rec.ConversionAttempts, &rec.Loudness, &rec.TrimStartMS, &rec.TrimEndMS, &rec.FailureKind, &rec.FailureSummary, &rec.CreatedAt, &rec.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -59,23 +60,12 @@ func (t *mysqlTx) IsAudioRecordExists(ctx context.Context, userID, phraseID int6
 	return count > 0, nil
 }
 
-func (t *mysqlTx) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error {
-	query := "UPDATE audio_records SET stored_file_uri =?, status =? WHERE user_id =? AND phrase_id =?"
-	res, err := t.tx.ExecContext(ctx, query, uri, model.AudioConversionCompleted, userID, phraseID)
-	if err != nil {
-		return err
-	}
-
-	rows, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rows == 0 {
-		return errors.New("no record found")
-	}
+func (t *mysqlTx) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error {
+	return saveConvertedFormat(ctx, t.tx, userID, phraseID, uri, profile)
+}
 
-	return nil
+func (t *mysqlTx) EnqueueJob(ctx context.Context, job model.Job) error {
+	return enqueueJob(ctx, t.tx, job)
 }
 
 // BeginTx starts a new transaction
@@ -110,38 +100,21 @@ func (m *MySQL) IsAudioRecordExists(ctx context.Context, userID, phraseID int64)
 
 // SaveAudioRecord inserts or replaces an audio record.
 func (m *MySQL) SaveAudioRecord(ctx context.Context, record model.AudioRecord) error {
-	query := "INSERT INTO audio_records (user_id, phrase_id, original_filename, original_format, original_file_uri, status) VALUES (?, ?, ?, ?, ?, ?)"
-	_, err := m.db.ExecContext(ctx, query, record.UserID, record.PhraseID, record.OriginalFilename, record.OriginalFormat, record.OriginalURI, record.Status)
-	return err
+	return saveAudioRecord(ctx, m.db, record)
 }
 
-// SaveConvertedFormat updates the stored file URI and record status for a given user and phrase
-func (m *MySQL) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error {
-	query := "UPDATE audio_records SET stored_file_uri =?, status =? WHERE user_id =? AND phrase_id =?"
-	res, err := m.db.ExecContext(ctx, query, uri, model.AudioConversionCompleted, userID, phraseID)
-	if err != nil {
-		return err
-	}
-
-	rows, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rows == 0 {
-		return errors.New("no record found")
-	}
-
-	return nil
+// SaveConvertedFormat updates the stored file URI, the profile it was converted with and the record status for a given user and phrase
+func (m *MySQL) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error {
+	return saveConvertedFormat(ctx, m.db, userID, phraseID, uri, profile)
 }
 
 // GetAudioRecord retrieves an audio record for the given user and phrase
 func (m *MySQL) GetAudioRecord(ctx context.Context, userID, phraseID int64) (*model.AudioRecord, error) {
-	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, status, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
+	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, storage_profile, status, conversion_attempts, loudness, trim_start_ms, trim_end_ms, failure_kind, failure_summary, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
 	row := m.db.QueryRowContext(ctx, query, userID, phraseID)
 
 	var rec model.AudioRecord
-	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &rec.StoredURI, &rec.Status, &rec.CreatedAt, &rec.UpdatedAt)
+	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &rec.StoredURI, &rec.StorageProfile, &rec.Status, &rec.ConversionAttempts, &rec.Loudness, &rec.TrimStartMS, &rec.TrimEndMS, &rec.FailureKind, &rec.FailureSummary, &rec.CreatedAt, &rec.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -151,3 +124,139 @@ func (m *MySQL) GetAudioRecord(ctx context.Context, userID, phraseID int64) (*mo
 
 	return &rec, nil
 }
+
+// EnqueueJob inserts a pending job into the jobs table
+func (m *MySQL) EnqueueJob(ctx context.Context, job model.Job) error {
+	return enqueueJob(ctx, m.db, job)
+}
+
+// LeaseJobs leases up to limit available jobs of a topic. Rows locked by concurrent workers are skipped,
+// so several workers can lease from the same topic without blocking each other.
+func (m *MySQL) LeaseJobs(ctx context.Context, topic string, limit int, leaseFor time.Duration) (jobs []model.Job, err error) {
+	token, err := newLeaseToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+	query := "SELECT id FROM jobs WHERE " + leasableJobsCondition + " ORDER BY priority DESC, available_at, id LIMIT ? FOR UPDATE SKIP LOCKED"
+	rows, err := tx.QueryContext(ctx, query, append(leaseArgs(topic, now.UnixMilli()), limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []any
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, tx.Commit()
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	update := "UPDATE jobs SET status = ?, leased_until = ?, lease_token = ?, attempts = attempts + 1 WHERE id IN (" + placeholders + ")"
+	if _, err = tx.ExecContext(ctx, update, append([]any{model.JobLeased, now.Add(leaseFor).UnixMilli(), token}, ids...)...); err != nil {
+		return nil, err
+	}
+
+	if jobs, err = selectLeasedJobs(ctx, tx, token); err != nil {
+		return nil, err
+	}
+
+	return jobs, tx.Commit()
+}
+
+// CountLeasableJobs counts the jobs of a topic LeaseJobs would lease right now
+func (m *MySQL) CountLeasableJobs(ctx context.Context, topic string) (int64, error) {
+	return countLeasableJobs(ctx, m.db, topic)
+}
+
+// ExtendJobLease leases a leased job for another leaseFor from now, while it is still being processed
+func (m *MySQL) ExtendJobLease(ctx context.Context, job model.Job, leaseFor time.Duration) error {
+	return extendJobLease(ctx, m.db, job, leaseFor)
+}
+
+// CompleteJob removes a leased job once it was processed
+func (m *MySQL) CompleteJob(ctx context.Context, job model.Job) error {
+	return completeJob(ctx, m.db, job)
+}
+
+// RetryJob releases a leased job so it can be leased again from availableAt
+func (m *MySQL) RetryJob(ctx context.Context, job model.Job, availableAt time.Time, lastError string) error {
+	return retryJob(ctx, m.db, job, availableAt, lastError)
+}
+
+// FailJob marks a leased job as dead so it is never leased again
+func (m *MySQL) FailJob(ctx context.Context, job model.Job, lastError string) error {
+	return failJob(ctx, m.db, job, lastError)
+}
+
+// ListStaleConversions lists up to limit records still converting that were not updated since updatedBefore
+func (m *MySQL) ListStaleConversions(ctx context.Context, updatedBefore time.Time, limit int) ([]model.AudioRecord, error) {
+	return listStaleConversions(ctx, m.db, updatedBefore, limit)
+}
+
+// ClaimConversionRetry counts another conversion attempt unless the record changed since it was listed
+func (m *MySQL) ClaimConversionRetry(ctx context.Context, record model.AudioRecord) (bool, error) {
+	return claimConversionRetry(ctx, m.db, record)
+}
+
+// SaveAudioAnalysis stores the measurements of the processed conversion for a given user and phrase
+func (m *MySQL) SaveAudioAnalysis(ctx context.Context, userID, phraseID int64, analysis model.AudioAnalysis) error {
+	return saveAudioAnalysis(ctx, m.db, userID, phraseID, analysis)
+}
+
+// SaveAudioRendition records a rendition of the converted recording, replacing the rendition of the same profile
+func (m *MySQL) SaveAudioRendition(ctx context.Context, rendition model.AudioRendition) error {
+	return saveAudioRendition(ctx, m.db, rendition)
+}
+
+// ListAudioRenditions lists the renditions of the converted recording for a given user and phrase by profile name
+func (m *MySQL) ListAudioRenditions(ctx context.Context, userID, phraseID int64) ([]model.AudioRendition, error) {
+	return listAudioRenditions(ctx, m.db, userID, phraseID)
+}
+
+// FailConversion marks a record still converting as failed, recording why
+func (m *MySQL) FailConversion(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error {
+	return failConversion(ctx, m.db, userID, phraseID, failure)
+}
+
+// RecordConversionFailure records why the last attempt to convert a record still converting failed
+func (m *MySQL) RecordConversionFailure(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error {
+	return recordConversionFailure(ctx, m.db, userID, phraseID, failure)
+}
+
+// IsMessageProcessed checks if the message is recorded as processed and its entry has not expired
+func (m *MySQL) IsMessageProcessed(ctx context.Context, messageID string) (bool, error) {
+	return isMessageProcessed(ctx, m.db, messageID, time.Now())
+}
+
+// MarkMessageProcessed records the message as processed for the given time to live
+func (m *MySQL) MarkMessageProcessed(ctx context.Context, messageID string, ttl time.Duration) error {
+	return markMessageProcessed(ctx, m.db, messageID, ttl)
+}
+
+// PurgeProcessedMessages removes the processed message entries expired at now
+func (m *MySQL) PurgeProcessedMessages(ctx context.Context, now time.Time) (int64, error) {
+	return purgeProcessedMessages(ctx, m.db, now)
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"testing"
+	"time"
 
 	"phonon/pkg/model"
 
@@ -36,6 +37,8 @@ func TestMySQL(t *testing.T) {
 			record.OriginalFormat,
 			record.OriginalURI,
 			record.Status,
+			record.ConversionAttempts,
+			sqlmock.AnyArg(),
 		).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err := db.SaveAudioRecord(ctx, record)
@@ -43,11 +46,12 @@ func TestMySQL(t *testing.T) {
 
 		rows := sqlmock.NewRows([]string{
 			"user_id", "phrase_id", "original_filename", "original_format",
-			"original_file_uri", "stored_file_uri", "status", "created_at", "updated_at",
+			"original_file_uri", "stored_file_uri", "storage_profile", "status", "conversion_attempts",
+			"loudness", "trim_start_ms", "trim_end_ms", "failure_kind", "failure_summary", "created_at", "updated_at",
 		}).AddRow(
 			record.UserID, record.PhraseID, record.OriginalFilename,
-			record.OriginalFormat, record.OriginalURI, "", record.Status,
-			1234567890, 1234567890,
+			record.OriginalFormat, record.OriginalURI, "", "", record.Status,
+			1, -23.5, 1200, 300, "", "", 1234567890, 1234567890,
 		)
 
 		mock.ExpectQuery("SELECT .+ FROM audio_records").WithArgs(record.UserID, record.PhraseID).WillReturnRows(rows)
@@ -61,6 +65,10 @@ func TestMySQL(t *testing.T) {
 		assert.Equal(t, record.OriginalFormat, saved.OriginalFormat)
 		assert.Equal(t, record.OriginalURI, saved.OriginalURI)
 		assert.Equal(t, record.Status, saved.Status)
+		require.NotNil(t, saved.Loudness)
+		assert.Equal(t, -23.5, *saved.Loudness)
+		assert.Equal(t, int64(1200), saved.TrimStartMS)
+		assert.Equal(t, int64(300), saved.TrimEndMS)
 	})
 
 	t.Run("IsAudioRecordExists", func(t *testing.T) {
@@ -88,11 +96,30 @@ func TestMySQL(t *testing.T) {
 		convertedURI := "file:///test3.mp3"
 
 		mock.ExpectExec("UPDATE audio_records SET").WithArgs(
-			convertedURI, model.AudioConversionCompleted, userID, phraseID,
+			convertedURI, "storage_master", model.AudioConversionCompleted, sqlmock.AnyArg(), userID, phraseID, model.AudioConversionFailed,
 		).WillReturnResult(sqlmock.NewResult(0, 1))
 
-		err := db.SaveConvertedFormat(ctx, userID, phraseID, convertedURI)
+		err := db.SaveConvertedFormat(ctx, userID, phraseID, convertedURI, "storage_master")
 		require.NoError(t, err)
+
+		// a record the reconciler gave up on stays failed
+		mock.ExpectExec("UPDATE audio_records SET").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT status FROM audio_records").WithArgs(userID, phraseID).WillReturnRows(
+			sqlmock.NewRows([]string{"status"}).AddRow(model.AudioConversionFailed))
+
+		err = db.SaveConvertedFormat(ctx, userID, phraseID, convertedURI, "storage_master")
+		assert.ErrorIs(t, err, ErrConversionFailed)
+	})
+
+	t.Run("RecordConversionFailure", func(t *testing.T) {
+		ctx := context.Background()
+		failure := model.ConversionFailure{FailureKind: "io_error", FailureSummary: "No space left on device"}
+
+		mock.ExpectExec("UPDATE audio_records SET failure_kind").WithArgs(
+			failure.FailureKind, failure.FailureSummary, int64(3), int64(3), model.AudioConversionOngoing,
+		).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, db.RecordConversionFailure(ctx, 3, 3, failure))
 	})
 
 	t.Run("TransactionCommit", func(t *testing.T) {
@@ -118,6 +145,8 @@ func TestMySQL(t *testing.T) {
 			record.OriginalFormat,
 			record.OriginalURI,
 			record.Status,
+			record.ConversionAttempts,
+			sqlmock.AnyArg(),
 		).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err = tx.SaveAudioRecord(ctx, record)
@@ -152,6 +181,8 @@ func TestMySQL(t *testing.T) {
 			record.OriginalFormat,
 			record.OriginalURI,
 			record.Status,
+			record.ConversionAttempts,
+			sqlmock.AnyArg(),
 		).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err = tx.SaveAudioRecord(ctx, record)
@@ -163,5 +194,127 @@ func TestMySQL(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("AudioRenditions", func(t *testing.T) {
+		ctx := context.Background()
+		rendition := model.AudioRendition{UserID: 6, PhraseID: 6, Profile: "playback", Format: "M4A", URI: "file:///test6.playback.m4a", Size: 800, Checksum: "bb"}
+
+		mock.ExpectExec("REPLACE INTO audio_renditions").WithArgs(
+			rendition.UserID, rendition.PhraseID, rendition.Profile, rendition.Format, rendition.URI, rendition.Size, rendition.Checksum, "", "", sqlmock.AnyArg(),
+		).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		err := db.SaveAudioRendition(ctx, rendition)
+		require.NoError(t, err)
+
+		rendition.CreatedAt = 1700000000
+		mock.ExpectQuery("SELECT .+ FROM audio_renditions WHERE user_id = \\? AND phrase_id = \\? ORDER BY profile").WithArgs(rendition.UserID, rendition.PhraseID).WillReturnRows(
+			sqlmock.NewRows([]string{"user_id", "phrase_id", "profile", "format", "uri", "size", "checksum", "failure_kind", "failure_summary", "created_at"}).
+				AddRow(rendition.UserID, rendition.PhraseID, rendition.Profile, rendition.Format, rendition.URI, rendition.Size, rendition.Checksum, "", "", rendition.CreatedAt))
+
+		renditions, err := db.ListAudioRenditions(ctx, rendition.UserID, rendition.PhraseID)
+		require.NoError(t, err)
+		assert.Equal(t, []model.AudioRendition{rendition}, renditions)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLJobs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	db := &MySQL{db: mockDB}
+	ctx := context.Background()
+
+	t.Run("EnqueueJob", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO jobs").WithArgs(
+			"audio_conversion", "msg-1", "1", []byte("payload"), `{"type":"audio_conversion"}`, 5, model.JobPending, sqlmock.AnyArg(), sqlmock.AnyArg(),
+		).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		err := db.EnqueueJob(ctx, model.Job{
+			Topic:      "audio_conversion",
+			MessageID:  "msg-1",
+			MessageKey: "1",
+			Payload:    []byte("payload"),
+			Headers:    map[string]string{"type": "audio_conversion"},
+			Priority:   5,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("LeaseJobs", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM jobs WHERE .+ FOR UPDATE SKIP LOCKED").WithArgs(
+			"audio_conversion", model.JobPending, sqlmock.AnyArg(), model.JobLeased, sqlmock.AnyArg(), 10,
+		).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectExec("UPDATE jobs SET status = \\?, leased_until = \\?, lease_token = \\?, attempts = attempts \\+ 1 WHERE id IN \\(\\?, \\?\\)").WithArgs(
+			model.JobLeased, sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1), int64(2),
+		).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery("SELECT .+ FROM jobs WHERE lease_token = \\?").WithArgs(sqlmock.AnyArg(), model.JobLeased).WillReturnRows(
+			sqlmock.NewRows([]string{
+				"id", "topic", "message_id", "message_key", "payload", "headers", "priority", "status",
+				"attempts", "available_at", "leased_until", "lease_token", "last_error", "created_at",
+			}).
+				AddRow(1, "audio_conversion", "msg-1", "1", []byte("first"), "{}", 5, model.JobLeased, 1, 1, 2, "token", nil, 1).
+				AddRow(2, "audio_conversion", "msg-2", "2", []byte("second"), "null", 0, model.JobLeased, 2, 1, 2, "token", "boom", 1))
+		mock.ExpectCommit()
+
+		jobs, err := db.LeaseJobs(ctx, "audio_conversion", 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, jobs, 2)
+		assert.Equal(t, "msg-1", jobs[0].MessageID)
+		assert.Equal(t, "boom", jobs[1].LastError)
+	})
+
+	t.Run("LeaseJobsEmpty", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM jobs WHERE .+ FOR UPDATE SKIP LOCKED").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+
+		jobs, err := db.LeaseJobs(ctx, "audio_conversion", 10, time.Minute)
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+	})
+
+	t.Run("ExtendJobLease", func(t *testing.T) {
+		mock.ExpectExec("UPDATE jobs SET leased_until = \\? WHERE id = \\? AND lease_token = \\? AND status = \\?").
+			WithArgs(sqlmock.AnyArg(), int64(1), "token", model.JobLeased).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, db.ExtendJobLease(ctx, model.Job{ID: 1, LeaseToken: "token"}, time.Minute))
+	})
+
+	t.Run("CompleteJobLeaseLost", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM jobs").WithArgs(int64(1), "token").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		err := db.CompleteJob(ctx, model.Job{ID: 1, LeaseToken: "token"})
+		assert.ErrorIs(t, err, ErrJobLeaseLost)
+	})
+
+	t.Run("MarkMessageProcessed", func(t *testing.T) {
+		mock.ExpectExec("REPLACE INTO processed_messages").WithArgs("msg-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, db.MarkMessageProcessed(ctx, "msg-1", time.Hour))
+	})
+
+	t.Run("IsMessageProcessed", func(t *testing.T) {
+		mock.ExpectQuery("SELECT 1 FROM processed_messages WHERE message_id = \\? AND expires_at > \\?").WithArgs("msg-1", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+
+		processed, err := db.IsMessageProcessed(ctx, "msg-1")
+		require.NoError(t, err)
+		assert.True(t, processed)
+	})
+
+	t.Run("PurgeProcessedMessages", func(t *testing.T) {
+		now := time.Now()
+		mock.ExpectExec("DELETE FROM processed_messages WHERE expires_at <= \\?").WithArgs(now.UnixMilli()).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		purged, err := db.PurgeProcessedMessages(ctx, now)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), purged)
+	})
+
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func isMessageProcessed(ctx context.Context, db execQuerier, messageID string, now time.Time) (bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT 1 FROM processed_messages WHERE message_id = ? AND expires_at > ?", messageID, now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
+}
+
+// markMessageProcessed records the message, refreshing the expiry of an entry left by an earlier delivery.
+// REPLACE INTO is understood by both MySQL and SQLite.
+func markMessageProcessed(ctx context.Context, db execQuerier, messageID string, ttl time.Duration) error {
+	if messageID == "" {
+		return errors.New("message ID is required")
+	}
+
+	now := time.Now()
+	query := "REPLACE INTO processed_messages (message_id, processed_at, expires_at) VALUES (?, ?, ?)"
+	_, err := db.ExecContext(ctx, query, messageID, now.UnixMilli(), now.Add(ttl).UnixMilli())
+	return err
+}
+
+func purgeProcessedMessages(ctx context.Context, db execQuerier, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM processed_messages WHERE expires_at <= ?", now.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"phonon/pkg/model"
+)
+
+// saveAudioRendition records the rendition, replacing the rendition of the same profile left by an earlier attempt.
+// REPLACE INTO is understood by both MySQL and SQLite.
+func saveAudioRendition(ctx context.Context, db execQuerier, rendition model.AudioRendition) error {
+	query := "REPLACE INTO audio_renditions (user_id, phrase_id, profile, format, uri, size, checksum, failure_kind, failure_summary, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := db.ExecContext(ctx, query, rendition.UserID, rendition.PhraseID, rendition.Profile, rendition.Format, rendition.URI, rendition.Size, rendition.Checksum,
+		rendition.FailureKind, rendition.FailureSummary, time.Now().Unix())
+	return err
+}
+
+func listAudioRenditions(ctx context.Context, db execQuerier, userID, phraseID int64) ([]model.AudioRendition, error) {
+	query := "SELECT user_id, phrase_id, profile, format, uri, size, checksum, failure_kind, failure_summary, created_at FROM audio_renditions WHERE user_id = ? AND phrase_id = ? ORDER BY profile"
+	rows, err := db.QueryContext(ctx, query, userID, phraseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var renditions []model.AudioRendition
+	for rows.Next() {
+		var rendition model.AudioRendition
+		err = rows.Scan(&rendition.UserID, &rendition.PhraseID, &rendition.Profile, &rendition.Format, &rendition.URI, &rendition.Size, &rendition.Checksum,
+			&rendition.FailureKind, &rendition.FailureSummary, &rendition.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		renditions = append(renditions, rendition)
+	}
+
+	return renditions, rows.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"time"
 
 	"database/sql"
 	"errors"
@@ -51,12 +52,56 @@ func runSQLiteMigrations(db *sql.DB) error {
 			original_format VARCHAR(10),
 			original_file_uri VARCHAR(255),
 			stored_file_uri VARCHAR(255),
+			storage_profile VARCHAR(64) NOT NULL DEFAULT '',
 			status INT NOT NULL DEFAULT 0,
+			conversion_attempts INT NOT NULL DEFAULT 0,
+			loudness DOUBLE,
+			trim_start_ms BIGINT NOT NULL DEFAULT 0,
+			trim_end_ms BIGINT NOT NULL DEFAULT 0,
+			failure_kind VARCHAR(32) NOT NULL DEFAULT '',
+			failure_summary VARCHAR(512) NOT NULL DEFAULT '',
 			created_at BIGINT NOT NULL DEFAULT (strftime('%s','now')),
 			updated_at BIGINT NOT NULL DEFAULT (strftime('%s','now')),
 			PRIMARY KEY (user_id, phrase_id)
 		);
 		CREATE INDEX IF NOT EXISTS idx_audio_records_user_phrase ON audio_records(user_id, phrase_id);`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			topic VARCHAR(255) NOT NULL,
+			message_id VARCHAR(64) NOT NULL,
+			message_key VARCHAR(255) NOT NULL DEFAULT '',
+			payload BLOB NOT NULL,
+			headers TEXT NOT NULL,
+			priority INT NOT NULL DEFAULT 0,
+			status INT NOT NULL DEFAULT 0,
+			attempts INT NOT NULL DEFAULT 0,
+			available_at BIGINT NOT NULL,
+			leased_until BIGINT NOT NULL DEFAULT 0,
+			lease_token VARCHAR(64) NOT NULL DEFAULT '',
+			last_error TEXT,
+			created_at BIGINT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_jobs_lease ON jobs(topic, status, priority, available_at);
+		CREATE INDEX IF NOT EXISTS idx_jobs_lease_token ON jobs(lease_token);`,
+		`CREATE TABLE IF NOT EXISTS processed_messages (
+			message_id VARCHAR(64) NOT NULL PRIMARY KEY,
+			processed_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_processed_messages_expires_at ON processed_messages(expires_at);`,
+		`CREATE TABLE IF NOT EXISTS audio_renditions (
+			user_id BIGINT NOT NULL,
+			phrase_id BIGINT NOT NULL,
+			profile VARCHAR(64) NOT NULL,
+			format VARCHAR(10) NOT NULL,
+			uri VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			failure_kind VARCHAR(32) NOT NULL DEFAULT '',
+			failure_summary VARCHAR(512) NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (user_id, phrase_id, profile)
+		);`,
 	}
 
 	for _, ddl := range ddlStatements {
@@ -65,9 +110,63 @@ func runSQLiteMigrations(db *sql.DB) error {
 		}
 	}
 
+	// columns added after a table was first released are missing from databases created before
+	if err := addSQLiteColumn(db, "audio_records", "conversion_attempts", "INT NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_records", "storage_profile", "VARCHAR(64) NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_records", "loudness", "DOUBLE"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_records", "trim_start_ms", "BIGINT NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_records", "trim_end_ms", "BIGINT NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_records", "failure_kind", "VARCHAR(32) NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_records", "failure_summary", "VARCHAR(512) NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_renditions", "failure_kind", "VARCHAR(32) NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := addSQLiteColumn(db, "audio_renditions", "failure_summary", "VARCHAR(512) NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
 	return nil
 }
 
+// addSQLiteColumn adds a column to a table unless it already exists
+func addSQLiteColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // sqliteTx implements the Transaction interface for SQLite
 type sqliteTx struct {
 	tx *sql.Tx
@@ -82,17 +181,15 @@ func (t *sqliteTx) Rollback() error {
 }
 
 func (t *sqliteTx) SaveAudioRecord(ctx context.Context, record model.AudioRecord) error {
-	query := "INSERT INTO audio_records (user_id, phrase_id, original_filename, original_format, original_file_uri, status) VALUES (?, ?, ?, ?, ?, ?)"
-	_, err := t.tx.ExecContext(ctx, query, record.UserID, record.PhraseID, record.OriginalFilename, record.OriginalFormat, record.OriginalURI, record.Status)
-	return err
+	return saveAudioRecord(ctx, t.tx, record)
 }
 
 func (t *sqliteTx) GetAudioRecord(ctx context.Context, userID, phraseID int64) (*model.AudioRecord, error) {
-	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, status, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
+	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, storage_profile, status, conversion_attempts, loudness, trim_start_ms, trim_end_ms, failure_kind, failure_summary, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
 	row := t.tx.QueryRowContext(ctx, query, userID, phraseID)
 	var rec model.AudioRecord
 	var storedURI sql.NullString
-	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &storedURI, &rec.Status, &rec.CreatedAt, &rec.UpdatedAt)
+	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &storedURI, &rec.StorageProfile, &rec.Status, &rec.ConversionAttempts, &rec.Loudness, &rec.TrimStartMS, &rec.TrimEndMS, &rec.FailureKind, &rec.FailureSummary, &rec.CreatedAt, &rec.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -117,23 +214,12 @@ func (t *sqliteTx) IsAudioRecordExists(ctx context.Context, userID, phraseID int
 	return count > 0, nil
 }
 
-func (t *sqliteTx) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error {
-	query := "UPDATE audio_records SET stored_file_uri = ?, status = ? WHERE user_id = ? AND phrase_id = ?"
-	res, err := t.tx.ExecContext(ctx, query, uri, model.AudioConversionCompleted, userID, phraseID)
-	if err != nil {
-		return err
-	}
-
-	rows, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rows == 0 {
-		return errors.New("no record found")
-	}
+func (t *sqliteTx) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error {
+	return saveConvertedFormat(ctx, t.tx, userID, phraseID, uri, profile)
+}
 
-	return nil
+func (t *sqliteTx) EnqueueJob(ctx context.Context, job model.Job) error {
+	return enqueueJob(ctx, t.tx, job)
 }
 
 // BeginTx starts a new transaction
@@ -145,24 +231,9 @@ func (s *SQLite) BeginTx(ctx context.Context) (Transaction, error) {
 	return &sqliteTx{tx: tx}, nil
 }
 
-// SaveConvertedFormat updates the stored file URI and record status for a given user and phrase
-func (s *SQLite) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri string) error {
-	query := "UPDATE audio_records SET stored_file_uri = ?, status = ? WHERE user_id = ? AND phrase_id = ?"
-	res, err := s.db.ExecContext(ctx, query, uri, model.AudioConversionCompleted, userID, phraseID)
-	if err != nil {
-		return err
-	}
-
-	rows, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rows == 0 {
-		return errors.New("no record found")
-	}
-
-	return nil
+// SaveConvertedFormat updates the stored file URI, the profile it was converted with and the record status for a given user and phrase
+func (s *SQLite) SaveConvertedFormat(ctx context.Context, userID, phraseID int64, uri, profile string) error {
+	return saveConvertedFormat(ctx, s.db, userID, phraseID, uri, profile)
 }
 
 func (s *SQLite) IsAudioRecordExists(ctx context.Context, userID, phraseID int64) (bool, error) {
@@ -178,18 +249,16 @@ func (s *SQLite) IsAudioRecordExists(ctx context.Context, userID, phraseID int64
 
 // SaveAudioRecord inserts or replaces an audio record.
 func (s *SQLite) SaveAudioRecord(ctx context.Context, record model.AudioRecord) error {
-	query := "INSERT INTO audio_records (user_id, phrase_id, original_filename, original_format, original_file_uri, status) VALUES (?, ?, ?, ?, ?, ?)"
-	_, err := s.db.ExecContext(ctx, query, record.UserID, record.PhraseID, record.OriginalFilename, record.OriginalFormat, record.OriginalURI, record.Status)
-	return err
+	return saveAudioRecord(ctx, s.db, record)
 }
 
 // GetAudioRecord retrieves an audio record for the given user and phrase.
 func (s *SQLite) GetAudioRecord(ctx context.Context, userID, phraseID int64) (*model.AudioRecord, error) {
-	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, status, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
+	query := "SELECT user_id, phrase_id, original_filename, original_format, original_file_uri, stored_file_uri, storage_profile, status, conversion_attempts, loudness, trim_start_ms, trim_end_ms, failure_kind, failure_summary, created_at, updated_at FROM audio_records WHERE user_id = ? AND phrase_id = ?"
 	row := s.db.QueryRowContext(ctx, query, userID, phraseID)
 	var rec model.AudioRecord
 	var storedURI sql.NullString
-	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &storedURI, &rec.Status, &rec.CreatedAt, &rec.UpdatedAt)
+	err := row.Scan(&rec.UserID, &rec.PhraseID, &rec.OriginalFilename, &rec.OriginalFormat, &rec.OriginalURI, &storedURI, &rec.StorageProfile, &rec.Status, &rec.ConversionAttempts, &rec.Loudness, &rec.TrimStartMS, &rec.TrimEndMS, &rec.FailureKind, &rec.FailureSummary, &rec.CreatedAt, &rec.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -203,3 +272,101 @@ func (s *SQLite) GetAudioRecord(ctx context.Context, userID, phraseID int64) (*m
 	}
 	return &rec, nil
 }
+
+// EnqueueJob inserts a pending job into the jobs table
+func (s *SQLite) EnqueueJob(ctx context.Context, job model.Job) error {
+	return enqueueJob(ctx, s.db, job)
+}
+
+// LeaseJobs leases up to limit available jobs of a topic. SQLite serializes writers,
+// so selecting and leasing the jobs in a single UPDATE is enough to keep workers from leasing the same job.
+func (s *SQLite) LeaseJobs(ctx context.Context, topic string, limit int, leaseFor time.Duration) ([]model.Job, error) {
+	token, err := newLeaseToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	query := "UPDATE jobs SET status = ?, leased_until = ?, lease_token = ?, attempts = attempts + 1 WHERE id IN (SELECT id FROM jobs WHERE " + leasableJobsCondition + " ORDER BY priority DESC, available_at, id LIMIT ?)"
+	args := append([]any{model.JobLeased, now.Add(leaseFor).UnixMilli(), token}, leaseArgs(topic, now.UnixMilli())...)
+	if _, err = s.db.ExecContext(ctx, query, append(args, limit)...); err != nil {
+		return nil, err
+	}
+
+	return selectLeasedJobs(ctx, s.db, token)
+}
+
+// CountLeasableJobs counts the jobs of a topic LeaseJobs would lease right now
+func (s *SQLite) CountLeasableJobs(ctx context.Context, topic string) (int64, error) {
+	return countLeasableJobs(ctx, s.db, topic)
+}
+
+// ExtendJobLease leases a leased job for another leaseFor from now, while it is still being processed
+func (s *SQLite) ExtendJobLease(ctx context.Context, job model.Job, leaseFor time.Duration) error {
+	return extendJobLease(ctx, s.db, job, leaseFor)
+}
+
+// CompleteJob removes a leased job once it was processed
+func (s *SQLite) CompleteJob(ctx context.Context, job model.Job) error {
+	return completeJob(ctx, s.db, job)
+}
+
+// RetryJob releases a leased job so it can be leased again from availableAt
+func (s *SQLite) RetryJob(ctx context.Context, job model.Job, availableAt time.Time, lastError string) error {
+	return retryJob(ctx, s.db, job, availableAt, lastError)
+}
+
+// FailJob marks a leased job as dead so it is never leased again
+func (s *SQLite) FailJob(ctx context.Context, job model.Job, lastError string) error {
+	return failJob(ctx, s.db, job, lastError)
+}
+
+// ListStaleConversions lists up to limit records still converting that were not updated since updatedBefore
+func (s *SQLite) ListStaleConversions(ctx context.Context, updatedBefore time.Time, limit int) ([]model.AudioRecord, error) {
+	return listStaleConversions(ctx, s.db, updatedBefore, limit)
+}
+
+// ClaimConversionRetry counts another conversion attempt unless the record changed since it was listed
+func (s *SQLite) ClaimConversionRetry(ctx context.Context, record model.AudioRecord) (bool, error) {
+	return claimConversionRetry(ctx, s.db, record)
+}
+
+// SaveAudioAnalysis stores the measurements of the processed conversion for a given user and phrase
+func (s *SQLite) SaveAudioAnalysis(ctx context.Context, userID, phraseID int64, analysis model.AudioAnalysis) error {
+	return saveAudioAnalysis(ctx, s.db, userID, phraseID, analysis)
+}
+
+// SaveAudioRendition records a rendition of the converted recording, replacing the rendition of the same profile
+func (s *SQLite) SaveAudioRendition(ctx context.Context, rendition model.AudioRendition) error {
+	return saveAudioRendition(ctx, s.db, rendition)
+}
+
+// ListAudioRenditions lists the renditions of the converted recording for a given user and phrase by profile name
+func (s *SQLite) ListAudioRenditions(ctx context.Context, userID, phraseID int64) ([]model.AudioRendition, error) {
+	return listAudioRenditions(ctx, s.db, userID, phraseID)
+}
+
+// FailConversion marks a record still converting as failed, recording why
+func (s *SQLite) FailConversion(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error {
+	return failConversion(ctx, s.db, userID, phraseID, failure)
+}
+
+// RecordConversionFailure records why the last attempt to convert a record still converting failed
+func (s *SQLite) RecordConversionFailure(ctx context.Context, userID, phraseID int64, failure model.ConversionFailure) error {
+	return recordConversionFailure(ctx, s.db, userID, phraseID, failure)
+}
+
+// IsMessageProcessed checks if the message is recorded as processed and its entry has not expired
+func (s *SQLite) IsMessageProcessed(ctx context.Context, messageID string) (bool, error) {
+	return isMessageProcessed(ctx, s.db, messageID, time.Now())
+}
+
+// MarkMessageProcessed records the message as processed for the given time to live
+func (s *SQLite) MarkMessageProcessed(ctx context.Context, messageID string, ttl time.Duration) error {
+	return markMessageProcessed(ctx, s.db, messageID, ttl)
+}
+
+// PurgeProcessedMessages removes the processed message entries expired at now
+func (s *SQLite) PurgeProcessedMessages(ctx context.Context, now time.Time) (int64, error) {
+	return purgeProcessedMessages(ctx, s.db, now)
+}
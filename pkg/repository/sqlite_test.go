@@ -2,7 +2,10 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"phonon/pkg/model"
 
@@ -80,13 +83,23 @@ func TestSQLite(t *testing.T) {
 		require.NoError(t, err)
 
 		convertedURI := "file:///test3.mp3"
-		err = db.SaveConvertedFormat(ctx, record.UserID, record.PhraseID, convertedURI)
+		err = db.SaveConvertedFormat(ctx, record.UserID, record.PhraseID, convertedURI, "storage_master")
 		require.NoError(t, err)
 
 		saved, err := db.GetAudioRecord(ctx, record.UserID, record.PhraseID)
 		require.NoError(t, err)
 		assert.NotNil(t, saved)
 		assert.Equal(t, convertedURI, saved.StoredURI)
+		assert.Equal(t, "storage_master", saved.StorageProfile)
+		assert.Nil(t, saved.Loudness, "not measured")
+
+		loudness := -31.5
+		err = db.SaveAudioAnalysis(ctx, record.UserID, record.PhraseID, model.AudioAnalysis{Loudness: &loudness, TrimStartMS: 1200, TrimEndMS: 300})
+		require.NoError(t, err)
+
+		saved, err = db.GetAudioRecord(ctx, record.UserID, record.PhraseID)
+		require.NoError(t, err)
+		assert.Equal(t, model.AudioAnalysis{Loudness: &loudness, TrimStartMS: 1200, TrimEndMS: 300}, saved.AudioAnalysis)
 		assert.Equal(t, model.AudioConversionCompleted, saved.Status)
 	})
 
@@ -146,4 +159,304 @@ func TestSQLite(t *testing.T) {
 		require.NoError(t, err)
 		assert.Nil(t, saved)
 	})
+
+	t.Run("AudioRenditions", func(t *testing.T) {
+		ctx := context.Background()
+		master := model.AudioRendition{UserID: 6, PhraseID: 6, Profile: "storage_master", Format: "WAV", URI: "file:///test6.wav", Size: 3200, Checksum: "aa"}
+		playback := model.AudioRendition{UserID: 6, PhraseID: 6, Profile: "playback", Format: "M4A", URI: "file:///test6.playback.m4a", Size: 800, Checksum: "bb"}
+		web := model.AudioRendition{UserID: 6, PhraseID: 6, Profile: "web", Format: "OGG", ConversionFailure: model.ConversionFailure{
+			FailureKind: "unsupported_codec", FailureSummary: "Unknown encoder 'libopus'",
+		}}
+
+		renditions, err := db.ListAudioRenditions(ctx, 6, 6)
+		require.NoError(t, err)
+		assert.Empty(t, renditions)
+
+		require.NoError(t, db.SaveAudioRendition(ctx, master))
+		require.NoError(t, db.SaveAudioRendition(ctx, playback))
+		playback.Size, playback.Checksum = 900, "cc"
+		require.NoError(t, db.SaveAudioRendition(ctx, playback), "a redelivered conversion replaces its renditions")
+		require.NoError(t, db.SaveAudioRendition(ctx, web))
+
+		renditions, err = db.ListAudioRenditions(ctx, 6, 6)
+		require.NoError(t, err)
+		require.Len(t, renditions, 3)
+		for i := range renditions {
+			assert.NotZero(t, renditions[i].CreatedAt)
+			renditions[i].CreatedAt = 0
+		}
+		assert.Equal(t, []model.AudioRendition{playback, master, web}, renditions, "ordered by profile")
+		assert.True(t, renditions[2].Failed())
+	})
+}
+
+func TestSQLiteJobs(t *testing.T) {
+	db, err := NewSQLite(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	t.Run("LeaseByPriority", func(t *testing.T) {
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "priority", MessageID: "low", Payload: []byte("low"), Priority: 1}))
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "priority", MessageID: "high", Payload: []byte("high"), Priority: 9, Headers: map[string]string{"type": "audio_conversion"}}))
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "other", MessageID: "other", Payload: []byte("other")}))
+
+		jobs, err := db.LeaseJobs(ctx, "priority", 1, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "high", jobs[0].MessageID)
+		assert.Equal(t, []byte("high"), jobs[0].Payload)
+		assert.Equal(t, map[string]string{"type": "audio_conversion"}, jobs[0].Headers)
+		assert.Equal(t, model.JobLeased, jobs[0].Status)
+		assert.Equal(t, 1, jobs[0].Attempts)
+
+		count, err := db.CountLeasableJobs(ctx, "priority")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		jobs, err = db.LeaseJobs(ctx, "priority", 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "low", jobs[0].MessageID)
+
+		count, err = db.CountLeasableJobs(ctx, "priority")
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		jobs, err = db.LeaseJobs(ctx, "priority", 10, time.Minute)
+		require.NoError(t, err)
+		assert.Empty(t, jobs, "leased jobs are invisible until their lease expires")
+	})
+
+	t.Run("CompleteJob", func(t *testing.T) {
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "complete", MessageID: "job", Payload: []byte("job")}))
+
+		jobs, err := db.LeaseJobs(ctx, "complete", 1, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+
+		require.NoError(t, db.CompleteJob(ctx, jobs[0]))
+		assert.ErrorIs(t, db.CompleteJob(ctx, jobs[0]), ErrJobLeaseLost)
+	})
+
+	t.Run("ExpiredLeaseIsLeasedAgain", func(t *testing.T) {
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "visibility", MessageID: "job", Payload: []byte("job")}))
+
+		first, err := db.LeaseJobs(ctx, "visibility", 1, -time.Second)
+		require.NoError(t, err)
+		require.Len(t, first, 1)
+
+		second, err := db.LeaseJobs(ctx, "visibility", 1, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, second, 1)
+		assert.Equal(t, 2, second[0].Attempts)
+
+		assert.ErrorIs(t, db.CompleteJob(ctx, first[0]), ErrJobLeaseLost)
+		require.NoError(t, db.CompleteJob(ctx, second[0]))
+	})
+
+	t.Run("ExtendJobLease", func(t *testing.T) {
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "extend", MessageID: "job", Payload: []byte("job")}))
+
+		jobs, err := db.LeaseJobs(ctx, "extend", 1, -time.Second)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+
+		require.NoError(t, db.ExtendJobLease(ctx, jobs[0], time.Minute))
+
+		count, err := db.CountLeasableJobs(ctx, "extend")
+		require.NoError(t, err)
+		assert.Zero(t, count, "the extended lease hides the job again")
+
+		require.NoError(t, db.CompleteJob(ctx, jobs[0]))
+		assert.ErrorIs(t, db.ExtendJobLease(ctx, jobs[0], time.Minute), ErrJobLeaseLost)
+	})
+
+	t.Run("RetryJob", func(t *testing.T) {
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "retry", MessageID: "job", Payload: []byte("job")}))
+
+		jobs, err := db.LeaseJobs(ctx, "retry", 1, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+
+		require.NoError(t, db.RetryJob(ctx, jobs[0], time.Now().Add(time.Hour), "boom"))
+
+		jobs, err = db.LeaseJobs(ctx, "retry", 1, time.Minute)
+		require.NoError(t, err)
+		assert.Empty(t, jobs, "retried jobs wait until they are available again")
+	})
+
+	t.Run("DelayedJob", func(t *testing.T) {
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "delayed", MessageID: "job", Payload: []byte("job"), AvailableAt: time.Now().Add(time.Hour).UnixMilli()}))
+
+		jobs, err := db.LeaseJobs(ctx, "delayed", 1, time.Minute)
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+	})
+
+	t.Run("FailJob", func(t *testing.T) {
+		require.NoError(t, db.EnqueueJob(ctx, model.Job{Topic: "fail", MessageID: "job", Payload: []byte("job")}))
+
+		jobs, err := db.LeaseJobs(ctx, "fail", 1, -time.Second)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+
+		require.NoError(t, db.FailJob(ctx, jobs[0], "boom"))
+
+		jobs, err = db.LeaseJobs(ctx, "fail", 1, time.Minute)
+		require.NoError(t, err)
+		assert.Empty(t, jobs, "dead jobs are never leased again")
+	})
+}
+
+func TestSQLiteProcessedMessages(t *testing.T) {
+	db, err := NewSQLite(filepath.Join(t.TempDir(), "processed.db"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	processed, err := db.IsMessageProcessed(ctx, "msg-1")
+	require.NoError(t, err)
+	assert.False(t, processed)
+
+	require.NoError(t, db.MarkMessageProcessed(ctx, "msg-1", time.Hour))
+	require.NoError(t, db.MarkMessageProcessed(ctx, "msg-1", time.Hour), "marking twice refreshes the entry")
+	require.NoError(t, db.MarkMessageProcessed(ctx, "msg-2", -time.Second))
+
+	processed, err = db.IsMessageProcessed(ctx, "msg-1")
+	require.NoError(t, err)
+	assert.True(t, processed)
+
+	processed, err = db.IsMessageProcessed(ctx, "msg-2")
+	require.NoError(t, err)
+	assert.False(t, processed, "expired entries no longer count")
+
+	purged, err := db.PurgeProcessedMessages(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	processed, err = db.IsMessageProcessed(ctx, "msg-1")
+	require.NoError(t, err)
+	assert.True(t, processed)
+}
+
+func TestSQLiteStaleConversions(t *testing.T) {
+	db, err := NewSQLite(filepath.Join(t.TempDir(), "conversions.db"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	require.NoError(t, db.SaveAudioRecord(ctx, model.AudioRecord{UserID: 1, PhraseID: 1, OriginalURI: "file:///1.m4a", Status: model.AudioConversionOngoing, ConversionAttempts: 1}))
+	require.NoError(t, db.SaveAudioRecord(ctx, model.AudioRecord{UserID: 1, PhraseID: 2, OriginalURI: "file:///2.m4a", Status: model.AudioConversionCompleted, ConversionAttempts: 1}))
+
+	records, err := db.ListStaleConversions(ctx, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, records, "recently updated conversions are not stale")
+
+	records, err = db.ListStaleConversions(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, int64(1), records[0].PhraseID)
+	assert.Equal(t, 1, records[0].ConversionAttempts)
+
+	claimed, err := db.ClaimConversionRetry(ctx, records[0])
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = db.ClaimConversionRetry(ctx, records[0])
+	require.NoError(t, err)
+	assert.False(t, claimed, "a record is claimed once per attempt")
+
+	record, err := db.GetAudioRecord(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, record.ConversionAttempts)
+
+	lastFailure := model.ConversionFailure{FailureKind: "io_error", FailureSummary: "output/path.wav: No space left on device"}
+	require.NoError(t, db.RecordConversionFailure(ctx, 1, 1, lastFailure))
+	records, err = db.ListStaleConversions(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, model.AudioConversionOngoing, records[0].Status, "a failed attempt leaves the record converting")
+	assert.Equal(t, lastFailure, records[0].ConversionFailure)
+
+	failure := model.ConversionFailure{FailureKind: "corrupt_input", FailureSummary: "Invalid data found when processing input"}
+	require.NoError(t, db.FailConversion(ctx, 1, 1, failure))
+	require.NoError(t, db.FailConversion(ctx, 1, 2, failure))
+
+	record, err = db.GetAudioRecord(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, model.AudioConversionFailed, record.Status)
+	assert.Equal(t, failure, record.ConversionFailure)
+
+	record, err = db.GetAudioRecord(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, model.AudioConversionCompleted, record.Status, "completed conversions never fail")
+	assert.Empty(t, record.FailureKind)
+
+	err = db.SaveConvertedFormat(ctx, 1, 1, "file:///1.wav", "storage_master")
+	assert.ErrorIs(t, err, ErrConversionFailed, "a late worker does not complete a failed conversion")
+	record, err = db.GetAudioRecord(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, model.AudioConversionFailed, record.Status)
+	assert.Equal(t, failure, record.ConversionFailure)
+	assert.Empty(t, record.StoredURI)
+}
+
+func TestSQLiteStaleConversions_DueLater(t *testing.T) {
+	db, err := NewSQLite(filepath.Join(t.TempDir(), "conversions.db"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	due := time.Now().Add(5 * time.Minute)
+	require.NoError(t, db.SaveAudioRecord(ctx, model.AudioRecord{
+		UserID: 1, PhraseID: 1, OriginalURI: "file:///1.m4a", Status: model.AudioConversionOngoing, ConversionAttempts: 1, UpdatedAt: due.Unix(),
+	}))
+	require.NoError(t, db.RecordConversionFailure(ctx, 1, 1, model.ConversionFailure{FailureKind: "io_error", FailureSummary: "No space left on device"}))
+
+	records, err := db.ListStaleConversions(ctx, time.Now().Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Empty(t, records, "deferred conversions are not stale before they are due")
+
+	require.NoError(t, db.SaveConvertedFormat(ctx, 1, 1, "file:///1.wav", "storage_master"))
+	record, err := db.GetAudioRecord(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, model.AudioConversionCompleted, record.Status)
+	assert.Less(t, record.UpdatedAt, due.Unix(), "completing the conversion updates the record")
+	assert.Empty(t, record.ConversionFailure, "the failures of earlier attempts are cleared")
+}
+
+func TestSQLiteMigrationAddsColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	legacy, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = legacy.Exec(`CREATE TABLE audio_records (
+		user_id BIGINT NOT NULL,
+		phrase_id BIGINT NOT NULL,
+		original_filename VARCHAR(255),
+		original_format VARCHAR(10),
+		original_file_uri VARCHAR(255),
+		stored_file_uri VARCHAR(255),
+		status INT NOT NULL DEFAULT 0,
+		created_at BIGINT NOT NULL DEFAULT (strftime('%s','now')),
+		updated_at BIGINT NOT NULL DEFAULT (strftime('%s','now')),
+		PRIMARY KEY (user_id, phrase_id)
+	)`)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	db, err := NewSQLite(path)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, db.SaveAudioRecord(ctx, model.AudioRecord{UserID: 1, PhraseID: 1, ConversionAttempts: 1}))
+
+	record, err := db.GetAudioRecord(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.ConversionAttempts)
+	assert.Empty(t, record.StorageProfile)
+	assert.Empty(t, record.ConversionFailure)
+
+	_, err = NewSQLite(path)
+	assert.NoError(t, err, "migrations run again on an up to date database")
 }
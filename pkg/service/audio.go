@@ -1,8 +1,17 @@
 package service
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
 
 	"phonon/pkg/converter"
 	pkgerrors "phonon/pkg/errors"
@@ -17,7 +26,11 @@ import (
 // Audio defines methods for storing and retrieving audio.
 type Audio interface {
 	StoreAudio(ctx context.Context, userID int64, phraseID int64, file io.Reader, filename string) error
-	FetchAudio(ctx context.Context, userID int64, phraseID int64, targetFormat string) (string, error)
+	StoreAudioAndWait(ctx context.Context, userID int64, phraseID int64, file io.Reader, filename string) (bool, error)
+	StoreAudioDeferred(ctx context.Context, userID int64, phraseID int64, file io.Reader, filename string, delay time.Duration) error
+	FetchAudio(ctx context.Context, userID int64, phraseID int64, targetFormat string, accept string) (string, error)
+	FetchWaveform(ctx context.Context, userID int64, phraseID int64, samplesPerPixel int) (*converter.Waveform, error)
+	FetchSpectrogram(ctx context.Context, userID int64, phraseID int64, config converter.SpectrogramConfig) (io.ReadCloser, error)
 }
 
 // audioServiceImpl is the implementation of AudioService.
@@ -25,19 +38,70 @@ type audioServiceImpl struct {
 	repo       repository.Database
 	fileStore  storage.File
 	background *queue.AudioConversion
+	formats    *converter.Formats
+	waveforms  *converter.Waveforms
 }
 
-// NewAudioService creates a new AudioService instance.
-func NewAudioService(repo repository.Database, fileStore storage.File, background *queue.AudioConversion) Audio {
+// NewAudioService creates a new AudioService instance accepting uploads of the given formats.
+// Waveforms are served from waveforms, nil when the worker does not compute them.
+func NewAudioService(repo repository.Database, fileStore storage.File, background *queue.AudioConversion, formats *converter.Formats,
+	waveforms *converter.Waveforms) Audio {
 	return &audioServiceImpl{
 		repo:       repo,
 		fileStore:  fileStore,
 		background: background,
+		formats:    formats,
+		waveforms:  waveforms,
 	}
 }
 
 // StoreAudio converts the input audio to the desired storage format and saves it.
 func (s *audioServiceImpl) StoreAudio(ctx context.Context, userID, phraseID int64, file io.Reader, filename string) error {
+	return s.storeAudio(ctx, userID, phraseID, file, filename, s.background.PublishAudioConversionJob, 0)
+}
+
+// StoreAudioAndWait saves the audio like StoreAudio and waits for the conversion to finish.
+// It reports whether the conversion completed before the request timed out, the conversion goes on in the background otherwise.
+func (s *audioServiceImpl) StoreAudioAndWait(ctx context.Context, userID, phraseID int64, file io.Reader, filename string) (bool, error) {
+	var call *queue.Call
+	publish := func(ctx context.Context, conversionMessage model.AudioConversionMessage) error {
+		var err error
+		call, err = s.background.RequestAudioConversionJob(ctx, conversionMessage)
+		if errors.Is(err, queue.ErrNoRequester) {
+			return s.background.PublishAudioConversionJob(ctx, conversionMessage)
+		}
+		return err
+	}
+
+	if err := s.storeAudio(ctx, userID, phraseID, file, filename, publish, 0); err != nil {
+		return false, err
+	}
+	if call == nil {
+		return false, nil
+	}
+	defer call.Cancel()
+
+	if _, err := call.Wait(ctx); err != nil {
+		logrus.WithError(err).Info("audio conversion did not complete in time")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// StoreAudioDeferred saves the audio like StoreAudio but does not start the conversion before delay elapsed,
+// which relieves a conversion backlog without turning the upload away.
+func (s *audioServiceImpl) StoreAudioDeferred(ctx context.Context, userID, phraseID int64, file io.Reader, filename string, delay time.Duration) error {
+	publish := func(ctx context.Context, conversionMessage model.AudioConversionMessage) error {
+		return s.background.DeferAudioConversionJob(ctx, conversionMessage, delay)
+	}
+
+	return s.storeAudio(ctx, userID, phraseID, file, filename, publish, delay)
+}
+
+// storeAudio saves the upload and publishes its conversion job with publish, due after delay
+func (s *audioServiceImpl) storeAudio(ctx context.Context, userID, phraseID int64, file io.Reader, filename string,
+	publish func(ctx context.Context, conversionMessage model.AudioConversionMessage) error, delay time.Duration) error {
 	tx, err := s.repo.BeginTx(ctx)
 	if err != nil {
 		logrus.Error("failed to begin transaction", logrus.WithError(err))
@@ -62,11 +126,24 @@ func (s *audioServiceImpl) StoreAudio(ctx context.Context, userID, phraseID int6
 	}
 
 	fileFormat := storage.ExtractFileFormat(filename)
-	if !converter.IsValidAudioFormat(fileFormat) {
+	format, err := s.formats.Lookup(fileFormat)
+	if err != nil {
 		return pkgerrors.ErrInvalidInput
 	}
 
-	uri, err := s.fileStore.Save(ctx, userID, phraseID, file, fileFormat)
+	// the content must match the extension, so ffmpeg is never handed a mislabeled file
+	reader := bufio.NewReaderSize(file, s.formats.HeaderLength())
+	header, err := reader.Peek(s.formats.HeaderLength())
+	if err != nil && !errors.Is(err, io.EOF) {
+		logrus.Error("failed to read audio file", logrus.WithError(err))
+		return pkgerrors.ErrInvalidInput
+	}
+	if !format.Matches(header) {
+		err = pkgerrors.ErrInvalidInput
+		return err // err is set so the transaction is rolled back
+	}
+
+	uri, err := s.fileStore.Save(ctx, userID, phraseID, reader, fileFormat)
 	if err != nil {
 		logrus.Error("failed to save audio file", logrus.WithError(err))
 		return pkgerrors.ErrDatabaseOperation
@@ -76,21 +153,25 @@ func (s *audioServiceImpl) StoreAudio(ctx context.Context, userID, phraseID int6
 		UserID:   userID,
 		PhraseID: phraseID,
 		InputURI: uri,
+		Attempt:  1,
 	}
 
-	// conversion is done async to offload
-	if err = s.background.PublishAudioConversionJob(ctx, conversionMessage); err != nil {
+	// conversion is done async to offload; a job queued in the database commits with the record
+	if err = publish(repository.ContextWithTransaction(ctx, tx), conversionMessage); err != nil {
 		logrus.Error("failed to publish audio conversion job", logrus.WithError(err))
 		return pkgerrors.ErrAudioConversionFailed
 	}
 
 	record := model.AudioRecord{
-		UserID:           userID,
-		PhraseID:         phraseID,
-		Status:           model.AudioConversionOngoing,
-		OriginalFilename: filename,
-		OriginalFormat:   fileFormat,
-		OriginalURI:      uri,
+		UserID:             userID,
+		PhraseID:           phraseID,
+		Status:             model.AudioConversionOngoing,
+		OriginalFilename:   filename,
+		OriginalFormat:     fileFormat,
+		OriginalURI:        uri,
+		ConversionAttempts: 1,
+		// the reconciler only takes the conversion for stuck once its job was due for a while
+		UpdatedAt: time.Now().Add(delay).Unix(),
 	}
 
 	err = tx.SaveAudioRecord(ctx, record)
@@ -107,8 +188,126 @@ func (s *audioServiceImpl) StoreAudio(ctx context.Context, userID, phraseID int6
 	return nil
 }
 
-// FetchAudio retrieves the audio file for the given user and phrase, and converts it if needed.
-func (s *audioServiceImpl) FetchAudio(ctx context.Context, userID, phraseID int64, targetFormat string) (string, error) {
+// FetchWaveform retrieves the waveform of the converted audio for the given user and phrase at the stored resolution
+// closest to samplesPerPixel.
+func (s *audioServiceImpl) FetchWaveform(ctx context.Context, userID, phraseID int64, samplesPerPixel int) (*converter.Waveform, error) {
+	if s.waveforms == nil {
+		return nil, pkgerrors.ErrNotFound
+	}
+
+	record, err := s.repo.GetAudioRecord(ctx, userID, phraseID)
+	if err != nil {
+		logrus.Error("failed to fetch audio record", logrus.WithError(err))
+		return nil, pkgerrors.ErrDatabaseOperation
+	}
+	if record == nil {
+		return nil, pkgerrors.ErrNotFound
+	}
+
+	if record.Status == model.AudioConversionFailed {
+		return nil, pkgerrors.ErrAudioConversionFailed
+	}
+
+	if record.Status != model.AudioConversionCompleted {
+		return nil, pkgerrors.ErrAudioProcessingInProgress
+	}
+
+	waveform, err := s.waveforms.Load(record.StoredURI, samplesPerPixel)
+	if errors.Is(err, fs.ErrNotExist) {
+		// recordings converted before waveforms were enabled, or whose waveform failed, have none
+		return nil, pkgerrors.ErrNotFound
+	}
+	if err != nil {
+		logrus.Error("failed to load waveform", logrus.WithError(err))
+		return nil, pkgerrors.ErrStorageOperation
+	}
+
+	return waveform, nil
+}
+
+// FetchSpectrogram returns the spectrogram PNG of the converted audio for the given user and phrase, rendered until ctx
+// is done. Spectrograms of the configured settings, or of their variants converter.SpectrogramConfig.Cacheable accepts,
+// are rendered on the first request and cached in the file store. A conversion replacing the audio invalidates them,
+// and the worker removes them.
+func (s *audioServiceImpl) FetchSpectrogram(ctx context.Context, userID, phraseID int64, config converter.SpectrogramConfig) (io.ReadCloser, error) {
+	if err := config.Validate(); err != nil {
+		return nil, pkgerrors.ErrInvalidInput
+	}
+
+	record, err := s.repo.GetAudioRecord(ctx, userID, phraseID)
+	if err != nil {
+		logrus.Error("failed to fetch audio record", logrus.WithError(err))
+		return nil, pkgerrors.ErrDatabaseOperation
+	}
+	if record == nil {
+		return nil, pkgerrors.ErrNotFound
+	}
+
+	if record.Status == model.AudioConversionFailed {
+		return nil, pkgerrors.ErrAudioConversionFailed
+	}
+
+	if record.Status != model.AudioConversionCompleted {
+		return nil, pkgerrors.ErrAudioProcessingInProgress
+	}
+
+	format, err := s.formats.Lookup(filepath.Ext(record.StoredURI))
+	if err != nil || format.Name != converter.WAV {
+		return nil, pkgerrors.ErrInvalidAudioFormat
+	}
+
+	info, err := os.Stat(record.StoredURI)
+	if err != nil {
+		logrus.Error("failed to stat converted audio", logrus.WithError(err))
+		return nil, pkgerrors.ErrStorageOperation
+	}
+	name := fmt.Sprintf("%s%s-%x.png", converter.SpectrogramArtifactPrefix, config.Key(), info.ModTime().UnixNano())
+	cacheable := config.Cacheable(converter.ConfiguredSpectrogram())
+
+	if cacheable {
+		cached, err := s.fileStore.OpenArtifact(ctx, userID, phraseID, name)
+		if err == nil {
+			return cached, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			logrus.Error("failed to open cached spectrogram", logrus.WithError(err))
+			return nil, pkgerrors.ErrStorageOperation
+		}
+	}
+
+	input, err := os.Open(record.StoredURI)
+	if err != nil {
+		logrus.Error("failed to open converted audio", logrus.WithError(err))
+		return nil, pkgerrors.ErrStorageOperation
+	}
+	defer input.Close()
+
+	pcm, _, err := converter.DecodeWAV(input)
+	if err != nil {
+		logrus.Error("failed to decode converted audio", logrus.WithError(err))
+		return nil, pkgerrors.ErrStorageOperation
+	}
+
+	var image bytes.Buffer
+	if err = converter.RenderSpectrogram(ctx, &image, pcm, config); err != nil {
+		logrus.Error("failed to render spectrogram", logrus.WithError(err))
+		return nil, pkgerrors.ErrInternalServer
+	}
+
+	// the rendered image is served even when it cannot be cached
+	if cacheable {
+		if _, err = s.fileStore.SaveArtifact(ctx, userID, phraseID, name, bytes.NewReader(image.Bytes())); err != nil {
+			logrus.Warn("failed to cache spectrogram", logrus.WithError(err))
+		}
+	}
+
+	return io.NopCloser(&image), nil
+}
+
+// FetchAudio retrieves the URI of the rendition of the audio for the given user and phrase in targetFormat, or in the
+// format the Accept header prefers when targetFormat is empty. The conversion wins ties over the other renditions, and
+// the upload is served when no rendition has the format.
+func (s *audioServiceImpl) FetchAudio(ctx context.Context, userID, phraseID int64, targetFormat, accept string) (string, error) {
 	record, err := s.repo.GetAudioRecord(ctx, userID, phraseID)
 	if err != nil {
 		logrus.Error("failed to fetch audio record", logrus.WithError(err))
@@ -118,13 +317,49 @@ func (s *audioServiceImpl) FetchAudio(ctx context.Context, userID, phraseID int6
 		return "", pkgerrors.ErrNotFound
 	}
 
+	if record.Status == model.AudioConversionFailed {
+		return "", pkgerrors.ErrAudioConversionFailed
+	}
+
 	if record.Status != model.AudioConversionCompleted {
 		return "", pkgerrors.ErrAudioProcessingInProgress
 	}
 
-	if record.OriginalFormat != targetFormat {
+	renditions, err := s.repo.ListAudioRenditions(ctx, userID, phraseID)
+	if err != nil {
+		logrus.Error("failed to list audio renditions", logrus.WithError(err))
+		return "", pkgerrors.ErrDatabaseOperation
+	}
+	// renditions that could not be encoded have no file to serve
+	renditions = slices.DeleteFunc(renditions, model.AudioRendition.Failed)
+	isConversion := func(rendition model.AudioRendition) bool { return rendition.Profile == record.StorageProfile }
+	if i := slices.IndexFunc(renditions, isConversion); i > 0 {
+		conversion := renditions[i]
+		renditions = slices.Insert(slices.Delete(renditions, i, i+1), 0, conversion)
+	}
+	if original, err := s.formats.Lookup(record.OriginalFormat); err == nil {
+		renditions = append(renditions, model.AudioRendition{Format: string(original.Name), URI: record.OriginalURI})
+	}
+
+	var format *converter.FormatDescriptor
+	if targetFormat != "" {
+		format, err = s.formats.Lookup(targetFormat)
+	} else {
+		candidates := make([]converter.Format, len(renditions))
+		for i, rendition := range renditions {
+			candidates[i] = converter.Format(rendition.Format)
+		}
+		format, err = s.formats.Negotiate(accept, candidates)
+	}
+	if err != nil {
 		return "", pkgerrors.ErrInvalidAudioFormat
 	}
 
-	return record.OriginalURI, nil
+	for _, rendition := range renditions {
+		if rendition.Format == string(format.Name) {
+			return rendition.URI, nil
+		}
+	}
+
+	return "", pkgerrors.ErrInvalidAudioFormat
 }
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"phonon/pkg/converter"
+	pkgerrors "phonon/pkg/errors"
+	"phonon/pkg/model"
+	"phonon/pkg/queue"
+	"phonon/pkg/repository"
+	"phonon/pkg/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func wavUpload(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	pcm := &converter.PCM{SampleRate: 8000, Channels: 1, Samples: make([]float64, 800)}
+	require.NoError(t, converter.EncodeWAV(&buf, pcm, converter.PCMFormat{Encoding: converter.SampleInt, BitDepth: 16}))
+
+	return &buf
+}
+
+func TestStoreAudio_SQLQueue(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := repository.NewSQLite(filepath.Join(dir, "phonon.db"))
+	require.NoError(t, err)
+
+	producer, err := queue.NewSQLProducer(repo, queue.SQLConfig{Topic: "conversions"})
+	require.NoError(t, err)
+	background := queue.NewAudioConversion(nil, repo, queue.AudioConversionWithProducer(producer))
+	audio := NewAudioService(repo, storage.NewLocal(storage.Config{BasePath: filepath.Join(dir, "audio")}), background,
+		converter.DefaultFormats(), nil)
+
+	// SQLite waits 5 seconds for a lock before failing, an upload must not wait for its own transaction
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	require.NoError(t, audio.StoreAudio(ctx, 1, 1, wavUpload(t), "phrase.wav"))
+
+	record, err := repo.GetAudioRecord(ctx, 1, 1)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, model.AudioConversionOngoing, record.Status)
+
+	jobs, err := repo.CountLeasableJobs(ctx, "conversions")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), jobs, "the job is committed with the record")
+
+	t.Run("rolled back uploads leave no job", func(t *testing.T) {
+		err := audio.StoreAudio(ctx, 1, 2, bytes.NewBufferString("not a wav file"), "phrase.wav")
+		assert.ErrorIs(t, err, pkgerrors.ErrInvalidInput)
+
+		err = audio.StoreAudio(ctx, 1, 1, wavUpload(t), "phrase.wav")
+		assert.ErrorIs(t, err, pkgerrors.ErrInvalidInput, "the phrase already has a recording")
+
+		jobs, err := repo.CountLeasableJobs(ctx, "conversions")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), jobs)
+	})
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -17,8 +18,22 @@ type File interface {
 	Save(ctx context.Context, userID, phraseID int64, file io.Reader, originalFormat string) (string, error)
 	// Delete deletes the content of the file on the given URI
 	Delete(ctx context.Context, userID, phraseID int64) error
+	// Remove deletes the file stored at the given URI
+	Remove(ctx context.Context, uri string) error
+	// SaveArtifact stores a file derived from the recording of the user and phrase, such as a rendered image, under name
+	SaveArtifact(ctx context.Context, userID, phraseID int64, name string, file io.Reader) (string, error)
+	// OpenArtifact opens an artifact stored by SaveArtifact, the error matches fs.ErrNotExist when there is none
+	OpenArtifact(ctx context.Context, userID, phraseID int64, name string) (io.ReadCloser, error)
+	// RemoveArtifacts deletes the artifacts of the recording of the user and phrase whose names start with prefix
+	RemoveArtifacts(ctx context.Context, userID, phraseID int64, prefix string) error
 }
 
+// ErrURIOutsideStorage is returned for URIs that do not belong to the storage
+var ErrURIOutsideStorage = errors.New("uri outside of storage")
+
+// ErrInvalidArtifactName is returned for artifact names that are not plain file names
+var ErrInvalidArtifactName = errors.New("invalid artifact name")
+
 // Type represents the type of storage implementation to use
 type Type string
 
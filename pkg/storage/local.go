@@ -2,9 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -64,6 +67,107 @@ func (l *Local) Delete(ctx context.Context, userID, phraseID int64) error {
 	return os.Remove(uri)
 }
 
+// Remove deletes the file stored at the given URI, refusing URIs outside of the base path.
+func (l *Local) Remove(ctx context.Context, uri string) error {
+	if !l.isStoragePath(uri) {
+		return ErrURIOutsideStorage
+	}
+
+	return os.Remove(uri)
+}
+
+// isStoragePath reports whether uri names a file the storage creates: a file of the base path directory named
+// after the base path, so neither sibling directories sharing its prefix nor paths leaving it are accepted
+func (l *Local) isStoragePath(uri string) bool {
+	base := filepath.Clean(l.BasePath)
+	rel, err := filepath.Rel(filepath.Dir(base), filepath.Clean(uri))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	return !strings.ContainsRune(rel, filepath.Separator) && strings.HasPrefix(rel, filepath.Base(base)+"_")
+}
+
+// SaveArtifact stores an artifact of the recording next to it. The artifact is written to a temporary file first,
+// so concurrent readers never open a partial artifact.
+func (l *Local) SaveArtifact(ctx context.Context, userID, phraseID int64, name string, file io.Reader) (string, error) {
+	if err := validateArtifactName(name); err != nil {
+		return "", err
+	}
+	uri := l.createLocalStoragePath(userID, phraseID, name)
+
+	dir := l.BasePath[:strings.LastIndex(l.BasePath, "/")+1]
+	if err := os.MkdirAll(dir, dirPermissions); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	outputFile, err := os.CreateTemp(filepath.Dir(uri), filepath.Base(uri)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(outputFile, file)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(outputFile.Name(), uri)
+	}
+	if err != nil {
+		os.Remove(outputFile.Name())
+		return "", err
+	}
+
+	return uri, nil
+}
+
+// OpenArtifact opens an artifact stored by SaveArtifact.
+func (l *Local) OpenArtifact(ctx context.Context, userID, phraseID int64, name string) (io.ReadCloser, error) {
+	if err := validateArtifactName(name); err != nil {
+		return nil, err
+	}
+
+	return os.Open(l.createLocalStoragePath(userID, phraseID, name))
+}
+
+// RemoveArtifacts deletes the artifacts of the recording named with prefix, with the temporary files of the ones
+// being saved.
+func (l *Local) RemoveArtifacts(ctx context.Context, userID, phraseID int64, prefix string) error {
+	if err := validateArtifactName(prefix); err != nil {
+		return err
+	}
+	path := l.createLocalStoragePath(userID, phraseID, prefix)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), filepath.Base(path)) {
+			continue
+		}
+		if err = os.Remove(filepath.Join(filepath.Dir(path), entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateArtifactName refuses names that would leave the path of the recording
+func validateArtifactName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("%w: %q", ErrInvalidArtifactName, name)
+	}
+
+	return nil
+}
+
 // createLocalStoragePath generates the file path for storing or retrieving files
 // based on the user ID, phrase ID and format.
 func (l *Local) createLocalStoragePath(userID, phraseID int64, format string) string {
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
 	"testing"
@@ -140,3 +142,155 @@ func TestLocal_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestLocal_Remove(t *testing.T) {
+	testDir := "./testdata"
+	defer os.RemoveAll(testDir)
+
+	local := &Local{
+		BasePath:     testDir + "/test",
+		StoredFormat: "WAV",
+	}
+
+	testFile := local.createLocalStoragePath(1, 1, "m4a")
+	os.MkdirAll(testDir, 0755)
+	f, _ := os.Create(testFile)
+	f.Close()
+
+	tests := []struct {
+		name    string
+		uri     string
+		wantErr bool
+	}{
+		{
+			name:    "existing file",
+			uri:     testFile,
+			wantErr: false,
+		},
+		{
+			name:    "non-existing file",
+			uri:     testFile,
+			wantErr: true,
+		},
+		{
+			name:    "outside of base path",
+			uri:     testDir + "/../local.go",
+			wantErr: true,
+		},
+		{
+			name:    "sibling directory sharing the base path prefix",
+			uri:     testDir + "/test_1_1/../../local.go",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := local.Remove(context.Background(), tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Local.Remove() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := os.Stat("local.go"); err != nil {
+		t.Errorf("file outside of base path was removed: %v", err)
+	}
+}
+
+func TestLocal_isStoragePath(t *testing.T) {
+	local := &Local{BasePath: "/data/audio/rec"}
+
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{uri: "/data/audio/rec_1_1.wav", want: true},
+		{uri: "/data/audio/./rec_1_1.spectrogram.png", want: true},
+		{uri: "/data/audio/rec_1_1.wav/../rec_1_2.wav", want: true},
+		{uri: "/data/audio/recordings/rec_1_1.wav", want: false},
+		{uri: "/data/audio/rec_backup/secret.wav", want: false},
+		{uri: "/data/audio-other/rec_1_1.wav", want: false},
+		{uri: "/data/audio/other_1_1.wav", want: false},
+		{uri: "/data/audio/../rec_1_1.wav", want: false},
+		{uri: "/data/audio/rec", want: false},
+		{uri: "rec_1_1.wav", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if got := local.isStoragePath(tt.uri); got != tt.want {
+				t.Errorf("Local.isStoragePath(%q) = %v, want %v", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocal_Artifact(t *testing.T) {
+	testDir := "./testdata"
+	defer os.RemoveAll(testDir)
+
+	local := &Local{
+		BasePath:     testDir + "/test",
+		StoredFormat: "WAV",
+	}
+
+	if _, err := local.OpenArtifact(context.Background(), 1, 1, "spectrogram.png"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Local.OpenArtifact() error = %v, want fs.ErrNotExist", err)
+	}
+
+	uri, err := local.SaveArtifact(context.Background(), 1, 1, "spectrogram.png", strings.NewReader("image"))
+	if err != nil {
+		t.Fatalf("Local.SaveArtifact() error = %v", err)
+	}
+	if uri != local.createLocalStoragePath(1, 1, "spectrogram.png") {
+		t.Errorf("Local.SaveArtifact() uri = %v", uri)
+	}
+
+	artifact, err := local.OpenArtifact(context.Background(), 1, 1, "spectrogram.png")
+	if err != nil {
+		t.Fatalf("Local.OpenArtifact() error = %v", err)
+	}
+	content, _ := io.ReadAll(artifact)
+	artifact.Close()
+	if string(content) != "image" {
+		t.Errorf("Local.OpenArtifact() content = %q, want %q", content, "image")
+	}
+
+	entries, _ := os.ReadDir(testDir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	for _, name := range []string{"spectrogram-a.png", "spectrogram-b.png", "waveform.json"} {
+		if _, err := local.SaveArtifact(context.Background(), 1, 1, name, strings.NewReader("image")); err != nil {
+			t.Fatalf("Local.SaveArtifact(%q) error = %v", name, err)
+		}
+	}
+	if _, err := local.SaveArtifact(context.Background(), 1, 10, "spectrogram-a.png", strings.NewReader("image")); err != nil {
+		t.Fatalf("Local.SaveArtifact() error = %v", err)
+	}
+	if err := local.RemoveArtifacts(context.Background(), 1, 1, "spectrogram-"); err != nil {
+		t.Fatalf("Local.RemoveArtifacts() error = %v", err)
+	}
+	for name, want := range map[string]bool{"spectrogram.png": true, "spectrogram-a.png": false, "spectrogram-b.png": false, "waveform.json": true} {
+		if _, err := os.Stat(local.createLocalStoragePath(1, 1, name)); (err == nil) != want {
+			t.Errorf("Local.RemoveArtifacts() left %q = %v, want %v", name, err == nil, want)
+		}
+	}
+	if _, err := os.Stat(local.createLocalStoragePath(1, 10, "spectrogram-a.png")); err != nil {
+		t.Errorf("Local.RemoveArtifacts() removed the artifact of another recording: %v", err)
+	}
+
+	for _, name := range []string{"", "../escape.png", "dir/name.png"} {
+		if _, err := local.SaveArtifact(context.Background(), 1, 1, name, strings.NewReader("image")); !errors.Is(err, ErrInvalidArtifactName) {
+			t.Errorf("Local.SaveArtifact(%q) error = %v, want ErrInvalidArtifactName", name, err)
+		}
+		if _, err := local.OpenArtifact(context.Background(), 1, 1, name); !errors.Is(err, ErrInvalidArtifactName) {
+			t.Errorf("Local.OpenArtifact(%q) error = %v, want ErrInvalidArtifactName", name, err)
+		}
+		if err := local.RemoveArtifacts(context.Background(), 1, 1, name); !errors.Is(err, ErrInvalidArtifactName) {
+			t.Errorf("Local.RemoveArtifacts(%q) error = %v, want ErrInvalidArtifactName", name, err)
+		}
+	}
+}
@@ -48,9 +48,14 @@ echo "APP_STORAGE_TYPE=$STORAGE_TYPE" >> .env
 
 echo "APP_STORAGE_LOCAL_BASE_PATH=./data/user/audio" >> .env
 
+MQ_DRIVER=$(prompt_choice "Select message queue driver:" "kafka sql nats" "kafka")
+echo "APP_MQ_DRIVER=$MQ_DRIVER" >> .env
+
 echo "APP_MQ_KAFKA_BROKERS=localhost:9092" >> .env
 echo "APP_MQ_KAFKA_AUDIO_CONVERSION_GROUP=main" >> .env
 echo "APP_MQ_KAFKA_AUDIO_CONVERSION_TOPIC=audio_conversion" >> .env
+echo "APP_MQ_KAFKA_AUDIO_CONVERSION_PARTITION_KEY=user" >> .env
+echo "APP_MQ_KAFKA_AUDIO_CONVERSION_DEAD_LETTER_TOPIC=audio_conversion_dlq" >> .env
 
 chmod +x "$0"
 
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    topic VARCHAR(255) NOT NULL,
+    message_id VARCHAR(64) NOT NULL,
+    message_key VARCHAR(255) NOT NULL DEFAULT '',
+    payload MEDIUMBLOB NOT NULL,
+    headers TEXT NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    status INT NOT NULL DEFAULT 0,
+    attempts INT NOT NULL DEFAULT 0,
+    available_at BIGINT NOT NULL,
+    leased_until BIGINT NOT NULL DEFAULT 0,
+    lease_token VARCHAR(64) NOT NULL DEFAULT '',
+    last_error TEXT,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_jobs_lease (topic, status, priority, available_at),
+    INDEX idx_jobs_lease_token (lease_token)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS processed_messages (
+    message_id VARCHAR(64) NOT NULL,
+    processed_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY (message_id),
+    INDEX idx_processed_messages_expires_at (expires_at)
+);
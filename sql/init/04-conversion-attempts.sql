@@ -0,0 +1 @@
+ALTER TABLE audio_records ADD COLUMN conversion_attempts INT NOT NULL DEFAULT 0 AFTER status;
@@ -0,0 +1 @@
+ALTER TABLE audio_records ADD COLUMN storage_profile VARCHAR(64) NOT NULL DEFAULT '' AFTER stored_file_uri;
@@ -0,0 +1,4 @@
+ALTER TABLE audio_records
+    ADD COLUMN loudness DOUBLE NULL AFTER conversion_attempts,
+    ADD COLUMN trim_start_ms BIGINT NOT NULL DEFAULT 0 AFTER loudness,
+    ADD COLUMN trim_end_ms BIGINT NOT NULL DEFAULT 0 AFTER trim_start_ms;
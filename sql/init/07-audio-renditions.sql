@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS audio_renditions (
+    user_id BIGINT NOT NULL,
+    phrase_id BIGINT NOT NULL,
+    profile VARCHAR(64) NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    uri VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (user_id, phrase_id, profile)
+);
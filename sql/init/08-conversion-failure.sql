@@ -0,0 +1,3 @@
+ALTER TABLE audio_records
+    ADD COLUMN failure_kind VARCHAR(32) NOT NULL DEFAULT '' AFTER trim_end_ms,
+    ADD COLUMN failure_summary VARCHAR(512) NOT NULL DEFAULT '' AFTER failure_kind;